@@ -55,4 +55,10 @@ if ! helm upgrade --install jobworker-low-1 ./k8s/helm_charts --set app.version=
     echo -e "${ERROR_COLOR}==> Failed to load update container image of jobworker-low-1 helm chart ...${NO_COLOR}"
     exit 1
 fi
-echo -e "${OK_COLOR}==> Successfully updated version of jobworker-low-1 helm chart to $VERSION ...${NO_COLOR}"
\ No newline at end of file
+echo -e "${OK_COLOR}==> Successfully updated version of jobworker-low-1 helm chart to $VERSION ...${NO_COLOR}"
+
+if ! helm upgrade --install queue-recovery ./k8s/helm_charts --set app.version=$VERSION -f ./k8s/helm_charts/myvalues.yaml -f ./k8s/helm_charts/myvalues_queue_recovery.yaml; then
+    echo -e "${ERROR_COLOR}==> Failed to load update container image of queue-recovery helm chart ...${NO_COLOR}"
+    exit 1
+fi
+echo -e "${OK_COLOR}==> Successfully updated version of queue-recovery helm chart to $VERSION ...${NO_COLOR}"
\ No newline at end of file
@@ -3,51 +3,246 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sf7293/task-manager/configs"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
 	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/metrics"
 	"github.com/sf7293/task-manager/internal/postgres"
 	"github.com/sf7293/task-manager/internal/rabbitmq"
+	"github.com/sf7293/task-manager/internal/recovery"
+	"github.com/sf7293/task-manager/internal/redis"
+	"github.com/sf7293/task-manager/internal/subtask"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/tasktypes"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const (
+	daemonCommand    = "daemon"
+	reconcileCommand = "reconcile"
+)
+
+var postgresIsReady, rabbitIsReady, redisIsReady bool
+
 func main() {
 	cfg := configs.InitConfig()
 	args := os.Args
-	if len(args) < 4 {
-		log.Fatal("Insufficient arguments are provided in calling the command")
+	if len(args) >= 2 && args[1] == daemonCommand {
+		runDaemon(cfg)
 		return
 	}
-
-	taskStatus := args[1]
-	if taskStatus != string(domain.Queued) && taskStatus != string(domain.Failed) {
-		slog.Error("only queued and failed tasks can be re-queued", "provided_task_status", taskStatus)
+	if len(args) >= 2 && args[1] == reconcileCommand {
+		runReconcile(cfg, args[2:])
 		return
 	}
 
-	// This argument defines the condition for the query, The query finds tasks with the given 'taskStatus' whose updated_at has not been changed since passed X seconds (their updated_at <= nowStamp - passedXSeconds)
-	pastSecondsStr := args[2]
-	pastSeconds, err := strconv.ParseInt(pastSecondsStr, 10, 64)
+	runOnce(cfg, args[1:])
+}
+
+// onceOptions holds the flags of the one-shot mode
+type onceOptions struct {
+	requeue recovery.RequeueOptions
+	output  string
+}
+
+// parseOnceFlags parses the flags of the one-shot mode, optional filters are left nil when their flag is not set
+func parseOnceFlags(args []string) (*onceOptions, error) {
+	flags := flag.NewFlagSet("queue_recovery", flag.ContinueOnError)
+	status := flags.String("status", "", "Status of the tasks to be re-queued, it must be queued or failed (required)")
+	pastSeconds := flags.Int("past-seconds", 0, "Only tasks whose updated_at has not been changed in the last X seconds are re-queued (required)")
+	limit := flags.Int("limit", 100, "Maximum number of tasks to be fetched")
+	taskType := flags.String("type", "", "Only re-queue tasks of this type")
+	priority := flags.String("priority", "", "Only re-queue tasks of this priority (high, normal or low)")
+	minID := flags.Int("min-id", 0, "Only re-queue tasks whose ID is greater than or equal to this value")
+	maxID := flags.Int("max-id", 0, "Only re-queue tasks whose ID is less than or equal to this value")
+	rate := flags.Int("rate", 0, "Maximum number of re-queues per second, 0 means no limit")
+	dryRun := flags.Bool("dry-run", false, "Only report the tasks which would be re-queued, without publishing anything")
+	output := flags.String("output", "text", "Output format of the report: text or json")
+	err := flags.Parse(args)
 	if err != nil {
-		log.Fatal("Invalid input is given for the pastSeconds arg, it must be an integer", "provided_past_seconds", pastSecondsStr, "error", err)
-		return
+		return nil, err
+	}
+
+	if *status != string(domain.Queued) && *status != string(domain.Failed) {
+		return nil, fmt.Errorf("only queued and failed tasks can be re-queued, provided status: %q", *status)
+	}
+	if *pastSeconds <= 0 {
+		return nil, errors.New("past-seconds must be a positive integer")
+	}
+	if *limit <= 0 {
+		return nil, errors.New("limit must be a positive integer")
+	}
+	if *output != "text" && *output != "json" {
+		return nil, fmt.Errorf("output must be text or json, provided output: %q", *output)
+	}
+
+	filter := domain.MissedTasksFilter{
+		Status:        *status,
+		PassedSeconds: int32(*pastSeconds),
+		Limit:         int32(*limit),
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "type":
+			filter.Type = taskType
+		case "priority":
+			filter.Priority = priority
+		case "min-id":
+			id := int32(*minID)
+			filter.MinID = &id
+		case "max-id":
+			id := int32(*maxID)
+			filter.MaxID = &id
+		}
+	})
+	if filter.Priority != nil && *priority != string(domain.High) && *priority != string(domain.Normal) && *priority != string(domain.Low) {
+		return nil, fmt.Errorf("priority must be high, normal or low, provided priority: %q", *priority)
+	}
+
+	return &onceOptions{
+		requeue: recovery.RequeueOptions{
+			Filter:               filter,
+			DryRun:               *dryRun,
+			MaxRequeuesPerSecond: int32(*rate),
+		},
+		output: *output,
+	}, nil
+}
+
+// runOnce re-queues the missed tasks matching the flags only once, it's useful for emergency cases
+func runOnce(cfg *configs.Config, args []string) {
+	opts, err := parseOnceFlags(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal("Invalid arguments are provided in calling the command: ", err)
+	}
+
+	ctx := context.Background()
+	pool, err := postgres.NewPool(ctx, cfg.Database.ToDbConnectionUri())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	taskTypes, err := tasktypes.NewDefaultRegistry(cfg, postgres.NewTemplateStorage(pool))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage := postgres.NewStorage(pool, taskTypes)
+	slog.Info("Postgres connection has been initialized successfully")
+
+	mainQueueNames := cfg.RabbitMQ.GetMainQueueNames()
+	rabbitClient, err := rabbitmq.NewRabbitMQClient(ctx, cfg.RabbitMQ.ToRabbitConnectionUri(), mainQueueNames)
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer func() {
+		err = rabbitClient.Close()
+		if err != nil {
+			slog.Error("An error occurred while closing RabbitMQ connection", "error", err.Error())
+		}
+	}()
+	slog.Info("RabbitMQ has been initialized successfully")
 
-	// This argument defines maximum number of tasks to be fetched by query
-	limitStr := args[2]
-	limit, err := strconv.ParseInt(limitStr, 10, 64)
+	filter := opts.requeue.Filter
+	slog.Info("Fetching missed tasks", "task_status", filter.Status, "past_seconds_threshold", filter.PassedSeconds, "limit", filter.Limit, "dry_run", opts.requeue.DryRun)
+	report, err := recovery.Requeue(ctx, storage, dispatch.NewDispatcher(rabbitClient, cfg.RabbitMQ.GetPriorityQueueNames()), opts.requeue)
 	if err != nil {
-		log.Fatal("Invalid input is given for the limit arg, it must be an integer", "provided_limit", limitStr, "error", err)
+		slog.Error("Error occurred while re-queuing missed tasks", "error", err.Error())
+		os.Exit(1)
+	}
+
+	if opts.output == "json" {
+		// Logs are written to stderr, so stdout only contains the report
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+		if err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
+	for _, item := range report.Tasks {
+		fmt.Printf("task_id=%d type=%s priority=%s status=%s attempts=%d action=%s %s\n", item.TaskID, item.Type, item.Priority, item.Status, item.Attempts, item.Action, item.Error)
+	}
+	fmt.Printf("dry_run=%t considered=%d requeued=%d failed=%d skipped=%d\n", report.DryRun, report.ConsideredCount, report.RequeuedCount, report.FailedCount, report.SkippedCount)
+}
+
+// reconcileOptions holds the flags of the reconcile mode
+type reconcileOptions struct {
+	reconciliation recovery.ReconciliationOptions
+	output         string
+}
+
+// parseReconcileFlags parses the flags of the reconcile mode
+func parseReconcileFlags(args []string) (*reconcileOptions, error) {
+	flags := flag.NewFlagSet("queue_recovery reconcile", flag.ContinueOnError)
+	sampleSize := flags.Int("sample-size", 100, "Maximum number of messages to be inspected in each queue")
+	graceSeconds := flags.Int("grace-seconds", 60, "Only queued tasks whose updated_at has not been changed in the last X seconds are reported as missing from the queue")
+	fix := flags.Bool("fix", false, "Remove the orphan messages and re-queue the missing tasks")
+	output := flags.String("output", "text", "Output format of the report: text or json")
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *sampleSize <= 0 {
+		return nil, errors.New("sample-size must be a positive integer")
+	}
+	if *graceSeconds < 0 {
+		return nil, errors.New("grace-seconds must not be negative")
+	}
+	if *output != "text" && *output != "json" {
+		return nil, fmt.Errorf("output must be text or json, provided output: %q", *output)
+	}
+
+	return &reconcileOptions{
+		reconciliation: recovery.ReconciliationOptions{
+			SampleSize:   *sampleSize,
+			GraceSeconds: int32(*graceSeconds),
+			Fix:          *fix,
+		},
+		output: *output,
+	}, nil
+}
+
+// runReconcile compares the queues with the tasks table once, and reports the drift between them
+func runReconcile(cfg *configs.Config, args []string) {
+	opts, err := parseReconcileFlags(args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		log.Fatal("Invalid arguments are provided in calling the command: ", err)
+	}
+
 	ctx := context.Background()
-	storage, err := postgres.NewStorage(ctx, cfg.Database.ToDbConnectionUri())
+	pool, err := postgres.NewPool(ctx, cfg.Database.ToDbConnectionUri())
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	taskTypes, err := tasktypes.NewDefaultRegistry(cfg, postgres.NewTemplateStorage(pool))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage := postgres.NewStorage(pool, taskTypes)
 	slog.Info("Postgres connection has been initialized successfully")
 
 	mainQueueNames := cfg.RabbitMQ.GetMainQueueNames()
@@ -63,41 +258,175 @@ func main() {
 	}()
 	slog.Info("RabbitMQ has been initialized successfully")
 
-	slog.Info("Fetching missed tasks", "task_status", taskStatus, "past_seconds_threshold", pastSeconds, "limit", limit)
-	missedTasks, err := storage.GetMissedTasks(ctx, taskStatus, int32(pastSeconds), int32(limit))
+	report, err := recovery.ReconcileQueues(ctx, storage, rabbitClient, cfg.RabbitMQ.GetPriorityQueueNames(), opts.reconciliation)
 	if err != nil {
-		slog.Error("Error occurred while fetching missed tasks", "error", err.Error())
+		slog.Error("Error occurred while reconciling the queues", "error", err.Error())
+		os.Exit(1)
+	}
+
+	if opts.output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+		if err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
-	slog.Info("Missed tasks are fetched", "task_status", taskStatus, "past_seconds_threshold", pastSeconds, "limit", limit, "fetched_items_count", len(missedTasks))
 
-	requeuedCount := 0
-	for i, task := range missedTasks {
-		jobsQueueName := cfg.RabbitMQ.NormalPriorityJobsQueueName
-		switch task.Priority {
-		case string(domain.High):
-			jobsQueueName = cfg.RabbitMQ.HighPriorityJobsQueueName
-		case string(domain.Low):
-			jobsQueueName = cfg.RabbitMQ.LowPriorityJobsQueueName
+	for _, item := range report.Queues {
+		fmt.Printf("queue=%s priority=%s db_queued=%d queue_depth=%d drift=%d sampled=%d fully_sampled=%t orphans=%d missing=%d %s\n", item.QueueName, item.Priority, item.DBQueuedCount, item.QueueDepth, item.Drift, item.SampledCount, item.IsFullySampled, len(item.OrphanMessages), len(item.MissingTasks), item.Error)
+		for _, orphan := range item.OrphanMessages {
+			fmt.Printf("  orphan task_id=%d reason=%s removed=%t\n", orphan.TaskID, orphan.Reason, orphan.IsRemoved)
 		}
+		for _, missing := range item.MissingTasks {
+			fmt.Printf("  missing task_id=%d requeued=%t %s\n", missing.TaskID, missing.IsRequeued, missing.RequeueError)
+		}
+	}
+}
+
+// runDaemon runs the reconciler continuously, only the replica which is elected as the leader re-queues the stuck tasks
+func runDaemon(cfg *configs.Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool, err := postgres.NewPool(ctx, cfg.Database.ToDbConnectionUri())
+	if err != nil {
+		log.Fatal(err)
+	}
 
-		slog.Info("Start of marshalling task", "task_id", task.ID, "missed_tasks_count", len(missedTasks), "item_index", i)
-		marshalledTask, err := json.Marshal(task)
+	taskTypes, err := tasktypes.NewDefaultRegistry(cfg, postgres.NewTemplateStorage(pool))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage := postgres.NewStorage(pool, taskTypes)
+	postgresIsReady = true
+	slog.Info("Postgres connection has been initialized successfully")
+
+	mainQueueNames := cfg.RabbitMQ.GetMainQueueNames()
+	rabbitClient, err := rabbitmq.NewRabbitMQClient(ctx, cfg.RabbitMQ.ToRabbitConnectionUri(), mainQueueNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		err = rabbitClient.Close()
 		if err != nil {
-			slog.Error("There was an error in marshalling task", "task_id", task.ID, "error", err.Error())
-			// I've ignored returning the error here and just log it because I'll handle re-queueing task again in another worker
-			continue
+			slog.Error("An error occurred while closing RabbitMQ connection", "error", err.Error())
 		}
-		slog.Info("Task is marshalled successfully and ready to be re-queued", "task_id", task.ID)
+	}()
+	rabbitIsReady = true
+	slog.Info("RabbitMQ has been initialized successfully")
 
-		err = rabbitClient.PublishMessage(jobsQueueName, string(marshalledTask))
+	// The Redis client must outlive the daemon context, because the leadership lease is released after the reconciler is stopped
+	redisClient, err := redis.NewClient(context.Background(), cfg.RedisConfig.ToRedisConnectionUri())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		err = redisClient.Close()
 		if err != nil {
-			slog.Error("Error occurred while queuing marshalled task to jobs queue", "error", err.Error())
-			// Again I've ignored returning the error here and just log it because I'll handle re-queueing task again in another worker
+			slog.Error("An error occurred while closing Redis connection", "error", err.Error())
 		}
-		slog.Info("Task is re-queued successfully", "task_id", task.ID, "priority", task.Priority, "missed_tasks_count", len(missedTasks), "item_index", i)
-		requeuedCount++
+	}()
+	redisIsReady = true
+	slog.Info("Redis connection has been initialized successfully")
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The owner must be unique for each replica, in Kubernetes the hostname is the pod name
+	owner := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	elector := recovery.NewLeaderElector(redisClient, cfg.Recovery.LeaderLeaseKey, owner, time.Duration(cfg.Recovery.LeaderLeaseInSeconds)*time.Second)
+
+	dispatcher := dispatch.NewDispatcher(rabbitClient, cfg.RabbitMQ.GetPriorityQueueNames())
+	workflowEngine := workflow.NewEngine(postgres.NewWorkflowStorage(pool, taskTypes), dispatcher, cfg.Recovery.MaxAttempts)
+	batchTracker := batch.NewTracker(postgres.NewBatchStorage(pool, taskTypes), dispatcher, cfg.Recovery.MaxAttempts)
+	subtaskManager := subtask.NewManager(postgres.NewChildTaskStorage(pool, taskTypes), dispatcher, taskTypes, workflowEngine, batchTracker, cfg.Recovery.MaxAttempts)
+	reconciler := recovery.NewReconciler(storage, dispatcher, workflowEngine, batchTracker, subtaskManager, elector, recovery.Settings{
+		Interval:                time.Duration(cfg.Recovery.IntervalInSeconds) * time.Second,
+		QueuedAfterSeconds:      cfg.Recovery.QueuedAfterSeconds,
+		RunningLeaseSeconds:     cfg.Recovery.RunningLeaseInSeconds,
+		FailedRetryAfterSeconds: cfg.Recovery.FailedRetryAfterSeconds,
+		MaxAttempts:             cfg.Recovery.MaxAttempts,
+		BatchSize:               cfg.Recovery.BatchSize,
+		MaxRequeuesPerSecond:    cfg.Recovery.MaxRequeuesPerSecond,
+	})
+
+	srv := setUpHealthCheckerAPIs(cfg, storage, rabbitClient, redisClient, reconciler)
+
+	reconcilerDone := make(chan struct{})
+	go func() {
+		reconciler.Run(ctx)
+		close(reconcilerDone)
+	}()
+	slog.Info("Recovery daemon is running. To exit press CTRL+C", "owner", owner)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	slog.Info("Recovery daemon is shutting down...", "owner", owner)
+
+	// Stopping the reconciler releases the leadership lease, so another replica could take over immediately
+	cancel()
+	<-reconcilerDone
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Duration(cfg.ServerTimeOutInSeconds)*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Health checker server forced to shutdown", "error", err.Error())
 	}
+}
+
+func setUpHealthCheckerAPIs(cfg *configs.Config, storage domain.Storage, rabbitClient *rabbitmq.RabbitMQClient, redisClient *redis.Client, reconciler *recovery.Reconciler) *http.Server {
+	r := gin.Default()
+	r.GET("/readiness", func(c *gin.Context) {
+		if postgresIsReady && rabbitIsReady && redisIsReady {
+			c.JSON(http.StatusOK, gin.H{"status": "ready"})
+		} else {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready"})
+		}
+	})
+	r.GET("/liveness", func(c *gin.Context) {
+		err := storage.Ping(c)
+		if err != nil {
+			slog.Error("Postgresql seem not to be pingable in liveness API", "error", err.Error())
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not healthy"})
+			return
+		}
+
+		isRabbitHealthy := rabbitClient.IsHealthy()
+		if !isRabbitHealthy {
+			slog.Error("Rabbit is not healthy")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not healthy"})
+			return
+		}
+
+		err = redisClient.Ping(c)
+		if err != nil {
+			slog.Error("Redis seem not to be pingable in liveness API", "error", err.Error())
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not healthy"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "up"})
+	})
+	// The counters of the reconciler are served with the metrics of the dispatcher and the Go runtime
+	prometheus.MustRegister(reconciler.Stats())
+	r.GET("/metrics", metrics.Handler())
+
+	srv := &http.Server{
+		Addr:    ":" + cfg.ServerPort,
+		Handler: r,
+	}
+
+	go func() {
+		log.Printf("Starting health checker server on port %s\n", cfg.ServerPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("listen: %s\n", err)
+		}
+	}()
 
-	slog.Info("Missed tasks have been re-queued", "missed_tasks_count", len(missedTasks), "successful_requeued_count", requeuedCount)
+	return srv
 }
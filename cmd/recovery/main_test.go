@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_parseOnceFlags(t *testing.T) {
+	t.Run("it should leave optional filters nil when their flags are not set", func(t *testing.T) {
+		opts, err := parseOnceFlags([]string{"-status=queued", "-past-seconds=60"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "queued", opts.requeue.Filter.Status)
+		assert.Equal(t, int32(60), opts.requeue.Filter.PassedSeconds)
+		assert.Equal(t, int32(100), opts.requeue.Filter.Limit)
+		assert.Nil(t, opts.requeue.Filter.Type)
+		assert.Nil(t, opts.requeue.Filter.Priority)
+		assert.Nil(t, opts.requeue.Filter.MinID)
+		assert.Nil(t, opts.requeue.Filter.MaxID)
+		assert.False(t, opts.requeue.DryRun)
+		assert.Equal(t, "text", opts.output)
+	})
+
+	t.Run("it should set all the filters", func(t *testing.T) {
+		opts, err := parseOnceFlags([]string{"-status=failed", "-past-seconds=10", "-limit=5", "-type=send_email", "-priority=low", "-min-id=0", "-max-id=20", "-rate=3", "-dry-run", "-output=json"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "send_email", *opts.requeue.Filter.Type)
+		assert.Equal(t, "low", *opts.requeue.Filter.Priority)
+		assert.Equal(t, int32(0), *opts.requeue.Filter.MinID)
+		assert.Equal(t, int32(20), *opts.requeue.Filter.MaxID)
+		assert.Equal(t, int32(3), opts.requeue.MaxRequeuesPerSecond)
+		assert.True(t, opts.requeue.DryRun)
+		assert.Equal(t, "json", opts.output)
+	})
+
+	t.Run("it should reject invalid flags", func(t *testing.T) {
+		invalidArgs := [][]string{
+			{"-status=running", "-past-seconds=60"},
+			{"-status=queued"},
+			{"-status=queued", "-past-seconds=60", "-priority=urgent"},
+			{"-status=queued", "-past-seconds=60", "-output=xml"},
+		}
+
+		for _, args := range invalidArgs {
+			_, err := parseOnceFlags(args)
+			assert.Error(t, err, args)
+		}
+	})
+}
+
+func Test_parseReconcileFlags(t *testing.T) {
+	t.Run("it should set the defaults", func(t *testing.T) {
+		opts, err := parseReconcileFlags([]string{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 100, opts.reconciliation.SampleSize)
+		assert.Equal(t, int32(60), opts.reconciliation.GraceSeconds)
+		assert.False(t, opts.reconciliation.Fix)
+		assert.Equal(t, "text", opts.output)
+	})
+
+	t.Run("it should reject invalid flags", func(t *testing.T) {
+		invalidArgs := [][]string{
+			{"-sample-size=0"},
+			{"-grace-seconds=-1"},
+			{"-output=xml"},
+		}
+
+		for _, args := range invalidArgs {
+			_, err := parseReconcileFlags(args)
+			assert.Error(t, err, args)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/server"
+	"github.com/sf7293/task-manager/pkg/process"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// setupBatchRoutes adds the APIs which create the batches, report their progress and cancel them
+func setupBatchRoutes(r *gin.Engine, serverLogic *server.ServerLogic) {
+	batches := r.Group("/batches")
+	batches.POST("", func(c *gin.Context) {
+		req := domain.RouterRequestAddBatch{}
+		err := c.ShouldBindBodyWith(&req, binding.JSON)
+		if err != nil {
+			slog.Error("error occurred while binding request", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{})
+			return
+		}
+		req.Caller = c.GetString(callerKey)
+
+		createdBatch, err := serverLogic.AddBatch(c, req)
+		if err != nil {
+			var validationErr *process.PayloadValidationError
+			if errors.As(err, &validationErr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "fields": validationErr.Fields})
+				return
+			}
+			if errors.Is(err, errval.ErrInvalidBatch) || errors.Is(err, errval.ErrInvalidTaskType) || errors.Is(err, errval.ErrInvalidPayload) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, errval.ErrForbidden) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, createdBatch)
+	})
+
+	batches.GET("/:id", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+		if err != nil {
+			slog.Error("Invalid id parameter, error occurred while casting id str to int", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		progress, err := serverLogic.GetBatch(c, int32(id))
+		if err != nil {
+			if errors.Is(err, errval.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, progress)
+	})
+
+	batches.POST("/:id/cancel", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+		if err != nil {
+			slog.Error("Invalid id parameter, error occurred while casting id str to int", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		progress, err := serverLogic.CancelBatch(c, int32(id))
+		if err != nil {
+			if errors.Is(err, errval.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, progress)
+	})
+}
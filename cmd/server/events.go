@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/server"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// taskEventName is the event field of the status changes in the streams
+	taskEventName = "task.status_changed"
+	// eventsKeepaliveInterval is shorter than the idle timeouts of the usual proxies, so the quiet streams aren't closed by them
+	eventsKeepaliveInterval = 15 * time.Second
+
+	defaultTaskWaitTimeout = 30 * time.Second
+	maxTaskWaitTimeout     = 120 * time.Second
+)
+
+// setupEventRoutes adds the APIs which are woken by the status changes of the tasks, the Server-Sent Events streams and the long-poll wait
+func setupEventRoutes(r *gin.Engine, serverLogic *server.ServerLogic) {
+	r.GET("/tasks/:id/events", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+		if err != nil {
+			slog.Error("Invalid id parameter, error occurred while casting id str to int", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		taskID := int32(id)
+		streamTaskEvents(c, serverLogic, domain.TaskEventsFilter{TaskID: &taskID})
+	})
+
+	r.GET("/events", func(c *gin.Context) {
+		streamTaskEvents(c, serverLogic, domain.TaskEventsFilter{TaskType: c.Query("type"), NewStatus: c.Query("status")})
+	})
+
+	r.GET("/tasks/:id/wait", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+		if err != nil {
+			slog.Error("Invalid id parameter, error occurred while casting id str to int", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		timeout := defaultTaskWaitTimeout
+		if timeoutStr := c.Query("timeout"); timeoutStr != "" {
+			timeout, err = parseTaskWaitTimeout(timeoutStr)
+			if err != nil || timeout <= 0 || timeout > maxTaskWaitTimeout {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timeout"})
+				return
+			}
+		}
+
+		task, isReached, err := serverLogic.WaitForTask(c, int32(id), c.DefaultQuery("until", server.TaskWaitUntilTerminal), timeout)
+		if err != nil {
+			if errors.Is(err, errval.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{})
+				return
+			}
+			if errors.Is(err, errval.ErrInvalidTaskStatus) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"task": task, "is_reached": isReached})
+	})
+}
+
+// parseTaskWaitTimeout accepts a duration like 30s, or a number of seconds
+func parseTaskWaitTimeout(timeoutStr string) (time.Duration, error) {
+	seconds, err := strconv.ParseInt(timeoutStr, 10, 64)
+	if err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return time.ParseDuration(timeoutStr)
+}
+
+// streamTaskEvents writes the events of the filter until the client goes away, the events after the Last-Event-ID header are replayed first
+func streamTaskEvents(c *gin.Context, serverLogic *server.ServerLogic, filter domain.TaskEventsFilter) {
+	var lastEventID int32
+	if lastEventIDStr := c.GetHeader("Last-Event-ID"); lastEventIDStr != "" {
+		id, err := strconv.ParseInt(lastEventIDStr, 10, 32)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Last-Event-ID"})
+			return
+		}
+		lastEventID = int32(id)
+	}
+
+	subscription, err := serverLogic.SubscribeTaskEvents(c, filter, lastEventID)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{})
+			return
+		}
+		if errors.Is(err, errval.ErrInvalidTaskType) || errors.Is(err, errval.ErrInvalidTaskStatus) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{})
+		return
+	}
+	defer subscription.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Disables the response buffering of nginx, otherwise the events are only received in chunks
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	for {
+		event, err := subscription.Next(ctx, eventsKeepaliveInterval)
+		if err != nil {
+			if ctx.Err() == nil {
+				// The client resumes the stream by reconnecting with the id of its last event
+				slog.Info("Task events stream is closed", "error", err.Error())
+			}
+			return
+		}
+
+		if event == nil {
+			_, err = fmt.Fprint(c.Writer, ": keepalive\n\n")
+		} else {
+			err = writeTaskEvent(c, event)
+		}
+		if err != nil {
+			slog.Info("Error occurred while writing to the task events stream", "error", err.Error())
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeTaskEvent(c *gin.Context, event *domain.TaskEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, taskEventName, data)
+	return err
+}
@@ -11,11 +11,22 @@ import (
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/sf7293/task-manager/configs"
 	db2 "github.com/sf7293/task-manager/db"
+	"github.com/sf7293/task-manager/internal/aging"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
 	"github.com/sf7293/task-manager/internal/domain"
 	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/eventbus"
+	"github.com/sf7293/task-manager/internal/events"
+	"github.com/sf7293/task-manager/internal/metrics"
 	"github.com/sf7293/task-manager/internal/postgres"
 	"github.com/sf7293/task-manager/internal/rabbitmq"
+	"github.com/sf7293/task-manager/internal/recovery"
 	"github.com/sf7293/task-manager/internal/server"
+	"github.com/sf7293/task-manager/internal/webhook"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/process"
+	"github.com/sf7293/task-manager/pkg/tasktypes"
 	"log"
 	"log/slog"
 	"net/http"
@@ -29,6 +40,11 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 )
 
+const (
+	defaultReconciliationSampleSize   = 100
+	defaultReconciliationGraceSeconds = 60
+)
+
 var postgresIsReady, rabbitIsReady bool
 
 func main() {
@@ -57,10 +73,18 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ServerTimeOutInSeconds)*time.Second)
 	defer cancel()
 
-	storage, err := postgres.NewStorage(ctx, cfg.Database.ToDbConnectionUri())
+	pool, err := postgres.NewPool(ctx, cfg.Database.ToDbConnectionUri())
+	if err != nil {
+		log.Fatal(err)
+	}
+	templateStorage := postgres.NewTemplateStorage(pool)
+
+	taskTypes, err := tasktypes.NewDefaultRegistry(cfg, templateStorage)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	storage := postgres.NewStorage(pool, taskTypes)
 	postgresIsReady = true
 	slog.Info("Postgres connection has been initialized successfully")
 
@@ -85,11 +109,79 @@ func main() {
 	exitChan := make(chan os.Signal, 1)
 	signal.Notify(exitChan, syscall.SIGINT, syscall.SIGTERM)
 
-	router := setupHTTPServer(storage, rabbitClient, cfg.RabbitMQ.HighPriorityJobsQueueName, cfg.RabbitMQ.NormalPriorityJobsQueueName, cfg.RabbitMQ.LowPriorityJobsQueueName)
+	dispatcher := dispatch.NewDispatcher(rabbitClient, cfg.RabbitMQ.GetPriorityQueueNames())
+	workflowEngine := workflow.NewEngine(postgres.NewWorkflowStorage(pool, taskTypes), dispatcher, cfg.Recovery.MaxAttempts)
+	batchTracker := batch.NewTracker(postgres.NewBatchStorage(pool, taskTypes), dispatcher, cfg.Recovery.MaxAttempts)
+	webhookStorage := postgres.NewWebhookStorage(pool)
+
+	if cfg.Aging.Enabled {
+		// The aging loop must outlive the initialization context, so it gets its own context which is cancelled on shutdown
+		agingCtx, stopAging := context.WithCancel(context.Background())
+		defer stopAging()
+
+		ager := aging.NewAger(storage, dispatcher, []aging.Rule{
+			{FromPriority: domain.Low, ToPriority: domain.Normal, AfterSeconds: cfg.Aging.LowToNormalAfterSeconds},
+			{FromPriority: domain.Normal, ToPriority: domain.High, AfterSeconds: cfg.Aging.NormalToHighAfterSeconds},
+		}, time.Duration(cfg.Aging.IntervalInSeconds)*time.Second, cfg.Aging.MaxPromotionsPerIteration)
+		go ager.Run(agingCtx)
+		slog.Info("Priority aging loop has been started", "interval_in_seconds", cfg.Aging.IntervalInSeconds)
+	}
+
+	if cfg.Webhook.DeliveryEnabled {
+		if cfg.Webhook.SigningSecret == "" {
+			// The callbacks are kept pending in the outbox, so they are delivered after the server is restarted with a secret
+			slog.Warn("Webhook delivery loop is not started, since WEBHOOK_SIGNING_SECRET is empty")
+		} else {
+			// The delivery loop must outlive the initialization context like the aging loop
+			webhookCtx, stopWebhooks := context.WithCancel(context.Background())
+			defer stopWebhooks()
+
+			deliverer := webhook.NewDeliverer(webhookStorage, webhook.Settings{
+				SigningSecret:  cfg.Webhook.SigningSecret,
+				Interval:       time.Duration(cfg.Webhook.IntervalInSeconds) * time.Second,
+				BatchSize:      cfg.Webhook.BatchSize,
+				MaxAttempts:    cfg.Webhook.MaxAttempts,
+				InitialBackoff: time.Duration(cfg.Webhook.InitialBackoffInSeconds) * time.Second,
+				MaxBackoff:     time.Duration(cfg.Webhook.MaxBackoffInSeconds) * time.Second,
+				Timeout:        time.Duration(cfg.Webhook.TimeoutInSeconds) * time.Second,
+			})
+			go deliverer.Run(webhookCtx)
+			slog.Info("Webhook delivery loop has been started", "interval_in_seconds", cfg.Webhook.IntervalInSeconds)
+		}
+	}
+
+	if cfg.EventBus.Enabled {
+		// The publisher loop must outlive the initialization context like the aging loop
+		eventBusCtx, stopEventBus := context.WithCancel(context.Background())
+		defer stopEventBus()
+
+		publisher := eventbus.NewPublisher(postgres.NewLifecycleEventStorage(pool), rabbitClient, eventbus.Settings{
+			Exchange:     cfg.EventBus.Exchange,
+			Interval:     time.Duration(cfg.EventBus.IntervalInSeconds) * time.Second,
+			BatchSize:    cfg.EventBus.BatchSize,
+			LeaseSeconds: cfg.EventBus.LeaseInSeconds,
+		})
+		go publisher.Run(eventBusCtx)
+		slog.Info("Event bus publisher loop has been started", "exchange", cfg.EventBus.Exchange)
+	}
+
+	// The event hub must outlive the initialization context like the aging loop
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	defer stopEvents()
+	eventHub := events.NewHub(postgres.NewEventStorage(pool), events.Settings{
+		SubscriberBufferSize: cfg.Events.SubscriberBufferSize,
+		MaxReplayedEvents:    cfg.Events.MaxReplayedEvents,
+		ReconnectInterval:    time.Duration(cfg.Events.ReconnectIntervalInSeconds) * time.Second,
+	})
+	go eventHub.Run(eventsCtx)
+
+	router := setupHTTPServer(cfg.APIKeys, storage, templateStorage, rabbitClient, taskTypes, workflowEngine, batchTracker, webhookStorage, eventHub, cfg.Recovery.MaxAttempts, cfg.RabbitMQ.HighPriorityJobsQueueName, cfg.RabbitMQ.NormalPriorityJobsQueueName, cfg.RabbitMQ.LowPriorityJobsQueueName)
 	srv := &http.Server{
 		Addr:    ":" + cfg.ServerPort,
 		Handler: router,
 	}
+	// The event streams never end by themselves, so the hub is stopped to drop them before the server waits for its connections to be closed
+	srv.RegisterOnShutdown(stopEvents)
 
 	// Initializing the server in a goroutine so that
 	// it won't block the graceful shutdown handling below
@@ -111,10 +203,13 @@ func main() {
 	log.Println("Server exiting")
 }
 
-func setupHTTPServer(storage domain.Storage, rabbitClient *rabbitmq.RabbitMQClient, rabbitHighPriorityJobsQueueName, rabbitNormalPriorityJobsQueueName, rabbitLowPriorityJobsQueueName string) *gin.Engine {
+func setupHTTPServer(apiKeys map[string]string, storage domain.Storage, templateStorage domain.TemplateStorage, rabbitClient *rabbitmq.RabbitMQClient, taskTypes *process.Registry, workflowEngine *workflow.Engine, batchTracker *batch.Tracker, webhookStorage domain.WebhookStorage, eventHub *events.Hub, maxAttempts int32, rabbitHighPriorityJobsQueueName, rabbitNormalPriorityJobsQueueName, rabbitLowPriorityJobsQueueName string) *gin.Engine {
 	r := gin.Default()
+	// The handlers pass the gin context to the server logic, so the values of the request context must be reachable through it
+	r.ContextWithFallback = true
+	r.Use(metrics.Middleware, traceContextMiddleware, newCallerMiddleware(apiKeys))
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
-		err := v.RegisterValidation("validate_task_type", validateTaskType)
+		err := v.RegisterValidation("validate_task_type", newTaskTypeValidator(taskTypes))
 		if err != nil {
 			log.Fatal("failed to bind validation rule of validate_task_type")
 		}
@@ -130,7 +225,7 @@ func setupHTTPServer(storage domain.Storage, rabbitClient *rabbitmq.RabbitMQClie
 		}
 	}
 
-	serverLogic := server.NewServerLogic(storage, rabbitClient, rabbitHighPriorityJobsQueueName, rabbitNormalPriorityJobsQueueName, rabbitLowPriorityJobsQueueName)
+	serverLogic := server.NewServerLogic(storage, templateStorage, rabbitClient, taskTypes, workflowEngine, batchTracker, webhookStorage, eventHub, maxAttempts, rabbitHighPriorityJobsQueueName, rabbitNormalPriorityJobsQueueName, rabbitLowPriorityJobsQueueName)
 	tasks := r.Group("/tasks")
 	tasks.POST("", func(c *gin.Context) {
 		req := domain.RouterRequestAddTask{}
@@ -141,9 +236,24 @@ func setupHTTPServer(storage domain.Storage, rabbitClient *rabbitmq.RabbitMQClie
 			c.JSON(http.StatusBadRequest, gin.H{})
 			return
 		}
+		req.Caller = c.GetString(callerKey)
 
 		addedTaskID, err := serverLogic.AddTask(c, req)
 		if err != nil {
+			var validationErr *process.PayloadValidationError
+			if errors.As(err, &validationErr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": errval.ErrInvalidPayload.Error(), "fields": validationErr.Fields})
+				return
+			}
+			if errors.Is(err, errval.ErrInvalidTaskType) || errors.Is(err, errval.ErrInvalidPayload) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, errval.ErrForbidden) {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 			return
 		}
@@ -174,6 +284,29 @@ func setupHTTPServer(storage domain.Storage, rabbitClient *rabbitmq.RabbitMQClie
 		c.JSON(http.StatusOK, gin.H{"status": taskStatus})
 	})
 
+	tasks.GET("/:id/result", func(c *gin.Context) {
+		idStr := c.Param("id")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			slog.Error("Invalid id parameter, error occurred while casting id str to int", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		taskResult, err := serverLogic.GetTaskResult(c, int32(id))
+		if err != nil {
+			if err == errval.ErrNotFound {
+				c.JSON(http.StatusNotFound, gin.H{})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"result": taskResult})
+	})
+
 	tasks.GET("/:id/history", func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.ParseInt(idStr, 10, 64)
@@ -194,9 +327,68 @@ func setupHTTPServer(storage domain.Storage, rabbitClient *rabbitmq.RabbitMQClie
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"history": taskHistory})
+		// Priority changes are only made by the aging mechanism, so they might not exist for the task
+		taskPriorityHistory, err := serverLogic.GetTaskPriorityHistory(c, int32(id))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"history": taskHistory, "priority_history": taskPriorityHistory})
 	})
 
+	r.GET("/task-types", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"task_types": serverLogic.GetTaskTypes()})
+	})
+
+	// The admin group is shared by the APIs of the webhook deliveries and the queues
+	admin := r.Group("/admin")
+	setupTemplateRoutes(r, serverLogic)
+	setupWorkflowRoutes(r, serverLogic)
+	setupBatchRoutes(r, serverLogic)
+	setupEventRoutes(r, serverLogic)
+	setupWebhookRoutes(admin, serverLogic)
+
+	// Reconciliation reads a sample of each queue, so it's exposed under the admin group which must not be public
+	reconcileQueues := func(c *gin.Context, fix bool) {
+		opts := recovery.ReconciliationOptions{
+			SampleSize:   defaultReconciliationSampleSize,
+			GraceSeconds: defaultReconciliationGraceSeconds,
+			Fix:          fix,
+		}
+		if sampleSizeStr := c.Query("sample_size"); sampleSizeStr != "" {
+			sampleSize, err := strconv.Atoi(sampleSizeStr)
+			if err != nil || sampleSize <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sample_size"})
+				return
+			}
+			opts.SampleSize = sampleSize
+		}
+		if graceSecondsStr := c.Query("grace_seconds"); graceSecondsStr != "" {
+			graceSeconds, err := strconv.ParseInt(graceSecondsStr, 10, 32)
+			if err != nil || graceSeconds < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grace_seconds"})
+				return
+			}
+			opts.GraceSeconds = int32(graceSeconds)
+		}
+
+		report, err := serverLogic.ReconcileQueues(c, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+	admin.GET("/queues/reconciliation", func(c *gin.Context) {
+		reconcileQueues(c, false)
+	})
+	admin.POST("/queues/reconciliation", func(c *gin.Context) {
+		reconcileQueues(c, true)
+	})
+
+	r.GET("/metrics", metrics.Handler())
 	r.GET("/readiness", func(c *gin.Context) {
 		if postgresIsReady && rabbitIsReady {
 			c.JSON(http.StatusOK, gin.H{"status": "ready"})
@@ -226,31 +418,56 @@ func setupHTTPServer(storage domain.Storage, rabbitClient *rabbitmq.RabbitMQClie
 	return r
 }
 
-var validateTaskType validator.Func = func(fl validator.FieldLevel) bool {
-	taskType := fl.Field().String()
-	switch taskType {
-	case string(domain.SendEmail), string(domain.RunQuery):
-		return true
-	default:
-		return false
+// traceContextMiddleware puts the W3C trace context headers of the request into its context, so they are carried by the messages of the queued tasks
+func traceContextMiddleware(c *gin.Context) {
+	traceContext := dispatch.TraceContext{
+		TraceParent: c.GetHeader(dispatch.HeaderTraceParent),
+		TraceState:  c.GetHeader(dispatch.HeaderTraceState),
 	}
+	c.Request = c.Request.WithContext(dispatch.WithTraceContext(c.Request.Context(), traceContext))
+	c.Next()
 }
 
-var validatePayload validator.Func = func(fl validator.FieldLevel) bool {
-	payloadStr := fl.Field().String()
-	if payloadStr == "null" {
-		return false
+// callerKey is the key of the authenticated caller of the request in the gin context
+const callerKey = "caller"
+
+// newCallerMiddleware identifies the caller of the request by its X-API-Key header, the requests without any key have no caller
+// The caller is never taken from a header which the client names itself, since any client could claim any caller by it
+func newCallerMiddleware(apiKeys map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetHeader("X-API-Key")
+		if apiKey == "" {
+			c.Next()
+			return
+		}
+
+		caller, ok := apiKeys[apiKey]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid API key"})
+			return
+		}
+		c.Set(callerKey, caller)
+		c.Next()
 	}
+}
 
-	unmarshalledPayload := map[string]string{}
-	err := json.Unmarshal([]byte(payloadStr), &unmarshalledPayload)
-	if err != nil {
-		slog.Error("An error occurred while unmarshalling payload to map[string]string", "error", err.Error())
+// newTaskTypeValidator only accepts the task types which are registered in the registry
+func newTaskTypeValidator(taskTypes *process.Registry) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return taskTypes.IsRegistered(fl.Field().String())
+	}
+}
+
+var validatePayload validator.Func = func(fl validator.FieldLevel) bool {
+	payload, ok := fl.Field().Interface().(json.RawMessage)
+	if !ok {
 		return false
 	}
 
-	// If map must not be empty, uncomment the following rule
-	if len(unmarshalledPayload) == 0 {
+	// The payload is only checked to be a JSON object here, its fields are validated by its task type
+	_, err := domain.UnwrapPayload(payload)
+	if err != nil {
+		slog.Error("An error occurred while validating payload", "error", err.Error())
 		return false
 	}
 
@@ -6,12 +6,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/sf7293/task-manager/configs"
 	db2 "github.com/sf7293/task-manager/db"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/events"
 	"github.com/sf7293/task-manager/internal/postgres"
 	"github.com/sf7293/task-manager/internal/rabbitmq"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/tasktypes"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"log"
@@ -20,6 +28,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/database/pgx/v5"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
@@ -60,14 +69,24 @@ func TestMain(t *testing.M) {
 	slog.Info("Migrations rolled back successfully")
 }
 
+var testAPIKeys = map[string]string{"test-reporting-key": "reporting"}
+
 func runTestServer() *httptest.Server {
 	cfg := configs.InitConfig()
 
 	ctx := context.Background()
-	storage, err := postgres.NewStorage(ctx, cfg.Database.ToTestDBConnectionUri())
+	pool, err := postgres.NewPool(ctx, cfg.Database.ToTestDBConnectionUri())
 	if err != nil {
 		log.Fatal(err)
 	}
+	templateStorage := postgres.NewTemplateStorage(pool)
+
+	taskTypes, err := tasktypes.NewDefaultRegistry(cfg, templateStorage)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage := postgres.NewStorage(pool, taskTypes)
 	slog.Info("Postgres connection has been initialized successfully")
 
 	mainQueueNames := cfg.RabbitMQ.GetMainQueueNamesForTest()
@@ -88,7 +107,13 @@ func runTestServer() *httptest.Server {
 
 	// I have considered all the queues as one test queue
 	// TODO: have different test jobs queue for each priority and test whether the workers work true for each priority or not
-	return httptest.NewServer(setupHTTPServer(storage, rabbitClient, cfg.RabbitMQ.TestJobsQueueName, cfg.RabbitMQ.TestJobsQueueName, cfg.RabbitMQ.TestJobsQueueName))
+	testQueueNames := domain.PriorityQueueNames{High: cfg.RabbitMQ.TestJobsQueueName, Normal: cfg.RabbitMQ.TestJobsQueueName, Low: cfg.RabbitMQ.TestJobsQueueName}
+	dispatcher := dispatch.NewDispatcher(rabbitClient, testQueueNames)
+	workflowEngine := workflow.NewEngine(postgres.NewWorkflowStorage(pool, taskTypes), dispatcher, cfg.Recovery.MaxAttempts)
+	batchTracker := batch.NewTracker(postgres.NewBatchStorage(pool, taskTypes), dispatcher, cfg.Recovery.MaxAttempts)
+	eventHub := events.NewHub(postgres.NewEventStorage(pool), events.Settings{SubscriberBufferSize: 16, MaxReplayedEvents: 100, ReconnectInterval: time.Second})
+	go eventHub.Run(context.Background())
+	return httptest.NewServer(setupHTTPServer(testAPIKeys, storage, templateStorage, rabbitClient, taskTypes, workflowEngine, batchTracker, postgres.NewWebhookStorage(pool), eventHub, cfg.Recovery.MaxAttempts, cfg.RabbitMQ.TestJobsQueueName, cfg.RabbitMQ.TestJobsQueueName, cfg.RabbitMQ.TestJobsQueueName))
 }
 
 func Test_liveness_api(t *testing.T) {
@@ -128,9 +153,13 @@ func Test_create_task_api(t *testing.T) {
 	t.Run("it should return 200 when health is ok", func(t *testing.T) {
 		// Define the request payload as a map
 		payload := map[string]interface{}{
-			"name":    "sample_task_1",
-			"type":    "send_email",
-			"payload": `{"item1":"value1"}`,
+			"name": "sample_task_1",
+			"type": "send_email",
+			"payload": map[string]interface{}{
+				"to":      []string{"user@example.com"},
+				"subject": "sample subject",
+				"body":    "sample body",
+			},
 		}
 
 		// Convert the payload to JSON
@@ -182,8 +211,427 @@ func Test_create_task_api(t *testing.T) {
 	})
 }
 
+func Test_create_workflow_api(t *testing.T) {
+	ts := runTestServer()
+	defer ts.Close()
+
+	sendWorkflow := func(tasks []map[string]interface{}) *http.Response {
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"name":           "sample_workflow",
+			"failure_policy": "skip_dependents",
+			"tasks":          tasks,
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		resp, err := http.Post(fmt.Sprintf("%s/workflows", ts.URL), "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		return resp
+	}
+	emailTask := func(key string, dependsOn ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"key":        key,
+			"name":       "sample_task_" + key,
+			"type":       "send_email",
+			"depends_on": dependsOn,
+			"payload": map[string]interface{}{
+				"to":      []string{"user@example.com"},
+				"subject": "sample subject",
+				"body":    "sample body",
+			},
+		}
+	}
+
+	t.Run("it should only queue the tasks without any dependency", func(t *testing.T) {
+		resp := sendWorkflow([]map[string]interface{}{emailTask("first"), emailTask("second", "first")})
+		defer resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+
+		createdWorkflow := domain.RouterResponseAddWorkflow{}
+		err := json.NewDecoder(resp.Body).Decode(&createdWorkflow)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		assert.Len(t, createdWorkflow.TaskIDs, 2)
+
+		reportResp, err := http.Get(fmt.Sprintf("%s/workflows/%d", ts.URL, createdWorkflow.WorkflowID))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer reportResp.Body.Close()
+		assert.Equal(t, 200, reportResp.StatusCode)
+
+		report := workflow.Report{}
+		err = json.NewDecoder(reportResp.Body).Decode(&report)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		assert.Equal(t, domain.WorkflowQueued, report.Status)
+		assert.Equal(t, domain.SkipDependents, report.FailurePolicy)
+		assert.Equal(t, map[string]int{"queued": 1, "pending": 1}, report.TaskCounts)
+	})
+
+	t.Run("it should return 400 when the dependencies have a cycle", func(t *testing.T) {
+		resp := sendWorkflow([]map[string]interface{}{emailTask("first", "second"), emailTask("second", "first")})
+		defer resp.Body.Close()
+		assert.Equal(t, 400, resp.StatusCode)
+	})
+
+	t.Run("it should return 404 when the workflow doesn't exist", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/workflows/%d", ts.URL, 1000000))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode)
+	})
+}
+
+func Test_create_batch_api(t *testing.T) {
+	ts := runTestServer()
+	defer ts.Close()
+
+	emailTask := map[string]interface{}{
+		"name": "sample_batch_task",
+		"type": "send_email",
+		"payload": map[string]interface{}{
+			"to":      []string{"user@example.com"},
+			"subject": "sample subject",
+			"body":    "sample body",
+		},
+	}
+
+	t.Run("it should queue all the tasks of the batch", func(t *testing.T) {
+		jsonData, err := json.Marshal(map[string]interface{}{
+			"name":  "sample_batch",
+			"tasks": []map[string]interface{}{emailTask, emailTask, emailTask},
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		resp, err := http.Post(fmt.Sprintf("%s/batches", ts.URL), "application/json", bytes.NewBuffer(jsonData))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, 200, resp.StatusCode)
+
+		createdBatch := domain.RouterResponseAddBatch{}
+		err = json.NewDecoder(resp.Body).Decode(&createdBatch)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		assert.Len(t, createdBatch.TaskIDs, 3)
+
+		progressResp, err := http.Get(fmt.Sprintf("%s/batches/%d", ts.URL, createdBatch.BatchID))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer progressResp.Body.Close()
+		assert.Equal(t, 200, progressResp.StatusCode)
+
+		progress := batch.Progress{}
+		err = json.NewDecoder(progressResp.Body).Decode(&progress)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		assert.Equal(t, batch.Running, progress.Status)
+		assert.Equal(t, int32(3), progress.TotalCount)
+		assert.Equal(t, int32(3), progress.RemainingCount)
+	})
+
+	t.Run("it should return 400 when the batch has no task", func(t *testing.T) {
+		resp, err := http.Post(fmt.Sprintf("%s/batches", ts.URL), "application/json", bytes.NewBufferString(`{"name": "empty_batch", "tasks": []}`))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, 400, resp.StatusCode)
+	})
+
+	t.Run("it should return 404 when the batch doesn't exist", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/batches/%d", ts.URL, 1000000))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		assert.Equal(t, 404, resp.StatusCode)
+	})
+}
+
+func Test_wait_task_api(t *testing.T) {
+	ts := runTestServer()
+	defer ts.Close()
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"name": "sample_wait_task",
+		"type": "send_email",
+		"payload": map[string]interface{}{
+			"to":      []string{"user@example.com"},
+			"subject": "sample subject",
+			"body":    "sample body",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("%s/tasks", ts.URL), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	createdTask := map[string]int32{}
+	err = json.NewDecoder(resp.Body).Decode(&createdTask)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	taskID := createdTask["added_task_id"]
+
+	t.Run("it should return the task immediately when it has already reached the status", func(t *testing.T) {
+		waitResp, err := http.Get(fmt.Sprintf("%s/tasks/%d/wait?until=queued&timeout=5s", ts.URL, taskID))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer waitResp.Body.Close()
+		assert.Equal(t, 200, waitResp.StatusCode)
+
+		waitResult := struct {
+			Task      domain.Task `json:"task"`
+			IsReached bool        `json:"is_reached"`
+		}{}
+		err = json.NewDecoder(waitResp.Body).Decode(&waitResult)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		assert.True(t, waitResult.IsReached)
+		assert.Equal(t, taskID, waitResult.Task.ID)
+	})
+
+	t.Run("it should return the task after the timeout when it hasn't finished", func(t *testing.T) {
+		startedAt := time.Now()
+		waitResp, err := http.Get(fmt.Sprintf("%s/tasks/%d/wait?timeout=1", ts.URL, taskID))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer waitResp.Body.Close()
+		assert.Equal(t, 200, waitResp.StatusCode)
+		assert.GreaterOrEqual(t, time.Since(startedAt), time.Second)
+
+		waitResult := struct {
+			IsReached bool `json:"is_reached"`
+		}{}
+		err = json.NewDecoder(waitResp.Body).Decode(&waitResult)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		assert.False(t, waitResult.IsReached)
+	})
+
+	t.Run("it should return the task immediately without reaching the status when it has finished in another status", func(t *testing.T) {
+		cfg := configs.InitConfig()
+		pool, err := postgres.NewPool(context.Background(), cfg.Database.ToTestDBConnectionUri())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer pool.Close()
+		taskTypes, err := tasktypes.NewDefaultRegistry(cfg, postgres.NewTemplateStorage(pool))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		storage := postgres.NewStorage(pool, taskTypes)
+		for _, statuses := range [][2]domain.TaskStatus{{domain.Queued, domain.Running}, {domain.Running, domain.Succeeded}} {
+			err = storage.UpdateTaskStatusAndLogChangeInTx(context.Background(), taskID, string(statuses[0]), string(statuses[1]))
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+
+		startedAt := time.Now()
+		waitResp, err := http.Get(fmt.Sprintf("%s/tasks/%d/wait?until=failed&timeout=5s", ts.URL, taskID))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer waitResp.Body.Close()
+		assert.Equal(t, 200, waitResp.StatusCode)
+		assert.Less(t, time.Since(startedAt), 5*time.Second)
+
+		waitResult := struct {
+			Task      domain.Task `json:"task"`
+			IsReached bool        `json:"is_reached"`
+		}{}
+		err = json.NewDecoder(waitResp.Body).Decode(&waitResult)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		assert.False(t, waitResult.IsReached)
+		assert.Equal(t, string(domain.Succeeded), waitResult.Task.Status)
+	})
+
+	t.Run("it should return 400 for an invalid until", func(t *testing.T) {
+		waitResp, err := http.Get(fmt.Sprintf("%s/tasks/%d/wait?until=done", ts.URL, taskID))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer waitResp.Body.Close()
+		assert.Equal(t, 400, waitResp.StatusCode)
+	})
+
+	t.Run("it should return 404 for a task which doesn't exist", func(t *testing.T) {
+		waitResp, err := http.Get(fmt.Sprintf("%s/tasks/%d/wait?timeout=1s", ts.URL, int32(2147483647)))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer waitResp.Body.Close()
+		assert.Equal(t, 404, waitResp.StatusCode)
+	})
+}
+
 // TODO for tests:
 // 1 - Development of tests fo other APIs (all APIs)
 // 2 - Development of tests for running job worker and checking that:
 // - Status of tasks are changed
 // - History of changes are available in db (or by checking /tasks/:id/history) API
+
+func Test_permanently_failed_task(t *testing.T) {
+	cfg := configs.InitConfig()
+
+	ctx := context.Background()
+	pool, err := postgres.NewPool(ctx, cfg.Database.ToTestDBConnectionUri())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer pool.Close()
+	taskTypes, err := tasktypes.NewDefaultRegistry(cfg, postgres.NewTemplateStorage(pool))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	storage := postgres.NewStorage(pool, taskTypes)
+
+	// failTask inserts a task, runs it once and fails it
+	failTask := func(isPermanent bool) int32 {
+		payload := json.RawMessage(`{"to":["user@example.com"],"subject":"sample subject","body":"sample body"}`)
+		task, err := storage.InsertTask(ctx, "sample_failed_task", "send_email", string(domain.Queued), string(domain.Normal), payload, "", nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, string(domain.Queued), string(domain.Running))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if isPermanent {
+			err = storage.FailTaskPermanentlyAndLogChangeInTx(ctx, task.ID, string(domain.Running), cfg.Recovery.MaxAttempts)
+		} else {
+			err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, string(domain.Running), string(domain.Failed))
+		}
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		return task.ID
+	}
+	failedTaskID := failTask(false)
+	permanentlyFailedTaskID := failTask(true)
+
+	t.Run("it should raise the attempts of the permanently failed task to the max attempts", func(t *testing.T) {
+		task, err := storage.GetTaskByID(ctx, permanentlyFailedTaskID)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		assert.Equal(t, string(domain.Failed), task.Status)
+		assert.Equal(t, cfg.Recovery.MaxAttempts, task.Attempts)
+		assert.True(t, task.IsFinished(cfg.Recovery.MaxAttempts))
+	})
+
+	t.Run("it should only retry the failed task which is not permanently failed", func(t *testing.T) {
+		tasks, err := storage.GetRetryableFailedTasks(ctx, cfg.Recovery.MaxAttempts, 0, 1000)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		retryableTaskIDs := []int32{}
+		for _, task := range tasks {
+			retryableTaskIDs = append(retryableTaskIDs, task.ID)
+		}
+		assert.Contains(t, retryableTaskIDs, failedTaskID)
+		assert.NotContains(t, retryableTaskIDs, permanentlyFailedTaskID)
+	})
+
+	t.Run("it should not fail the task which is not in the current status anymore", func(t *testing.T) {
+		err := storage.FailTaskPermanentlyAndLogChangeInTx(ctx, failedTaskID, string(domain.Running), cfg.Recovery.MaxAttempts)
+		assert.ErrorIs(t, err, errval.ErrStatusConflict)
+	})
+}
+
+func Test_publish_events_after_initialization(t *testing.T) {
+	cfg := configs.InitConfig()
+
+	// The client is created by the initialization context, which has expired before the events are published
+	initCtx, cancelInit := context.WithTimeout(context.Background(), time.Duration(cfg.ServerTimeOutInSeconds)*time.Second)
+	rabbitClient, err := rabbitmq.NewRabbitMQClient(initCtx, cfg.RabbitMQ.ToRabbitConnectionUri(), cfg.RabbitMQ.GetMainQueueNamesForTest())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cancelInit()
+
+	t.Run("it should wait for the confirmations by the context of the publishing", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := rabbitClient.PublishEvents(ctx, "test_task_events", []domain.EventMessage{
+			{RoutingKey: "task.send_email.created", MessageID: "1", Body: []byte(`{"version":1,"id":"1","type":"task.created"}`)},
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func Test_caller_middleware(t *testing.T) {
+	r := gin.New()
+	r.Use(newCallerMiddleware(testAPIKeys))
+	r.GET("/caller", func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString(callerKey))
+	})
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	getCaller := func(headers map[string]string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/caller", nil)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return resp.StatusCode, string(body)
+	}
+
+	t.Run("it should take the caller from the API key", func(t *testing.T) {
+		statusCode, caller := getCaller(map[string]string{"X-API-Key": "test-reporting-key"})
+		assert.Equal(t, 200, statusCode)
+		assert.Equal(t, "reporting", caller)
+	})
+
+	t.Run("it should ignore the caller which is named by the client", func(t *testing.T) {
+		statusCode, caller := getCaller(map[string]string{"X-Caller": "reporting"})
+		assert.Equal(t, 200, statusCode)
+		assert.Equal(t, "", caller)
+	})
+
+	t.Run("it should return 401 for an unknown API key", func(t *testing.T) {
+		statusCode, _ := getCaller(map[string]string{"X-API-Key": "unknown-key"})
+		assert.Equal(t, 401, statusCode)
+	})
+}
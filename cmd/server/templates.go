@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/server"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// setupTemplateRoutes adds the CRUD and the preview APIs of the email templates
+func setupTemplateRoutes(r *gin.Engine, serverLogic *server.ServerLogic) {
+	templates := r.Group("/templates")
+	templates.POST("", func(c *gin.Context) {
+		req := domain.RouterRequestAddTemplate{}
+		err := c.ShouldBindBodyWith(&req, binding.JSON)
+		if err != nil {
+			slog.Error("error occurred while binding request", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{})
+			return
+		}
+
+		template, err := serverLogic.CreateTemplate(c, req)
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, template)
+	})
+
+	templates.GET("", func(c *gin.Context) {
+		templateList, err := serverLogic.GetTemplates(c)
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"templates": templateList})
+	})
+
+	templates.GET("/:template_id", func(c *gin.Context) {
+		locales, err := serverLogic.GetTemplateLocales(c, c.Param("template_id"))
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"templates": locales})
+	})
+
+	templates.DELETE("/:template_id", func(c *gin.Context) {
+		err := serverLogic.DeleteTemplate(c, c.Param("template_id"))
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+
+	templates.POST("/:template_id/preview", func(c *gin.Context) {
+		req := domain.RouterRequestPreviewTemplate{}
+		err := c.ShouldBindBodyWith(&req, binding.JSON)
+		if err != nil {
+			slog.Error("error occurred while binding request", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{})
+			return
+		}
+
+		preview, err := serverLogic.PreviewTemplate(c, c.Param("template_id"), req)
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, preview)
+	})
+
+	templates.GET("/:template_id/:locale", func(c *gin.Context) {
+		var version *int32
+		if versionStr := c.Query("version"); versionStr != "" {
+			parsedVersion, err := strconv.ParseInt(versionStr, 10, 32)
+			if err != nil || parsedVersion < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+				return
+			}
+			templateVersion := int32(parsedVersion)
+			version = &templateVersion
+		}
+
+		template, err := serverLogic.GetTemplate(c, c.Param("template_id"), c.Param("locale"), version)
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, template)
+	})
+
+	templates.PUT("/:template_id/:locale", func(c *gin.Context) {
+		req := domain.RouterRequestUpdateTemplate{}
+		err := c.ShouldBindBodyWith(&req, binding.JSON)
+		if err != nil {
+			slog.Error("error occurred while binding request", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{})
+			return
+		}
+
+		template, err := serverLogic.UpdateTemplate(c, c.Param("template_id"), c.Param("locale"), req)
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, template)
+	})
+
+	templates.DELETE("/:template_id/:locale", func(c *gin.Context) {
+		err := serverLogic.DeleteTemplateLocale(c, c.Param("template_id"), c.Param("locale"))
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+
+	templates.GET("/:template_id/:locale/versions", func(c *gin.Context) {
+		versions, err := serverLogic.GetTemplateVersions(c, c.Param("template_id"), c.Param("locale"))
+		if err != nil {
+			respondTemplateError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"templates": versions})
+	})
+}
+
+// respondTemplateError maps the errors of the template APIs to their HTTP status
+func respondTemplateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errval.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{})
+	case errors.Is(err, errval.ErrTemplateExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errval.ErrInvalidTemplate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/server"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultWebhookDeliveriesLimit = 50
+	maxWebhookDeliveriesLimit     = 500
+)
+
+// setupWebhookRoutes adds the admin APIs which list the deliveries of the callbacks and redeliver the failed ones
+func setupWebhookRoutes(admin *gin.RouterGroup, serverLogic *server.ServerLogic) {
+	deliveries := admin.Group("/webhooks/deliveries")
+	deliveries.GET("", func(c *gin.Context) {
+		filter := domain.WebhookDeliveriesFilter{Limit: defaultWebhookDeliveriesLimit}
+		if statusStr := c.Query("status"); statusStr != "" {
+			status := domain.WebhookDeliveryStatus(statusStr)
+			switch status {
+			case domain.WebhookPending, domain.WebhookDelivered, domain.WebhookFailed:
+				filter.Status = &status
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+				return
+			}
+		}
+		if taskIDStr := c.Query("task_id"); taskIDStr != "" {
+			taskID, err := strconv.ParseInt(taskIDStr, 10, 32)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task_id"})
+				return
+			}
+			taskID32 := int32(taskID)
+			filter.TaskID = &taskID32
+		}
+		if limitStr := c.Query("limit"); limitStr != "" {
+			limit, err := strconv.ParseInt(limitStr, 10, 32)
+			if err != nil || limit <= 0 || limit > maxWebhookDeliveriesLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+				return
+			}
+			filter.Limit = int32(limit)
+		}
+
+		webhookDeliveries, err := serverLogic.ListWebhookDeliveries(c, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"deliveries": webhookDeliveries})
+	})
+
+	deliveries.POST("/:id/redeliver", func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 32)
+		if err != nil {
+			slog.Error("Invalid id parameter, error occurred while casting id str to int", "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+			return
+		}
+
+		delivery, err := serverLogic.RedeliverWebhookDelivery(c, int32(id))
+		if err != nil {
+			if errors.Is(err, errval.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{})
+				return
+			}
+			if errors.Is(err, errval.ErrNotRedeliverable) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{})
+			return
+		}
+
+		c.JSON(http.StatusOK, delivery)
+	})
+}
@@ -2,17 +2,24 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/gin-gonic/gin"
 	"github.com/sf7293/task-manager/configs"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
 	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/heartbeat"
+	"github.com/sf7293/task-manager/internal/metrics"
 	"github.com/sf7293/task-manager/internal/postgres"
 	"github.com/sf7293/task-manager/internal/rabbitmq"
 	"github.com/sf7293/task-manager/internal/redis"
-	process2 "github.com/sf7293/task-manager/pkg/process"
+	"github.com/sf7293/task-manager/internal/subtask"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/process"
+	"github.com/sf7293/task-manager/pkg/tasktypes"
 	"log"
 	"log/slog"
 	"net/http"
@@ -57,8 +64,9 @@ func main() {
 		return
 	}
 
-	// Setting up a context with cfg.WorkerTimeOutInSeconds seconds time out, which limits the task process time with a timeout of cfg.WorkerTimeOutInSeconds seconds
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.WorkerTimeOutInSeconds)*time.Second)
+	// The connections and the consumer must live as long as the worker, so they use a context without timeout
+	// The cfg.WorkerTimeOutInSeconds timeout is applied to the process of each task separately in the handler
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	mainQueueNames := cfg.RabbitMQ.GetMainQueueNames()
@@ -88,89 +96,149 @@ func main() {
 	redisIsReady = true
 	slog.Info("Redis connection has been initialized successfully")
 
-	storage, err := postgres.NewStorage(ctx, cfg.Database.ToDbConnectionUri())
+	pool, err := postgres.NewPool(ctx, cfg.Database.ToDbConnectionUri())
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	taskTypes, err := tasktypes.NewDefaultRegistry(cfg, postgres.NewTemplateStorage(pool))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storage := postgres.NewStorage(pool, taskTypes)
 	postgresIsReady = true
 	slog.Info("Postgres connection has been initialized successfully")
 
-	handlerFunc := func(input string) {
-		task := new(domain.Task)
-		err := json.Unmarshal([]byte(input), &task)
+	// The dependents of the finished tasks are queued by the worker, the failed tasks are considered finished with the same max attempts as the recovery
+	dispatcher := dispatch.NewDispatcher(rabbitClient, cfg.RabbitMQ.GetPriorityQueueNames())
+	workflowStorage := postgres.NewWorkflowStorage(pool, taskTypes)
+	workflowEngine := workflow.NewEngine(workflowStorage, dispatcher, cfg.Recovery.MaxAttempts)
+	// The finished members of the batches are counted by the worker too, and the last one fires the completion of its batch
+	batchTracker := batch.NewTracker(postgres.NewBatchStorage(pool, taskTypes), dispatcher, cfg.Recovery.MaxAttempts)
+	// The processes spawn their child tasks through the manager, and the last finished child finishes its waiting parent
+	subtaskManager := subtask.NewManager(postgres.NewChildTaskStorage(pool, taskTypes), dispatcher, taskTypes, workflowEngine, batchTracker, cfg.Recovery.MaxAttempts)
+	payloadResolver := workflow.NewResolver(workflowStorage, cfg.Workflow.MaxReferenceBytes, cfg.Workflow.MaxResolvedPayloadBytes)
+
+	// failTask moves the task from its current status to failed, and moves its workflow, batch and waiting parent forward when it's finished
+	// A permanently failed task has its attempts raised to the max attempts, so it's finished and the recovery doesn't retry it
+	failTask := func(ctx context.Context, task *domain.Task, isPermanent bool) {
+		currentStatus := task.Status
+		slog.Info(fmt.Sprintf("Updating task state from from '%s' to 'failed'", currentStatus), "task_id", task.ID, "is_permanent", isPermanent)
+		var err error
+		if isPermanent {
+			err = storage.FailTaskPermanentlyAndLogChangeInTx(ctx, task.ID, currentStatus, cfg.Recovery.MaxAttempts)
+		} else {
+			err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, currentStatus, string(domain.Failed))
+		}
 		if err != nil {
-			slog.Error("There was an error in unmarshalling the item", "error", err)
+			slog.Error("There was an error in updating task status to failed", "error", err, "task_id", task.ID)
 			return
 		}
-		slog.Info("Task is picked up from the queue", "task_id", task.ID)
+		slog.Info(fmt.Sprintf("Task state is changed from '%s' to 'failed'", currentStatus), "task_id", task.ID, "is_permanent", isPermanent)
 
-		if task.Status != string(domain.Queued) && task.Status != string(domain.Failed) {
-			slog.Error("Task with invalid status has been pushed to queue, ignoring the task...", "task_id", task.ID, "task_status", task.Status)
+		task.Status = string(domain.Failed)
+		if isPermanent {
+			task.Attempts = max(task.Attempts, cfg.Recovery.MaxAttempts)
+		}
+		err = workflowEngine.TaskFinished(ctx, task)
+		if err != nil {
+			// The workflow is moved forward later by the recovery
+			slog.Error("There was an error in moving the workflow of the failed task forward", "error", err, "task_id", task.ID)
+		}
+		err = batchTracker.TaskFinished(ctx, task)
+		if err != nil {
+			// The task is counted later by the recovery
+			slog.Error("There was an error in counting the failed task in its batch", "error", err, "task_id", task.ID)
+		}
+		err = subtaskManager.TaskFinished(ctx, task)
+		if err != nil {
+			// The parent is finished later by the recovery
+			slog.Error("There was an error in finishing the parent of the failed task", "error", err, "task_id", task.ID)
+		}
+	}
+
+	// The consumer name must be unique for each worker, so I've added workerNumber to it
+	// It's also used as the owner of the task locks, so only this worker is able to renew or release them
+	consumerName := "my-consumer:" + workerNumber
+	heartbeatInterval := time.Duration(cfg.WorkerHeartbeatIntervalInSeconds) * time.Second
+	// The lock outlives a few missed heartbeats, so a short hiccup of Redis doesn't let another worker take the task
+	lockDuration := 3 * heartbeatInterval
+
+	handlerFunc := func(input string) {
+		// Both the envelope and the raw tasks which are published by the older servers are accepted
+		message, err := dispatch.Decode(input)
+		if err != nil {
+			slog.Error("There was an error in unmarshalling the item", "error", err)
 			return
 		}
+		slog.Info("Task is picked up from the queue", "task_id", message.TaskID, "message_id", message.MessageID, "schema_version", message.SchemaVersion, "attempt", message.Attempt, "traceparent", message.TraceContext.TraceParent)
+
+		// The context of the task is cancelled when the worker loses the lease of the task, the process time is limited separately below
+		// The trace context of the message is carried to the tasks which are queued while processing the task
+		ctx, cancel := context.WithCancel(dispatch.WithTraceContext(ctx, message.TraceContext))
+		defer cancel()
 
 		// Handling concurrency problems using distributed lock system => A task cannot be processed simultaneously via two workers
-		lockKey := "lock:" + strconv.FormatInt(int64(task.ID), 10)
+		lockKey := "lock:" + strconv.FormatInt(int64(message.TaskID), 10)
 		slog.Info("Locking the key in distributed lock system", "lock_key", lockKey)
-		isLocked, err := redisClient.Lock(lockKey, time.Duration(10)*time.Second)
+		isLocked, err := redisClient.AcquireLease(lockKey, consumerName, lockDuration)
 		if err != nil {
 			slog.Error("Error occurred while locking the key for task", "lock_key", lockKey, "error", err.Error())
 			return
 		}
 		if !isLocked {
-			slog.Error("Concurrent processing error happened for the task, ignoring running current process...", "task_id", task.ID)
+			metrics.LockContentions.Inc()
+			slog.Error("Concurrent processing error happened for the task, ignoring running current process...", "task_id", message.TaskID)
 			return
 		}
 		slog.Info("Key is locked successfully for the task in the distributed lock infra", "lock_key", lockKey)
 		defer func() {
-			err = redisClient.Unlock(lockKey)
+			err = redisClient.ReleaseLease(lockKey, consumerName)
 			if err != nil {
 				slog.Error("Error while unlocking locked key", "lock_key", lockKey, "err", err.Error())
 			}
 		}()
 
-		// First unmarshal to get the inner JSON string
-		var innerJSONString string
-		err = json.Unmarshal([]byte(task.PayLoad), &innerJSONString)
+		// The message only points to the task, so the stored task is the source of truth
+		task, err := storage.GetTaskByID(ctx, message.TaskID)
 		if err != nil {
-			slog.Error("Error occurred while unmarshalling inner JSON string:", "error", err.Error(), "task_id", task.ID, "payload", task.PayLoad)
-			slog.Info(fmt.Sprintf("Updating task state from from '%s' to 'failed'", task.Status), "task_id", task.ID)
-			err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, task.Status, string(domain.Failed))
-			if err != nil {
-				slog.Error("There was an error in updating task status to failed", "error", err, "task_id", task.ID)
-				return
-			}
-			slog.Info(fmt.Sprintf("Task state is changed from '%s' to 'failed'", task.Status), "task_id", task.ID)
+			slog.Error("Error occurred while fetching the task from storage", "task_id", message.TaskID, "error", err.Error())
 			return
 		}
 
-		paramsMap := map[string]string{}
-		err = json.Unmarshal([]byte(innerJSONString), &paramsMap)
-		if err != nil {
-			slog.Error("Failed to unmarshal innerJSONString to params map[string]string", "error", err, "task_id", task.ID, "inner_json_str", innerJSONString, "error", err.Error())
-			slog.Info(fmt.Sprintf("Updating task state from from '%s' to 'failed'", task.Status), "task_id", task.ID)
-			err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, task.Status, string(domain.Failed))
-			if err != nil {
-				slog.Error("There was an error in updating task status to failed", "error", err, "task_id", task.ID)
-				return
-			}
-			slog.Info(fmt.Sprintf("Task state is changed from '%s' to 'failed'", task.Status), "task_id", task.ID)
+		// The aging mechanism re-queues a promoted task into a higher priority queue, so the copy left in the lower priority queue must be ignored
+		if message.TaskPriority != "" && message.TaskPriority != task.Priority {
+			slog.Info("Task priority has been changed after queueing, ignoring the stale message...", "task_id", task.ID, "queued_priority", message.TaskPriority, "stored_priority", task.Priority)
+			return
+		}
+
+		if task.Status != string(domain.Queued) && task.Status != string(domain.Failed) {
+			slog.Error("Task with invalid status has been pushed to queue, ignoring the task...", "task_id", task.ID, "task_status", task.Status)
 			return
 		}
-		slog.Info("Payload field of the task, is marshalled into a map[string]string", "task_id", task.ID)
 
-		process, err := process2.NewProcess(domain.TaskType(task.Type))
+		taskProcess, err := taskTypes.NewProcess(task.Type)
 		if err != nil {
 			slog.Error("Error while creating process for the task", "task_id", task.ID, "task_type", task.Type, "error", err.Error())
-			slog.Info(fmt.Sprintf("Updating task state from from '%s' to 'failed'", task.Status), "task_id", task.ID)
-			// Updating task status to failed
-			err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, task.Status, string(domain.Failed))
+			// Retrying a task of an unknown type won't help
+			failTask(ctx, task, true)
+			return
+		}
+
+		// The payload is decoded into the payload struct of its task type, which also validates it
+		// The payloads which refer to the results of the parent tasks are decoded after their references are resolved
+		definition, _ := taskTypes.Lookup(task.Type)
+		hasReferences := task.WorkflowID != nil && workflow.HasReferences(task.PayLoad)
+		var payload process.Payload
+		if !hasReferences {
+			payload, err = definition.DecodePayload(task.PayLoad)
 			if err != nil {
-				slog.Error("There was an error in updating task status to failed", "error", err, "task_id", task.ID)
+				slog.Error("Error occurred while decoding the payload of the task", "task_id", task.ID, "task_type", task.Type, "payload", string(task.PayLoad), "error", err.Error())
+				// Retrying an invalid payload won't help
+				failTask(ctx, task, true)
 				return
 			}
-			slog.Info(fmt.Sprintf("Task state is changed from '%s' to 'failed'", task.Status), "task_id", task.ID)
-			return
 		}
 
 		// Atomic changing task status, and insertion of the log in the tasks_status_change_history table
@@ -181,28 +249,134 @@ func main() {
 			return
 		}
 		slog.Info(fmt.Sprintf("Task state is changed from '%s' to 'running'", task.Status), "task_id", task.ID)
+		if task.Attempts == 0 {
+			metrics.TaskQueueWait.WithLabelValues(task.Type, task.Priority).Observe(time.Since(time.Unix(task.CreatedAtStamp, 0)).Seconds())
+		} else {
+			metrics.TaskRetries.WithLabelValues(task.Type, metrics.RetryAttempt).Inc()
+		}
 
+		// Heartbeats keep the running lease of the task alive, otherwise the reaper considers the worker dead and takes the task back
+		taskHeartbeat := heartbeat.NewHeartbeat(storage, redisClient, task.ID, lockKey, consumerName, heartbeatInterval, lockDuration, cancel)
+		taskHeartbeat.Start(ctx)
+		defer taskHeartbeat.Stop()
+
+		// Setting up a context with the timeout of the task type, which limits the task process time including all the retries
+		// cfg.WorkerTimeOutInSeconds is used for the task types which don't define their own timeout
+		// The status of the task is still updated with the task context after the process is timed out
+		timeout := time.Duration(cfg.WorkerTimeOutInSeconds) * time.Second
+		if definition.Timeout > 0 {
+			timeout = definition.Timeout
+		}
+		executionCtx, cancelExecution := context.WithTimeout(ctx, timeout)
+		defer cancelExecution()
+
+		deadline, _ := executionCtx.Deadline()
+		taskLogger := slog.With("task_id", task.ID, "task_type", task.Type)
+		taskCtx := &process.TaskContext{
+			TaskID: task.ID,
+			// The attempts of the task are increased by the transition to running
+			Attempt:  task.Attempts + 1,
+			Deadline: deadline,
+			Logger:   taskLogger,
+			Progress: process.LogProgressReporter{Logger: taskLogger},
+			Payload:  payload,
+			Caller:   task.Caller,
+			Spawner:  subtaskManager,
+		}
+
+		retryBackOff := &retryAfterBackOff{BackOff: backoff.NewExponentialBackOff()}
+		executionsCount := 0
 		operation := func() error {
-			return process.Execute(paramsMap)
+			executionsCount++
+			if executionsCount > 1 {
+				metrics.TaskRetries.WithLabelValues(task.Type, metrics.RetryInProcess).Inc()
+			}
+			err := taskProcess.Execute(executionCtx, taskCtx)
+			if process.IsPermanent(err) {
+				// Retrying permanent errors won't help, like sending an email to an invalid address
+				return backoff.Permanent(err)
+			}
+			if delay, ok := process.RetryDelay(err); ok {
+				if time.Now().Add(delay).After(deadline) {
+					// Waiting for the requested delay would time out the task, so the failed task is left to be retried later by the recovery
+					slog.Warn("Requested retry delay is after the deadline of the task", "task_id", task.ID, "delay", delay.String())
+					return backoff.Permanent(err)
+				}
+				retryBackOff.delay = delay
+			}
+
+			return err
 		}
 
-		// Implementation of retrial of the operation, in case of failure
-		err = backoff.Retry(operation, backoff.NewExponentialBackOff())
+		// The references are resolved after the task is moved to running, so a task whose references can't be resolved fails like the other failed tasks
+		if hasReferences {
+			taskCtx.Payload, err = resolvePayload(ctx, payloadResolver, taskTypes, definition, task)
+			if err != nil {
+				slog.Error("Error occurred while resolving the references of the payload", "task_id", task.ID, "task_type", task.Type, "error", err.Error())
+			}
+		}
+
+		// Implementation of retrial of the operation, in case of failure, the number of retries is defined by the task type
+		executionStart := time.Now()
+		if err == nil {
+			err = backoff.Retry(operation, backoff.WithContext(backoff.WithMaxRetries(retryBackOff, definition.MaxRetries), executionCtx))
+		}
 		if err != nil {
-			slog.Error("Error has happened while doing the task", "task_id", task.ID, "task_type", task.Type, "params", paramsMap)
+			// A permanent error fails the task for good, so neither the worker nor the recovery retries it
+			isPermanent := process.IsPermanent(err)
+			outcome := metrics.OutcomeFailed
+			if errors.Is(executionCtx.Err(), context.DeadlineExceeded) {
+				outcome = metrics.OutcomeTimedOut
+				slog.Error("Task is timed out", "task_id", task.ID, "task_type", task.Type, "timeout", timeout.String())
+			}
+			metrics.TaskExecutionDuration.WithLabelValues(task.Type, outcome).Observe(time.Since(executionStart).Seconds())
+			slog.Error("Error has happened while doing the task", "task_id", task.ID, "task_type", task.Type, "error", err.Error())
+
+			// The result of a failed task is kept too, like the output of a shell command which exits with an error
+			if result := taskCtx.Result(); result != nil {
+				err = storage.SetTaskResult(ctx, task.ID, result)
+				if err != nil {
+					slog.Error("There was an error in storing the task result", "error", err, "task_id", task.ID)
+				}
+			}
+
+			// The attempts of the stored task are increased by the transition to running
+			task.Status = string(domain.Running)
+			task.Attempts++
+			failTask(ctx, task, isPermanent)
+			return
+		}
+
+		// The result is stored before the task is marked as succeeded, so the clients never see a succeeded task without its result
+		if result := taskCtx.Result(); result != nil {
+			err = storage.SetTaskResult(ctx, task.ID, result)
+			if err != nil {
+				slog.Error("There was an error in storing the task result", "error", err, "task_id", task.ID)
+				return
+			}
+		}
 
-			// Updating task status to failed
-			err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, string(domain.Running), string(domain.Failed))
+		// The task which waits for its children is finished when its last child finishes, or right away when its children have already finished
+		if taskCtx.WaitsForChildren() {
+			metrics.TaskExecutionDuration.WithLabelValues(task.Type, metrics.OutcomeWaiting).Observe(time.Since(executionStart).Seconds())
+			slog.Info("Updating task state from 'running' to 'waiting'", "task_id", task.ID)
+			err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, string(domain.Running), string(domain.Waiting))
 			if err != nil {
-				slog.Error("There was an error in updating task status to failed", "error", err, "task_id", task.ID)
+				slog.Error("There was an error in updating task status to waiting", "error", err, "task_id", task.ID)
 				return
 			}
+			slog.Info("Task state is changed from 'running' to 'waiting'", "task_id", task.ID)
 
-			slog.Info("Task state is changed from 'running' to 'failed'", "task_id", task.ID)
+			err = subtaskManager.TaskWaiting(ctx, task)
+			if err != nil {
+				// The task is finished later by the recovery
+				slog.Error("There was an error in finishing the waiting task", "error", err, "task_id", task.ID)
+			}
 			return
 		}
 
 		// Updating task status to succeeded
+		metrics.TaskExecutionDuration.WithLabelValues(task.Type, metrics.OutcomeSucceeded).Observe(time.Since(executionStart).Seconds())
 		slog.Info("Updating task state from 'running' to 'succeeded'", "task_id", task.ID)
 		err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, string(domain.Running), string(domain.Succeeded))
 		if err != nil {
@@ -211,6 +385,21 @@ func main() {
 		}
 		slog.Info("Task state is changed from 'running' to 'succeeded'", "task_id", task.ID)
 
+		task.Status = string(domain.Succeeded)
+		task.Attempts++
+		err = workflowEngine.TaskFinished(ctx, task)
+		if err != nil {
+			slog.Error("There was an error in moving the workflow of the succeeded task forward", "error", err, "task_id", task.ID)
+		}
+		err = batchTracker.TaskFinished(ctx, task)
+		if err != nil {
+			slog.Error("There was an error in counting the succeeded task in its batch", "error", err, "task_id", task.ID)
+		}
+		err = subtaskManager.TaskFinished(ctx, task)
+		if err != nil {
+			slog.Error("There was an error in finishing the parent of the succeeded task", "error", err, "task_id", task.ID)
+		}
+
 		slog.Info("Task running has been successfully finished", "task_id", task.ID, "task_type", task.Type)
 		return
 	}
@@ -227,9 +416,7 @@ func main() {
 		queueName = cfg.RabbitMQ.LowPriorityJobsQueueName
 	}
 
-	consumerName := "my-consumer:" + workerNumber
 	slog.Info("Creating consumer for RabbitMQ", "queueName", queueName, "consumer_name", consumerName)
-	// The consumer name must be unique for each worker, so I've added workerNumber to it
 	err = rabbitClient.ConsumeMessages(consumerName, queueName, handlerFunc)
 	if err != nil {
 		log.Fatalf("Failed to start consuming messages: %v", err)
@@ -278,6 +465,8 @@ func setUpHealthCheckerAPIs(ctx context.Context, cfg *configs.Config, storage do
 		}
 	})
 
+	r.GET("/metrics", metrics.Handler())
+
 	srv := &http.Server{
 		Addr:    ":" + cfg.ServerPort,
 		Handler: r,
@@ -297,3 +486,55 @@ func setUpHealthCheckerAPIs(ctx context.Context, cfg *configs.Config, storage do
 	<-quit
 	log.Println("Shutting down server...")
 }
+
+// retryAfterBackOff waits at least the delay which is requested by the last error of the process, like the Retry-After header of HTTP responses
+type retryAfterBackOff struct {
+	backoff.BackOff
+	delay time.Duration
+}
+
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	if next == backoff.Stop {
+		return next
+	}
+	if b.delay > next {
+		next = b.delay
+	}
+	b.delay = 0
+
+	return next
+}
+
+// resolvePayload replaces the references of the payload with the results of the parent tasks
+// The resolved payload is validated and authorized here, since the server has skipped it for the payloads with references
+// The results of the finished parents don't change, so only the errors of the storage are retryable and the rest are permanent
+func resolvePayload(ctx context.Context, resolver *workflow.Resolver, taskTypes *process.Registry, definition process.Definition, task *domain.Task) (process.Payload, error) {
+	resolvedPayload, err := resolver.Resolve(ctx, task)
+	if err != nil {
+		if errors.Is(err, errval.ErrUnresolvedReference) {
+			return nil, process.Permanent(err)
+		}
+
+		return nil, err
+	}
+
+	err = taskTypes.ValidatePayload(task.Type, resolvedPayload)
+	if err != nil {
+		return nil, process.Permanent(fmt.Errorf("resolved payload doesn't match the schema of %s tasks: %w", task.Type, err))
+	}
+
+	payload, err := definition.DecodePayload(resolvedPayload)
+	if err != nil {
+		return nil, process.Permanent(err)
+	}
+
+	if definition.Authorize != nil {
+		err = definition.Authorize(task.Caller, payload)
+		if err != nil {
+			return nil, process.Permanent(fmt.Errorf("caller is not allowed to run the task with the resolved payload: %w", err))
+		}
+	}
+
+	return payload, nil
+}
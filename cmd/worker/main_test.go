@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/process"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// fakeWorkflowStorage returns the parents of every task, or err
+type fakeWorkflowStorage struct {
+	domain.WorkflowStorage
+	parents []*domain.Task
+	err     error
+}
+
+func (f *fakeWorkflowStorage) GetParentTasks(ctx context.Context, taskID int32) ([]*domain.Task, error) {
+	return f.parents, f.err
+}
+
+type echoPayload struct {
+	Message string `json:"message"`
+}
+
+func (p *echoPayload) Validate() error {
+	return nil
+}
+
+func newEchoDefinition(authorize func(caller string, payload process.Payload) error) process.Definition {
+	return process.Definition{
+		Name:          "echo",
+		PayloadSchema: json.RawMessage(`{"type":"object","properties":{"message":{"type":"string"}},"required":["message"]}`),
+		NewPayload: func() process.Payload {
+			return &echoPayload{}
+		},
+		Factory: func() process.Process {
+			return nil
+		},
+		Authorize: authorize,
+	}
+}
+
+func Test_resolvePayload(t *testing.T) {
+	workflowID := int32(1)
+	task := &domain.Task{ID: 2, Type: "echo", WorkflowID: &workflowID, PayLoad: json.RawMessage(`{"message":"{{ tasks.extract.result.message }}"}`)}
+	resolve := func(storage *fakeWorkflowStorage, definition process.Definition) (process.Payload, error) {
+		taskTypes := process.NewRegistry()
+		err := taskTypes.Register(definition)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		return resolvePayload(context.Background(), workflow.NewResolver(storage, 1024, 4096), taskTypes, definition, task)
+	}
+
+	t.Run("it should resolve the references by the results of the parents", func(t *testing.T) {
+		payload, err := resolve(&fakeWorkflowStorage{parents: []*domain.Task{{WorkflowKey: "extract", Result: json.RawMessage(`{"message":"hi"}`)}}}, newEchoDefinition(nil))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "hi", payload.(*echoPayload).Message)
+	})
+
+	t.Run("it should fail permanently when the references can't be resolved", func(t *testing.T) {
+		parentResults := []json.RawMessage{nil, json.RawMessage(`null`), json.RawMessage(`{"rows":[]}`), json.RawMessage(`{"message":1}`)}
+		for _, parentResult := range parentResults {
+			_, err := resolve(&fakeWorkflowStorage{parents: []*domain.Task{{WorkflowKey: "extract", Result: parentResult}}}, newEchoDefinition(nil))
+
+			assert.True(t, process.IsPermanent(err), string(parentResult))
+		}
+	})
+
+	t.Run("it should fail permanently when the caller is not allowed", func(t *testing.T) {
+		definition := newEchoDefinition(func(caller string, payload process.Payload) error {
+			return errors.New("caller is not allowed")
+		})
+		_, err := resolve(&fakeWorkflowStorage{parents: []*domain.Task{{WorkflowKey: "extract", Result: json.RawMessage(`{"message":"hi"}`)}}}, definition)
+
+		assert.True(t, process.IsPermanent(err))
+	})
+
+	t.Run("it should keep the errors of the storage retryable", func(t *testing.T) {
+		_, err := resolve(&fakeWorkflowStorage{err: errors.New("connection reset")}, newEchoDefinition(nil))
+
+		assert.Error(t, err)
+		assert.False(t, process.IsPermanent(err))
+	})
+}
@@ -1,20 +1,38 @@
 package configs
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/sf7293/task-manager/internal/domain"
 	"log"
 	"os"
 )
 
 type Config struct {
 	ServerPort             string `envconfig:"SERVER_PORT" default:"8080"`
-	ServerTimeOutInSeconds int64  `envconfig:"SERVER_TIME_OUT_IN_SECONDS" default:5`
-	WorkerTimeOutInSeconds int64  `envconfig:"WORKER_TIME_OUT_IN_SECONDS" default:15`
-	Database               DatabaseConfig
-	RabbitMQ               RabbitMQConfig
-	RedisConfig            RedisConfig
+	ServerTimeOutInSeconds int64  `envconfig:"SERVER_TIME_OUT_IN_SECONDS" default:"5"`
+	WorkerTimeOutInSeconds int64  `envconfig:"WORKER_TIME_OUT_IN_SECONDS" default:"15"`
+	// WorkerHeartbeatIntervalInSeconds must be a few times shorter than RECOVERY_RUNNING_LEASE_IN_SECONDS, otherwise the reaper takes back tasks of healthy workers
+	WorkerHeartbeatIntervalInSeconds int64 `envconfig:"WORKER_HEARTBEAT_INTERVAL_IN_SECONDS" default:"10"`
+	Database                         DatabaseConfig
+	RabbitMQ                         RabbitMQConfig
+	RedisConfig                      RedisConfig
+	Aging                            AgingConfig
+	Recovery                         RecoveryConfig
+	SMTP                             SMTPConfig
+	Query                            QueryConfig
+	HTTPRequest                      HTTPRequestConfig
+	ShellCommand                     ShellCommandConfig
+	Plugins                          PluginsConfig
+	Workflow                         WorkflowConfig
+	Webhook                          WebhookConfig
+	Events                           EventsConfig
+	EventBus                         EventBusConfig
+
+	// APIKeys maps the API keys of the clients to their callers, e.g. key1:reporting,key2:billing, the clients send their key by the X-API-Key header
+	APIKeys map[string]string `envconfig:"API_KEYS"`
 }
 
 type DatabaseConfig struct {
@@ -47,6 +65,166 @@ type RedisConfig struct {
 	DBIndex  int32  `envconfig:"REDIS_DB_INDEX"`
 }
 
+// AgingConfig controls promotion of tasks which have been waiting too long in a lower priority queue
+type AgingConfig struct {
+	Enabled                   bool  `envconfig:"AGING_ENABLED" default:"true"`
+	IntervalInSeconds         int64 `envconfig:"AGING_INTERVAL_IN_SECONDS" default:"30"`
+	LowToNormalAfterSeconds   int32 `envconfig:"AGING_LOW_TO_NORMAL_AFTER_SECONDS" default:"300"`
+	NormalToHighAfterSeconds  int32 `envconfig:"AGING_NORMAL_TO_HIGH_AFTER_SECONDS" default:"600"`
+	MaxPromotionsPerIteration int32 `envconfig:"AGING_MAX_PROMOTIONS_PER_ITERATION" default:"100"`
+}
+
+// RecoveryConfig controls the reconciler which is run by the daemon mode of the recovery command
+type RecoveryConfig struct {
+	IntervalInSeconds       int64  `envconfig:"RECOVERY_INTERVAL_IN_SECONDS" default:"30"`
+	QueuedAfterSeconds      int32  `envconfig:"RECOVERY_QUEUED_AFTER_SECONDS" default:"300"`
+	RunningLeaseInSeconds   int32  `envconfig:"RECOVERY_RUNNING_LEASE_IN_SECONDS" default:"60"`
+	FailedRetryAfterSeconds int32  `envconfig:"RECOVERY_FAILED_RETRY_AFTER_SECONDS" default:"60"`
+	MaxAttempts             int32  `envconfig:"RECOVERY_MAX_ATTEMPTS" default:"3"`
+	BatchSize               int32  `envconfig:"RECOVERY_BATCH_SIZE" default:"100"`
+	MaxRequeuesPerSecond    int32  `envconfig:"RECOVERY_MAX_REQUEUES_PER_SECOND" default:"50"`
+	LeaderLeaseKey          string `envconfig:"RECOVERY_LEADER_LEASE_KEY" default:"lease:recovery_leader"`
+	LeaderLeaseInSeconds    int64  `envconfig:"RECOVERY_LEADER_LEASE_IN_SECONDS" default:"90"`
+}
+
+// SMTPConfig is used by the workers to send the emails of send_email tasks
+type SMTPConfig struct {
+	Host     string `envconfig:"SMTP_HOST" default:"localhost"`
+	Port     int    `envconfig:"SMTP_PORT" default:"587"`
+	Username string `envconfig:"SMTP_USERNAME"`
+	Password string `envconfig:"SMTP_PASSWORD"`
+	From     string `envconfig:"SMTP_FROM" default:"task-manager@localhost"`
+	// RequireStartTLS must only be disabled for local SMTP servers, otherwise the credentials are sent in plain text
+	RequireStartTLS  bool  `envconfig:"SMTP_REQUIRE_STARTTLS" default:"true"`
+	TimeoutInSeconds int64 `envconfig:"SMTP_TIMEOUT_IN_SECONDS" default:"10"`
+}
+
+// QueryConfig is used by the workers to run the queries of run_query tasks
+type QueryConfig struct {
+	// DataSources is a JSON array, e.g. [{"name":"analytics","driver":"postgres","uri":"postgres://...","allowed_callers":["reporting"]}]
+	DataSources DataSourcesConfig `envconfig:"QUERY_DATA_SOURCES"`
+	// DefaultDataSource is used by the payloads without data_source, like the ones which are created before the data sources were supported
+	DefaultDataSource         string `envconfig:"QUERY_DEFAULT_DATA_SOURCE"`
+	StatementTimeoutInSeconds int64  `envconfig:"QUERY_STATEMENT_TIMEOUT_IN_SECONDS" default:"30"`
+	MaxRows                   int    `envconfig:"QUERY_MAX_ROWS" default:"1000"`
+}
+
+// DataSourceConfig is a named database which run_query tasks are able to query
+type DataSourceConfig struct {
+	Name   string `json:"name"`
+	Driver string `json:"driver"`
+	URI    string `json:"uri"`
+	// AllowWrites lets the payloads with read_write run their queries outside of read-only transactions
+	AllowWrites bool `json:"allow_writes"`
+	// AllowedCallers are the callers which are able to query the data source, "*" allows all callers including the tasks without a caller
+	AllowedCallers []string `json:"allowed_callers"`
+}
+
+// DataSourcesConfig is decoded from JSON, since the connection URIs can't be written in the map format of envconfig
+type DataSourcesConfig []DataSourceConfig
+
+func (d *DataSourcesConfig) Decode(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(value), d)
+}
+
+// HTTPRequestConfig is used by the workers to send the requests of http_request tasks
+type HTTPRequestConfig struct {
+	// MaxResponseBodyBytes caps the response bodies which are stored as the result of the tasks
+	MaxResponseBodyBytes int64 `envconfig:"HTTP_REQUEST_MAX_RESPONSE_BODY_BYTES" default:"65536"`
+}
+
+// ShellCommandConfig is the sandbox of shell_command tasks, no executable is allowed by default
+type ShellCommandConfig struct {
+	// AllowedExecutables is a comma separated list of the absolute paths of the executables which are able to be run
+	AllowedExecutables []string `envconfig:"SHELL_COMMAND_ALLOWED_EXECUTABLES"`
+	// AllowedWorkingDirectories is a comma separated list of the directories which the commands are able to be run in
+	AllowedWorkingDirectories []string `envconfig:"SHELL_COMMAND_ALLOWED_WORKING_DIRECTORIES"`
+	// AllowedEnvNames is a comma separated list of the environment variables which the payloads are able to set, PATH and LD_* are never allowed
+	AllowedEnvNames []string `envconfig:"SHELL_COMMAND_ALLOWED_ENV_NAMES"`
+	// AllowedCallers is a comma separated list of the callers which are able to create the tasks, no caller is allowed by default
+	AllowedCallers []string `envconfig:"SHELL_COMMAND_ALLOWED_CALLERS"`
+	// MaxOutputBytes caps each of stdout and stderr which are stored as the result of the tasks
+	MaxOutputBytes int `envconfig:"SHELL_COMMAND_MAX_OUTPUT_BYTES" default:"65536"`
+}
+
+// WorkflowConfig limits the results which are passed between the tasks of the workflows by the workers
+type WorkflowConfig struct {
+	// MaxReferenceBytes caps each value which a payload refers to in the result of a parent task
+	MaxReferenceBytes int `envconfig:"WORKFLOW_MAX_REFERENCE_BYTES" default:"262144"`
+	// MaxResolvedPayloadBytes caps the payload after all its references are resolved
+	MaxResolvedPayloadBytes int `envconfig:"WORKFLOW_MAX_RESOLVED_PAYLOAD_BYTES" default:"1048576"`
+}
+
+// WebhookConfig controls the delivery of the callbacks of the tasks which have a callback_url, it's used by the server
+type WebhookConfig struct {
+	DeliveryEnabled bool `envconfig:"WEBHOOK_DELIVERY_ENABLED" default:"true"`
+	// SigningSecret is shared with the receivers to verify the X-Webhook-Signature header, nothing is delivered while it's empty
+	SigningSecret           string `envconfig:"WEBHOOK_SIGNING_SECRET"`
+	IntervalInSeconds       int64  `envconfig:"WEBHOOK_INTERVAL_IN_SECONDS" default:"5"`
+	BatchSize               int32  `envconfig:"WEBHOOK_BATCH_SIZE" default:"50"`
+	MaxAttempts             int32  `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"8"`
+	InitialBackoffInSeconds int64  `envconfig:"WEBHOOK_INITIAL_BACKOFF_IN_SECONDS" default:"10"`
+	MaxBackoffInSeconds     int64  `envconfig:"WEBHOOK_MAX_BACKOFF_IN_SECONDS" default:"3600"`
+	TimeoutInSeconds        int64  `envconfig:"WEBHOOK_TIMEOUT_IN_SECONDS" default:"10"`
+}
+
+// EventsConfig controls the Server-Sent Events streams of the status changes of the tasks
+type EventsConfig struct {
+	// SubscriberBufferSize is the number of the events which are kept for a slow client, the client is disconnected when its buffer is full
+	SubscriberBufferSize int `envconfig:"EVENTS_SUBSCRIBER_BUFFER_SIZE" default:"256"`
+	// MaxReplayedEvents caps the missed events which are replayed to a client which reconnects with Last-Event-ID
+	MaxReplayedEvents          int32 `envconfig:"EVENTS_MAX_REPLAYED_EVENTS" default:"1000"`
+	ReconnectIntervalInSeconds int64 `envconfig:"EVENTS_RECONNECT_INTERVAL_IN_SECONDS" default:"5"`
+}
+
+// EventBusConfig controls the publishing of the lifecycle events of the tasks to a RabbitMQ topic exchange, it's used by the server
+type EventBusConfig struct {
+	// Enabled must only be disabled when no service consumes the events, otherwise the events are kept in the outbox until it's enabled
+	Enabled           bool   `envconfig:"EVENT_BUS_ENABLED" default:"true"`
+	Exchange          string `envconfig:"EVENT_BUS_EXCHANGE" default:"task_events"`
+	IntervalInSeconds int64  `envconfig:"EVENT_BUS_INTERVAL_IN_SECONDS" default:"1"`
+	BatchSize         int32  `envconfig:"EVENT_BUS_BATCH_SIZE" default:"100"`
+	// LeaseInSeconds must be longer than publishing a batch takes, the events of a publisher which has died are published again after it
+	LeaseInSeconds int32 `envconfig:"EVENT_BUS_LEASE_IN_SECONDS" default:"30"`
+}
+
+// PluginsConfig registers the out-of-process handlers as task types, it must be the same on the server and the workers
+type PluginsConfig struct {
+	// Handlers is a JSON array, e.g. [{"name":"resize_image","command":"/opt/plugins/resize","timeout_in_seconds":60}]
+	Handlers                   PluginHandlersConfig `envconfig:"PLUGIN_HANDLERS"`
+	MaxMessageBytes            int                  `envconfig:"PLUGIN_MAX_MESSAGE_BYTES" default:"1048576"`
+	CancelGracePeriodInSeconds int64                `envconfig:"PLUGIN_CANCEL_GRACE_PERIOD_IN_SECONDS" default:"5"`
+}
+
+// PluginHandlerConfig is a handler which is either launched by Command for each task, or is listening on the unix Socket
+type PluginHandlerConfig struct {
+	Name    string            `json:"name"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	Socket  string            `json:"socket"`
+	// PayloadSchema is the JSON schema of the payloads, any object is accepted when it's not set
+	PayloadSchema    json.RawMessage `json:"payload_schema"`
+	DefaultPriority  string          `json:"default_priority"`
+	TimeoutInSeconds int64           `json:"timeout_in_seconds"`
+	MaxRetries       uint64          `json:"max_retries"`
+}
+
+// PluginHandlersConfig is decoded from JSON like DataSourcesConfig
+type PluginHandlersConfig []PluginHandlerConfig
+
+func (p *PluginHandlersConfig) Decode(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(value), p)
+}
+
 // ToMigrationUri returns a string specifically for the migration package with the right prefix
 func (d DatabaseConfig) ToMigrationUri() string {
 	return fmt.Sprintf("pgx5://%s:%s@%s:%s/%s?sslmode=%s",
@@ -112,6 +290,15 @@ func (d RabbitMQConfig) GetMainQueueNames() []string {
 	return []string{d.HighPriorityJobsQueueName, d.NormalPriorityJobsQueueName, d.LowPriorityJobsQueueName}
 }
 
+// GetPriorityQueueNames returns the jobs queue name of each task priority
+func (d RabbitMQConfig) GetPriorityQueueNames() domain.PriorityQueueNames {
+	return domain.PriorityQueueNames{
+		High:   d.HighPriorityJobsQueueName,
+		Normal: d.NormalPriorityJobsQueueName,
+		Low:    d.LowPriorityJobsQueueName,
+	}
+}
+
 // GetMainQueueNamesForTest returns a list of important queue names which must be defined before running workers
 // In the test mode, I have listed all main queues to be one separated queue for testing, however, it could be changed to have test queues for each priority in the future
 func (d RabbitMQConfig) GetMainQueueNamesForTest() []string {
@@ -0,0 +1,4 @@
+-- this migration rolls back the history of priority changes
+DROP INDEX tasks_status_priority_updated_at_idx;
+
+DROP TABLE tasks_priority_change_history;
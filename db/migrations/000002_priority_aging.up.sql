@@ -0,0 +1,11 @@
+-- this migration adds the history of priority changes which are made by the aging mechanism
+
+CREATE TABLE tasks_priority_change_history(
+      id SERIAL PRIMARY KEY,
+      task_id INTEGER REFERENCES tasks(id) NOT NULL,
+      old_priority task_priority NOT NULL,
+      new_priority task_priority NOT NULL,
+      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX tasks_status_priority_updated_at_idx ON tasks (status, priority, updated_at);
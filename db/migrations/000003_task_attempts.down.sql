@@ -0,0 +1,2 @@
+-- this migration removes the attempts of tasks
+ALTER TABLE tasks DROP COLUMN attempts;
@@ -0,0 +1,8 @@
+-- this migration adds the number of times that a task has been started by workers, it's used to limit retries of failed tasks
+
+ALTER TABLE tasks ADD COLUMN attempts INTEGER DEFAULT 0 NOT NULL;
+
+-- Tasks which have been processed before this migration get their attempts from the history of their status changes
+UPDATE tasks SET attempts = (
+    SELECT COUNT(*) FROM tasks_status_change_history WHERE tasks_status_change_history.task_id = tasks.id AND new_status = 'running'
+);
@@ -0,0 +1,4 @@
+CREATE TYPE task_type AS ENUM ('send_email', 'run_query');
+
+-- It fails if there are tasks of types which have been registered after this migration
+ALTER TABLE tasks ALTER COLUMN type TYPE task_type USING type::task_type;
@@ -0,0 +1,4 @@
+-- Task types are validated by the task type registry of the app, so adding a new type doesn't need a migration anymore
+ALTER TABLE tasks ALTER COLUMN type TYPE TEXT USING type::text;
+
+DROP TYPE task_type;
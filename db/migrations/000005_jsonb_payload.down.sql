@@ -0,0 +1,2 @@
+-- this migration wraps the payloads in a JSON string again
+ALTER TABLE tasks ALTER COLUMN payload TYPE JSON USING to_json(payload::text);
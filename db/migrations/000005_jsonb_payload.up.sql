@@ -0,0 +1,8 @@
+-- Payloads used to be stored as a JSON string which contains the encoded JSON object, they are unwrapped to the object itself
+-- The trigger of updated_at is disabled during the backfill, otherwise the age of every legacy task is reset, which is used by the recovery and the aging
+-- Disabling the trigger only needs the table to be owned, unlike session_replication_role which needs a superuser
+ALTER TABLE tasks DISABLE TRIGGER update_tasks_table_updated_at;
+UPDATE tasks SET payload = (payload #>> '{}')::json WHERE json_typeof(payload) = 'string';
+ALTER TABLE tasks ENABLE TRIGGER update_tasks_table_updated_at;
+
+ALTER TABLE tasks ALTER COLUMN payload TYPE JSONB USING payload::jsonb;
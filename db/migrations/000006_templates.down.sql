@@ -0,0 +1,2 @@
+-- this migration removes the email templates
+DROP TABLE templates;
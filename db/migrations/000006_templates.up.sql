@@ -0,0 +1,11 @@
+-- this migration adds the email templates, every update of a template is stored as a new version of its locale
+CREATE TABLE templates(
+    template_id VARCHAR(128) NOT NULL,
+    locale VARCHAR(35) NOT NULL,
+    version INTEGER NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT DEFAULT '' NOT NULL,
+    html_body TEXT DEFAULT '' NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (template_id, locale, version)
+);
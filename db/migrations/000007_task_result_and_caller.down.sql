@@ -0,0 +1,4 @@
+-- this migration removes the result and the caller of tasks
+ALTER TABLE tasks DROP COLUMN caller;
+
+ALTER TABLE tasks DROP COLUMN result;
@@ -0,0 +1,4 @@
+-- this migration adds the result of succeeded tasks, and the caller who has created each task
+ALTER TABLE tasks ADD COLUMN result JSONB;
+
+ALTER TABLE tasks ADD COLUMN caller VARCHAR(128);
@@ -0,0 +1,30 @@
+-- this migration removes the workflows
+DROP TABLE task_dependencies;
+
+ALTER TABLE tasks DROP COLUMN workflow_key;
+
+ALTER TABLE tasks DROP COLUMN workflow_id;
+
+DROP TABLE workflows;
+
+-- The values of an enum can't be dropped, so task_status is created again without them, and the tasks which have them are failed
+UPDATE tasks SET status = 'failed' WHERE status IN ('pending', 'skipped', 'cancelled');
+
+DELETE FROM tasks_status_change_history
+WHERE old_status IN ('pending', 'skipped', 'cancelled') OR new_status IN ('pending', 'skipped', 'cancelled');
+
+ALTER TYPE task_status RENAME TO task_status_old;
+
+CREATE TYPE task_status AS ENUM ('queued', 'running', 'failed', 'succeeded');
+
+ALTER TABLE tasks ALTER COLUMN status DROP DEFAULT;
+
+ALTER TABLE tasks ALTER COLUMN status TYPE task_status USING status::text::task_status;
+
+ALTER TABLE tasks ALTER COLUMN status SET DEFAULT 'queued';
+
+ALTER TABLE tasks_status_change_history ALTER COLUMN old_status TYPE task_status USING old_status::text::task_status;
+
+ALTER TABLE tasks_status_change_history ALTER COLUMN new_status TYPE task_status USING new_status::text::task_status;
+
+DROP TYPE task_status_old;
@@ -0,0 +1,27 @@
+-- this migration adds the workflows, which are DAGs of tasks where each task is queued once all of its parents are finished
+-- pending tasks are waiting for their parents, and the tasks which won't run because of a failure are skipped or cancelled
+ALTER TYPE task_status ADD VALUE IF NOT EXISTS 'pending';
+ALTER TYPE task_status ADD VALUE IF NOT EXISTS 'skipped';
+ALTER TYPE task_status ADD VALUE IF NOT EXISTS 'cancelled';
+
+CREATE TABLE workflows(
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(128) NOT NULL,
+    failure_policy VARCHAR(32) NOT NULL,
+    caller VARCHAR(128),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE tasks ADD COLUMN workflow_id INTEGER REFERENCES workflows(id);
+
+ALTER TABLE tasks ADD COLUMN workflow_key VARCHAR(128);
+
+CREATE INDEX tasks_workflow_id_idx ON tasks (workflow_id);
+
+CREATE TABLE task_dependencies(
+    task_id INTEGER REFERENCES tasks(id) NOT NULL,
+    depends_on_task_id INTEGER REFERENCES tasks(id) NOT NULL,
+    PRIMARY KEY (task_id, depends_on_task_id)
+);
+
+CREATE INDEX task_dependencies_depends_on_task_id_idx ON task_dependencies (depends_on_task_id);
@@ -0,0 +1,6 @@
+-- this migration removes the batches
+ALTER TABLE tasks DROP COLUMN batch_counted_at;
+
+ALTER TABLE tasks DROP COLUMN batch_id;
+
+DROP TABLE batches;
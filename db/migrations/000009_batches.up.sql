@@ -0,0 +1,23 @@
+-- this migration adds the batches, which are groups of independent tasks whose completion is tracked by counters
+-- batch_counted_at of a task is set when it's counted in the counters of its batch, so each task is counted only once
+CREATE TABLE batches(
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(128) NOT NULL,
+    caller VARCHAR(128),
+    total_count INTEGER NOT NULL,
+    succeeded_count INTEGER NOT NULL DEFAULT 0,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    cancelled_count INTEGER NOT NULL DEFAULT 0,
+    completion_task JSONB,
+    completion_webhook_url TEXT,
+    completion_task_id INTEGER REFERENCES tasks(id),
+    completion_webhook_task_id INTEGER REFERENCES tasks(id),
+    completed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE tasks ADD COLUMN batch_id INTEGER REFERENCES batches(id);
+
+ALTER TABLE tasks ADD COLUMN batch_counted_at TIMESTAMP;
+
+CREATE INDEX tasks_batch_id_idx ON tasks (batch_id);
@@ -0,0 +1,27 @@
+-- this migration removes the child tasks
+DROP INDEX tasks_parent_id_child_key_idx;
+
+ALTER TABLE tasks DROP COLUMN child_key;
+
+ALTER TABLE tasks DROP COLUMN parent_id;
+
+-- The values of an enum can't be dropped, so task_status is created again without it, and the waiting tasks are failed
+UPDATE tasks SET status = 'failed' WHERE status = 'waiting';
+
+DELETE FROM tasks_status_change_history WHERE old_status = 'waiting' OR new_status = 'waiting';
+
+ALTER TYPE task_status RENAME TO task_status_old;
+
+CREATE TYPE task_status AS ENUM ('queued', 'running', 'failed', 'succeeded', 'pending', 'skipped', 'cancelled');
+
+ALTER TABLE tasks ALTER COLUMN status DROP DEFAULT;
+
+ALTER TABLE tasks ALTER COLUMN status TYPE task_status USING status::text::task_status;
+
+ALTER TABLE tasks ALTER COLUMN status SET DEFAULT 'queued';
+
+ALTER TABLE tasks_status_change_history ALTER COLUMN old_status TYPE task_status USING old_status::text::task_status;
+
+ALTER TABLE tasks_status_change_history ALTER COLUMN new_status TYPE task_status USING new_status::text::task_status;
+
+DROP TYPE task_status_old;
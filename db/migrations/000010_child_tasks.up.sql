@@ -0,0 +1,10 @@
+-- this migration adds the child tasks, which are spawned by a running task and linked to it by parent_id
+-- a parent which waits for its children is waiting after its execution, until all of its children are finished
+ALTER TYPE task_status ADD VALUE IF NOT EXISTS 'waiting';
+
+ALTER TABLE tasks ADD COLUMN parent_id INTEGER REFERENCES tasks(id);
+
+-- child_key is optional, the retried executions of a parent get the existing child with the same key instead of spawning it again
+ALTER TABLE tasks ADD COLUMN child_key VARCHAR(128);
+
+CREATE UNIQUE INDEX tasks_parent_id_child_key_idx ON tasks (parent_id, child_key);
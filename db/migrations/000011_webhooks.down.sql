@@ -0,0 +1,6 @@
+-- this migration removes the webhook callbacks
+DROP TABLE webhook_deliveries;
+
+ALTER TABLE tasks DROP COLUMN callback_events;
+
+ALTER TABLE tasks DROP COLUMN callback_url;
@@ -0,0 +1,27 @@
+-- this migration adds the webhook callbacks, which POST the status changes of the tasks to their callback_url
+-- callback_events filters the new statuses which are posted, all the status changes are posted when it's null
+ALTER TABLE tasks ADD COLUMN callback_url TEXT;
+
+ALTER TABLE tasks ADD COLUMN callback_events TEXT[];
+
+-- webhook_deliveries is the outbox of the callbacks, a delivery is inserted in the same transaction as the status change
+-- next_attempt_at of a claimed delivery is pushed forward by a lease, so the delivery is retried when its sender dies
+CREATE TABLE webhook_deliveries(
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER REFERENCES tasks(id) NOT NULL,
+    url TEXT NOT NULL,
+    old_status task_status NOT NULL,
+    new_status task_status NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP,
+    last_response_status INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE INDEX webhook_deliveries_task_id_idx ON webhook_deliveries (task_id);
@@ -0,0 +1,4 @@
+-- this migration stops notifying the status changes of the tasks
+DROP TRIGGER notify_tasks_status_change_history_insert ON tasks_status_change_history;
+
+DROP FUNCTION notify_task_status_change();
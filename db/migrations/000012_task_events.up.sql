@@ -0,0 +1,21 @@
+-- this migration notifies the task_status_changes channel of every status change of the tasks, so the event streams of all the server replicas receive it
+-- the notification is only sent when the transaction of the status change is committed
+CREATE OR REPLACE FUNCTION notify_task_status_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('task_status_changes', json_build_object(
+        'id', NEW.id,
+        'task_id', NEW.task_id,
+        'task_type', (SELECT type FROM tasks WHERE id = NEW.task_id),
+        'old_status', NEW.old_status,
+        'new_status', NEW.new_status,
+        'created_at_stamp', EXTRACT(EPOCH FROM NEW.created_at)::bigint
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_tasks_status_change_history_insert
+    AFTER INSERT ON tasks_status_change_history
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_task_status_change();
@@ -0,0 +1,10 @@
+-- this migration removes the outbox of the lifecycle events of the tasks
+DROP TRIGGER record_tasks_status_change_history_insert_lifecycle_event ON tasks_status_change_history;
+
+DROP FUNCTION record_task_status_change_lifecycle_event();
+
+DROP TRIGGER record_tasks_insert_lifecycle_event ON tasks;
+
+DROP FUNCTION record_task_created_lifecycle_event();
+
+DROP TABLE task_lifecycle_events;
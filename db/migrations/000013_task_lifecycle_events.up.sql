@@ -0,0 +1,60 @@
+-- this migration adds the outbox of the lifecycle events of the tasks, which are published to the event bus
+-- the events are inserted by triggers in the same transaction as the creation and the status changes of the tasks, so no event is lost when the publisher is down
+-- next_attempt_at of a claimed event is pushed forward by a lease, so the event is published again when its publisher dies, the published events are deleted
+CREATE TABLE task_lifecycle_events(
+    id SERIAL PRIMARY KEY,
+    task_id INTEGER NOT NULL,
+    task_type TEXT NOT NULL,
+    task_priority task_priority NOT NULL,
+    event VARCHAR(16) NOT NULL,
+    old_status task_status,
+    new_status task_status NOT NULL,
+    attempts INTEGER NOT NULL,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX task_lifecycle_events_next_attempt_at_idx ON task_lifecycle_events (next_attempt_at);
+
+-- a task which is created as queued has both the created and the queued events
+CREATE OR REPLACE FUNCTION record_task_created_lifecycle_event()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO task_lifecycle_events (task_id, task_type, task_priority, event, new_status, attempts)
+    VALUES (NEW.id, NEW.type, NEW.priority, 'created', NEW.status, NEW.attempts);
+
+    IF NEW.status = 'queued' THEN
+        INSERT INTO task_lifecycle_events (task_id, task_type, task_priority, event, new_status, attempts)
+        VALUES (NEW.id, NEW.type, NEW.priority, 'queued', NEW.status, NEW.attempts);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_tasks_insert_lifecycle_event
+    AFTER INSERT ON tasks
+    FOR EACH ROW
+    EXECUTE FUNCTION record_task_created_lifecycle_event();
+
+-- a task which is queued again after it has been started is retried, e.g. by the recovery
+CREATE OR REPLACE FUNCTION record_task_status_change_lifecycle_event()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO task_lifecycle_events (task_id, task_type, task_priority, event, old_status, new_status, attempts)
+    SELECT tasks.id, tasks.type, tasks.priority,
+           CASE
+               WHEN NEW.new_status = 'queued' AND NEW.old_status IN ('running', 'failed') THEN 'retried'
+               WHEN NEW.new_status = 'running' THEN 'started'
+               ELSE NEW.new_status::text
+           END,
+           NEW.old_status, NEW.new_status, tasks.attempts
+    FROM tasks
+    WHERE tasks.id = NEW.task_id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_tasks_status_change_history_insert_lifecycle_event
+    AFTER INSERT ON tasks_status_change_history
+    FOR EACH ROW
+    EXECUTE FUNCTION record_task_status_change_lifecycle_event();
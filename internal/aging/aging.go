@@ -0,0 +1,107 @@
+package aging
+
+import (
+	"context"
+	"errors"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"log/slog"
+	"time"
+)
+
+// DefaultInterval is used when the configured interval is not positive, which would make the ticker panic
+const DefaultInterval = 30 * time.Second
+
+// Rule promotes queued tasks of FromPriority to ToPriority when they have been waiting for at least AfterSeconds
+type Rule struct {
+	FromPriority domain.TaskPriority
+	ToPriority   domain.TaskPriority
+	AfterSeconds int32
+}
+
+// Ager periodically promotes the queued tasks which have been waiting too long in a lower priority queue,
+// so tasks of the low queue won't starve under a sustained load of high priority tasks
+type Ager struct {
+	storage                   domain.Storage
+	dispatcher                *dispatch.Dispatcher
+	rules                     []Rule
+	interval                  time.Duration
+	maxPromotionsPerIteration int32
+}
+
+func NewAger(storage domain.Storage, dispatcher *dispatch.Dispatcher, rules []Rule, interval time.Duration, maxPromotionsPerIteration int32) *Ager {
+	if interval <= 0 {
+		slog.Warn("Aging interval is not positive, the default interval is used", "interval", interval.String(), "default_interval", DefaultInterval.String())
+		interval = DefaultInterval
+	}
+
+	return &Ager{
+		storage:                   storage,
+		dispatcher:                dispatcher,
+		rules:                     rules,
+		interval:                  interval,
+		maxPromotionsPerIteration: maxPromotionsPerIteration,
+	}
+}
+
+// Run blocks and promotes aged tasks every interval until the context is cancelled
+func (a *Ager) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Aging loop is stopped")
+			return
+		case <-ticker.C:
+			a.PromoteAgedTasks(ctx)
+		}
+	}
+}
+
+// PromoteAgedTasks runs one iteration of all the rules and returns the number of promoted tasks
+func (a *Ager) PromoteAgedTasks(ctx context.Context) (promotedCount int) {
+	for _, rule := range a.rules {
+		promotedCount += a.applyRule(ctx, rule)
+	}
+
+	return promotedCount
+}
+
+func (a *Ager) applyRule(ctx context.Context, rule Rule) (promotedCount int) {
+	agedTasks, err := a.storage.GetAgedQueuedTasks(ctx, string(rule.FromPriority), rule.AfterSeconds, a.maxPromotionsPerIteration)
+	if err != nil {
+		if !errors.Is(err, errval.ErrNotFound) {
+			slog.Error("Error occurred while fetching aged tasks", "from_priority", rule.FromPriority, "error", err.Error())
+		}
+
+		return 0
+	}
+
+	for _, task := range agedTasks {
+		// The priority is only changed if the task is still queued with the old priority, so concurrent agers won't promote a task twice
+		isUpdated, err := a.storage.UpdateTaskPriorityAndLogChangeInTx(ctx, task.ID, string(rule.FromPriority), string(rule.ToPriority))
+		if err != nil {
+			slog.Error("Error occurred while promoting priority of the task", "task_id", task.ID, "error", err.Error())
+			continue
+		}
+		if !isUpdated {
+			slog.Info("Task has been picked up or promoted meanwhile, skipping the promotion", "task_id", task.ID)
+			continue
+		}
+		slog.Info("Task priority is promoted", "task_id", task.ID, "old_priority", rule.FromPriority, "new_priority", rule.ToPriority)
+		promotedCount++
+
+		task.Priority = string(rule.ToPriority)
+		// The copy of the task in the lower priority queue is left there, workers ignore it because its priority doesn't match the stored one
+		err = a.dispatcher.Dispatch(ctx, task)
+		if err != nil {
+			// The task remains queued with its new priority, so the recovery command is able to re-queue it later
+			slog.Error("Error occurred while queuing promoted task to jobs queue", "task_id", task.ID, "error", err.Error())
+		}
+	}
+
+	return promotedCount
+}
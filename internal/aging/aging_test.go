@@ -0,0 +1,179 @@
+package aging
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeStorage keeps the tasks in memory, waitedSeconds is the time since the last update of each task
+// The methods which are not used by the ager panic through the embedded nil interface
+type fakeStorage struct {
+	domain.Storage
+	tasks         map[int32]*domain.Task
+	waitedSeconds map[int32]int32
+	// beforeUpdate runs before each priority update, so the tests change the tasks while they are being promoted
+	beforeUpdate func(taskID int32)
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{tasks: map[int32]*domain.Task{}, waitedSeconds: map[int32]int32{}}
+}
+
+func (f *fakeStorage) add(id int32, priority domain.TaskPriority, waitedSeconds int32) {
+	f.tasks[id] = &domain.Task{ID: id, Type: "send_email", Status: string(domain.Queued), Priority: string(priority)}
+	f.waitedSeconds[id] = waitedSeconds
+}
+
+func (f *fakeStorage) GetAgedQueuedTasks(ctx context.Context, taskPriority string, passedSeconds, limit int32) ([]*domain.Task, error) {
+	tasks := []*domain.Task{}
+	for id, task := range f.tasks {
+		if task.Status == string(domain.Queued) && task.Priority == taskPriority && f.waitedSeconds[id] >= passedSeconds {
+			taskCopy := *task
+			tasks = append(tasks, &taskCopy)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	if len(tasks) > int(limit) {
+		tasks = tasks[:limit]
+	}
+	if len(tasks) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return tasks, nil
+}
+
+func (f *fakeStorage) UpdateTaskPriorityAndLogChangeInTx(ctx context.Context, taskID int32, currentPriority, newPriority string) (bool, error) {
+	if f.beforeUpdate != nil {
+		f.beforeUpdate(taskID)
+	}
+	task := f.tasks[taskID]
+	if task.Status != string(domain.Queued) || task.Priority != currentPriority {
+		return false, nil
+	}
+	task.Priority = newPriority
+	f.waitedSeconds[taskID] = 0
+
+	return true, nil
+}
+
+// fakeQueue records the IDs of the published tasks by queue
+type fakeQueue struct {
+	domain.Queue
+	published map[string][]int32
+}
+
+func (f *fakeQueue) PublishMessage(queueName string, message domain.QueueMessage) error {
+	decoded, err := dispatch.Decode(string(message.Body))
+	if err != nil {
+		return err
+	}
+	f.published[queueName] = append(f.published[queueName], decoded.TaskID)
+
+	return nil
+}
+
+var testRules = []Rule{
+	{FromPriority: domain.Low, ToPriority: domain.Normal, AfterSeconds: 300},
+	{FromPriority: domain.Normal, ToPriority: domain.High, AfterSeconds: 600},
+}
+
+func newTestAger(storage *fakeStorage, maxPromotionsPerIteration int32) (*Ager, *fakeQueue) {
+	queue := &fakeQueue{published: map[string][]int32{}}
+	dispatcher := dispatch.NewDispatcher(queue, domain.PriorityQueueNames{High: "high", Normal: "normal", Low: "low"})
+
+	return NewAger(storage, dispatcher, testRules, 0, maxPromotionsPerIteration), queue
+}
+
+// TestPromoteAgedTasks_Thresholds: low tasks are promoted to normal after 300 seconds, and normal tasks to high after 600 seconds
+func TestPromoteAgedTasks_Thresholds(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Low, 400)
+	storage.add(2, domain.Low, 100)
+	storage.add(3, domain.Normal, 700)
+	storage.add(4, domain.Normal, 500)
+	storage.add(5, domain.High, 5000)
+	ager, queue := newTestAger(storage, 100)
+
+	promotedCount := ager.PromoteAgedTasks(context.Background())
+	if promotedCount != 2 {
+		t.Fatalf("expected 2 promoted tasks, got %d", promotedCount)
+	}
+
+	expectedPriorities := map[int32]domain.TaskPriority{1: domain.Normal, 2: domain.Low, 3: domain.High, 4: domain.Normal, 5: domain.High}
+	for id, priority := range expectedPriorities {
+		if storage.tasks[id].Priority != string(priority) {
+			t.Errorf("expected task %d to be %s, got %s", id, priority, storage.tasks[id].Priority)
+		}
+	}
+	// The promoted low task has just been updated, so it's not promoted again to high in the same iteration
+	if len(queue.published["normal"]) != 1 || queue.published["normal"][0] != 1 || len(queue.published["high"]) != 1 || queue.published["high"][0] != 3 {
+		t.Fatalf("expected the promoted tasks to be queued in their new priority, got %v", queue.published)
+	}
+}
+
+// TestPromoteAgedTasks_MaxPromotionsPerIteration: each rule promotes at most MaxPromotionsPerIteration tasks, the rest wait for the next iterations
+func TestPromoteAgedTasks_MaxPromotionsPerIteration(t *testing.T) {
+	storage := newFakeStorage()
+	for id := int32(1); id <= 5; id++ {
+		storage.add(id, domain.Low, 400)
+	}
+	ager, queue := newTestAger(storage, 2)
+
+	promotedCount := ager.PromoteAgedTasks(context.Background())
+	if promotedCount != 2 || len(queue.published["normal"]) != 2 {
+		t.Fatalf("expected 2 promoted tasks, got %d and published %v", promotedCount, queue.published)
+	}
+
+	promotedCount = ager.PromoteAgedTasks(context.Background())
+	if promotedCount != 2 || len(queue.published["normal"]) != 4 {
+		t.Fatalf("expected 2 more promoted tasks in the next iteration, got %d and published %v", promotedCount, queue.published)
+	}
+}
+
+// TestPromoteAgedTasks_ChangedDuringPromotion: the tasks which are picked up or promoted by another ager after being fetched are not re-queued
+func TestPromoteAgedTasks_ChangedDuringPromotion(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Low, 400)
+	storage.add(2, domain.Low, 400)
+	storage.add(3, domain.Low, 400)
+	storage.beforeUpdate = func(taskID int32) {
+		switch taskID {
+		case 1:
+			storage.tasks[taskID].Status = string(domain.Running)
+		case 2:
+			storage.tasks[taskID].Priority = string(domain.Normal)
+		}
+	}
+	ager, queue := newTestAger(storage, 100)
+
+	promotedCount := ager.PromoteAgedTasks(context.Background())
+	if promotedCount != 1 {
+		t.Fatalf("expected 1 promoted task, got %d", promotedCount)
+	}
+	if len(queue.published["normal"]) != 1 || queue.published["normal"][0] != 3 {
+		t.Fatalf("expected only task 3 to be queued, got %v", queue.published)
+	}
+	if storage.tasks[1].Priority != string(domain.Low) {
+		t.Fatalf("expected the running task to keep its priority, got %s", storage.tasks[1].Priority)
+	}
+}
+
+// TestNewAger_InvalidInterval: a non-positive interval falls back to the default one, so Run doesn't panic
+func TestNewAger_InvalidInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		ager := NewAger(newFakeStorage(), nil, testRules, interval, 100)
+		if ager.interval != DefaultInterval {
+			t.Fatalf("expected the default interval for %s, got %s", interval, ager.interval)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		ager.Run(ctx)
+	}
+}
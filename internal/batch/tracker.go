@@ -0,0 +1,236 @@
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/metrics"
+	"github.com/sf7293/task-manager/pkg/httprequest"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// MaxTasks limits the size of a batch, since all of its members are inserted in a single transaction
+const MaxTasks = 10000
+
+type Status string
+
+const (
+	Running Status = "running"
+	// Completed batches have all their members in a terminal status, and their completion has been fired
+	Completed Status = "completed"
+)
+
+// Progress is the batch with the aggregated counters of its members
+type Progress struct {
+	domain.Batch
+	Status         Status `json:"status"`
+	RemainingCount int32  `json:"remaining_count"`
+}
+
+// Tracker creates the batches, counts their members when they finish, and fires their completion exactly once
+type Tracker struct {
+	storage     domain.BatchStorage
+	dispatcher  *dispatch.Dispatcher
+	maxAttempts int32
+}
+
+// NewTracker returns the batch tracker, maxAttempts decides when a failed member is counted
+func NewTracker(storage domain.BatchStorage, dispatcher *dispatch.Dispatcher, maxAttempts int32) *Tracker {
+	return &Tracker{
+		storage:     storage,
+		dispatcher:  dispatcher,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Start stores the batch and queues all its members
+func (t *Tracker) Start(ctx context.Context, batch *domain.Batch, tasks []*domain.BatchTask) (*domain.Batch, []*domain.Task, error) {
+	if len(tasks) == 0 {
+		return nil, nil, fmt.Errorf("%w: batch must have at least one task", errval.ErrInvalidBatch)
+	}
+	if len(tasks) > MaxTasks {
+		return nil, nil, fmt.Errorf("%w: batch must not have more than %d tasks", errval.ErrInvalidBatch, MaxTasks)
+	}
+
+	insertedBatch, insertedTasks, err := t.storage.InsertBatch(ctx, batch, tasks)
+	if err != nil {
+		return nil, nil, err
+	}
+	slog.Info("Batch is created", "batch_id", insertedBatch.ID, "tasks_count", len(insertedTasks))
+
+	for _, task := range insertedTasks {
+		metrics.TaskCreated(task.Type, task.Priority)
+		t.dispatch(ctx, task)
+	}
+
+	return insertedBatch, insertedTasks, nil
+}
+
+// TaskFinished counts the task in its batch, the task must have its status and attempts after the finished execution
+// It's a no-op for the tasks which are not a member of any batch
+func (t *Tracker) TaskFinished(ctx context.Context, task *domain.Task) error {
+	if task.BatchID == nil {
+		return nil
+	}
+
+	// The members of the batches are never skipped, since they don't belong to any workflow
+	if !task.IsFinished(t.maxAttempts) {
+		return nil
+	}
+
+	batch, err := t.storage.CountBatchTask(ctx, task, domain.TaskStatus(task.Status))
+	if err != nil {
+		return err
+	}
+	if batch == nil || !batch.IsFinished() {
+		return nil
+	}
+
+	return t.complete(ctx, batch)
+}
+
+// Cancel cancels the members of the batch which have not started yet, the running members are left to finish
+func (t *Tracker) Cancel(ctx context.Context, batchID int32) (*Progress, error) {
+	batch, cancelledTasks, err := t.storage.CancelBatchTasks(ctx, batchID, t.maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Batch is cancelled", "batch_id", batchID, "cancelled_tasks_count", len(cancelledTasks))
+
+	if batch.IsFinished() {
+		err = t.complete(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t.Progress(ctx, batchID)
+}
+
+// Progress returns the batch with its aggregated counters, errval.ErrNotFound is returned when the batch doesn't exist
+func (t *Tracker) Progress(ctx context.Context, batchID int32) (*Progress, error) {
+	batch, err := t.storage.GetBatchByID(ctx, batchID)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &Progress{
+		Batch:          *batch,
+		Status:         Running,
+		RemainingCount: batch.TotalCount - batch.SucceededCount - batch.FailedCount - batch.CancelledCount,
+	}
+	if batch.CompletedAtStamp != nil {
+		progress.Status = Completed
+	}
+
+	return progress, nil
+}
+
+// RecoverStalled counts the finished members which their worker has stopped before counting, and completes the finished batches whose completion has not been fired
+// It returns the number of the members and the batches which are handled
+func (t *Tracker) RecoverStalled(ctx context.Context, passedSeconds, limit int32) (int, error) {
+	recoveredCount := 0
+	var recoveryErrs []error
+
+	tasks, err := t.storage.GetUncountedBatchTasks(ctx, t.maxAttempts, passedSeconds, limit)
+	if err != nil && !errors.Is(err, errval.ErrNotFound) {
+		return 0, err
+	}
+	for _, task := range tasks {
+		slog.Warn("Finished task of the batch has not been counted, counting it", "task_id", task.ID, "batch_id", *task.BatchID, "task_status", task.Status)
+		err = t.TaskFinished(ctx, task)
+		if err != nil {
+			recoveryErrs = append(recoveryErrs, err)
+			continue
+		}
+		recoveredCount++
+	}
+
+	batches, err := t.storage.GetFinishedUncompletedBatches(ctx, limit)
+	if err != nil && !errors.Is(err, errval.ErrNotFound) {
+		return recoveredCount, errors.Join(append(recoveryErrs, err)...)
+	}
+	for _, batch := range batches {
+		slog.Warn("Completion of the finished batch has not been fired, firing it", "batch_id", batch.ID)
+		err = t.complete(ctx, batch)
+		if err != nil {
+			recoveryErrs = append(recoveryErrs, err)
+			continue
+		}
+		recoveredCount++
+	}
+
+	return recoveredCount, errors.Join(recoveryErrs...)
+}
+
+// NewWebhookTask returns the http_request task which posts the batch to its completion webhook
+func NewWebhookTask(batch *domain.Batch) (*domain.BatchTask, error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(httprequest.Payload{
+		Method:  http.MethodPost,
+		URL:     batch.CompletionWebhookURL,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    string(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.BatchTask{
+		Name:     fmt.Sprintf("batch_%d_completion_webhook", batch.ID),
+		Type:     httprequest.TaskTypeName,
+		Priority: string(domain.Normal),
+		Payload:  payload,
+	}, nil
+}
+
+// complete fires the completion of the finished batch, the storage makes sure that it's fired only once
+func (t *Tracker) complete(ctx context.Context, batch *domain.Batch) error {
+	var webhookTask *domain.BatchTask
+	if batch.CompletionWebhookURL != "" {
+		// The counters of a finished batch don't change anymore, so the posted batch has its final counters
+		completedAtStamp := time.Now().Unix()
+		completedBatch := *batch
+		completedBatch.CompletedAtStamp = &completedAtStamp
+
+		var err error
+		webhookTask, err = NewWebhookTask(&completedBatch)
+		if err != nil {
+			return err
+		}
+	}
+
+	isCompleted, completionTasks, err := t.storage.CompleteBatch(ctx, batch.ID, batch.CompletionTask, webhookTask)
+	if err != nil {
+		return err
+	}
+	if !isCompleted {
+		return nil
+	}
+	slog.Info("Batch is completed", "batch_id", batch.ID, "succeeded_count", batch.SucceededCount, "failed_count", batch.FailedCount, "cancelled_count", batch.CancelledCount)
+
+	for _, task := range completionTasks {
+		metrics.TaskCreated(task.Type, task.Priority)
+		t.dispatch(ctx, task)
+	}
+
+	return nil
+}
+
+func (t *Tracker) dispatch(ctx context.Context, task *domain.Task) {
+	err := t.dispatcher.Dispatch(ctx, task)
+	if err != nil {
+		// The task is left queued, so it's re-published by the recovery after RECOVERY_QUEUED_AFTER_SECONDS
+		slog.Error("Error occurred while queuing the task of the batch", "task_id", task.ID, "error", err.Error())
+	}
+}
@@ -0,0 +1,159 @@
+package batch
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"testing"
+)
+
+const testMaxAttempts = 3
+
+// fakeStorage keeps a single batch in memory, and counts and completes it once like the storage does
+// The methods which are not used by the tracker panic through the embedded nil interface
+type fakeStorage struct {
+	domain.BatchStorage
+	batch          domain.Batch
+	counted        map[int32]bool
+	completedCount int
+	nextID         int32
+}
+
+func newFakeStorage(totalCount int32) *fakeStorage {
+	return &fakeStorage{
+		batch:   domain.Batch{ID: 1, TotalCount: totalCount, CompletionTask: &domain.BatchTask{Name: "report", Type: "echo", Priority: string(domain.Normal)}},
+		counted: map[int32]bool{},
+		nextID:  100,
+	}
+}
+
+func (f *fakeStorage) CountBatchTask(ctx context.Context, task *domain.Task, status domain.TaskStatus) (*domain.Batch, error) {
+	if f.counted[task.ID] {
+		return nil, nil
+	}
+	f.counted[task.ID] = true
+
+	switch status {
+	case domain.Succeeded:
+		f.batch.SucceededCount++
+	case domain.Failed:
+		f.batch.FailedCount++
+	case domain.Cancelled:
+		f.batch.CancelledCount++
+	}
+	batch := f.batch
+
+	return &batch, nil
+}
+
+func (f *fakeStorage) CompleteBatch(ctx context.Context, batchID int32, completionTask, completionWebhookTask *domain.BatchTask) (bool, []*domain.Task, error) {
+	if f.batch.CompletedAtStamp != nil {
+		return false, nil, nil
+	}
+	completedAtStamp := int64(1)
+	f.batch.CompletedAtStamp = &completedAtStamp
+	f.completedCount++
+
+	var tasks []*domain.Task
+	for _, task := range []*domain.BatchTask{completionTask, completionWebhookTask} {
+		if task != nil {
+			f.nextID++
+			tasks = append(tasks, &domain.Task{ID: f.nextID, Type: task.Type, Status: string(domain.Queued), Priority: task.Priority})
+		}
+	}
+
+	return true, tasks, nil
+}
+
+// fakeQueue records the IDs of the published tasks
+type fakeQueue struct {
+	domain.Queue
+	published []int32
+}
+
+func (f *fakeQueue) PublishMessage(queueName string, message domain.QueueMessage) error {
+	decoded, err := dispatch.Decode(string(message.Body))
+	if err != nil {
+		return err
+	}
+	f.published = append(f.published, decoded.TaskID)
+
+	return nil
+}
+
+func newTestTracker(storage *fakeStorage) (*Tracker, *fakeQueue) {
+	queue := &fakeQueue{}
+	dispatcher := dispatch.NewDispatcher(queue, domain.PriorityQueueNames{High: "high", Normal: "normal", Low: "low"})
+
+	return NewTracker(storage, dispatcher, testMaxAttempts), queue
+}
+
+func newMember(id int32, status domain.TaskStatus, attempts int32) *domain.Task {
+	batchID := int32(1)
+
+	return &domain.Task{ID: id, Type: "echo", Status: string(status), Attempts: attempts, BatchID: &batchID}
+}
+
+// TestTaskFinished_Counting: the succeeded, the cancelled and the exhausted failed members are counted once, the retryable failed and the unfinished members are not
+func TestTaskFinished_Counting(t *testing.T) {
+	storage := newFakeStorage(10)
+	tracker, _ := newTestTracker(storage)
+	members := []*domain.Task{
+		newMember(1, domain.Succeeded, 1),
+		newMember(2, domain.Cancelled, 0),
+		newMember(3, domain.Failed, testMaxAttempts),
+		newMember(4, domain.Failed, 1),
+		newMember(5, domain.Running, 1),
+		newMember(6, domain.Queued, 0),
+		{ID: 7, Type: "echo", Status: string(domain.Succeeded), Attempts: 1},
+	}
+
+	for range 2 {
+		for _, member := range members {
+			err := tracker.TaskFinished(context.Background(), member)
+			if err != nil {
+				t.Fatalf("expected no error for task %d, got %v", member.ID, err)
+			}
+		}
+	}
+
+	if storage.batch.SucceededCount != 1 || storage.batch.CancelledCount != 1 || storage.batch.FailedCount != 1 {
+		t.Fatalf("expected 1 succeeded, 1 cancelled and 1 failed member, got %d, %d and %d", storage.batch.SucceededCount, storage.batch.CancelledCount, storage.batch.FailedCount)
+	}
+	if storage.completedCount != 0 {
+		t.Fatalf("expected the unfinished batch not to be completed, got %d completions", storage.completedCount)
+	}
+}
+
+// TestTaskFinished_CompletesOnce: the batch is completed by its last member, and its completion task is queued once even when the members and the batch are reported again
+func TestTaskFinished_CompletesOnce(t *testing.T) {
+	storage := newFakeStorage(2)
+	tracker, queue := newTestTracker(storage)
+	members := []*domain.Task{newMember(1, domain.Succeeded, 1), newMember(2, domain.Failed, testMaxAttempts)}
+
+	err := tracker.TaskFinished(context.Background(), members[0])
+	if err != nil || storage.completedCount != 0 {
+		t.Fatalf("expected the batch not to be completed by its first member, got %v and %d completions", err, storage.completedCount)
+	}
+
+	for range 2 {
+		for _, member := range members {
+			err = tracker.TaskFinished(context.Background(), member)
+			if err != nil {
+				t.Fatalf("expected no error for task %d, got %v", member.ID, err)
+			}
+		}
+	}
+	finishedBatch := storage.batch
+	err = tracker.complete(context.Background(), &finishedBatch)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if storage.completedCount != 1 {
+		t.Fatalf("expected the batch to be completed once, got %d completions", storage.completedCount)
+	}
+	if len(queue.published) != 1 || queue.published[0] != 101 {
+		t.Fatalf("expected the completion task to be queued once, got %v", queue.published)
+	}
+}
@@ -0,0 +1,175 @@
+package dispatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/metrics"
+	"strconv"
+	"time"
+)
+
+const (
+	// SchemaVersion is the version of the Message which is published by Dispatch
+	// Version 1 is the raw JSON of domain.Task which was published before the envelope, it's still accepted by Decode
+	SchemaVersion = 2
+	// MessageType is the AMQP type of the messages of the jobs queues
+	MessageType = "task.job"
+
+	// The headers of the messages, they repeat the fields of the envelope for the tools which don't decode the body
+	HeaderSchemaVersion = "x-schema-version"
+	HeaderTaskID        = "x-task-id"
+	HeaderAttempt       = "x-attempt"
+	HeaderTraceParent   = "traceparent"
+	HeaderTraceState    = "tracestate"
+)
+
+// Message is the envelope of the messages of the jobs queues
+// It only points to the task, the workers load the authoritative task from the storage
+type Message struct {
+	SchemaVersion int    `json:"schema_version"`
+	MessageID     string `json:"message_id"`
+	TaskID        int32  `json:"task_id"`
+	// TaskPriority is the priority of the task at the time of queueing, so the copies which are left in the queue of a lower priority after aging are detected
+	TaskPriority string `json:"task_priority"`
+	// Attempt is the attempt of the task which the message starts
+	Attempt         int32 `json:"attempt"`
+	EnqueuedAtStamp int64 `json:"enqueued_at_stamp"`
+	// TraceContext is the W3C trace context of the request which has queued the task, it has empty fields when the request had none
+	TraceContext TraceContext `json:"trace_context"`
+	ContentType  string       `json:"content_type"`
+}
+
+// TraceContext holds the traceparent and tracestate headers of the W3C trace context
+type TraceContext struct {
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+}
+
+type traceContextKey struct{}
+
+// WithTraceContext returns a context which carries the trace context to the messages which are dispatched with it
+func WithTraceContext(ctx context.Context, traceContext TraceContext) context.Context {
+	if traceContext.TraceParent == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, traceContextKey{}, traceContext)
+}
+
+// TraceContextFrom returns the trace context which is carried by the context
+func TraceContextFrom(ctx context.Context) TraceContext {
+	traceContext, _ := ctx.Value(traceContextKey{}).(TraceContext)
+	return traceContext
+}
+
+// Dispatcher publishes tasks to the jobs queue which is consumed by the workers of their priority
+type Dispatcher struct {
+	queueClient domain.Queue
+	queueNames  domain.PriorityQueueNames
+}
+
+func NewDispatcher(queueClient domain.Queue, queueNames domain.PriorityQueueNames) *Dispatcher {
+	return &Dispatcher{
+		queueClient: queueClient,
+		queueNames:  queueNames,
+	}
+}
+
+// Dispatch publishes the envelope of the task to the jobs queue of its priority, the trace context of ctx is carried by the message
+func (d *Dispatcher) Dispatch(ctx context.Context, task *domain.Task) error {
+	enqueuedAt := time.Now()
+	message := Message{
+		SchemaVersion: SchemaVersion,
+		// The message ID is unique for each publish, since a task is re-queued by the recovery and the aging
+		MessageID:       strconv.FormatInt(int64(task.ID), 10) + "-" + strconv.FormatInt(enqueuedAt.UnixNano(), 10),
+		TaskID:          task.ID,
+		TaskPriority:    task.Priority,
+		Attempt:         task.Attempts + 1,
+		EnqueuedAtStamp: enqueuedAt.Unix(),
+		TraceContext:    TraceContextFrom(ctx),
+		ContentType:     "application/json",
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]any{
+		HeaderSchemaVersion: int32(message.SchemaVersion),
+		HeaderTaskID:        message.TaskID,
+		HeaderAttempt:       message.Attempt,
+	}
+	if message.TraceContext.TraceParent != "" {
+		headers[HeaderTraceParent] = message.TraceContext.TraceParent
+	}
+	if message.TraceContext.TraceState != "" {
+		headers[HeaderTraceState] = message.TraceContext.TraceState
+	}
+
+	err = d.queueClient.PublishMessage(d.queueNames.ByPriority(task.Priority), domain.QueueMessage{
+		Body:        body,
+		ContentType: message.ContentType,
+		MessageID:   message.MessageID,
+		Type:        MessageType,
+		Timestamp:   enqueuedAt,
+		Headers:     headers,
+	})
+	if err != nil {
+		metrics.PublishFailures.WithLabelValues(metrics.DestinationJobs).Inc()
+		return err
+	}
+
+	return nil
+}
+
+// Decode unmarshals the body of a message of the jobs queues, the messages of all the versions are returned as the current version
+// The messages of the versions which are newer than SchemaVersion are refused, they are published by a newer server which must be deployed after the workers
+func Decode(body string) (*Message, error) {
+	var versioned struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	err := json.Unmarshal([]byte(body), &versioned)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case versioned.SchemaVersion == 0:
+		return decodeVersion1(body)
+	case versioned.SchemaVersion > SchemaVersion:
+		return nil, fmt.Errorf("unsupported schema version %d of the message", versioned.SchemaVersion)
+	}
+
+	message := new(Message)
+	err = json.Unmarshal([]byte(body), message)
+	if err != nil {
+		return nil, err
+	}
+	if message.TaskID == 0 {
+		return nil, fmt.Errorf("task_id of the message is missing")
+	}
+
+	return message, nil
+}
+
+// decodeVersion1 decodes the raw JSON of domain.Task which was published before the envelope
+func decodeVersion1(body string) (*Message, error) {
+	task := new(domain.Task)
+	err := json.Unmarshal([]byte(body), task)
+	if err != nil {
+		return nil, err
+	}
+	if task.ID == 0 {
+		return nil, fmt.Errorf("id of the task of the message is missing")
+	}
+
+	return &Message{
+		SchemaVersion: 1,
+		TaskID:        task.ID,
+		TaskPriority:  task.Priority,
+		Attempt:       task.Attempts + 1,
+		ContentType:   "application/json",
+	}, nil
+}
@@ -0,0 +1,115 @@
+package dispatch
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/domain"
+	"testing"
+)
+
+// fakeQueue records the published messages
+type fakeQueue struct {
+	queueName string
+	messages  []domain.QueueMessage
+}
+
+func (f *fakeQueue) IsHealthy() bool {
+	return true
+}
+
+func (f *fakeQueue) PublishMessage(queueName string, message domain.QueueMessage) error {
+	f.queueName = queueName
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+func (f *fakeQueue) ConsumeMessages(consumerName, queueName string, handler func(string)) error {
+	return nil
+}
+
+func (f *fakeQueue) QueueDepth(queueName string) (int, error) {
+	return len(f.messages), nil
+}
+
+func (f *fakeQueue) InspectMessages(queueName string, maxCount int, inspector func(body string) (remove bool)) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeQueue) Close() error {
+	return nil
+}
+
+var testQueueNames = domain.PriorityQueueNames{High: "jobs_high", Normal: "jobs_normal", Low: "jobs_low"}
+
+// TestDispatch: the envelope is published to the queue of the priority of the task, with the trace context of ctx in its body and headers
+func TestDispatch(t *testing.T) {
+	queue := &fakeQueue{}
+	traceContext := TraceContext{TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", TraceState: "vendor=value"}
+	ctx := WithTraceContext(context.Background(), traceContext)
+
+	err := NewDispatcher(queue, testQueueNames).Dispatch(ctx, &domain.Task{ID: 7, Priority: "high", Attempts: 1, PayLoad: []byte(`{"secret":"value"}`)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if queue.queueName != "jobs_high" || len(queue.messages) != 1 {
+		t.Fatalf("expected one message in the high priority queue, got %d messages in %q", len(queue.messages), queue.queueName)
+	}
+
+	published := queue.messages[0]
+	if published.ContentType != "application/json" || published.Type != MessageType || published.MessageID == "" || published.Timestamp.IsZero() {
+		t.Fatalf("unexpected properties of the message: %+v", published)
+	}
+	if published.Headers[HeaderSchemaVersion] != int32(SchemaVersion) || published.Headers[HeaderTaskID] != int32(7) || published.Headers[HeaderTraceParent] != traceContext.TraceParent || published.Headers[HeaderTraceState] != traceContext.TraceState {
+		t.Fatalf("unexpected headers of the message: %+v", published.Headers)
+	}
+
+	message, err := Decode(string(published.Body))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if message.SchemaVersion != SchemaVersion || message.MessageID != published.MessageID || message.TaskID != 7 || message.TaskPriority != "high" || message.Attempt != 2 || message.TraceContext != traceContext {
+		t.Fatalf("unexpected decoded message: %+v", message)
+	}
+}
+
+// TestDispatch_WithoutTraceContext: the trace headers are left out when the context has no trace
+func TestDispatch_WithoutTraceContext(t *testing.T) {
+	queue := &fakeQueue{}
+
+	err := NewDispatcher(queue, testQueueNames).Dispatch(context.Background(), &domain.Task{ID: 7, Priority: "unknown"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if queue.queueName != "jobs_normal" {
+		t.Fatalf("expected the unknown priority to fall back to the normal queue, got %q", queue.queueName)
+	}
+	if _, ok := queue.messages[0].Headers[HeaderTraceParent]; ok {
+		t.Fatalf("expected no traceparent header, got %+v", queue.messages[0].Headers)
+	}
+}
+
+// TestDecode_Version1: the raw tasks which were published before the envelope are decoded as messages
+func TestDecode_Version1(t *testing.T) {
+	message, err := Decode(`{"id":12,"name":"send","type":"send_email","status":"queued","priority":"low","attempts":2}`)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if message.SchemaVersion != 1 || message.TaskID != 12 || message.TaskPriority != "low" || message.Attempt != 3 {
+		t.Fatalf("unexpected decoded message: %+v", message)
+	}
+}
+
+// TestDecode_Invalid: the messages of the unknown versions, without a task and with broken JSON are refused
+func TestDecode_Invalid(t *testing.T) {
+	bodies := []string{
+		`{"schema_version":3,"task_id":12}`,
+		`{"schema_version":2,"message_id":"12-1"}`,
+		`{"name":"send"}`,
+		`not json`,
+	}
+	for _, body := range bodies {
+		_, err := Decode(body)
+		if err == nil {
+			t.Errorf("expected an error for %s", body)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Batch is a group of independent tasks, each member is counted once in the counters when it's finished
+type Batch struct {
+	ID             int32  `json:"id"`
+	Name           string `json:"name"`
+	Caller         string `json:"caller,omitempty"`
+	TotalCount     int32  `json:"total_count"`
+	SucceededCount int32  `json:"succeeded_count"`
+	FailedCount    int32  `json:"failed_count"`
+	CancelledCount int32  `json:"cancelled_count"`
+	// CompletionTask and CompletionWebhookURL are created as tasks once, when all the members are terminal
+	CompletionTask          *BatchTask `json:"completion_task,omitempty"`
+	CompletionWebhookURL    string     `json:"completion_webhook_url,omitempty"`
+	CompletionTaskID        *int32     `json:"completion_task_id,omitempty"`
+	CompletionWebhookTaskID *int32     `json:"completion_webhook_task_id,omitempty"`
+	// CompletedAtStamp is only set when the batch is completed
+	CompletedAtStamp *int64 `json:"completed_at_stamp,omitempty"`
+	CreatedAtStamp   int64  `json:"created_at_stamp"`
+}
+
+// IsFinished tells whether all the members of the batch are counted, the completion of a finished batch might have not been fired yet
+func (b *Batch) IsFinished() bool {
+	return b.SucceededCount+b.FailedCount+b.CancelledCount >= b.TotalCount
+}
+
+// BatchTask is a member or the completion task of a new batch
+type BatchTask struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Priority string          `json:"priority"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type BatchStorage interface {
+	// InsertBatch inserts the batch with all its members as queued tasks in a transaction
+	InsertBatch(ctx context.Context, batch *Batch, tasks []*BatchTask) (*Batch, []*Task, error)
+	GetBatchByID(ctx context.Context, batchID int32) (*Batch, error)
+	// CountBatchTask increases the counter of the status in the batch of the task, and returns the updated batch
+	// Nil is returned when the task has already been counted, so each task is counted only once
+	CountBatchTask(ctx context.Context, task *Task, status TaskStatus) (*Batch, error)
+	// CancelBatchTasks moves the queued members and the failed members with attempts left to cancelled, and counts them
+	CancelBatchTasks(ctx context.Context, batchID int32, maxAttempts int32) (*Batch, []*Task, error)
+	// CompleteBatch marks the finished batch as completed, and inserts its completion tasks as queued in the same transaction
+	// It returns false when the batch has already been completed, so the completion tasks are inserted only once
+	CompleteBatch(ctx context.Context, batchID int32, completionTask, completionWebhookTask *BatchTask) (bool, []*Task, error)
+	// GetUncountedBatchTasks returns the terminal members which have not been counted in the last passedSeconds seconds
+	// Their worker has probably stopped before counting them
+	GetUncountedBatchTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*Task, error)
+	// GetFinishedUncompletedBatches returns the batches whose members are all counted, but their completion has not been fired
+	GetFinishedUncompletedBatches(ctx context.Context, limit int32) ([]*Batch, error)
+}
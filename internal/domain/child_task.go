@@ -0,0 +1,16 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+)
+
+type ChildTaskStorage interface {
+	// InsertChildTask inserts the child as a queued task, isCreated is false when the parent already has a child with the same key and the existing child is returned
+	InsertChildTask(ctx context.Context, parentID int32, key, name, taskType, priority string, payload json.RawMessage, caller string) (child *Task, isCreated bool, err error)
+	// FinishWaitingTask moves the waiting task to succeeded, or to failed when any of its children hasn't succeeded, and returns the finished task
+	// It returns nil when the task is not waiting anymore or it has unfinished children, a failed child is only finished when it has no attempts left
+	FinishWaitingTask(ctx context.Context, taskID int32, maxAttempts int32) (*Task, error)
+	// GetFinishableWaitingTasks returns the waiting tasks whose children are all finished, and which haven't been updated in the last passedSeconds seconds
+	GetFinishableWaitingTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*Task, error)
+}
@@ -9,5 +9,7 @@ type DistributedLock interface {
 	Ping(ctx context.Context) (err error)
 	Lock(lockKey string, lockTimeDuration time.Duration) (result bool, err error)
 	Unlock(lockKey string) (err error)
+	AcquireLease(leaseKey, owner string, leaseDuration time.Duration) (isAcquired bool, err error)
+	ReleaseLease(leaseKey, owner string) (err error)
 	Close() error
 }
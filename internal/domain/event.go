@@ -0,0 +1,42 @@
+package domain
+
+import "context"
+
+// TaskEvent is a status change of a task which is pushed to the event streams, ID is the id of the change in the status change history of the tasks
+type TaskEvent struct {
+	ID             int32  `json:"id"`
+	TaskID         int32  `json:"task_id"`
+	TaskType       string `json:"task_type"`
+	OldStatus      string `json:"old_status"`
+	NewStatus      string `json:"new_status"`
+	CreatedAtStamp int64  `json:"created_at_stamp"`
+}
+
+// TaskEventsFilter selects the events of a stream, the empty fields match all the events
+type TaskEventsFilter struct {
+	TaskID    *int32
+	TaskType  string
+	NewStatus string
+}
+
+func (f TaskEventsFilter) Matches(event *TaskEvent) bool {
+	if f.TaskID != nil && *f.TaskID != event.TaskID {
+		return false
+	}
+	if f.TaskType != "" && f.TaskType != event.TaskType {
+		return false
+	}
+	if f.NewStatus != "" && f.NewStatus != event.NewStatus {
+		return false
+	}
+
+	return true
+}
+
+type EventStorage interface {
+	// ListenTaskEvents blocks and calls handle with each status change which is committed, until the context is cancelled or the connection is lost
+	// listening is called once the changes are being received, the changes which are committed before it or after the connection is lost are only returned by GetTaskEventsAfter
+	ListenTaskEvents(ctx context.Context, listening func(), handle func(event *TaskEvent)) error
+	// GetTaskEventsAfter returns the first limit events whose id is greater than afterID in the order of their ids
+	GetTaskEventsAfter(ctx context.Context, afterID int32, filter TaskEventsFilter, limit int32) ([]*TaskEvent, error)
+}
@@ -0,0 +1,52 @@
+package domain
+
+import "context"
+
+type LifecycleEventType string
+
+const (
+	EventCreated   LifecycleEventType = "created"
+	EventQueued    LifecycleEventType = "queued"
+	EventStarted   LifecycleEventType = "started"
+	EventRetried   LifecycleEventType = "retried"
+	EventSucceeded LifecycleEventType = "succeeded"
+	EventFailed    LifecycleEventType = "failed"
+	EventCancelled LifecycleEventType = "cancelled"
+	// EventSkipped and EventWaiting are the changes to the statuses of the workflows and the child tasks
+	EventSkipped LifecycleEventType = "skipped"
+	EventWaiting LifecycleEventType = "waiting"
+)
+
+// LifecycleEvent is an event of the outbox of the event bus, it's recorded in the same transaction as the creation or the status change of its task
+type LifecycleEvent struct {
+	ID           int32
+	TaskID       int32
+	TaskType     string
+	TaskPriority string
+	Event        LifecycleEventType
+	// OldStatus is empty for the created events
+	OldStatus      string
+	NewStatus      string
+	Attempts       int32
+	CreatedAtStamp int64
+}
+
+// EventMessage is a message which is published to a topic exchange
+type EventMessage struct {
+	RoutingKey string
+	MessageID  string
+	Body       []byte
+}
+
+type LifecycleEventStorage interface {
+	// ClaimLifecycleEvents returns the oldest events of the outbox in the order of their ids, and postpones publishing them again by leaseSeconds
+	// So the concurrent publishers don't claim the same events, and an event whose publisher has died is published again after the lease
+	ClaimLifecycleEvents(ctx context.Context, leaseSeconds, limit int32) ([]*LifecycleEvent, error)
+	// DeleteLifecycleEvents removes the published events from the outbox
+	DeleteLifecycleEvents(ctx context.Context, eventIDs []int32) error
+}
+
+// EventPublisher publishes the messages of the event bus, all of them are confirmed by the broker when no error is returned
+type EventPublisher interface {
+	PublishEvents(ctx context.Context, exchange string, messages []EventMessage) error
+}
@@ -1,8 +1,41 @@
 package domain
 
+import "time"
+
 type Queue interface {
 	IsHealthy() bool
-	PublishMessage(queueName, body string) error
+	PublishMessage(queueName string, message QueueMessage) error
 	ConsumeMessages(consumerName, queueName string, handler func(string)) error
+	QueueDepth(queueName string) (depth int, err error)
+	InspectMessages(queueName string, maxCount int, inspector func(body string) (remove bool)) (inspectedCount int, err error)
 	Close() error
 }
+
+// QueueMessage is a message of the jobs queues, its fields except the body are published as the AMQP properties and headers
+type QueueMessage struct {
+	Body        []byte
+	ContentType string
+	MessageID   string
+	Type        string
+	Timestamp   time.Time
+	Headers     map[string]any
+}
+
+// PriorityQueueNames holds the name of the jobs queue which is consumed by the workers of each priority
+type PriorityQueueNames struct {
+	High   string
+	Normal string
+	Low    string
+}
+
+// ByPriority returns the jobs queue name of the given priority, unknown priorities fall back to the normal queue
+func (q PriorityQueueNames) ByPriority(priority string) string {
+	switch priority {
+	case string(High):
+		return q.High
+	case string(Low):
+		return q.Low
+	default:
+		return q.Normal
+	}
+}
@@ -1,8 +1,119 @@
 package domain
 
+import (
+	"encoding/json"
+	"errors"
+)
+
 type RouterRequestAddTask struct {
 	Name         string  `json:"name" form:"name" binding:"required"`
 	TaskType     string  `json:"type" form:"type" binding:"required,validate_task_type"`
 	TaskPriority *string `json:"priority" form:"priority" binding:"omitempty,validate_priority"`
-	Payload      string  `json:"payload" binding:"required,validate_payload"`
+	// Payload is a JSON object, an encoded JSON object in a string is also accepted for the clients of the old API
+	Payload json.RawMessage `json:"payload" binding:"required,validate_payload"`
+	// CallbackURL receives the status changes of the task, CallbackEvents filters the new statuses which are posted to it
+	CallbackURL    string   `json:"callback_url" binding:"omitempty,http_url,max=2048"`
+	CallbackEvents []string `json:"callback_events" binding:"omitempty,excluded_without=CallbackURL,dive,oneof=queued running failed succeeded pending skipped cancelled waiting"`
+	// Caller is mapped from the X-API-Key header, it's used by the task types which limit what each caller is able to reach
+	Caller string `json:"-"`
+}
+
+// RouterRequestAddWorkflow creates a DAG of tasks, each task is queued when all the tasks which it depends on have succeeded
+type RouterRequestAddWorkflow struct {
+	Name string `json:"name" binding:"required"`
+	// FailurePolicy is fail_fast when it's not set
+	FailurePolicy string                      `json:"failure_policy" binding:"omitempty,oneof=fail_fast continue skip_dependents"`
+	Tasks         []RouterRequestWorkflowTask `json:"tasks" binding:"required,min=1,dive"`
+	// Caller is mapped from the X-API-Key header, all the tasks of the workflow are created on behalf of it
+	Caller string `json:"-"`
+}
+
+// RouterRequestWorkflowTask is a task of a new workflow, DependsOn refers to the keys of the other tasks of the same workflow
+type RouterRequestWorkflowTask struct {
+	RouterRequestAddTask
+	Key       string   `json:"key" binding:"required,max=128"`
+	DependsOn []string `json:"depends_on"`
+}
+
+// RouterResponseAddWorkflow maps the keys of the tasks of the created workflow to their ids
+type RouterResponseAddWorkflow struct {
+	WorkflowID int32            `json:"added_workflow_id"`
+	TaskIDs    map[string]int32 `json:"task_ids"`
+}
+
+// RouterRequestAddBatch creates a group of independent tasks, its completion is fired once when all of its tasks are terminal
+type RouterRequestAddBatch struct {
+	Name  string                 `json:"name" binding:"required"`
+	Tasks []RouterRequestAddTask `json:"tasks" binding:"required,min=1,dive"`
+	// CompletionTask is queued when all the tasks of the batch are terminal
+	CompletionTask *RouterRequestAddTask `json:"completion_task"`
+	// CompletionWebhookURL receives the batch in a POST request when all the tasks of the batch are terminal
+	CompletionWebhookURL string `json:"completion_webhook_url" binding:"omitempty,url"`
+	// Caller is mapped from the X-API-Key header, all the tasks of the batch are created on behalf of it
+	Caller string `json:"-"`
+}
+
+// RouterResponseAddBatch has the ids of the created tasks in the order of the tasks of the request
+type RouterResponseAddBatch struct {
+	BatchID int32   `json:"added_batch_id"`
+	TaskIDs []int32 `json:"task_ids"`
+}
+
+// RouterResponseTaskType describes a registered task type, so the clients are able to generate forms and SDKs from the payload schema
+type RouterResponseTaskType struct {
+	Name            string `json:"name"`
+	DefaultPriority string `json:"default_priority"`
+	// TimeoutInSeconds is zero when the task type uses the default timeout of the workers
+	TimeoutInSeconds int64           `json:"timeout_in_seconds"`
+	MaxRetries       uint64          `json:"max_retries"`
+	PayloadSchema    json.RawMessage `json:"payload_schema"`
+}
+
+// UnwrapPayload returns the JSON object of the payload, and decodes the payloads which are sent as an encoded string by the old clients
+func UnwrapPayload(payload json.RawMessage) (json.RawMessage, error) {
+	var encodedPayload string
+	if json.Unmarshal(payload, &encodedPayload) == nil {
+		payload = json.RawMessage(encodedPayload)
+	}
+
+	var object map[string]json.RawMessage
+	err := json.Unmarshal(payload, &object)
+	if err != nil || object == nil {
+		return nil, errors.New("payload must be a JSON object")
+	}
+
+	return payload, nil
+}
+
+// RouterRequestAddTemplate creates the first version of a locale of a template
+type RouterRequestAddTemplate struct {
+	TemplateID string `json:"template_id" binding:"required,max=128"`
+	Locale     string `json:"locale" binding:"required,max=35"`
+	Subject    string `json:"subject" binding:"required"`
+	Text       string `json:"text"`
+	HTML       string `json:"html"`
+}
+
+// RouterRequestUpdateTemplate stores a new version of a locale of a template, the previous versions are kept for the tasks which refer to them
+type RouterRequestUpdateTemplate struct {
+	Subject string `json:"subject" binding:"required"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// RouterRequestPreviewTemplate renders a template with the variables the same way that send_email does, without sending anything
+type RouterRequestPreviewTemplate struct {
+	Locale    string         `json:"locale"`
+	Version   *int32         `json:"version" binding:"omitempty,min=1"`
+	Variables map[string]any `json:"variables"`
+}
+
+// RouterResponsePreviewTemplate is the rendered template, the locale and the version are the ones which are chosen after the fallbacks
+type RouterResponsePreviewTemplate struct {
+	TemplateID string `json:"template_id"`
+	Locale     string `json:"locale"`
+	Version    int32  `json:"version"`
+	Subject    string `json:"subject"`
+	Text       string `json:"text"`
+	HTML       string `json:"html"`
 }
@@ -1,14 +1,28 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+)
 
 type Storage interface {
 	Ping(ctx context.Context) (err error)
 	GetTaskByID(ctx context.Context, ID int32) (*Task, error)
+	GetTasksByIDs(ctx context.Context, IDs []int32) ([]*Task, error)
+	CountQueuedTasksByPriority(ctx context.Context) (map[string]int64, error)
 	GetLimitedTasksByStatus(ctx context.Context, taskStatus string, limit int32) ([]*Task, error)
 	GetMissedTasks(ctx context.Context, taskStatus string, passedSeconds, limit int32) ([]*Task, error)
+	GetFilteredMissedTasks(ctx context.Context, filter MissedTasksFilter) ([]*Task, error)
+	GetAgedQueuedTasks(ctx context.Context, taskPriority string, passedSeconds, limit int32) ([]*Task, error)
+	GetRetryableFailedTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*Task, error)
 	GetTasksByStatus(ctx context.Context, taskStatus string) ([]*Task, error)
 	GetTaskStatusChangeHistory(ctx context.Context, taskID int32) ([]*TaskStatusChangeHistory, error)
-	InsertTask(ctx context.Context, name string, taskType, taskStatus, taskPriority, payload string) (task *Task, err error)
+	GetTaskPriorityChangeHistory(ctx context.Context, taskID int32) ([]*TaskPriorityChangeHistory, error)
+	// InsertTask inserts the task, callback is nil for the tasks without a webhook
+	InsertTask(ctx context.Context, name string, taskType, taskStatus, taskPriority string, payload json.RawMessage, caller string, callback *TaskCallback) (task *Task, err error)
+	SetTaskResult(ctx context.Context, taskID int32, result json.RawMessage) (err error)
+	TouchTask(ctx context.Context, taskID int32, taskStatus string) (isTouched bool, err error)
 	UpdateTaskStatusAndLogChangeInTx(ctx context.Context, taskID int32, currentStatus, newStatus string) (err error)
+	FailTaskPermanentlyAndLogChangeInTx(ctx context.Context, taskID int32, currentStatus string, maxAttempts int32) (err error)
+	UpdateTaskPriorityAndLogChangeInTx(ctx context.Context, taskID int32, currentPriority, newPriority string) (isUpdated bool, err error)
 }
@@ -1,5 +1,7 @@
 package domain
 
+import "encoding/json"
+
 type TaskStatus string
 
 const (
@@ -7,14 +9,23 @@ const (
 	Running   TaskStatus = "running"
 	Failed    TaskStatus = "failed"
 	Succeeded TaskStatus = "succeeded"
+	// Pending tasks of a workflow are waiting for their parents to finish, they are queued by the workflow engine
+	Pending TaskStatus = "pending"
+	// Skipped tasks of a workflow won't run, since one of their ancestors has failed
+	Skipped TaskStatus = "skipped"
+	// Cancelled tasks won't run, since another task of their workflow has failed or their batch has been cancelled
+	Cancelled TaskStatus = "cancelled"
+	// Waiting tasks have been executed successfully, and are waiting for their children to finish before they succeed or fail
+	Waiting TaskStatus = "waiting"
 )
 
-type TaskType string
+// TaskStatuses lists all the statuses of the tasks
+var TaskStatuses = []TaskStatus{Queued, Running, Failed, Succeeded, Pending, Skipped, Cancelled, Waiting}
 
-const (
-	SendEmail TaskType = "send_email"
-	RunQuery  TaskType = "run_query"
-)
+// TaskTypeRegistry tells which task types are able to be processed by the workers
+type TaskTypeRegistry interface {
+	IsRegistered(taskType string) bool
+}
 
 type TaskPriority string
 
@@ -24,12 +35,63 @@ const (
 	Low    TaskPriority = "low"
 )
 
+// TaskPriorities lists all the priorities in the order of their importance
+var TaskPriorities = []TaskPriority{High, Normal, Low}
+
 type Task struct {
-	ID             int32  `json:"id"`
-	Type           string `json:"type"`
-	Status         string `json:"status"`
-	Priority       string `json:"priority"`
-	PayLoad        string `json:"payload"`
-	CreatedAtStamp int64  `json:"created_at_stamp"`
-	UpdatedAtStamp int64  `json:"updated_at_stamp"`
+	ID       int32  `json:"id"`
+	Type     string `json:"type"`
+	Status   string `json:"status"`
+	Priority string `json:"priority"`
+	// PayLoad is the JSON payload of the task, the messages which are published before the payloads became JSONB carry it as an encoded string
+	PayLoad  json.RawMessage `json:"payload"`
+	Attempts int32           `json:"attempts"`
+	// Caller is the client who has created the task, it's empty for the tasks which are created without an API key
+	Caller string `json:"caller,omitempty"`
+	// Result is the output of a succeeded task, only some task types produce a result
+	Result json.RawMessage `json:"result,omitempty"`
+	// WorkflowID and WorkflowKey are only set for the tasks which are created as a part of a workflow
+	WorkflowID  *int32 `json:"workflow_id,omitempty"`
+	WorkflowKey string `json:"workflow_key,omitempty"`
+	// BatchID is only set for the members of a batch
+	BatchID *int32 `json:"batch_id,omitempty"`
+	// ParentID and ChildKey are only set for the tasks which are spawned by another task
+	ParentID *int32 `json:"parent_id,omitempty"`
+	ChildKey string `json:"child_key,omitempty"`
+	// Callback is only set for the tasks whose status changes are posted to a webhook
+	Callback       *TaskCallback `json:"callback,omitempty"`
+	CreatedAtStamp int64         `json:"created_at_stamp"`
+	UpdatedAtStamp int64         `json:"updated_at_stamp"`
+}
+
+// TaskCallback posts the status changes of a task to URL, Events filters the new statuses which are posted and all of them are posted when it's empty
+type TaskCallback struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+// IsFinished tells whether the task won't change anymore, so its workflow, its batch and its waiting parent can move forward
+// A failed task with attempts left is not finished since the recovery retries it, so maxAttempts must be the RECOVERY_MAX_ATTEMPTS config
+// The finished tasks may be reported more than once, by their worker and by the recovery, so what they move forward must be idempotent
+func (t *Task) IsFinished(maxAttempts int32) bool {
+	switch TaskStatus(t.Status) {
+	case Succeeded, Skipped, Cancelled:
+		return true
+	case Failed:
+		return t.Attempts >= maxAttempts
+	default:
+		return false
+	}
+}
+
+// MissedTasksFilter selects the tasks with Status whose updated_at has not been changed in the last PassedSeconds seconds
+// The optional fields are only applied when they are set
+type MissedTasksFilter struct {
+	Status        string
+	PassedSeconds int32
+	Limit         int32
+	Type          *string
+	Priority      *string
+	MinID         *int32
+	MaxID         *int32
 }
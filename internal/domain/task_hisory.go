@@ -7,3 +7,11 @@ type TaskStatusChangeHistory struct {
 	NewStatus      string `json:"new_status"`
 	CreatedAtStamp int64  `json:"created_at_stamp"`
 }
+
+type TaskPriorityChangeHistory struct {
+	ID             int32  `json:"-"`
+	TaskID         int32  `json:"task_id"`
+	OldPriority    string `json:"old_priority"`
+	NewPriority    string `json:"new_priority"`
+	CreatedAtStamp int64  `json:"created_at_stamp"`
+}
@@ -0,0 +1,31 @@
+package domain
+
+import "context"
+
+// Template is a version of an email template in one locale, the subject and the text body are rendered with text/template and the HTML body with html/template
+type Template struct {
+	TemplateID     string `json:"template_id"`
+	Locale         string `json:"locale"`
+	Version        int32  `json:"version"`
+	Subject        string `json:"subject"`
+	Text           string `json:"text"`
+	HTML           string `json:"html"`
+	CreatedAtStamp int64  `json:"created_at_stamp"`
+}
+
+// TemplateStorage keeps every version of the templates, the versions are never updated and a change is stored as a new version
+type TemplateStorage interface {
+	// CreateTemplate stores the first version of a locale of the template, errval.ErrTemplateExists is returned when the locale already has a version
+	CreateTemplate(ctx context.Context, template *Template) (*Template, error)
+	// AddTemplateVersion stores the next version of a locale of the template, errval.ErrNotFound is returned when the locale has no version yet
+	AddTemplateVersion(ctx context.Context, template *Template) (*Template, error)
+	GetTemplate(ctx context.Context, templateID, locale string) (*Template, error)
+	GetTemplateVersion(ctx context.Context, templateID, locale string, version int32) (*Template, error)
+	GetTemplateVersions(ctx context.Context, templateID, locale string) ([]*Template, error)
+	// GetTemplates returns the latest version of every locale of all templates
+	GetTemplates(ctx context.Context) ([]*Template, error)
+	// GetTemplateLocales returns the latest version of every locale of the template
+	GetTemplateLocales(ctx context.Context, templateID string) ([]*Template, error)
+	DeleteTemplate(ctx context.Context, templateID string) (isDeleted bool, err error)
+	DeleteTemplateLocale(ctx context.Context, templateID, locale string) (isDeleted bool, err error)
+}
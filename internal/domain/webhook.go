@@ -0,0 +1,55 @@
+package domain
+
+import "context"
+
+type WebhookDeliveryStatus string
+
+const (
+	// WebhookPending deliveries are waiting for their next attempt
+	WebhookPending WebhookDeliveryStatus = "pending"
+	// WebhookDelivered deliveries have been accepted by their URL with a 2xx response
+	WebhookDelivered WebhookDeliveryStatus = "delivered"
+	// WebhookFailed deliveries have used all their attempts, they are only retried when they are redelivered
+	WebhookFailed WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is a status change of a task which is posted to the callback URL of the task
+type WebhookDelivery struct {
+	ID        int32                 `json:"id"`
+	TaskID    int32                 `json:"task_id"`
+	URL       string                `json:"url"`
+	OldStatus string                `json:"old_status"`
+	NewStatus string                `json:"new_status"`
+	Status    WebhookDeliveryStatus `json:"status"`
+	Attempts  int32                 `json:"attempts"`
+	// NextAttemptAtStamp is only meaningful for the pending deliveries
+	NextAttemptAtStamp int64  `json:"next_attempt_at_stamp"`
+	LastAttemptAtStamp *int64 `json:"last_attempt_at_stamp,omitempty"`
+	// LastResponseStatus is not set when the last attempt hasn't received any response
+	LastResponseStatus *int32 `json:"last_response_status,omitempty"`
+	LastError          string `json:"last_error,omitempty"`
+	DeliveredAtStamp   *int64 `json:"delivered_at_stamp,omitempty"`
+	CreatedAtStamp     int64  `json:"created_at_stamp"`
+}
+
+// WebhookDeliveriesFilter selects the latest Limit deliveries, the optional fields are only applied when they are set
+type WebhookDeliveriesFilter struct {
+	Status *WebhookDeliveryStatus
+	TaskID *int32
+	Limit  int32
+}
+
+type WebhookStorage interface {
+	// ClaimWebhookDeliveries returns the pending deliveries whose next attempt is due, and postpones their next attempt by leaseSeconds
+	// So the concurrent senders don't claim the same deliveries, and a delivery whose sender has died is retried after the lease
+	ClaimWebhookDeliveries(ctx context.Context, leaseSeconds, limit int32) ([]*WebhookDelivery, error)
+	// MarkWebhookDeliveryDelivered records the successful attempt of the claimed delivery, isUpdated is false when the delivery has been redelivered meanwhile
+	MarkWebhookDeliveryDelivered(ctx context.Context, delivery *WebhookDelivery, responseStatus int) (isUpdated bool, err error)
+	// MarkWebhookDeliveryAttemptFailed records the failed attempt of the claimed delivery, the delivery is failed when isFinal is set, otherwise it's retried after retryAfterSeconds
+	// responseStatus is zero when no response has been received
+	MarkWebhookDeliveryAttemptFailed(ctx context.Context, delivery *WebhookDelivery, isFinal bool, retryAfterSeconds int32, responseStatus int, errMessage string) (isUpdated bool, err error)
+	GetWebhookDeliveryByID(ctx context.Context, deliveryID int32) (*WebhookDelivery, error)
+	GetFilteredWebhookDeliveries(ctx context.Context, filter WebhookDeliveriesFilter) ([]*WebhookDelivery, error)
+	// RedeliverWebhookDelivery moves the failed delivery back to pending with all its attempts, errval.ErrNotRedeliverable is returned when it's not failed
+	RedeliverWebhookDelivery(ctx context.Context, deliveryID int32) (*WebhookDelivery, error)
+}
@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// WorkflowFailurePolicy decides what happens to the rest of a workflow when one of its tasks fails without any attempts left
+type WorkflowFailurePolicy string
+
+const (
+	// FailFast cancels all the tasks of the workflow which have not started yet
+	FailFast WorkflowFailurePolicy = "fail_fast"
+	// Continue runs the dependents of the failed task as if it has succeeded
+	Continue WorkflowFailurePolicy = "continue"
+	// SkipDependents skips all the tasks which depend on the failed task directly or indirectly, the other branches keep running
+	SkipDependents WorkflowFailurePolicy = "skip_dependents"
+)
+
+type WorkflowStatus string
+
+const (
+	// WorkflowQueued workflows have no task which has been started yet
+	WorkflowQueued    WorkflowStatus = "queued"
+	WorkflowRunning   WorkflowStatus = "running"
+	WorkflowSucceeded WorkflowStatus = "succeeded"
+	// WorkflowFailed workflows are finished, and at least one of their tasks has failed, been skipped or been cancelled
+	WorkflowFailed WorkflowStatus = "failed"
+)
+
+type Workflow struct {
+	ID             int32                 `json:"id"`
+	Name           string                `json:"name"`
+	FailurePolicy  WorkflowFailurePolicy `json:"failure_policy"`
+	Caller         string                `json:"caller,omitempty"`
+	CreatedAtStamp int64                 `json:"created_at_stamp"`
+}
+
+// WorkflowTask is a task of a new workflow, Key is unique in the workflow and DependsOn refers to the keys of its parents
+type WorkflowTask struct {
+	Key       string
+	Name      string
+	Type      string
+	Priority  string
+	Payload   json.RawMessage
+	DependsOn []string
+}
+
+// TaskDependency is an edge of a workflow, the task is queued after the task which it depends on
+type TaskDependency struct {
+	TaskID          int32 `json:"task_id"`
+	DependsOnTaskID int32 `json:"depends_on_task_id"`
+}
+
+type WorkflowStorage interface {
+	// InsertWorkflow inserts the workflow with all its tasks and their dependencies in a transaction
+	// The tasks without any dependency are inserted as queued, and the rest as pending
+	InsertWorkflow(ctx context.Context, workflow *Workflow, tasks []*WorkflowTask) (*Workflow, []*Task, error)
+	GetWorkflowByID(ctx context.Context, workflowID int32) (*Workflow, error)
+	GetWorkflowTasks(ctx context.Context, workflowID int32) ([]*Task, error)
+	GetWorkflowDependencies(ctx context.Context, workflowID int32) ([]*TaskDependency, error)
+	GetWorkflowStatusChangeHistory(ctx context.Context, workflowID int32) ([]*TaskStatusChangeHistory, error)
+	// GetParentTasks returns the tasks which the task depends on directly, with their results
+	GetParentTasks(ctx context.Context, taskID int32) ([]*Task, error)
+	// QueueReadyDependentTasks moves the pending dependents of the task whose parents are all finished to queued, and returns them
+	// A parent is finished when it has succeeded, or when failedIsFinished is set and it has failed maxAttempts times
+	QueueReadyDependentTasks(ctx context.Context, taskID int32, failedIsFinished bool, maxAttempts int32) ([]*Task, error)
+	// SkipDependentTasks moves all the pending tasks which depend on the task directly or indirectly to skipped
+	SkipDependentTasks(ctx context.Context, taskID int32) ([]*Task, error)
+	// CancelWorkflowTasks moves the pending and queued tasks of the workflow to cancelled
+	CancelWorkflowTasks(ctx context.Context, workflowID int32) ([]*Task, error)
+	// GetStalledWorkflowParentTasks returns the finished tasks which have not been updated in the last passedSeconds seconds, and still have a pending dependent
+	// whose parents are not waiting or running anymore, the workflow engine has probably stopped before moving their dependents forward
+	GetStalledWorkflowParentTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*Task, error)
+}
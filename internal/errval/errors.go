@@ -8,4 +8,17 @@ var (
 	ErrInternal        = errors.New("internal server error")
 	ErrNotFound        = errors.New("not found")
 	ErrInvalidTaskType = errors.New("invalid task type")
+	// ErrInvalidTaskStatus is returned when a filter refers to a status which doesn't exist
+	ErrInvalidTaskStatus = errors.New("invalid task status")
+	ErrInvalidPayload    = errors.New("invalid payload")
+	ErrStatusConflict    = errors.New("task status has been changed concurrently")
+	ErrForbidden         = errors.New("caller is not allowed to create the task")
+	ErrTemplateExists    = errors.New("template already exists")
+	ErrInvalidTemplate   = errors.New("invalid template")
+	ErrInvalidWorkflow   = errors.New("invalid workflow")
+	ErrInvalidBatch      = errors.New("invalid batch")
+	// ErrNotRedeliverable is returned when a webhook delivery which hasn't failed is redelivered
+	ErrNotRedeliverable = errors.New("only the failed webhook deliveries are able to be redelivered")
+	// ErrUnresolvedReference is returned when a reference of a payload to the result of a parent task can't be resolved
+	ErrUnresolvedReference = errors.New("unresolved reference")
 )
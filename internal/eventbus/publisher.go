@@ -0,0 +1,160 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/metrics"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// EnvelopeVersion is increased whenever a field of the envelope or its data is changed or removed, the new fields are added without changing it
+const EnvelopeVersion = 1
+
+// Envelope is the body of the messages of the event bus
+type Envelope struct {
+	Version int `json:"version"`
+	// ID is the same for all the publishes of an event, so the consumers are able to drop the duplicates
+	ID string `json:"id"`
+	// Type is task.<event>, e.g. task.succeeded
+	Type            string    `json:"type"`
+	OccurredAtStamp int64     `json:"occurred_at_stamp"`
+	Data            EventData `json:"data"`
+}
+
+// EventData is the state of the task right after the event
+type EventData struct {
+	TaskID       int32  `json:"task_id"`
+	TaskType     string `json:"task_type"`
+	TaskPriority string `json:"task_priority"`
+	// OldStatus is empty for the created events
+	OldStatus string `json:"old_status,omitempty"`
+	NewStatus string `json:"new_status"`
+	Attempts  int32  `json:"attempts"`
+}
+
+// Settings controls the publishing of the outbox
+type Settings struct {
+	Exchange  string
+	Interval  time.Duration
+	BatchSize int32
+	// LeaseSeconds must be longer than publishing a batch takes, otherwise the events are published twice by the concurrent publishers
+	LeaseSeconds int32
+}
+
+// Publisher periodically publishes the lifecycle events of the outbox to the topic exchange, and removes them from the outbox after the broker confirms them
+// The events are published at least once, a publisher which dies after publishing but before removing them publishes them again after the lease
+type Publisher struct {
+	storage   domain.LifecycleEventStorage
+	publisher domain.EventPublisher
+	settings  Settings
+}
+
+func NewPublisher(storage domain.LifecycleEventStorage, publisher domain.EventPublisher, settings Settings) *Publisher {
+	return &Publisher{
+		storage:   storage,
+		publisher: publisher,
+		settings:  settings,
+	}
+}
+
+// Run blocks and publishes the outbox every interval until the context is cancelled, a full batch is followed by the next one immediately
+func (p *Publisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Event bus publisher loop is stopped")
+			return
+		case <-ticker.C:
+			for {
+				publishedCount := p.PublishPending(ctx)
+				if publishedCount < int(p.settings.BatchSize) || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
+
+// PublishPending publishes one batch of the outbox in the order of the events, and returns the number of the published events
+func (p *Publisher) PublishPending(ctx context.Context) int {
+	events, err := p.storage.ClaimLifecycleEvents(ctx, p.settings.LeaseSeconds, p.settings.BatchSize)
+	if err != nil {
+		if !errors.Is(err, errval.ErrNotFound) {
+			slog.Error("Error occurred while claiming the lifecycle events", "error", err.Error())
+		}
+
+		return 0
+	}
+
+	messages := make([]domain.EventMessage, 0, len(events))
+	eventIDs := make([]int32, 0, len(events))
+	for _, event := range events {
+		message, err := NewMessage(event)
+		if err != nil {
+			slog.Error("Error occurred while encoding the lifecycle event", "event_id", event.ID, "error", err.Error())
+			return 0
+		}
+		messages = append(messages, message)
+		eventIDs = append(eventIDs, event.ID)
+	}
+
+	// The confirmations are waited for at most for the lease, the events are published again after it anyway
+	publishCtx, cancel := context.WithTimeout(ctx, time.Duration(p.settings.LeaseSeconds)*time.Second)
+	defer cancel()
+	err = p.publisher.PublishEvents(publishCtx, p.settings.Exchange, messages)
+	if err != nil {
+		metrics.PublishFailures.WithLabelValues(metrics.DestinationEvents).Inc()
+		// The events are published again after the lease
+		slog.Error("Error occurred while publishing the lifecycle events", "events_count", len(events), "error", err.Error())
+		return 0
+	}
+
+	err = p.storage.DeleteLifecycleEvents(ctx, eventIDs)
+	if err != nil {
+		slog.Error("Error occurred while removing the published lifecycle events from the outbox", "events_count", len(events), "error", err.Error())
+	}
+	slog.Info("Lifecycle events are published", "events_count", len(events), "last_event_id", eventIDs[len(eventIDs)-1])
+
+	return len(events)
+}
+
+// NewMessage returns the message of the event, which is routed by task.<type>.<event>
+func NewMessage(event *domain.LifecycleEvent) (domain.EventMessage, error) {
+	id := strconv.FormatInt(int64(event.ID), 10)
+	body, err := json.Marshal(Envelope{
+		Version:         EnvelopeVersion,
+		ID:              id,
+		Type:            "task." + string(event.Event),
+		OccurredAtStamp: event.CreatedAtStamp,
+		Data: EventData{
+			TaskID:       event.TaskID,
+			TaskType:     event.TaskType,
+			TaskPriority: event.TaskPriority,
+			OldStatus:    event.OldStatus,
+			NewStatus:    event.NewStatus,
+			Attempts:     event.Attempts,
+		},
+	})
+	if err != nil {
+		return domain.EventMessage{}, err
+	}
+
+	return domain.EventMessage{
+		RoutingKey: RoutingKey(event.TaskType, event.Event),
+		MessageID:  id,
+		Body:       body,
+	}, nil
+}
+
+// RoutingKey returns task.<type>.<event>, e.g. task.send_email.succeeded
+func RoutingKey(taskType string, event domain.LifecycleEventType) string {
+	return "task." + taskType + "." + string(event)
+}
@@ -0,0 +1,128 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"testing"
+	"time"
+)
+
+// fakeStorage hands out its events once, and records the deleted ones
+type fakeStorage struct {
+	events     []*domain.LifecycleEvent
+	deletedIDs []int32
+}
+
+func (f *fakeStorage) ClaimLifecycleEvents(ctx context.Context, leaseSeconds, limit int32) ([]*domain.LifecycleEvent, error) {
+	if len(f.events) == 0 {
+		return nil, errval.ErrNotFound
+	}
+	events := f.events
+	f.events = nil
+
+	return events, nil
+}
+
+func (f *fakeStorage) DeleteLifecycleEvents(ctx context.Context, eventIDs []int32) error {
+	f.deletedIDs = append(f.deletedIDs, eventIDs...)
+	return nil
+}
+
+// fakePublisher fails like the confirmations of the broker when ctx is done, and records the deadline of ctx
+type fakePublisher struct {
+	err      error
+	exchange string
+	messages []domain.EventMessage
+	deadline time.Time
+}
+
+func (f *fakePublisher) PublishEvents(ctx context.Context, exchange string, messages []domain.EventMessage) error {
+	if f.err != nil {
+		return f.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.deadline, _ = ctx.Deadline()
+	f.exchange = exchange
+	f.messages = append(f.messages, messages...)
+
+	return nil
+}
+
+var testEvents = []*domain.LifecycleEvent{
+	{ID: 1, TaskID: 5, TaskType: "send_email", TaskPriority: "normal", Event: domain.EventCreated, NewStatus: "queued", CreatedAtStamp: 1700000000},
+	{ID: 2, TaskID: 5, TaskType: "send_email", TaskPriority: "normal", Event: domain.EventStarted, OldStatus: "queued", NewStatus: "running", Attempts: 1, CreatedAtStamp: 1700000001},
+}
+
+// TestNewMessage: the message is routed by the type and the event of the task, and its body is the versioned envelope
+func TestNewMessage(t *testing.T) {
+	message, err := NewMessage(testEvents[1])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if message.RoutingKey != "task.send_email.started" || message.MessageID != "2" {
+		t.Fatalf("unexpected routing key %s or message id %s", message.RoutingKey, message.MessageID)
+	}
+
+	var envelope Envelope
+	err = json.Unmarshal(message.Body, &envelope)
+	if err != nil {
+		t.Fatalf("expected a JSON envelope, got %v", err)
+	}
+	expectedEnvelope := Envelope{
+		Version:         EnvelopeVersion,
+		ID:              "2",
+		Type:            "task.started",
+		OccurredAtStamp: 1700000001,
+		Data:            EventData{TaskID: 5, TaskType: "send_email", TaskPriority: "normal", OldStatus: "queued", NewStatus: "running", Attempts: 1},
+	}
+	if envelope != expectedEnvelope {
+		t.Fatalf("expected %#v, got %#v", expectedEnvelope, envelope)
+	}
+}
+
+// TestPublishPending_Published: the events are published in their order, and removed from the outbox after they are published
+func TestPublishPending_Published(t *testing.T) {
+	storage := &fakeStorage{events: testEvents}
+	publisher := &fakePublisher{}
+	publishedCount := NewPublisher(storage, publisher, Settings{Exchange: "task_events", BatchSize: 10, LeaseSeconds: 30}).PublishPending(context.Background())
+
+	if publishedCount != 2 || publisher.exchange != "task_events" || len(publisher.messages) != 2 {
+		t.Fatalf("expected 2 messages to be published to task_events, got %d to %s", len(publisher.messages), publisher.exchange)
+	}
+	if publisher.messages[0].MessageID != "1" || publisher.messages[1].MessageID != "2" {
+		t.Fatalf("expected the messages in the order of the events")
+	}
+	if len(storage.deletedIDs) != 2 {
+		t.Fatalf("expected the published events to be deleted, got %v", storage.deletedIDs)
+	}
+}
+
+// TestPublishPending_Failed: the events are kept in the outbox when the broker doesn't confirm them, so they are published again after the lease
+func TestPublishPending_Failed(t *testing.T) {
+	storage := &fakeStorage{events: testEvents}
+	publishedCount := NewPublisher(storage, &fakePublisher{err: errors.New("channel closed")}, Settings{BatchSize: 10, LeaseSeconds: 30}).PublishPending(context.Background())
+
+	if publishedCount != 0 || len(storage.deletedIDs) != 0 {
+		t.Fatalf("expected nothing to be published or deleted, got %d and %v", publishedCount, storage.deletedIDs)
+	}
+}
+
+// TestPublishPending_ConfirmTimeout: the confirmations are waited for by the context of the publishing, which is bounded by the lease
+func TestPublishPending_ConfirmTimeout(t *testing.T) {
+	storage := &fakeStorage{events: testEvents}
+	publisher := &fakePublisher{}
+	startedAt := time.Now()
+	publishedCount := NewPublisher(storage, publisher, Settings{BatchSize: 10, LeaseSeconds: 30}).PublishPending(context.Background())
+
+	if publishedCount != 2 || len(storage.deletedIDs) != 2 {
+		t.Fatalf("expected the events to be published and deleted, got %d and %v", publishedCount, storage.deletedIDs)
+	}
+	if publisher.deadline.Before(startedAt.Add(30*time.Second)) || publisher.deadline.After(time.Now().Add(30*time.Second)) {
+		t.Fatalf("expected the confirmations to be waited for during the lease, got the deadline %s", publisher.deadline)
+	}
+}
@@ -0,0 +1,204 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// replayPageSize is the number of the missed events which are fetched at once while a subscription is resumed
+const replayPageSize = 100
+
+// ErrDropped is returned by the subscriptions which have been dropped by the hub, the clients are expected to resume them by the id of their last event
+var ErrDropped = errors.New("subscription has been dropped")
+
+// Settings controls the subscriptions of the hub
+type Settings struct {
+	// SubscriberBufferSize is the number of the events which are kept for a slow subscriber, it's dropped when its buffer is full
+	SubscriberBufferSize int
+	// MaxReplayedEvents caps the missed events which are replayed when a subscription is resumed
+	MaxReplayedEvents int32
+	// ReconnectInterval is the delay before listening again after the connection of the listener is lost
+	ReconnectInterval time.Duration
+}
+
+// Hub listens to the status changes of the tasks which are committed by any replica, and fans them out to the subscriptions of this replica
+// The subscriptions are dropped whenever the hub stops listening, so their clients resume them and the missed events are replayed from the database
+type Hub struct {
+	storage       domain.EventStorage
+	settings      Settings
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func NewHub(storage domain.EventStorage, settings Settings) *Hub {
+	return &Hub{
+		storage:       storage,
+		settings:      settings,
+		subscriptions: map[*Subscription]struct{}{},
+	}
+}
+
+// Run blocks and listens to the status changes until the context is cancelled, it listens again whenever its connection is lost
+func (h *Hub) Run(ctx context.Context) {
+	for {
+		// The subscriptions which are made before listening might have missed some events, so they are dropped as soon as the hub is listening
+		err := h.storage.ListenTaskEvents(ctx, h.dropAll, h.publish)
+		h.dropAll()
+		if ctx.Err() != nil {
+			slog.Info("Task events listener is stopped")
+			return
+		}
+		slog.Error("Task events listener has stopped listening, it's going to listen again", "error", err.Error())
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Task events listener is stopped")
+			return
+		case <-time.After(h.settings.ReconnectInterval):
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events which match the filter
+// When lastEventID is set, the events after it are replayed from the database before the live events
+func (h *Hub) Subscribe(filter domain.TaskEventsFilter, lastEventID int32) *Subscription {
+	subscription := &Subscription{
+		hub:         h,
+		filter:      filter,
+		live:        make(chan *domain.TaskEvent, h.settings.SubscriberBufferSize),
+		cursor:      lastEventID,
+		isReplaying: lastEventID > 0,
+		replayedIDs: map[int32]struct{}{},
+	}
+
+	h.mu.Lock()
+	h.subscriptions[subscription] = struct{}{}
+	h.mu.Unlock()
+
+	return subscription
+}
+
+func (h *Hub) publish(event *domain.TaskEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscription := range h.subscriptions {
+		if !subscription.filter.Matches(event) {
+			continue
+		}
+
+		select {
+		case subscription.live <- event:
+		default:
+			slog.Warn("Task events subscriber is too slow, dropping it", "last_event_id", event.ID)
+			h.drop(subscription)
+		}
+	}
+}
+
+func (h *Hub) dropAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscription := range h.subscriptions {
+		h.drop(subscription)
+	}
+}
+
+// drop must be called while holding the lock
+func (h *Hub) drop(subscription *Subscription) {
+	delete(h.subscriptions, subscription)
+	close(subscription.live)
+}
+
+// Subscription is the stream of the events of a client, it must only be used by one goroutine
+type Subscription struct {
+	hub    *Hub
+	filter domain.TaskEventsFilter
+	live   chan *domain.TaskEvent
+	// cursor is the id of the last replayed event
+	cursor        int32
+	isReplaying   bool
+	isLastPage    bool
+	replayed      []*domain.TaskEvent
+	replayedCount int32
+	// replayedIDs prevents the live events which have also been replayed from being sent twice
+	replayedIDs map[int32]struct{}
+}
+
+// Next returns the next event, the missed events are returned first in the order of their ids
+// It returns nil when no event is received in timeout, and ErrDropped when the subscription has been dropped by the hub
+func (s *Subscription) Next(ctx context.Context, timeout time.Duration) (*domain.TaskEvent, error) {
+	if s.isReplaying {
+		event, err := s.nextReplayed(ctx)
+		if err != nil || event != nil {
+			return event, err
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, nil
+		case event, ok := <-s.live:
+			if !ok {
+				return nil, ErrDropped
+			}
+			if _, isReplayed := s.replayedIDs[event.ID]; isReplayed {
+				continue
+			}
+
+			return event, nil
+		}
+	}
+}
+
+// nextReplayed returns nil when all the missed events have been replayed
+func (s *Subscription) nextReplayed(ctx context.Context) (*domain.TaskEvent, error) {
+	if len(s.replayed) == 0 {
+		limit := min(replayPageSize, s.hub.settings.MaxReplayedEvents-s.replayedCount)
+		if s.isLastPage || limit <= 0 {
+			s.isReplaying = false
+			return nil, nil
+		}
+
+		events, err := s.hub.storage.GetTaskEventsAfter(ctx, s.cursor, s.filter, limit)
+		if err != nil {
+			if errors.Is(err, errval.ErrNotFound) {
+				s.isReplaying = false
+				return nil, nil
+			}
+
+			return nil, err
+		}
+		s.replayed = events
+		s.isLastPage = int32(len(events)) < limit
+	}
+
+	event := s.replayed[0]
+	s.replayed = s.replayed[1:]
+	s.cursor = event.ID
+	s.replayedCount++
+	s.replayedIDs[event.ID] = struct{}{}
+
+	return event, nil
+}
+
+// Close stops the subscription, it's safe to be called after the subscription has been dropped
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if _, ok := s.hub.subscriptions[s]; ok {
+		s.hub.drop(s)
+	}
+}
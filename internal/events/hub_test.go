@@ -0,0 +1,137 @@
+package events
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"testing"
+	"time"
+)
+
+// fakeStorage returns the stored events after the given id, it never listens
+type fakeStorage struct {
+	events []*domain.TaskEvent
+}
+
+func (f *fakeStorage) ListenTaskEvents(ctx context.Context, listening func(), handle func(event *domain.TaskEvent)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (f *fakeStorage) GetTaskEventsAfter(ctx context.Context, afterID int32, filter domain.TaskEventsFilter, limit int32) ([]*domain.TaskEvent, error) {
+	var events []*domain.TaskEvent
+	for _, event := range f.events {
+		if event.ID > afterID && filter.Matches(event) && int32(len(events)) < limit {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return events, nil
+}
+
+func newTestEvents(count int32) []*domain.TaskEvent {
+	events := make([]*domain.TaskEvent, 0, count)
+	for id := int32(1); id <= count; id++ {
+		events = append(events, &domain.TaskEvent{ID: id, TaskID: id % 2, NewStatus: string(domain.Succeeded)})
+	}
+
+	return events
+}
+
+func nextIDs(t *testing.T, subscription *Subscription, count int) []int32 {
+	ids := make([]int32, 0, count)
+	for range count {
+		event, err := subscription.Next(context.Background(), 10*time.Millisecond)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if event == nil {
+			t.Fatalf("expected %d events, got %v", count, ids)
+		}
+		ids = append(ids, event.ID)
+	}
+
+	return ids
+}
+
+// TestSubscription_Replay: the events after the last event id are replayed in pages before the live events, and the live events which have been replayed are skipped
+func TestSubscription_Replay(t *testing.T) {
+	storage := &fakeStorage{events: newTestEvents(250)}
+	hub := NewHub(storage, Settings{SubscriberBufferSize: 10, MaxReplayedEvents: 1000})
+	subscription := hub.Subscribe(domain.TaskEventsFilter{}, 245)
+	defer subscription.Close()
+
+	// Event 250 is committed after the subscription, so it's received both by the replay and live
+	hub.publish(storage.events[249])
+	hub.publish(&domain.TaskEvent{ID: 251})
+
+	ids := nextIDs(t, subscription, 6)
+	expectedIDs := []int32{246, 247, 248, 249, 250, 251}
+	for i := range expectedIDs {
+		if ids[i] != expectedIDs[i] {
+			t.Fatalf("expected %v, got %v", expectedIDs, ids)
+		}
+	}
+
+	event, err := subscription.Next(context.Background(), 10*time.Millisecond)
+	if event != nil || err != nil {
+		t.Fatalf("expected no more events, got %v and %v", event, err)
+	}
+}
+
+// TestSubscription_MaxReplayedEvents: the replay stops after MaxReplayedEvents, even when it takes several pages
+func TestSubscription_MaxReplayedEvents(t *testing.T) {
+	hub := NewHub(&fakeStorage{events: newTestEvents(500)}, Settings{SubscriberBufferSize: 10, MaxReplayedEvents: 150})
+	subscription := hub.Subscribe(domain.TaskEventsFilter{}, 1)
+	defer subscription.Close()
+
+	ids := nextIDs(t, subscription, 150)
+	if ids[0] != 2 || ids[149] != 151 {
+		t.Fatalf("expected the events 2 to 151, got %d to %d", ids[0], ids[149])
+	}
+
+	event, _ := subscription.Next(context.Background(), 10*time.Millisecond)
+	if event != nil {
+		t.Fatalf("expected the replay to stop, got event %d", event.ID)
+	}
+}
+
+// TestHub_Filter: the subscriptions only receive the live events which match their filter
+func TestHub_Filter(t *testing.T) {
+	hub := NewHub(&fakeStorage{}, Settings{SubscriberBufferSize: 10})
+	taskID := int32(1)
+	subscription := hub.Subscribe(domain.TaskEventsFilter{TaskID: &taskID}, 0)
+	defer subscription.Close()
+
+	for _, event := range newTestEvents(4) {
+		hub.publish(event)
+	}
+
+	ids := nextIDs(t, subscription, 2)
+	if ids[0] != 1 || ids[1] != 3 {
+		t.Fatalf("expected the events of task 1, got %v", ids)
+	}
+}
+
+// TestHub_DropSlowSubscriber: a subscription whose buffer is full is dropped, and it returns ErrDropped after its buffered events
+func TestHub_DropSlowSubscriber(t *testing.T) {
+	hub := NewHub(&fakeStorage{}, Settings{SubscriberBufferSize: 2})
+	subscription := hub.Subscribe(domain.TaskEventsFilter{}, 0)
+	defer subscription.Close()
+
+	for _, event := range newTestEvents(3) {
+		hub.publish(event)
+	}
+
+	nextIDs(t, subscription, 2)
+	_, err := subscription.Next(context.Background(), 10*time.Millisecond)
+	if err != ErrDropped {
+		t.Fatalf("expected ErrDropped, got %v", err)
+	}
+	if len(hub.subscriptions) != 0 {
+		t.Fatalf("expected the subscription to be removed from the hub")
+	}
+}
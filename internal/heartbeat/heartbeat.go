@@ -0,0 +1,96 @@
+package heartbeat
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/domain"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Heartbeat periodically proves that a worker is still processing a running task
+// On each beat, it touches updated_at of the task, which is used by the reaper as the expiry of the running lease,
+// and it renews the lock of the task in the distributed lock infra, so the lock doesn't expire during long tasks
+type Heartbeat struct {
+	storage      domain.Storage
+	lock         domain.DistributedLock
+	taskID       int32
+	lockKey      string
+	owner        string
+	interval     time.Duration
+	lockDuration time.Duration
+	onLost       func()
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewHeartbeat(storage domain.Storage, lock domain.DistributedLock, taskID int32, lockKey, owner string, interval, lockDuration time.Duration, onLost func()) *Heartbeat {
+	return &Heartbeat{
+		storage:      storage,
+		lock:         lock,
+		taskID:       taskID,
+		lockKey:      lockKey,
+		owner:        owner,
+		interval:     interval,
+		lockDuration: lockDuration,
+		onLost:       onLost,
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Start beats in the background until Stop is called or the context is done
+// onLost is called once if the task is not running anymore, e.g. the reaper has taken it back because the heartbeats were late
+func (h *Heartbeat) Start(ctx context.Context) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-h.stopChan:
+				return
+			case <-ticker.C:
+				if !h.beat(ctx) {
+					h.onLost()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the heartbeats and waits for the in-flight beat to finish
+func (h *Heartbeat) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopChan)
+	})
+	h.wg.Wait()
+}
+
+// beat returns false only if the task is known to be lost, transient errors are logged and the next beat is tried
+func (h *Heartbeat) beat(ctx context.Context) bool {
+	isTouched, err := h.storage.TouchTask(ctx, h.taskID, string(domain.Running))
+	if err != nil {
+		slog.Error("Error occurred while touching the running task in heartbeat", "task_id", h.taskID, "error", err.Error())
+	} else if !isTouched {
+		slog.Warn("Task is not running anymore, the running lease is lost", "task_id", h.taskID)
+		return false
+	}
+
+	isAcquired, err := h.lock.AcquireLease(h.lockKey, h.owner, h.lockDuration)
+	if err != nil {
+		slog.Error("Error occurred while renewing the lock of the task in heartbeat", "task_id", h.taskID, "lock_key", h.lockKey, "error", err.Error())
+	} else if !isAcquired {
+		slog.Warn("Lock of the task is held by another worker, the running lease is lost", "task_id", h.taskID, "lock_key", h.lockKey)
+		return false
+	}
+
+	return true
+}
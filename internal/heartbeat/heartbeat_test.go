@@ -0,0 +1,154 @@
+package heartbeat
+
+import (
+	"context"
+	"errors"
+	"github.com/sf7293/task-manager/internal/domain"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeStorage counts the touches of the running task, the task is lost once isLost is set
+type fakeStorage struct {
+	domain.Storage
+	touchedCount atomic.Int32
+	isLost       atomic.Bool
+	err          atomic.Pointer[error]
+}
+
+func (f *fakeStorage) TouchTask(ctx context.Context, taskID int32, taskStatus string) (bool, error) {
+	if err := f.err.Load(); err != nil {
+		return false, *err
+	}
+	if f.isLost.Load() || taskStatus != string(domain.Running) {
+		return false, nil
+	}
+	f.touchedCount.Add(1)
+
+	return true, nil
+}
+
+// fakeLock records the lease of the task lock
+type fakeLock struct {
+	domain.DistributedLock
+	mu            sync.Mutex
+	holder        string
+	leaseDuration time.Duration
+	renewedCount  int
+}
+
+func (f *fakeLock) AcquireLease(leaseKey, owner string, leaseDuration time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.holder != owner {
+		return false, nil
+	}
+	f.leaseDuration = leaseDuration
+	f.renewedCount++
+
+	return true, nil
+}
+
+func (f *fakeLock) setHolder(holder string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.holder = holder
+}
+
+func (f *fakeLock) renewals() (int, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.renewedCount, f.leaseDuration
+}
+
+const (
+	testOwner        = "my-consumer:1"
+	testInterval     = 5 * time.Millisecond
+	testLockDuration = 15 * time.Millisecond
+)
+
+func newTestHeartbeat(storage *fakeStorage, lock *fakeLock) (*Heartbeat, *atomic.Int32) {
+	lostCount := &atomic.Int32{}
+	return NewHeartbeat(storage, lock, 7, "lock:7", testOwner, testInterval, testLockDuration, func() { lostCount.Add(1) }), lostCount
+}
+
+// TestHeartbeat_ExtendsLease: every beat touches the running task and renews the lock of the task for the lock duration
+func TestHeartbeat_ExtendsLease(t *testing.T) {
+	storage := &fakeStorage{}
+	lock := &fakeLock{holder: testOwner}
+	heartbeat, lostCount := newTestHeartbeat(storage, lock)
+
+	heartbeat.Start(context.Background())
+	time.Sleep(10 * testInterval)
+	heartbeat.Stop()
+
+	renewedCount, leaseDuration := lock.renewals()
+	if storage.touchedCount.Load() < 3 || renewedCount < 3 {
+		t.Fatalf("expected several beats, got %d touches and %d renewals", storage.touchedCount.Load(), renewedCount)
+	}
+	if leaseDuration != testLockDuration {
+		t.Fatalf("expected the lock to be renewed for %s, got %s", testLockDuration, leaseDuration)
+	}
+	if lostCount.Load() != 0 {
+		t.Fatalf("expected the task not to be lost")
+	}
+
+	// No beat happens after Stop
+	touchedCount := storage.touchedCount.Load()
+	time.Sleep(3 * testInterval)
+	if storage.touchedCount.Load() != touchedCount {
+		t.Fatalf("expected no beats after Stop")
+	}
+}
+
+// TestHeartbeat_Lost: the heartbeats stop and onLost is called once when the task is taken back or its lock is taken by another worker
+func TestHeartbeat_Lost(t *testing.T) {
+	cases := map[string]func(storage *fakeStorage, lock *fakeLock){
+		"task is not running": func(storage *fakeStorage, lock *fakeLock) { storage.isLost.Store(true) },
+		"lock is taken":       func(storage *fakeStorage, lock *fakeLock) { lock.setHolder("my-consumer:2") },
+	}
+	for name, loseTask := range cases {
+		t.Run(name, func(t *testing.T) {
+			storage := &fakeStorage{}
+			lock := &fakeLock{holder: testOwner}
+			heartbeat, lostCount := newTestHeartbeat(storage, lock)
+
+			heartbeat.Start(context.Background())
+			defer heartbeat.Stop()
+			time.Sleep(3 * testInterval)
+			loseTask(storage, lock)
+			time.Sleep(5 * testInterval)
+
+			if lostCount.Load() != 1 {
+				t.Fatalf("expected onLost to be called once, got %d", lostCount.Load())
+			}
+			touchedCount := storage.touchedCount.Load()
+			time.Sleep(3 * testInterval)
+			if storage.touchedCount.Load() != touchedCount {
+				t.Fatalf("expected the heartbeats to stop")
+			}
+		})
+	}
+}
+
+// TestHeartbeat_TransientError: the errors of the storage don't lose the task, the next beats are tried
+func TestHeartbeat_TransientError(t *testing.T) {
+	storage := &fakeStorage{}
+	err := errors.New("connection reset")
+	storage.err.Store(&err)
+	lock := &fakeLock{holder: testOwner}
+	heartbeat, lostCount := newTestHeartbeat(storage, lock)
+
+	heartbeat.Start(context.Background())
+	time.Sleep(3 * testInterval)
+	storage.err.Store(nil)
+	time.Sleep(3 * testInterval)
+	heartbeat.Stop()
+
+	if lostCount.Load() != 0 || storage.touchedCount.Load() == 0 {
+		t.Fatalf("expected the heartbeats to go on after the errors, got %d losses and %d touches", lostCount.Load(), storage.touchedCount.Load())
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"strconv"
+	"time"
+)
+
+// Namespace prefixes the names of all the metrics of the project
+const Namespace = "taskmanager"
+
+// The outcomes of the executions of the tasks
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeTimedOut  = "timed_out"
+	OutcomeWaiting   = "waiting"
+)
+
+// The kinds of the retries of the tasks
+const (
+	// RetryInProcess is a retry of the failed execution by the worker, within the same attempt
+	RetryInProcess = "in_process"
+	// RetryAttempt is a new attempt of a task which has been started before, e.g. after it's re-queued by the recovery
+	RetryAttempt = "attempt"
+)
+
+// The destinations of the published messages
+const (
+	DestinationJobs   = "jobs"
+	DestinationEvents = "events"
+)
+
+// The metrics are registered to the default registry, which also serves the Go runtime and the process metrics
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of the handled HTTP requests by route, method and status code.",
+	}, []string{"method", "route", "code"})
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the handled HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	TasksCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "tasks_created_total",
+		Help:      "Number of the created tasks by type and priority.",
+	}, []string{"type", "priority"})
+	TaskExecutionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "task_execution_duration_seconds",
+		Help:      "Duration of the executions of the tasks by the workers, including their in-process retries, by type and outcome.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"type", "outcome"})
+	TaskQueueWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "task_queue_wait_seconds",
+		Help:      "Time from the creation of the tasks to the start of their first attempt, by type and priority.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"type", "priority"})
+	TaskRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "task_retries_total",
+		Help:      "Number of the retries of the tasks by type and kind, which is in_process or attempt.",
+	}, []string{"type", "kind"})
+
+	LockContentions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "task_lock_contentions_total",
+		Help:      "Number of the messages which are skipped because their task is locked by another worker.",
+	})
+	PublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "publish_failures_total",
+		Help:      "Number of the failed publishes to RabbitMQ by destination, which is jobs or events.",
+	}, []string{"destination"})
+)
+
+// TaskCreated counts the created task
+func TaskCreated(taskType, priority string) {
+	TasksCreated.WithLabelValues(taskType, priority).Inc()
+}
+
+// Handler serves the metrics of the default registry
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware counts the requests and measures their latency by the route pattern, so the IDs in the paths don't explode the labels
+// The requests which don't match any route are grouped as unmatched
+func Middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	HTTPRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	HTTPRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+}
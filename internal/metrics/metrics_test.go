@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestMiddleware: the requests are counted by their route pattern, and the requests which don't match any route are grouped
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware)
+	r.GET("/tasks/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, path := range []string{"/tasks/1", "/tasks/2", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if count := testutil.ToFloat64(HTTPRequests.WithLabelValues(http.MethodGet, "/tasks/:id", "200")); count != 2 {
+		t.Fatalf("expected 2 requests of the route, got %v", count)
+	}
+	if count := testutil.ToFloat64(HTTPRequests.WithLabelValues(http.MethodGet, "unmatched", "404")); count != 1 {
+		t.Fatalf("expected 1 unmatched request, got %v", count)
+	}
+}
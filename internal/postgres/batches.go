@@ -0,0 +1,334 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+)
+
+type batchStorage struct {
+	queries   *Queries
+	pool      *pgxpool.Pool
+	taskTypes domain.TaskTypeRegistry
+}
+
+// NewBatchStorage returns the storage of the batches, the types of the tasks are checked against taskTypes before they are inserted
+func NewBatchStorage(pool *pgxpool.Pool, taskTypes domain.TaskTypeRegistry) *batchStorage {
+	return &batchStorage{
+		queries:   New(pool),
+		pool:      pool,
+		taskTypes: taskTypes,
+	}
+}
+
+func (s *batchStorage) InsertBatch(ctx context.Context, batch *domain.Batch, tasks []*domain.BatchTask) (*domain.Batch, []*domain.Task, error) {
+	for _, task := range tasks {
+		if !s.taskTypes.IsRegistered(task.Type) {
+			return nil, nil, errval.ErrInvalidTaskType
+		}
+	}
+	if batch.CompletionTask != nil && !s.taskTypes.IsRegistered(batch.CompletionTask.Type) {
+		return nil, nil, errval.ErrInvalidTaskType
+	}
+
+	var completionTaskJSON pgtype.JSONB
+	if batch.CompletionTask != nil {
+		err := completionTaskJSON.Set(batch.CompletionTask)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		completionTaskJSON.Status = pgtype.Null
+	}
+
+	var insertedBatch *domain.Batch
+	var insertedTasks []*domain.Task
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		batchRow, err := qtx.InsertBatch(ctx, InsertBatchParams{
+			Name:                 batch.Name,
+			Caller:               sql.NullString{String: batch.Caller, Valid: batch.Caller != ""},
+			TotalCount:           int32(len(tasks)),
+			CompletionTask:       completionTaskJSON,
+			CompletionWebhookUrl: sql.NullString{String: batch.CompletionWebhookURL, Valid: batch.CompletionWebhookURL != ""},
+		})
+		if err != nil {
+			return err
+		}
+		insertedBatch, err = convertBatch(batchRow)
+		if err != nil {
+			return err
+		}
+
+		insertedTasks = make([]*domain.Task, 0, len(tasks))
+		for _, task := range tasks {
+			insertedTask, err := insertQueuedBatchTask(ctx, qtx, task, batch.Caller, sql.NullInt32{Int32: batchRow.ID, Valid: true})
+			if err != nil {
+				return err
+			}
+			insertedTasks = append(insertedTasks, insertedTask)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return insertedBatch, insertedTasks, nil
+}
+
+func (s *batchStorage) GetBatchByID(ctx context.Context, batchID int32) (*domain.Batch, error) {
+	batch, err := s.queries.GetBatchByID(ctx, batchID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errval.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return convertBatch(batch)
+}
+
+// CountBatchTask marks the task as counted and increases the counter in the same transaction
+// When the task is counted concurrently, e.g. by the worker and the recovery, the second one waits for the first one and finds it counted
+func (s *batchStorage) CountBatchTask(ctx context.Context, task *domain.Task, status domain.TaskStatus) (*domain.Batch, error) {
+	params := IncreaseBatchCountersParams{ID: *task.BatchID}
+	switch status {
+	case domain.Succeeded:
+		params.SucceededCount = 1
+	case domain.Failed:
+		params.FailedCount = 1
+	case domain.Cancelled:
+		params.CancelledCount = 1
+	default:
+		return nil, errors.New("only the succeeded, failed and cancelled tasks are able to be counted")
+	}
+
+	var countedBatch *domain.Batch
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		affectedRows, err := qtx.MarkBatchTaskCounted(ctx, task.ID)
+		if err != nil {
+			return err
+		}
+		if affectedRows == 0 {
+			return nil
+		}
+
+		batchRow, err := qtx.IncreaseBatchCounters(ctx, params)
+		if err != nil {
+			return err
+		}
+		countedBatch, err = convertBatch(batchRow)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return countedBatch, nil
+}
+
+func (s *batchStorage) CancelBatchTasks(ctx context.Context, batchID int32, maxAttempts int32) (*domain.Batch, []*domain.Task, error) {
+	var cancelledBatch *domain.Batch
+	var cancelledTasks []*domain.Task
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		tasks, err := qtx.LockCancellableBatchTasks(ctx, LockCancellableBatchTasksParams{
+			BatchID:     sql.NullInt32{Int32: batchID, Valid: true},
+			MaxAttempts: maxAttempts,
+		})
+		if err != nil {
+			return err
+		}
+
+		cancelledTasks, err = transitLockedTasks(ctx, qtx, tasks, domain.Cancelled)
+		if err != nil {
+			return err
+		}
+
+		// The cancelled tasks are locked, so none of them has been counted concurrently
+		taskIDs := make([]int32, 0, len(tasks))
+		for _, task := range tasks {
+			taskIDs = append(taskIDs, task.ID)
+		}
+		err = qtx.MarkBatchTasksCounted(ctx, taskIDs)
+		if err != nil {
+			return err
+		}
+
+		batchRow, err := qtx.IncreaseBatchCounters(ctx, IncreaseBatchCountersParams{
+			CancelledCount: int32(len(tasks)),
+			ID:             batchID,
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return errval.ErrNotFound
+			}
+
+			return err
+		}
+		cancelledBatch, err = convertBatch(batchRow)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cancelledBatch, cancelledTasks, nil
+}
+
+func (s *batchStorage) CompleteBatch(ctx context.Context, batchID int32, completionTask, completionWebhookTask *domain.BatchTask) (bool, []*domain.Task, error) {
+	isCompleted := false
+	var completionTasks []*domain.Task
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		batchRow, err := qtx.CompleteBatch(ctx, batchID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				// The batch has already been completed, or it's not finished yet
+				return nil
+			}
+
+			return err
+		}
+		isCompleted = true
+
+		params := SetBatchCompletionTaskIDsParams{ID: batchID}
+		if completionTask != nil {
+			insertedTask, err := insertQueuedBatchTask(ctx, qtx, completionTask, batchRow.Caller.String, sql.NullInt32{})
+			if err != nil {
+				return err
+			}
+			params.CompletionTaskID = sql.NullInt32{Int32: insertedTask.ID, Valid: true}
+			completionTasks = append(completionTasks, insertedTask)
+		}
+		if completionWebhookTask != nil {
+			insertedTask, err := insertQueuedBatchTask(ctx, qtx, completionWebhookTask, batchRow.Caller.String, sql.NullInt32{})
+			if err != nil {
+				return err
+			}
+			params.CompletionWebhookTaskID = sql.NullInt32{Int32: insertedTask.ID, Valid: true}
+			completionTasks = append(completionTasks, insertedTask)
+		}
+
+		return qtx.SetBatchCompletionTaskIDs(ctx, params)
+	})
+	if err != nil {
+		return false, nil, err
+	}
+
+	return isCompleted, completionTasks, nil
+}
+
+func (s *batchStorage) GetUncountedBatchTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	tasks, err := s.queries.GetUncountedBatchTasks(ctx, GetUncountedBatchTasksParams{
+		MaxAttempts:   maxAttempts,
+		PassedSeconds: passedSeconds,
+		MaxCount:      limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return convertTasks(tasks), nil
+}
+
+func (s *batchStorage) GetFinishedUncompletedBatches(ctx context.Context, limit int32) ([]*domain.Batch, error) {
+	batches, err := s.queries.GetFinishedUncompletedBatches(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(batches) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	convertedBatches := make([]*domain.Batch, 0, len(batches))
+	for _, batch := range batches {
+		convertedBatch, err := convertBatch(batch)
+		if err != nil {
+			return nil, err
+		}
+		convertedBatches = append(convertedBatches, convertedBatch)
+	}
+
+	return convertedBatches, nil
+}
+
+// insertQueuedBatchTask inserts a queued task, batchID is null for the completion tasks since they are not a member of the batch
+func insertQueuedBatchTask(ctx context.Context, qtx *Queries, task *domain.BatchTask, caller string, batchID sql.NullInt32) (*domain.Task, error) {
+	var payloadJSON pgtype.JSONB
+	err := payloadJSON.Set([]byte(task.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	taskID, err := qtx.InsertBatchTask(ctx, InsertBatchTaskParams{
+		Name:     task.Name,
+		Type:     task.Type,
+		Status:   TaskStatus(domain.Queued),
+		Priority: TaskPriority(task.Priority),
+		Payload:  payloadJSON,
+		Caller:   sql.NullString{String: caller, Valid: caller != ""},
+		BatchID:  batchID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	insertedTask := &domain.Task{
+		ID:       taskID,
+		Type:     task.Type,
+		Status:   string(domain.Queued),
+		Priority: task.Priority,
+		PayLoad:  task.Payload,
+		Caller:   caller,
+	}
+	if batchID.Valid {
+		insertedTask.BatchID = &batchID.Int32
+	}
+
+	return insertedTask, nil
+}
+
+func convertBatch(batch Batch) (*domain.Batch, error) {
+	convertedBatch := &domain.Batch{
+		ID:                   batch.ID,
+		Name:                 batch.Name,
+		Caller:               batch.Caller.String,
+		TotalCount:           batch.TotalCount,
+		SucceededCount:       batch.SucceededCount,
+		FailedCount:          batch.FailedCount,
+		CancelledCount:       batch.CancelledCount,
+		CompletionWebhookURL: batch.CompletionWebhookUrl.String,
+		CreatedAtStamp:       batch.CreatedAt.Time.Unix(),
+	}
+	if batch.CompletionTask.Status == pgtype.Present {
+		convertedBatch.CompletionTask = &domain.BatchTask{}
+		err := json.Unmarshal(batch.CompletionTask.Bytes, convertedBatch.CompletionTask)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if batch.CompletionTaskID.Valid {
+		convertedBatch.CompletionTaskID = &batch.CompletionTaskID.Int32
+	}
+	if batch.CompletionWebhookTaskID.Valid {
+		convertedBatch.CompletionWebhookTaskID = &batch.CompletionWebhookTaskID.Int32
+	}
+	if batch.CompletedAt.Valid {
+		completedAtStamp := batch.CompletedAt.Time.Unix()
+		convertedBatch.CompletedAtStamp = &completedAtStamp
+	}
+
+	return convertedBatch, nil
+}
@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+)
+
+type childTaskStorage struct {
+	queries   *Queries
+	pool      *pgxpool.Pool
+	taskTypes domain.TaskTypeRegistry
+}
+
+// NewChildTaskStorage returns the storage of the child tasks, the types of the tasks are checked against taskTypes before they are inserted
+func NewChildTaskStorage(pool *pgxpool.Pool, taskTypes domain.TaskTypeRegistry) *childTaskStorage {
+	return &childTaskStorage{
+		queries:   New(pool),
+		pool:      pool,
+		taskTypes: taskTypes,
+	}
+}
+
+func (s *childTaskStorage) InsertChildTask(ctx context.Context, parentID int32, key, name, taskType, priority string, payload json.RawMessage, caller string) (*domain.Task, bool, error) {
+	if !s.taskTypes.IsRegistered(taskType) {
+		return nil, false, errval.ErrInvalidTaskType
+	}
+
+	var payloadJSON pgtype.JSONB
+	err := payloadJSON.Set([]byte(payload))
+	if err != nil {
+		return nil, false, err
+	}
+
+	parentIDParam := sql.NullInt32{Int32: parentID, Valid: true}
+	// A null key never conflicts, so the children without a key are inserted on every call
+	keyParam := sql.NullString{String: key, Valid: key != ""}
+	taskID, err := s.queries.InsertChildTask(ctx, InsertChildTaskParams{
+		Name:     name,
+		Type:     taskType,
+		Status:   TaskStatus(domain.Queued),
+		Priority: TaskPriority(priority),
+		Payload:  payloadJSON,
+		Caller:   sql.NullString{String: caller, Valid: caller != ""},
+		ParentID: parentIDParam,
+		ChildKey: keyParam,
+	})
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, err
+		}
+
+		// Nothing is returned when the key conflicts, so the existing child is returned instead
+		existingTask, err := s.queries.GetChildTaskByKey(ctx, GetChildTaskByKeyParams{
+			ParentID: parentIDParam,
+			ChildKey: keyParam,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+
+		return convertTask(existingTask), false, nil
+	}
+
+	insertedTask := &domain.Task{
+		ID:       taskID,
+		Type:     taskType,
+		Status:   string(domain.Queued),
+		Priority: priority,
+		PayLoad:  payload,
+		Caller:   caller,
+		ParentID: &parentID,
+		ChildKey: key,
+	}
+
+	return insertedTask, true, nil
+}
+
+// FinishWaitingTask locks the waiting task, so when its last children finish concurrently, only one of them finishes it
+func (s *childTaskStorage) FinishWaitingTask(ctx context.Context, taskID int32, maxAttempts int32) (*domain.Task, error) {
+	var finishedTask *domain.Task
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		task, err := qtx.LockWaitingTask(ctx, taskID)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+
+			return err
+		}
+
+		counts, err := qtx.CountChildTasks(ctx, CountChildTasksParams{
+			MaxAttempts: maxAttempts,
+			ParentID:    sql.NullInt32{Int32: taskID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		if counts.UnfinishedCount > 0 {
+			return nil
+		}
+
+		newStatus := domain.Succeeded
+		if counts.UnsucceededCount > 0 {
+			newStatus = domain.Failed
+		}
+		finishedTasks, err := transitLockedTasks(ctx, qtx, []Task{task}, newStatus)
+		if err != nil {
+			return err
+		}
+		finishedTask = finishedTasks[0]
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return finishedTask, nil
+}
+
+func (s *childTaskStorage) GetFinishableWaitingTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	tasks, err := s.queries.GetFinishableWaitingTasks(ctx, GetFinishableWaitingTasksParams{
+		PassedSeconds: passedSeconds,
+		MaxAttempts:   maxAttempts,
+		MaxCount:      limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return convertTasks(tasks), nil
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"log/slog"
+)
+
+// taskEventsChannel is notified by a trigger of tasks_status_change_history
+const taskEventsChannel = "task_status_changes"
+
+type eventStorage struct {
+	pool    *pgxpool.Pool
+	queries *Queries
+}
+
+// NewEventStorage returns the storage of the status changes of the tasks which are pushed to the event streams
+func NewEventStorage(pool *pgxpool.Pool) *eventStorage {
+	return &eventStorage{
+		pool:    pool,
+		queries: New(pool),
+	}
+}
+
+// ListenTaskEvents listens on a dedicated connection instead of a connection of the pool, since the connection is kept for as long as it's listening
+func (s *eventStorage) ListenTaskEvents(ctx context.Context, listening func(), handle func(event *domain.TaskEvent)) error {
+	conn, err := pgx.ConnectConfig(ctx, s.pool.Config().ConnConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "LISTEN "+taskEventsChannel)
+	if err != nil {
+		return err
+	}
+	listening()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		event := &domain.TaskEvent{}
+		err = json.Unmarshal([]byte(notification.Payload), event)
+		if err != nil {
+			slog.Error("Error occurred while decoding the notification of the task status change", "payload", notification.Payload, "error", err.Error())
+			continue
+		}
+		handle(event)
+	}
+}
+
+func (s *eventStorage) GetTaskEventsAfter(ctx context.Context, afterID int32, filter domain.TaskEventsFilter, limit int32) ([]*domain.TaskEvent, error) {
+	params := GetTaskEventsAfterParams{
+		AfterID:   afterID,
+		TaskType:  sql.NullString{String: filter.TaskType, Valid: filter.TaskType != ""},
+		NewStatus: sql.NullString{String: filter.NewStatus, Valid: filter.NewStatus != ""},
+		MaxCount:  limit,
+	}
+	if filter.TaskID != nil {
+		params.TaskID = sql.NullInt32{Int32: *filter.TaskID, Valid: true}
+	}
+
+	items, err := s.queries.GetTaskEventsAfter(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	events := make([]*domain.TaskEvent, 0, len(items))
+	for _, item := range items {
+		events = append(events, &domain.TaskEvent{
+			ID:             item.ID,
+			TaskID:         item.TaskID,
+			TaskType:       item.TaskType,
+			OldStatus:      string(item.OldStatus),
+			NewStatus:      string(item.NewStatus),
+			CreatedAtStamp: item.CreatedAt.Time.Unix(),
+		})
+	}
+
+	return events, nil
+}
@@ -0,0 +1,54 @@
+package postgres
+
+import (
+	"context"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+)
+
+type lifecycleEventStorage struct {
+	queries *Queries
+}
+
+// NewLifecycleEventStorage returns the storage of the outbox of the event bus, the events are inserted by the triggers of the tasks
+func NewLifecycleEventStorage(pool *pgxpool.Pool) *lifecycleEventStorage {
+	return &lifecycleEventStorage{
+		queries: New(pool),
+	}
+}
+
+func (s *lifecycleEventStorage) ClaimLifecycleEvents(ctx context.Context, leaseSeconds, limit int32) ([]*domain.LifecycleEvent, error) {
+	items, err := s.queries.ClaimTaskLifecycleEvents(ctx, ClaimTaskLifecycleEventsParams{
+		LeaseSeconds: leaseSeconds,
+		MaxCount:     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	events := make([]*domain.LifecycleEvent, 0, len(items))
+	for _, item := range items {
+		events = append(events, &domain.LifecycleEvent{
+			ID:             item.ID,
+			TaskID:         item.TaskID,
+			TaskType:       item.TaskType,
+			TaskPriority:   string(item.TaskPriority),
+			Event:          domain.LifecycleEventType(item.Event),
+			OldStatus:      string(item.OldStatus.TaskStatus),
+			NewStatus:      string(item.NewStatus),
+			Attempts:       item.Attempts,
+			CreatedAtStamp: item.CreatedAt.Unix(),
+		})
+	}
+
+	return events, nil
+}
+
+func (s *lifecycleEventStorage) DeleteLifecycleEvents(ctx context.Context, eventIDs []int32) error {
+	return s.queries.DeleteTaskLifecycleEvents(ctx, eventIDs)
+}
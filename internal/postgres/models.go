@@ -4,7 +4,9 @@ package postgres
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgtype"
 )
@@ -36,6 +38,10 @@ const (
 	TaskStatusRunning   TaskStatus = "running"
 	TaskStatusFailed    TaskStatus = "failed"
 	TaskStatusSucceeded TaskStatus = "succeeded"
+	TaskStatusPending   TaskStatus = "pending"
+	TaskStatusSkipped   TaskStatus = "skipped"
+	TaskStatusCancelled TaskStatus = "cancelled"
+	TaskStatusWaiting   TaskStatus = "waiting"
 )
 
 func (e *TaskStatus) Scan(src interface{}) error {
@@ -50,34 +56,91 @@ func (e *TaskStatus) Scan(src interface{}) error {
 	return nil
 }
 
-type TaskType string
+type NullTaskStatus struct {
+	TaskStatus TaskStatus
+	Valid      bool // Valid is true if TaskStatus is not NULL
+}
 
-const (
-	TaskTypeSendEmail TaskType = "send_email"
-	TaskTypeRunQuery  TaskType = "run_query"
-)
+// Scan implements the Scanner interface.
+func (ns *NullTaskStatus) Scan(value interface{}) error {
+	if value == nil {
+		ns.TaskStatus, ns.Valid = "", false
+		return nil
+	}
+	ns.Valid = true
+	return ns.TaskStatus.Scan(value)
+}
 
-func (e *TaskType) Scan(src interface{}) error {
-	switch s := src.(type) {
-	case []byte:
-		*e = TaskType(s)
-	case string:
-		*e = TaskType(s)
-	default:
-		return fmt.Errorf("unsupported scan type for TaskType: %T", src)
+// Value implements the driver Valuer interface.
+func (ns NullTaskStatus) Value() (driver.Value, error) {
+	if !ns.Valid {
+		return nil, nil
 	}
-	return nil
+	return string(ns.TaskStatus), nil
+}
+
+type Batch struct {
+	ID                      int32
+	Name                    string
+	Caller                  sql.NullString
+	TotalCount              int32
+	SucceededCount          int32
+	FailedCount             int32
+	CancelledCount          int32
+	CompletionTask          pgtype.JSONB
+	CompletionWebhookUrl    sql.NullString
+	CompletionTaskID        sql.NullInt32
+	CompletionWebhookTaskID sql.NullInt32
+	CompletedAt             sql.NullTime
+	CreatedAt               sql.NullTime
 }
 
 type Task struct {
-	ID        int32
-	Name      string
-	Type      TaskType
-	Status    TaskStatus
-	Priority  TaskPriority
-	Payload   pgtype.JSON
-	CreatedAt sql.NullTime
-	UpdatedAt sql.NullTime
+	ID             int32
+	Name           string
+	Type           string
+	Status         TaskStatus
+	Priority       TaskPriority
+	Payload        pgtype.JSONB
+	CreatedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
+	Attempts       int32
+	Result         pgtype.JSONB
+	Caller         sql.NullString
+	WorkflowID     sql.NullInt32
+	WorkflowKey    sql.NullString
+	BatchID        sql.NullInt32
+	BatchCountedAt sql.NullTime
+	ParentID       sql.NullInt32
+	ChildKey       sql.NullString
+	CallbackUrl    sql.NullString
+	CallbackEvents []string
+}
+
+type TaskDependency struct {
+	TaskID          int32
+	DependsOnTaskID int32
+}
+
+type TaskLifecycleEvent struct {
+	ID            int32
+	TaskID        int32
+	TaskType      string
+	TaskPriority  TaskPriority
+	Event         string
+	OldStatus     NullTaskStatus
+	NewStatus     TaskStatus
+	Attempts      int32
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+}
+
+type TasksPriorityChangeHistory struct {
+	ID          int32
+	TaskID      int32
+	OldPriority TaskPriority
+	NewPriority TaskPriority
+	CreatedAt   sql.NullTime
 }
 
 type TasksStatusChangeHistory struct {
@@ -87,3 +150,37 @@ type TasksStatusChangeHistory struct {
 	NewStatus TaskStatus
 	CreatedAt sql.NullTime
 }
+
+type Template struct {
+	TemplateID string
+	Locale     string
+	Version    int32
+	Subject    string
+	TextBody   string
+	HtmlBody   string
+	CreatedAt  sql.NullTime
+}
+
+type WebhookDelivery struct {
+	ID                 int32
+	TaskID             int32
+	Url                string
+	OldStatus          TaskStatus
+	NewStatus          TaskStatus
+	Status             string
+	Attempts           int32
+	NextAttemptAt      time.Time
+	LastAttemptAt      sql.NullTime
+	LastResponseStatus sql.NullInt32
+	LastError          sql.NullString
+	DeliveredAt        sql.NullTime
+	CreatedAt          sql.NullTime
+}
+
+type Workflow struct {
+	ID            int32
+	Name          string
+	FailurePolicy string
+	Caller        sql.NullString
+	CreatedAt     sql.NullTime
+}
@@ -15,20 +15,387 @@ SELECT *
 FROM tasks
 WHERE status = $1 AND updated_at <= now() - ($2 * interval '1 second') LIMIT $3;
 
+-- name: GetAgedQueuedTasks :many
+SELECT *
+FROM tasks
+WHERE status = 'queued' AND priority = $1 AND updated_at <= now() - ($2 * interval '1 second')
+ORDER BY id LIMIT $3;
+
+-- name: CountQueuedTasksByPriority :many
+SELECT priority, COUNT(*) AS tasks_count FROM tasks WHERE status = 'queued' GROUP BY priority;
+
+-- name: GetFilteredMissedTasks :many
+SELECT *
+FROM tasks
+WHERE status = @status
+  AND updated_at <= now() - (@passed_seconds::int * interval '1 second')
+  AND (sqlc.narg(type)::text IS NULL OR type::text = sqlc.narg(type)::text)
+  AND (sqlc.narg(priority)::text IS NULL OR priority::text = sqlc.narg(priority)::text)
+  AND (sqlc.narg(min_id)::int IS NULL OR id >= sqlc.narg(min_id)::int)
+  AND (sqlc.narg(max_id)::int IS NULL OR id <= sqlc.narg(max_id)::int)
+ORDER BY id
+LIMIT @max_count;
+
+-- name: GetRetryableFailedTasks :many
+SELECT *
+FROM tasks
+WHERE status = 'failed' AND attempts < $1 AND updated_at <= now() - ($2 * interval '1 second')
+ORDER BY id LIMIT $3;
+
+-- name: GetTasksByIDs :many
+SELECT * FROM tasks WHERE id = ANY(@ids::int[]);
+
+-- name: GetTaskPriorityChangeHistory :many
+SELECT * FROM tasks_priority_change_history WHERE task_id = $1;
+
 -- name: InsertTask :one
 INSERT INTO tasks (
-    name, type, status, priority, payload
+    name, type, status, priority, payload, caller, callback_url, callback_events
 ) VALUES (
-             $1, $2, $3, $4, $5
+             $1, $2, $3, $4, $5, $6, $7, $8
          )
     RETURNING id;
 
--- name: UpdateTaskStatus :exec
-UPDATE tasks SET status = $1 WHERE id = $2;
+-- name: UpdateTaskStatus :execrows
+UPDATE tasks
+SET status = @new_status, attempts = attempts + CASE WHEN @new_status = 'running'::task_status THEN 1 ELSE 0 END
+WHERE id = @id AND status = @current_status;
+
+-- name: FailTaskPermanently :execrows
+UPDATE tasks
+SET status = 'failed', attempts = GREATEST(attempts, @max_attempts::int)
+WHERE id = @id AND status = @current_status;
+
+-- name: SetTaskResult :execrows
+UPDATE tasks SET result = $2 WHERE id = $1;
+
+-- name: TouchTask :execrows
+UPDATE tasks SET updated_at = now() WHERE id = $1 AND status = $2;
 
 -- name: InsertTaskStatusChangeHistory :exec
 INSERT INTO tasks_status_change_history (
     task_id, old_status, new_status
 ) VALUES (
              $1, $2, $3
-         );
\ No newline at end of file
+         );
+
+-- name: UpdateQueuedTaskPriority :execrows
+UPDATE tasks SET priority = @new_priority WHERE id = @id AND priority = @current_priority AND status = 'queued';
+
+-- name: InsertTaskPriorityChangeHistory :exec
+INSERT INTO tasks_priority_change_history (
+    task_id, old_priority, new_priority
+) VALUES (
+             $1, $2, $3
+         );
+-- name: CreateTemplate :one
+INSERT INTO templates (
+    template_id, locale, version, subject, text_body, html_body
+) VALUES (
+             $1, $2, 1, $3, $4, $5
+         )
+    RETURNING *;
+
+-- name: InsertTemplateVersion :one
+INSERT INTO templates (template_id, locale, version, subject, text_body, html_body)
+SELECT @template_id::text, @locale::text, MAX(version) + 1, @subject::text, @text_body::text, @html_body::text
+FROM templates
+WHERE template_id = @template_id::text AND locale = @locale::text
+HAVING COUNT(*) > 0
+    RETURNING *;
+
+-- name: GetLatestTemplate :one
+SELECT * FROM templates WHERE template_id = $1 AND locale = $2 ORDER BY version DESC LIMIT 1;
+
+-- name: GetTemplateVersion :one
+SELECT * FROM templates WHERE template_id = $1 AND locale = $2 AND version = $3;
+
+-- name: GetTemplateVersions :many
+SELECT * FROM templates WHERE template_id = $1 AND locale = $2 ORDER BY version DESC;
+
+-- name: GetLatestTemplates :many
+SELECT DISTINCT ON (template_id, locale) * FROM templates ORDER BY template_id, locale, version DESC;
+
+-- name: GetLatestTemplatesByTemplateID :many
+SELECT DISTINCT ON (locale) * FROM templates WHERE template_id = $1 ORDER BY locale, version DESC;
+
+-- name: DeleteTemplate :execrows
+DELETE FROM templates WHERE template_id = $1;
+
+-- name: DeleteTemplateLocale :execrows
+DELETE FROM templates WHERE template_id = $1 AND locale = $2;
+
+-- name: InsertWorkflow :one
+INSERT INTO workflows (
+    name, failure_policy, caller
+) VALUES (
+             $1, $2, $3
+         )
+    RETURNING *;
+
+-- name: GetWorkflowByID :one
+SELECT * FROM workflows WHERE id = $1;
+
+-- name: InsertWorkflowTask :one
+INSERT INTO tasks (
+    name, type, status, priority, payload, caller, workflow_id, workflow_key
+) VALUES (
+             $1, $2, $3, $4, $5, $6, $7, $8
+         )
+    RETURNING id;
+
+-- name: InsertTaskDependency :exec
+INSERT INTO task_dependencies (
+    task_id, depends_on_task_id
+) VALUES (
+             $1, $2
+         );
+
+-- name: GetWorkflowTasks :many
+SELECT * FROM tasks WHERE workflow_id = $1 ORDER BY id;
+
+-- name: GetWorkflowDependencies :many
+SELECT task_dependencies.*
+FROM task_dependencies
+JOIN tasks ON tasks.id = task_dependencies.task_id
+WHERE tasks.workflow_id = $1;
+
+-- name: GetWorkflowStatusChangeHistory :many
+SELECT tasks_status_change_history.*
+FROM tasks_status_change_history
+JOIN tasks ON tasks.id = tasks_status_change_history.task_id
+WHERE tasks.workflow_id = $1
+ORDER BY tasks_status_change_history.id;
+
+-- name: GetParentTasks :many
+SELECT tasks.*
+FROM tasks
+JOIN task_dependencies ON task_dependencies.depends_on_task_id = tasks.id
+WHERE task_dependencies.task_id = $1
+ORDER BY tasks.id;
+
+-- name: LockPendingDependentTasks :many
+SELECT tasks.*
+FROM tasks
+JOIN task_dependencies ON task_dependencies.task_id = tasks.id
+WHERE task_dependencies.depends_on_task_id = $1 AND tasks.status = 'pending'
+ORDER BY tasks.id
+FOR UPDATE OF tasks;
+
+-- name: CountUnfinishedParentTasks :one
+SELECT COUNT(*)
+FROM task_dependencies
+JOIN tasks parents ON parents.id = task_dependencies.depends_on_task_id
+WHERE task_dependencies.task_id = @task_id
+  AND NOT (parents.status = 'succeeded' OR (@failed_is_finished::boolean AND parents.status = 'failed' AND parents.attempts >= @max_attempts::int));
+
+-- name: LockPendingDescendantTasks :many
+WITH RECURSIVE descendants AS (
+    SELECT task_dependencies.task_id FROM task_dependencies WHERE task_dependencies.depends_on_task_id = $1
+    UNION
+    SELECT task_dependencies.task_id FROM task_dependencies JOIN descendants ON task_dependencies.depends_on_task_id = descendants.task_id
+)
+SELECT tasks.*
+FROM tasks
+JOIN descendants ON descendants.task_id = tasks.id
+WHERE tasks.status = 'pending'
+ORDER BY tasks.id
+FOR UPDATE OF tasks;
+
+-- name: LockCancellableWorkflowTasks :many
+SELECT * FROM tasks WHERE workflow_id = $1 AND status IN ('pending', 'queued') ORDER BY id FOR UPDATE;
+
+-- name: GetStalledWorkflowParentTasks :many
+SELECT *
+FROM tasks parents
+WHERE (parents.status = 'succeeded' OR (parents.status = 'failed' AND parents.attempts >= @max_attempts::int))
+  AND parents.updated_at <= now() - (@passed_seconds::int * interval '1 second')
+  AND EXISTS (
+      SELECT 1
+      FROM task_dependencies children_dependencies
+      JOIN tasks children ON children.id = children_dependencies.task_id
+      WHERE children_dependencies.depends_on_task_id = parents.id
+        AND children.status = 'pending'
+        AND NOT EXISTS (
+            SELECT 1
+            FROM task_dependencies siblings_dependencies
+            JOIN tasks siblings ON siblings.id = siblings_dependencies.depends_on_task_id
+            WHERE siblings_dependencies.task_id = children.id AND siblings.status IN ('pending', 'queued', 'running')
+        )
+  )
+ORDER BY parents.id
+LIMIT @max_count;
+
+-- name: InsertBatch :one
+INSERT INTO batches (
+    name, caller, total_count, completion_task, completion_webhook_url
+) VALUES (
+             $1, $2, $3, $4, $5
+         )
+    RETURNING *;
+
+-- name: GetBatchByID :one
+SELECT * FROM batches WHERE id = $1;
+
+-- name: InsertBatchTask :one
+INSERT INTO tasks (
+    name, type, status, priority, payload, caller, batch_id
+) VALUES (
+             $1, $2, $3, $4, $5, $6, $7
+         )
+    RETURNING id;
+
+-- name: MarkBatchTaskCounted :execrows
+UPDATE tasks SET batch_counted_at = now() WHERE id = $1 AND batch_id IS NOT NULL AND batch_counted_at IS NULL;
+
+-- name: MarkBatchTasksCounted :exec
+UPDATE tasks SET batch_counted_at = now() WHERE id = ANY(@ids::int[]);
+
+-- name: IncreaseBatchCounters :one
+UPDATE batches
+SET succeeded_count = succeeded_count + @succeeded_count::int,
+    failed_count = failed_count + @failed_count::int,
+    cancelled_count = cancelled_count + @cancelled_count::int
+WHERE id = @id
+    RETURNING *;
+
+-- name: CompleteBatch :one
+UPDATE batches
+SET completed_at = now()
+WHERE id = $1 AND completed_at IS NULL AND succeeded_count + failed_count + cancelled_count >= total_count
+    RETURNING *;
+
+-- name: SetBatchCompletionTaskIDs :exec
+UPDATE batches SET completion_task_id = $2, completion_webhook_task_id = $3 WHERE id = $1;
+
+-- name: LockCancellableBatchTasks :many
+SELECT *
+FROM tasks
+WHERE batch_id = @batch_id AND (status = 'queued' OR (status = 'failed' AND attempts < @max_attempts::int))
+ORDER BY id
+FOR UPDATE;
+
+-- name: GetUncountedBatchTasks :many
+SELECT *
+FROM tasks
+WHERE batch_id IS NOT NULL AND batch_counted_at IS NULL
+  AND (status IN ('succeeded', 'cancelled') OR (status = 'failed' AND attempts >= @max_attempts::int))
+  AND updated_at <= now() - (@passed_seconds::int * interval '1 second')
+ORDER BY id
+LIMIT @max_count;
+
+-- name: GetFinishedUncompletedBatches :many
+SELECT * FROM batches WHERE completed_at IS NULL AND succeeded_count + failed_count + cancelled_count >= total_count ORDER BY id LIMIT $1;
+
+-- name: InsertChildTask :one
+INSERT INTO tasks (
+    name, type, status, priority, payload, caller, parent_id, child_key
+) VALUES (
+             $1, $2, $3, $4, $5, $6, $7, $8
+         )
+    ON CONFLICT (parent_id, child_key) DO NOTHING
+    RETURNING id;
+
+-- name: GetChildTaskByKey :one
+SELECT * FROM tasks WHERE parent_id = $1 AND child_key = $2;
+
+-- name: LockWaitingTask :one
+SELECT * FROM tasks WHERE id = $1 AND status = 'waiting' FOR UPDATE;
+
+-- name: CountChildTasks :one
+SELECT count(*) FILTER (WHERE NOT (status IN ('succeeded', 'skipped', 'cancelled') OR (status = 'failed' AND attempts >= @max_attempts::int)))::int AS unfinished_count,
+       count(*) FILTER (WHERE status <> 'succeeded')::int AS unsucceeded_count
+FROM tasks
+WHERE parent_id = @parent_id;
+
+-- name: GetFinishableWaitingTasks :many
+SELECT *
+FROM tasks
+WHERE status = 'waiting'
+  AND updated_at <= now() - (@passed_seconds::int * interval '1 second')
+  AND NOT EXISTS (
+    SELECT 1
+    FROM tasks AS children
+    WHERE children.parent_id = tasks.id
+      AND NOT (children.status IN ('succeeded', 'skipped', 'cancelled') OR (children.status = 'failed' AND children.attempts >= @max_attempts::int))
+  )
+ORDER BY id
+LIMIT @max_count;
+
+-- name: InsertTaskWebhookDelivery :exec
+INSERT INTO webhook_deliveries (task_id, url, old_status, new_status)
+SELECT id, callback_url, @old_status::task_status, @new_status::task_status
+FROM tasks
+WHERE id = @task_id AND callback_url IS NOT NULL
+  AND (callback_events IS NULL OR @new_status::task_status::text = ANY(callback_events));
+
+-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET attempts = attempts + 1, last_attempt_at = now(), next_attempt_at = now() + (@lease_seconds::int * interval '1 second')
+WHERE id IN (
+    SELECT id
+    FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= now()
+    ORDER BY next_attempt_at, id
+    LIMIT @max_count
+    FOR UPDATE SKIP LOCKED
+)
+    RETURNING *;
+
+-- name: MarkWebhookDeliveryDelivered :execrows
+UPDATE webhook_deliveries
+SET status = 'delivered', delivered_at = now(), last_response_status = @response_status, last_error = NULL
+WHERE id = @id AND status = 'pending' AND attempts = @attempts;
+
+-- name: MarkWebhookDeliveryAttemptFailed :execrows
+UPDATE webhook_deliveries
+SET status = @new_status, next_attempt_at = now() + (@retry_after_seconds::int * interval '1 second'),
+    last_response_status = @response_status, last_error = @last_error
+WHERE id = @id AND status = 'pending' AND attempts = @attempts;
+
+-- name: GetWebhookDeliveryByID :one
+SELECT * FROM webhook_deliveries WHERE id = $1;
+
+-- name: GetFilteredWebhookDeliveries :many
+SELECT *
+FROM webhook_deliveries
+WHERE (sqlc.narg(status)::text IS NULL OR status = sqlc.narg(status)::text)
+  AND (sqlc.narg(task_id)::int IS NULL OR task_id = sqlc.narg(task_id)::int)
+ORDER BY id DESC
+LIMIT @max_count;
+
+-- name: RedeliverWebhookDelivery :one
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = now()
+WHERE id = $1 AND status = 'failed'
+    RETURNING *;
+
+-- name: GetTaskEventsAfter :many
+SELECT tasks_status_change_history.id, tasks_status_change_history.task_id, tasks.type AS task_type, tasks_status_change_history.old_status, tasks_status_change_history.new_status, tasks_status_change_history.created_at
+FROM tasks_status_change_history
+JOIN tasks ON tasks.id = tasks_status_change_history.task_id
+WHERE tasks_status_change_history.id > @after_id
+  AND (sqlc.narg(task_id)::int IS NULL OR tasks_status_change_history.task_id = sqlc.narg(task_id)::int)
+  AND (sqlc.narg(task_type)::text IS NULL OR tasks.type = sqlc.narg(task_type)::text)
+  AND (sqlc.narg(new_status)::text IS NULL OR tasks_status_change_history.new_status::text = sqlc.narg(new_status)::text)
+ORDER BY tasks_status_change_history.id
+LIMIT @max_count;
+
+-- name: ClaimTaskLifecycleEvents :many
+WITH claimed AS (
+    UPDATE task_lifecycle_events
+    SET next_attempt_at = now() + (@lease_seconds::int * interval '1 second')
+    WHERE id IN (
+        SELECT id
+        FROM task_lifecycle_events
+        WHERE next_attempt_at <= now()
+        ORDER BY id
+        LIMIT @max_count
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *
+)
+SELECT * FROM claimed ORDER BY id;
+
+-- name: DeleteTaskLifecycleEvents :exec
+DELETE FROM task_lifecycle_events WHERE id = ANY(@ids::int[]);
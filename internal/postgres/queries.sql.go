@@ -5,37 +5,120 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/jackc/pgtype"
 )
 
-const getLimitedTasksByStatus = `-- name: GetLimitedTasksByStatus :many
-SELECT id, name, type, status, priority, payload, created_at, updated_at FROM tasks WHERE status = $1 LIMIT $2
+const claimTaskLifecycleEvents = `-- name: ClaimTaskLifecycleEvents :many
+WITH claimed AS (
+    UPDATE task_lifecycle_events
+    SET next_attempt_at = now() + ($1::int * interval '1 second')
+    WHERE id IN (
+        SELECT id
+        FROM task_lifecycle_events
+        WHERE next_attempt_at <= now()
+        ORDER BY id
+        LIMIT $2
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING id, task_id, task_type, task_priority, event, old_status, new_status, attempts, next_attempt_at, created_at
+)
+SELECT id, task_id, task_type, task_priority, event, old_status, new_status, attempts, next_attempt_at, created_at FROM claimed ORDER BY id
 `
 
-type GetLimitedTasksByStatusParams struct {
-	Status TaskStatus
-	Limit  int32
+type ClaimTaskLifecycleEventsParams struct {
+	LeaseSeconds int32
+	MaxCount     int32
 }
 
-func (q *Queries) GetLimitedTasksByStatus(ctx context.Context, arg GetLimitedTasksByStatusParams) ([]Task, error) {
-	rows, err := q.db.Query(ctx, getLimitedTasksByStatus, arg.Status, arg.Limit)
+type ClaimTaskLifecycleEventsRow struct {
+	ID            int32
+	TaskID        int32
+	TaskType      string
+	TaskPriority  TaskPriority
+	Event         string
+	OldStatus     NullTaskStatus
+	NewStatus     TaskStatus
+	Attempts      int32
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+}
+
+func (q *Queries) ClaimTaskLifecycleEvents(ctx context.Context, arg ClaimTaskLifecycleEventsParams) ([]ClaimTaskLifecycleEventsRow, error) {
+	rows, err := q.db.Query(ctx, claimTaskLifecycleEvents, arg.LeaseSeconds, arg.MaxCount)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Task
+	var items []ClaimTaskLifecycleEventsRow
 	for rows.Next() {
-		var i Task
+		var i ClaimTaskLifecycleEventsRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Type,
+			&i.TaskID,
+			&i.TaskType,
+			&i.TaskPriority,
+			&i.Event,
+			&i.OldStatus,
+			&i.NewStatus,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET attempts = attempts + 1, last_attempt_at = now(), next_attempt_at = now() + ($1::int * interval '1 second')
+WHERE id IN (
+    SELECT id
+    FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= now()
+    ORDER BY next_attempt_at, id
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+    RETURNING id, task_id, url, old_status, new_status, status, attempts, next_attempt_at, last_attempt_at, last_response_status, last_error, delivered_at, created_at
+`
+
+type ClaimWebhookDeliveriesParams struct {
+	LeaseSeconds int32
+	MaxCount     int32
+}
+
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, claimWebhookDeliveries, arg.LeaseSeconds, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.Url,
+			&i.OldStatus,
+			&i.NewStatus,
 			&i.Status,
-			&i.Priority,
-			&i.Payload,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastAttemptAt,
+			&i.LastResponseStatus,
+			&i.LastError,
+			&i.DeliveredAt,
 			&i.CreatedAt,
-			&i.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -47,20 +130,219 @@ func (q *Queries) GetLimitedTasksByStatus(ctx context.Context, arg GetLimitedTas
 	return items, nil
 }
 
-const getMissedTasks = `-- name: GetMissedTasks :many
-SELECT id, name, type, status, priority, payload, created_at, updated_at
+const completeBatch = `-- name: CompleteBatch :one
+UPDATE batches
+SET completed_at = now()
+WHERE id = $1 AND completed_at IS NULL AND succeeded_count + failed_count + cancelled_count >= total_count
+    RETURNING id, name, caller, total_count, succeeded_count, failed_count, cancelled_count, completion_task, completion_webhook_url, completion_task_id, completion_webhook_task_id, completed_at, created_at
+`
+
+func (q *Queries) CompleteBatch(ctx context.Context, id int32) (Batch, error) {
+	row := q.db.QueryRow(ctx, completeBatch, id)
+	var i Batch
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Caller,
+		&i.TotalCount,
+		&i.SucceededCount,
+		&i.FailedCount,
+		&i.CancelledCount,
+		&i.CompletionTask,
+		&i.CompletionWebhookUrl,
+		&i.CompletionTaskID,
+		&i.CompletionWebhookTaskID,
+		&i.CompletedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const countChildTasks = `-- name: CountChildTasks :one
+SELECT count(*) FILTER (WHERE NOT (status IN ('succeeded', 'skipped', 'cancelled') OR (status = 'failed' AND attempts >= $1::int)))::int AS unfinished_count,
+       count(*) FILTER (WHERE status <> 'succeeded')::int AS unsucceeded_count
 FROM tasks
-WHERE status = $1 AND updated_at <= now() - ($2 * interval '1 second') LIMIT $3
+WHERE parent_id = $2
 `
 
-type GetMissedTasksParams struct {
-	Status  TaskStatus
-	Column2 interface{}
-	Limit   int32
+type CountChildTasksParams struct {
+	MaxAttempts int32
+	ParentID    sql.NullInt32
 }
 
-func (q *Queries) GetMissedTasks(ctx context.Context, arg GetMissedTasksParams) ([]Task, error) {
-	rows, err := q.db.Query(ctx, getMissedTasks, arg.Status, arg.Column2, arg.Limit)
+type CountChildTasksRow struct {
+	UnfinishedCount  int32
+	UnsucceededCount int32
+}
+
+func (q *Queries) CountChildTasks(ctx context.Context, arg CountChildTasksParams) (CountChildTasksRow, error) {
+	row := q.db.QueryRow(ctx, countChildTasks, arg.MaxAttempts, arg.ParentID)
+	var i CountChildTasksRow
+	err := row.Scan(&i.UnfinishedCount, &i.UnsucceededCount)
+	return i, err
+}
+
+const countQueuedTasksByPriority = `-- name: CountQueuedTasksByPriority :many
+SELECT priority, COUNT(*) AS tasks_count FROM tasks WHERE status = 'queued' GROUP BY priority
+`
+
+type CountQueuedTasksByPriorityRow struct {
+	Priority   TaskPriority
+	TasksCount int64
+}
+
+func (q *Queries) CountQueuedTasksByPriority(ctx context.Context) ([]CountQueuedTasksByPriorityRow, error) {
+	rows, err := q.db.Query(ctx, countQueuedTasksByPriority)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountQueuedTasksByPriorityRow
+	for rows.Next() {
+		var i CountQueuedTasksByPriorityRow
+		if err := rows.Scan(&i.Priority, &i.TasksCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countUnfinishedParentTasks = `-- name: CountUnfinishedParentTasks :one
+SELECT COUNT(*)
+FROM task_dependencies
+JOIN tasks parents ON parents.id = task_dependencies.depends_on_task_id
+WHERE task_dependencies.task_id = $1
+  AND NOT (parents.status = 'succeeded' OR ($2::boolean AND parents.status = 'failed' AND parents.attempts >= $3::int))
+`
+
+type CountUnfinishedParentTasksParams struct {
+	TaskID           int32
+	FailedIsFinished bool
+	MaxAttempts      int32
+}
+
+func (q *Queries) CountUnfinishedParentTasks(ctx context.Context, arg CountUnfinishedParentTasksParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countUnfinishedParentTasks, arg.TaskID, arg.FailedIsFinished, arg.MaxAttempts)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createTemplate = `-- name: CreateTemplate :one
+INSERT INTO templates (
+    template_id, locale, version, subject, text_body, html_body
+) VALUES (
+             $1, $2, 1, $3, $4, $5
+         )
+    RETURNING template_id, locale, version, subject, text_body, html_body, created_at
+`
+
+type CreateTemplateParams struct {
+	TemplateID string
+	Locale     string
+	Subject    string
+	TextBody   string
+	HtmlBody   string
+}
+
+func (q *Queries) CreateTemplate(ctx context.Context, arg CreateTemplateParams) (Template, error) {
+	row := q.db.QueryRow(ctx, createTemplate,
+		arg.TemplateID,
+		arg.Locale,
+		arg.Subject,
+		arg.TextBody,
+		arg.HtmlBody,
+	)
+	var i Template
+	err := row.Scan(
+		&i.TemplateID,
+		&i.Locale,
+		&i.Version,
+		&i.Subject,
+		&i.TextBody,
+		&i.HtmlBody,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const deleteTaskLifecycleEvents = `-- name: DeleteTaskLifecycleEvents :exec
+DELETE FROM task_lifecycle_events WHERE id = ANY($1::int[])
+`
+
+func (q *Queries) DeleteTaskLifecycleEvents(ctx context.Context, ids []int32) error {
+	_, err := q.db.Exec(ctx, deleteTaskLifecycleEvents, ids)
+	return err
+}
+
+const deleteTemplate = `-- name: DeleteTemplate :execrows
+DELETE FROM templates WHERE template_id = $1
+`
+
+func (q *Queries) DeleteTemplate(ctx context.Context, templateID string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteTemplate, templateID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteTemplateLocale = `-- name: DeleteTemplateLocale :execrows
+DELETE FROM templates WHERE template_id = $1 AND locale = $2
+`
+
+type DeleteTemplateLocaleParams struct {
+	TemplateID string
+	Locale     string
+}
+
+func (q *Queries) DeleteTemplateLocale(ctx context.Context, arg DeleteTemplateLocaleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteTemplateLocale, arg.TemplateID, arg.Locale)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const failTaskPermanently = `-- name: FailTaskPermanently :execrows
+UPDATE tasks
+SET status = 'failed', attempts = GREATEST(attempts, $1::int)
+WHERE id = $2 AND status = $3
+`
+
+type FailTaskPermanentlyParams struct {
+	MaxAttempts   int32
+	ID            int32
+	CurrentStatus TaskStatus
+}
+
+func (q *Queries) FailTaskPermanently(ctx context.Context, arg FailTaskPermanentlyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, failTaskPermanently, arg.MaxAttempts, arg.ID, arg.CurrentStatus)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getAgedQueuedTasks = `-- name: GetAgedQueuedTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events
+FROM tasks
+WHERE status = 'queued' AND priority = $1 AND updated_at <= now() - ($2 * interval '1 second')
+ORDER BY id LIMIT $3
+`
+
+type GetAgedQueuedTasksParams struct {
+	Priority TaskPriority
+	Column2  interface{}
+	Limit    int32
+}
+
+func (q *Queries) GetAgedQueuedTasks(ctx context.Context, arg GetAgedQueuedTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getAgedQueuedTasks, arg.Priority, arg.Column2, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +359,17 @@ func (q *Queries) GetMissedTasks(ctx context.Context, arg GetMissedTasksParams)
 			&i.Payload,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
 		); err != nil {
 			return nil, err
 		}
@@ -88,12 +381,42 @@ func (q *Queries) GetMissedTasks(ctx context.Context, arg GetMissedTasksParams)
 	return items, nil
 }
 
-const getTaskByID = `-- name: GetTaskByID :one
-SELECT id, name, type, status, priority, payload, created_at, updated_at FROM tasks WHERE id = $1
+const getBatchByID = `-- name: GetBatchByID :one
+SELECT id, name, caller, total_count, succeeded_count, failed_count, cancelled_count, completion_task, completion_webhook_url, completion_task_id, completion_webhook_task_id, completed_at, created_at FROM batches WHERE id = $1
 `
 
-func (q *Queries) GetTaskByID(ctx context.Context, id int32) (Task, error) {
-	row := q.db.QueryRow(ctx, getTaskByID, id)
+func (q *Queries) GetBatchByID(ctx context.Context, id int32) (Batch, error) {
+	row := q.db.QueryRow(ctx, getBatchByID, id)
+	var i Batch
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Caller,
+		&i.TotalCount,
+		&i.SucceededCount,
+		&i.FailedCount,
+		&i.CancelledCount,
+		&i.CompletionTask,
+		&i.CompletionWebhookUrl,
+		&i.CompletionTaskID,
+		&i.CompletionWebhookTaskID,
+		&i.CompletedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getChildTaskByKey = `-- name: GetChildTaskByKey :one
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events FROM tasks WHERE parent_id = $1 AND child_key = $2
+`
+
+type GetChildTaskByKeyParams struct {
+	ParentID sql.NullInt32
+	ChildKey sql.NullString
+}
+
+func (q *Queries) GetChildTaskByKey(ctx context.Context, arg GetChildTaskByKeyParams) (Task, error) {
+	row := q.db.QueryRow(ctx, getChildTaskByKey, arg.ParentID, arg.ChildKey)
 	var i Task
 	err := row.Scan(
 		&i.ID,
@@ -104,28 +427,129 @@ func (q *Queries) GetTaskByID(ctx context.Context, id int32) (Task, error) {
 		&i.Payload,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.Attempts,
+		&i.Result,
+		&i.Caller,
+		&i.WorkflowID,
+		&i.WorkflowKey,
+		&i.BatchID,
+		&i.BatchCountedAt,
+		&i.ParentID,
+		&i.ChildKey,
+		&i.CallbackUrl,
+		&i.CallbackEvents,
 	)
 	return i, err
 }
 
-const getTaskStatusChangeHistory = `-- name: GetTaskStatusChangeHistory :many
-SELECT id, task_id, old_status, new_status, created_at FROM tasks_status_change_history WHERE task_id = $1
+const getFilteredMissedTasks = `-- name: GetFilteredMissedTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events
+FROM tasks
+WHERE status = $1
+  AND updated_at <= now() - ($2::int * interval '1 second')
+  AND ($3::text IS NULL OR type::text = $3::text)
+  AND ($4::text IS NULL OR priority::text = $4::text)
+  AND ($5::int IS NULL OR id >= $5::int)
+  AND ($6::int IS NULL OR id <= $6::int)
+ORDER BY id
+LIMIT $7
 `
 
-func (q *Queries) GetTaskStatusChangeHistory(ctx context.Context, taskID int32) ([]TasksStatusChangeHistory, error) {
-	rows, err := q.db.Query(ctx, getTaskStatusChangeHistory, taskID)
+type GetFilteredMissedTasksParams struct {
+	Status        TaskStatus
+	PassedSeconds int32
+	Type          sql.NullString
+	Priority      sql.NullString
+	MinID         sql.NullInt32
+	MaxID         sql.NullInt32
+	MaxCount      int32
+}
+
+func (q *Queries) GetFilteredMissedTasks(ctx context.Context, arg GetFilteredMissedTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getFilteredMissedTasks,
+		arg.Status,
+		arg.PassedSeconds,
+		arg.Type,
+		arg.Priority,
+		arg.MinID,
+		arg.MaxID,
+		arg.MaxCount,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []TasksStatusChangeHistory
+	var items []Task
 	for rows.Next() {
-		var i TasksStatusChangeHistory
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFilteredWebhookDeliveries = `-- name: GetFilteredWebhookDeliveries :many
+SELECT id, task_id, url, old_status, new_status, status, attempts, next_attempt_at, last_attempt_at, last_response_status, last_error, delivered_at, created_at
+FROM webhook_deliveries
+WHERE ($1::text IS NULL OR status = $1::text)
+  AND ($2::int IS NULL OR task_id = $2::int)
+ORDER BY id DESC
+LIMIT $3
+`
+
+type GetFilteredWebhookDeliveriesParams struct {
+	Status   sql.NullString
+	TaskID   sql.NullInt32
+	MaxCount int32
+}
+
+func (q *Queries) GetFilteredWebhookDeliveries(ctx context.Context, arg GetFilteredWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, getFilteredWebhookDeliveries, arg.Status, arg.TaskID, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
 		if err := rows.Scan(
 			&i.ID,
 			&i.TaskID,
+			&i.Url,
 			&i.OldStatus,
 			&i.NewStatus,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastAttemptAt,
+			&i.LastResponseStatus,
+			&i.LastError,
+			&i.DeliveredAt,
 			&i.CreatedAt,
 		); err != nil {
 			return nil, err
@@ -138,12 +562,29 @@ func (q *Queries) GetTaskStatusChangeHistory(ctx context.Context, taskID int32)
 	return items, nil
 }
 
-const getTasksByStatus = `-- name: GetTasksByStatus :many
-SELECT id, name, type, status, priority, payload, created_at, updated_at FROM tasks WHERE status = $1
+const getFinishableWaitingTasks = `-- name: GetFinishableWaitingTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events
+FROM tasks
+WHERE status = 'waiting'
+  AND updated_at <= now() - ($1::int * interval '1 second')
+  AND NOT EXISTS (
+    SELECT 1
+    FROM tasks AS children
+    WHERE children.parent_id = tasks.id
+      AND NOT (children.status IN ('succeeded', 'skipped', 'cancelled') OR (children.status = 'failed' AND children.attempts >= $2::int))
+  )
+ORDER BY id
+LIMIT $3
 `
 
-func (q *Queries) GetTasksByStatus(ctx context.Context, status TaskStatus) ([]Task, error) {
-	rows, err := q.db.Query(ctx, getTasksByStatus, status)
+type GetFinishableWaitingTasksParams struct {
+	PassedSeconds int32
+	MaxAttempts   int32
+	MaxCount      int32
+}
+
+func (q *Queries) GetFinishableWaitingTasks(ctx context.Context, arg GetFinishableWaitingTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getFinishableWaitingTasks, arg.PassedSeconds, arg.MaxAttempts, arg.MaxCount)
 	if err != nil {
 		return nil, err
 	}
@@ -160,6 +601,17 @@ func (q *Queries) GetTasksByStatus(ctx context.Context, status TaskStatus) ([]Ta
 			&i.Payload,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
 		); err != nil {
 			return nil, err
 		}
@@ -171,65 +623,1695 @@ func (q *Queries) GetTasksByStatus(ctx context.Context, status TaskStatus) ([]Ta
 	return items, nil
 }
 
-const insertTask = `-- name: InsertTask :one
-INSERT INTO tasks (
-    name, type, status, priority, payload
-) VALUES (
-             $1, $2, $3, $4, $5
-         )
-    RETURNING id
+const getFinishedUncompletedBatches = `-- name: GetFinishedUncompletedBatches :many
+SELECT id, name, caller, total_count, succeeded_count, failed_count, cancelled_count, completion_task, completion_webhook_url, completion_task_id, completion_webhook_task_id, completed_at, created_at FROM batches WHERE completed_at IS NULL AND succeeded_count + failed_count + cancelled_count >= total_count ORDER BY id LIMIT $1
 `
 
-type InsertTaskParams struct {
-	Name     string
-	Type     TaskType
-	Status   TaskStatus
-	Priority TaskPriority
-	Payload  pgtype.JSON
+func (q *Queries) GetFinishedUncompletedBatches(ctx context.Context, limit int32) ([]Batch, error) {
+	rows, err := q.db.Query(ctx, getFinishedUncompletedBatches, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Batch
+	for rows.Next() {
+		var i Batch
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Caller,
+			&i.TotalCount,
+			&i.SucceededCount,
+			&i.FailedCount,
+			&i.CancelledCount,
+			&i.CompletionTask,
+			&i.CompletionWebhookUrl,
+			&i.CompletionTaskID,
+			&i.CompletionWebhookTaskID,
+			&i.CompletedAt,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) InsertTask(ctx context.Context, arg InsertTaskParams) (int32, error) {
-	row := q.db.QueryRow(ctx, insertTask,
-		arg.Name,
-		arg.Type,
-		arg.Status,
-		arg.Priority,
-		arg.Payload,
+const getLatestTemplate = `-- name: GetLatestTemplate :one
+SELECT template_id, locale, version, subject, text_body, html_body, created_at FROM templates WHERE template_id = $1 AND locale = $2 ORDER BY version DESC LIMIT 1
+`
+
+type GetLatestTemplateParams struct {
+	TemplateID string
+	Locale     string
+}
+
+func (q *Queries) GetLatestTemplate(ctx context.Context, arg GetLatestTemplateParams) (Template, error) {
+	row := q.db.QueryRow(ctx, getLatestTemplate, arg.TemplateID, arg.Locale)
+	var i Template
+	err := row.Scan(
+		&i.TemplateID,
+		&i.Locale,
+		&i.Version,
+		&i.Subject,
+		&i.TextBody,
+		&i.HtmlBody,
+		&i.CreatedAt,
 	)
-	var id int32
-	err := row.Scan(&id)
-	return id, err
+	return i, err
 }
 
-const insertTaskStatusChangeHistory = `-- name: InsertTaskStatusChangeHistory :exec
-INSERT INTO tasks_status_change_history (
-    task_id, old_status, new_status
-) VALUES (
-             $1, $2, $3
+const getLatestTemplates = `-- name: GetLatestTemplates :many
+SELECT DISTINCT ON (template_id, locale) template_id, locale, version, subject, text_body, html_body, created_at FROM templates ORDER BY template_id, locale, version DESC
+`
+
+func (q *Queries) GetLatestTemplates(ctx context.Context) ([]Template, error) {
+	rows, err := q.db.Query(ctx, getLatestTemplates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Template
+	for rows.Next() {
+		var i Template
+		if err := rows.Scan(
+			&i.TemplateID,
+			&i.Locale,
+			&i.Version,
+			&i.Subject,
+			&i.TextBody,
+			&i.HtmlBody,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestTemplatesByTemplateID = `-- name: GetLatestTemplatesByTemplateID :many
+SELECT DISTINCT ON (locale) template_id, locale, version, subject, text_body, html_body, created_at FROM templates WHERE template_id = $1 ORDER BY locale, version DESC
+`
+
+func (q *Queries) GetLatestTemplatesByTemplateID(ctx context.Context, templateID string) ([]Template, error) {
+	rows, err := q.db.Query(ctx, getLatestTemplatesByTemplateID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Template
+	for rows.Next() {
+		var i Template
+		if err := rows.Scan(
+			&i.TemplateID,
+			&i.Locale,
+			&i.Version,
+			&i.Subject,
+			&i.TextBody,
+			&i.HtmlBody,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLimitedTasksByStatus = `-- name: GetLimitedTasksByStatus :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events FROM tasks WHERE status = $1 LIMIT $2
+`
+
+type GetLimitedTasksByStatusParams struct {
+	Status TaskStatus
+	Limit  int32
+}
+
+func (q *Queries) GetLimitedTasksByStatus(ctx context.Context, arg GetLimitedTasksByStatusParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getLimitedTasksByStatus, arg.Status, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMissedTasks = `-- name: GetMissedTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events
+FROM tasks
+WHERE status = $1 AND updated_at <= now() - ($2 * interval '1 second') LIMIT $3
+`
+
+type GetMissedTasksParams struct {
+	Status  TaskStatus
+	Column2 interface{}
+	Limit   int32
+}
+
+func (q *Queries) GetMissedTasks(ctx context.Context, arg GetMissedTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getMissedTasks, arg.Status, arg.Column2, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getParentTasks = `-- name: GetParentTasks :many
+SELECT tasks.id, tasks.name, tasks.type, tasks.status, tasks.priority, tasks.payload, tasks.created_at, tasks.updated_at, tasks.attempts, tasks.result, tasks.caller, tasks.workflow_id, tasks.workflow_key, tasks.batch_id, tasks.batch_counted_at, tasks.parent_id, tasks.child_key, tasks.callback_url, tasks.callback_events
+FROM tasks
+JOIN task_dependencies ON task_dependencies.depends_on_task_id = tasks.id
+WHERE task_dependencies.task_id = $1
+ORDER BY tasks.id
+`
+
+func (q *Queries) GetParentTasks(ctx context.Context, taskID int32) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getParentTasks, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRetryableFailedTasks = `-- name: GetRetryableFailedTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events
+FROM tasks
+WHERE status = 'failed' AND attempts < $1 AND updated_at <= now() - ($2 * interval '1 second')
+ORDER BY id LIMIT $3
+`
+
+type GetRetryableFailedTasksParams struct {
+	Attempts int32
+	Column2  interface{}
+	Limit    int32
+}
+
+func (q *Queries) GetRetryableFailedTasks(ctx context.Context, arg GetRetryableFailedTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getRetryableFailedTasks, arg.Attempts, arg.Column2, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStalledWorkflowParentTasks = `-- name: GetStalledWorkflowParentTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events
+FROM tasks parents
+WHERE (parents.status = 'succeeded' OR (parents.status = 'failed' AND parents.attempts >= $1::int))
+  AND parents.updated_at <= now() - ($2::int * interval '1 second')
+  AND EXISTS (
+      SELECT 1
+      FROM task_dependencies children_dependencies
+      JOIN tasks children ON children.id = children_dependencies.task_id
+      WHERE children_dependencies.depends_on_task_id = parents.id
+        AND children.status = 'pending'
+        AND NOT EXISTS (
+            SELECT 1
+            FROM task_dependencies siblings_dependencies
+            JOIN tasks siblings ON siblings.id = siblings_dependencies.depends_on_task_id
+            WHERE siblings_dependencies.task_id = children.id AND siblings.status IN ('pending', 'queued', 'running')
+        )
+  )
+ORDER BY parents.id
+LIMIT $3
+`
+
+type GetStalledWorkflowParentTasksParams struct {
+	MaxAttempts   int32
+	PassedSeconds int32
+	MaxCount      int32
+}
+
+func (q *Queries) GetStalledWorkflowParentTasks(ctx context.Context, arg GetStalledWorkflowParentTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getStalledWorkflowParentTasks, arg.MaxAttempts, arg.PassedSeconds, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskByID = `-- name: GetTaskByID :one
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events FROM tasks WHERE id = $1
+`
+
+func (q *Queries) GetTaskByID(ctx context.Context, id int32) (Task, error) {
+	row := q.db.QueryRow(ctx, getTaskByID, id)
+	var i Task
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Type,
+		&i.Status,
+		&i.Priority,
+		&i.Payload,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attempts,
+		&i.Result,
+		&i.Caller,
+		&i.WorkflowID,
+		&i.WorkflowKey,
+		&i.BatchID,
+		&i.BatchCountedAt,
+		&i.ParentID,
+		&i.ChildKey,
+		&i.CallbackUrl,
+		&i.CallbackEvents,
+	)
+	return i, err
+}
+
+const getTaskEventsAfter = `-- name: GetTaskEventsAfter :many
+SELECT tasks_status_change_history.id, tasks_status_change_history.task_id, tasks.type AS task_type, tasks_status_change_history.old_status, tasks_status_change_history.new_status, tasks_status_change_history.created_at
+FROM tasks_status_change_history
+JOIN tasks ON tasks.id = tasks_status_change_history.task_id
+WHERE tasks_status_change_history.id > $1
+  AND ($2::int IS NULL OR tasks_status_change_history.task_id = $2::int)
+  AND ($3::text IS NULL OR tasks.type = $3::text)
+  AND ($4::text IS NULL OR tasks_status_change_history.new_status::text = $4::text)
+ORDER BY tasks_status_change_history.id
+LIMIT $5
+`
+
+type GetTaskEventsAfterParams struct {
+	AfterID   int32
+	TaskID    sql.NullInt32
+	TaskType  sql.NullString
+	NewStatus sql.NullString
+	MaxCount  int32
+}
+
+type GetTaskEventsAfterRow struct {
+	ID        int32
+	TaskID    int32
+	TaskType  string
+	OldStatus TaskStatus
+	NewStatus TaskStatus
+	CreatedAt sql.NullTime
+}
+
+func (q *Queries) GetTaskEventsAfter(ctx context.Context, arg GetTaskEventsAfterParams) ([]GetTaskEventsAfterRow, error) {
+	rows, err := q.db.Query(ctx, getTaskEventsAfter,
+		arg.AfterID,
+		arg.TaskID,
+		arg.TaskType,
+		arg.NewStatus,
+		arg.MaxCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTaskEventsAfterRow
+	for rows.Next() {
+		var i GetTaskEventsAfterRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.TaskType,
+			&i.OldStatus,
+			&i.NewStatus,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskPriorityChangeHistory = `-- name: GetTaskPriorityChangeHistory :many
+SELECT id, task_id, old_priority, new_priority, created_at FROM tasks_priority_change_history WHERE task_id = $1
+`
+
+func (q *Queries) GetTaskPriorityChangeHistory(ctx context.Context, taskID int32) ([]TasksPriorityChangeHistory, error) {
+	rows, err := q.db.Query(ctx, getTaskPriorityChangeHistory, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TasksPriorityChangeHistory
+	for rows.Next() {
+		var i TasksPriorityChangeHistory
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.OldPriority,
+			&i.NewPriority,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskStatusChangeHistory = `-- name: GetTaskStatusChangeHistory :many
+SELECT id, task_id, old_status, new_status, created_at FROM tasks_status_change_history WHERE task_id = $1
+`
+
+func (q *Queries) GetTaskStatusChangeHistory(ctx context.Context, taskID int32) ([]TasksStatusChangeHistory, error) {
+	rows, err := q.db.Query(ctx, getTaskStatusChangeHistory, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TasksStatusChangeHistory
+	for rows.Next() {
+		var i TasksStatusChangeHistory
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.OldStatus,
+			&i.NewStatus,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasksByIDs = `-- name: GetTasksByIDs :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events FROM tasks WHERE id = ANY($1::int[])
+`
+
+func (q *Queries) GetTasksByIDs(ctx context.Context, ids []int32) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getTasksByIDs, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasksByStatus = `-- name: GetTasksByStatus :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events FROM tasks WHERE status = $1
+`
+
+func (q *Queries) GetTasksByStatus(ctx context.Context, status TaskStatus) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getTasksByStatus, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTemplateVersion = `-- name: GetTemplateVersion :one
+SELECT template_id, locale, version, subject, text_body, html_body, created_at FROM templates WHERE template_id = $1 AND locale = $2 AND version = $3
+`
+
+type GetTemplateVersionParams struct {
+	TemplateID string
+	Locale     string
+	Version    int32
+}
+
+func (q *Queries) GetTemplateVersion(ctx context.Context, arg GetTemplateVersionParams) (Template, error) {
+	row := q.db.QueryRow(ctx, getTemplateVersion, arg.TemplateID, arg.Locale, arg.Version)
+	var i Template
+	err := row.Scan(
+		&i.TemplateID,
+		&i.Locale,
+		&i.Version,
+		&i.Subject,
+		&i.TextBody,
+		&i.HtmlBody,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getTemplateVersions = `-- name: GetTemplateVersions :many
+SELECT template_id, locale, version, subject, text_body, html_body, created_at FROM templates WHERE template_id = $1 AND locale = $2 ORDER BY version DESC
+`
+
+type GetTemplateVersionsParams struct {
+	TemplateID string
+	Locale     string
+}
+
+func (q *Queries) GetTemplateVersions(ctx context.Context, arg GetTemplateVersionsParams) ([]Template, error) {
+	rows, err := q.db.Query(ctx, getTemplateVersions, arg.TemplateID, arg.Locale)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Template
+	for rows.Next() {
+		var i Template
+		if err := rows.Scan(
+			&i.TemplateID,
+			&i.Locale,
+			&i.Version,
+			&i.Subject,
+			&i.TextBody,
+			&i.HtmlBody,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUncountedBatchTasks = `-- name: GetUncountedBatchTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events
+FROM tasks
+WHERE batch_id IS NOT NULL AND batch_counted_at IS NULL
+  AND (status IN ('succeeded', 'cancelled') OR (status = 'failed' AND attempts >= $1::int))
+  AND updated_at <= now() - ($2::int * interval '1 second')
+ORDER BY id
+LIMIT $3
+`
+
+type GetUncountedBatchTasksParams struct {
+	MaxAttempts   int32
+	PassedSeconds int32
+	MaxCount      int32
+}
+
+func (q *Queries) GetUncountedBatchTasks(ctx context.Context, arg GetUncountedBatchTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getUncountedBatchTasks, arg.MaxAttempts, arg.PassedSeconds, arg.MaxCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWebhookDeliveryByID = `-- name: GetWebhookDeliveryByID :one
+SELECT id, task_id, url, old_status, new_status, status, attempts, next_attempt_at, last_attempt_at, last_response_status, last_error, delivered_at, created_at FROM webhook_deliveries WHERE id = $1
+`
+
+func (q *Queries) GetWebhookDeliveryByID(ctx context.Context, id int32) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, getWebhookDeliveryByID, id)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.TaskID,
+		&i.Url,
+		&i.OldStatus,
+		&i.NewStatus,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastAttemptAt,
+		&i.LastResponseStatus,
+		&i.LastError,
+		&i.DeliveredAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getWorkflowByID = `-- name: GetWorkflowByID :one
+SELECT id, name, failure_policy, caller, created_at FROM workflows WHERE id = $1
+`
+
+func (q *Queries) GetWorkflowByID(ctx context.Context, id int32) (Workflow, error) {
+	row := q.db.QueryRow(ctx, getWorkflowByID, id)
+	var i Workflow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.FailurePolicy,
+		&i.Caller,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getWorkflowDependencies = `-- name: GetWorkflowDependencies :many
+SELECT task_dependencies.task_id, task_dependencies.depends_on_task_id
+FROM task_dependencies
+JOIN tasks ON tasks.id = task_dependencies.task_id
+WHERE tasks.workflow_id = $1
+`
+
+func (q *Queries) GetWorkflowDependencies(ctx context.Context, workflowID sql.NullInt32) ([]TaskDependency, error) {
+	rows, err := q.db.Query(ctx, getWorkflowDependencies, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskDependency
+	for rows.Next() {
+		var i TaskDependency
+		if err := rows.Scan(
+			&i.TaskID,
+			&i.DependsOnTaskID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWorkflowStatusChangeHistory = `-- name: GetWorkflowStatusChangeHistory :many
+SELECT tasks_status_change_history.id, tasks_status_change_history.task_id, tasks_status_change_history.old_status, tasks_status_change_history.new_status, tasks_status_change_history.created_at
+FROM tasks_status_change_history
+JOIN tasks ON tasks.id = tasks_status_change_history.task_id
+WHERE tasks.workflow_id = $1
+ORDER BY tasks_status_change_history.id
+`
+
+func (q *Queries) GetWorkflowStatusChangeHistory(ctx context.Context, workflowID sql.NullInt32) ([]TasksStatusChangeHistory, error) {
+	rows, err := q.db.Query(ctx, getWorkflowStatusChangeHistory, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TasksStatusChangeHistory
+	for rows.Next() {
+		var i TasksStatusChangeHistory
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.OldStatus,
+			&i.NewStatus,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getWorkflowTasks = `-- name: GetWorkflowTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events FROM tasks WHERE workflow_id = $1 ORDER BY id
+`
+
+func (q *Queries) GetWorkflowTasks(ctx context.Context, workflowID sql.NullInt32) ([]Task, error) {
+	rows, err := q.db.Query(ctx, getWorkflowTasks, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const increaseBatchCounters = `-- name: IncreaseBatchCounters :one
+UPDATE batches
+SET succeeded_count = succeeded_count + $1::int,
+    failed_count = failed_count + $2::int,
+    cancelled_count = cancelled_count + $3::int
+WHERE id = $4
+    RETURNING id, name, caller, total_count, succeeded_count, failed_count, cancelled_count, completion_task, completion_webhook_url, completion_task_id, completion_webhook_task_id, completed_at, created_at
+`
+
+type IncreaseBatchCountersParams struct {
+	SucceededCount int32
+	FailedCount    int32
+	CancelledCount int32
+	ID             int32
+}
+
+func (q *Queries) IncreaseBatchCounters(ctx context.Context, arg IncreaseBatchCountersParams) (Batch, error) {
+	row := q.db.QueryRow(ctx, increaseBatchCounters,
+		arg.SucceededCount,
+		arg.FailedCount,
+		arg.CancelledCount,
+		arg.ID,
+	)
+	var i Batch
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Caller,
+		&i.TotalCount,
+		&i.SucceededCount,
+		&i.FailedCount,
+		&i.CancelledCount,
+		&i.CompletionTask,
+		&i.CompletionWebhookUrl,
+		&i.CompletionTaskID,
+		&i.CompletionWebhookTaskID,
+		&i.CompletedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const insertBatch = `-- name: InsertBatch :one
+INSERT INTO batches (
+    name, caller, total_count, completion_task, completion_webhook_url
+) VALUES (
+             $1, $2, $3, $4, $5
+         )
+    RETURNING id, name, caller, total_count, succeeded_count, failed_count, cancelled_count, completion_task, completion_webhook_url, completion_task_id, completion_webhook_task_id, completed_at, created_at
+`
+
+type InsertBatchParams struct {
+	Name                 string
+	Caller               sql.NullString
+	TotalCount           int32
+	CompletionTask       pgtype.JSONB
+	CompletionWebhookUrl sql.NullString
+}
+
+func (q *Queries) InsertBatch(ctx context.Context, arg InsertBatchParams) (Batch, error) {
+	row := q.db.QueryRow(ctx, insertBatch,
+		arg.Name,
+		arg.Caller,
+		arg.TotalCount,
+		arg.CompletionTask,
+		arg.CompletionWebhookUrl,
+	)
+	var i Batch
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Caller,
+		&i.TotalCount,
+		&i.SucceededCount,
+		&i.FailedCount,
+		&i.CancelledCount,
+		&i.CompletionTask,
+		&i.CompletionWebhookUrl,
+		&i.CompletionTaskID,
+		&i.CompletionWebhookTaskID,
+		&i.CompletedAt,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const insertBatchTask = `-- name: InsertBatchTask :one
+INSERT INTO tasks (
+    name, type, status, priority, payload, caller, batch_id
+) VALUES (
+             $1, $2, $3, $4, $5, $6, $7
+         )
+    RETURNING id
+`
+
+type InsertBatchTaskParams struct {
+	Name     string
+	Type     string
+	Status   TaskStatus
+	Priority TaskPriority
+	Payload  pgtype.JSONB
+	Caller   sql.NullString
+	BatchID  sql.NullInt32
+}
+
+func (q *Queries) InsertBatchTask(ctx context.Context, arg InsertBatchTaskParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertBatchTask,
+		arg.Name,
+		arg.Type,
+		arg.Status,
+		arg.Priority,
+		arg.Payload,
+		arg.Caller,
+		arg.BatchID,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const insertChildTask = `-- name: InsertChildTask :one
+INSERT INTO tasks (
+    name, type, status, priority, payload, caller, parent_id, child_key
+) VALUES (
+             $1, $2, $3, $4, $5, $6, $7, $8
+         )
+    ON CONFLICT (parent_id, child_key) DO NOTHING
+    RETURNING id
+`
+
+type InsertChildTaskParams struct {
+	Name     string
+	Type     string
+	Status   TaskStatus
+	Priority TaskPriority
+	Payload  pgtype.JSONB
+	Caller   sql.NullString
+	ParentID sql.NullInt32
+	ChildKey sql.NullString
+}
+
+func (q *Queries) InsertChildTask(ctx context.Context, arg InsertChildTaskParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertChildTask,
+		arg.Name,
+		arg.Type,
+		arg.Status,
+		arg.Priority,
+		arg.Payload,
+		arg.Caller,
+		arg.ParentID,
+		arg.ChildKey,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const insertTask = `-- name: InsertTask :one
+INSERT INTO tasks (
+    name, type, status, priority, payload, caller, callback_url, callback_events
+) VALUES (
+             $1, $2, $3, $4, $5, $6, $7, $8
+         )
+    RETURNING id
+`
+
+type InsertTaskParams struct {
+	Name           string
+	Type           string
+	Status         TaskStatus
+	Priority       TaskPriority
+	Payload        pgtype.JSONB
+	Caller         sql.NullString
+	CallbackUrl    sql.NullString
+	CallbackEvents []string
+}
+
+func (q *Queries) InsertTask(ctx context.Context, arg InsertTaskParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertTask,
+		arg.Name,
+		arg.Type,
+		arg.Status,
+		arg.Priority,
+		arg.Payload,
+		arg.Caller,
+		arg.CallbackUrl,
+		arg.CallbackEvents,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const insertTaskDependency = `-- name: InsertTaskDependency :exec
+INSERT INTO task_dependencies (
+    task_id, depends_on_task_id
+) VALUES (
+             $1, $2
+         )
+`
+
+type InsertTaskDependencyParams struct {
+	TaskID          int32
+	DependsOnTaskID int32
+}
+
+func (q *Queries) InsertTaskDependency(ctx context.Context, arg InsertTaskDependencyParams) error {
+	_, err := q.db.Exec(ctx, insertTaskDependency, arg.TaskID, arg.DependsOnTaskID)
+	return err
+}
+
+const insertTaskPriorityChangeHistory = `-- name: InsertTaskPriorityChangeHistory :exec
+INSERT INTO tasks_priority_change_history (
+    task_id, old_priority, new_priority
+) VALUES (
+             $1, $2, $3
+         )
+`
+
+type InsertTaskPriorityChangeHistoryParams struct {
+	TaskID      int32
+	OldPriority TaskPriority
+	NewPriority TaskPriority
+}
+
+func (q *Queries) InsertTaskPriorityChangeHistory(ctx context.Context, arg InsertTaskPriorityChangeHistoryParams) error {
+	_, err := q.db.Exec(ctx, insertTaskPriorityChangeHistory, arg.TaskID, arg.OldPriority, arg.NewPriority)
+	return err
+}
+
+const insertTaskStatusChangeHistory = `-- name: InsertTaskStatusChangeHistory :exec
+INSERT INTO tasks_status_change_history (
+    task_id, old_status, new_status
+) VALUES (
+             $1, $2, $3
+         )
+`
+
+type InsertTaskStatusChangeHistoryParams struct {
+	TaskID    int32
+	OldStatus TaskStatus
+	NewStatus TaskStatus
+}
+
+func (q *Queries) InsertTaskStatusChangeHistory(ctx context.Context, arg InsertTaskStatusChangeHistoryParams) error {
+	_, err := q.db.Exec(ctx, insertTaskStatusChangeHistory, arg.TaskID, arg.OldStatus, arg.NewStatus)
+	return err
+}
+
+const insertTaskWebhookDelivery = `-- name: InsertTaskWebhookDelivery :exec
+INSERT INTO webhook_deliveries (task_id, url, old_status, new_status)
+SELECT id, callback_url, $1::task_status, $2::task_status
+FROM tasks
+WHERE id = $3 AND callback_url IS NOT NULL
+  AND (callback_events IS NULL OR $2::task_status::text = ANY(callback_events))
+`
+
+type InsertTaskWebhookDeliveryParams struct {
+	OldStatus TaskStatus
+	NewStatus TaskStatus
+	TaskID    int32
+}
+
+func (q *Queries) InsertTaskWebhookDelivery(ctx context.Context, arg InsertTaskWebhookDeliveryParams) error {
+	_, err := q.db.Exec(ctx, insertTaskWebhookDelivery, arg.OldStatus, arg.NewStatus, arg.TaskID)
+	return err
+}
+
+const insertTemplateVersion = `-- name: InsertTemplateVersion :one
+INSERT INTO templates (template_id, locale, version, subject, text_body, html_body)
+SELECT $1::text, $2::text, MAX(version) + 1, $3::text, $4::text, $5::text
+FROM templates
+WHERE template_id = $1::text AND locale = $2::text
+HAVING COUNT(*) > 0
+    RETURNING template_id, locale, version, subject, text_body, html_body, created_at
+`
+
+type InsertTemplateVersionParams struct {
+	TemplateID string
+	Locale     string
+	Subject    string
+	TextBody   string
+	HtmlBody   string
+}
+
+func (q *Queries) InsertTemplateVersion(ctx context.Context, arg InsertTemplateVersionParams) (Template, error) {
+	row := q.db.QueryRow(ctx, insertTemplateVersion,
+		arg.TemplateID,
+		arg.Locale,
+		arg.Subject,
+		arg.TextBody,
+		arg.HtmlBody,
+	)
+	var i Template
+	err := row.Scan(
+		&i.TemplateID,
+		&i.Locale,
+		&i.Version,
+		&i.Subject,
+		&i.TextBody,
+		&i.HtmlBody,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const insertWorkflow = `-- name: InsertWorkflow :one
+INSERT INTO workflows (
+    name, failure_policy, caller
+) VALUES (
+             $1, $2, $3
          )
+    RETURNING id, name, failure_policy, caller, created_at
 `
 
-type InsertTaskStatusChangeHistoryParams struct {
-	TaskID    int32
-	OldStatus TaskStatus
-	NewStatus TaskStatus
+type InsertWorkflowParams struct {
+	Name          string
+	FailurePolicy string
+	Caller        sql.NullString
 }
 
-func (q *Queries) InsertTaskStatusChangeHistory(ctx context.Context, arg InsertTaskStatusChangeHistoryParams) error {
-	_, err := q.db.Exec(ctx, insertTaskStatusChangeHistory, arg.TaskID, arg.OldStatus, arg.NewStatus)
+func (q *Queries) InsertWorkflow(ctx context.Context, arg InsertWorkflowParams) (Workflow, error) {
+	row := q.db.QueryRow(ctx, insertWorkflow, arg.Name, arg.FailurePolicy, arg.Caller)
+	var i Workflow
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.FailurePolicy,
+		&i.Caller,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const insertWorkflowTask = `-- name: InsertWorkflowTask :one
+INSERT INTO tasks (
+    name, type, status, priority, payload, caller, workflow_id, workflow_key
+) VALUES (
+             $1, $2, $3, $4, $5, $6, $7, $8
+         )
+    RETURNING id
+`
+
+type InsertWorkflowTaskParams struct {
+	Name        string
+	Type        string
+	Status      TaskStatus
+	Priority    TaskPriority
+	Payload     pgtype.JSONB
+	Caller      sql.NullString
+	WorkflowID  sql.NullInt32
+	WorkflowKey sql.NullString
+}
+
+func (q *Queries) InsertWorkflowTask(ctx context.Context, arg InsertWorkflowTaskParams) (int32, error) {
+	row := q.db.QueryRow(ctx, insertWorkflowTask,
+		arg.Name,
+		arg.Type,
+		arg.Status,
+		arg.Priority,
+		arg.Payload,
+		arg.Caller,
+		arg.WorkflowID,
+		arg.WorkflowKey,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const lockCancellableBatchTasks = `-- name: LockCancellableBatchTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events
+FROM tasks
+WHERE batch_id = $1 AND (status = 'queued' OR (status = 'failed' AND attempts < $2::int))
+ORDER BY id
+FOR UPDATE
+`
+
+type LockCancellableBatchTasksParams struct {
+	BatchID     sql.NullInt32
+	MaxAttempts int32
+}
+
+func (q *Queries) LockCancellableBatchTasks(ctx context.Context, arg LockCancellableBatchTasksParams) ([]Task, error) {
+	rows, err := q.db.Query(ctx, lockCancellableBatchTasks, arg.BatchID, arg.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockCancellableWorkflowTasks = `-- name: LockCancellableWorkflowTasks :many
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events FROM tasks WHERE workflow_id = $1 AND status IN ('pending', 'queued') ORDER BY id FOR UPDATE
+`
+
+func (q *Queries) LockCancellableWorkflowTasks(ctx context.Context, workflowID sql.NullInt32) ([]Task, error) {
+	rows, err := q.db.Query(ctx, lockCancellableWorkflowTasks, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockPendingDependentTasks = `-- name: LockPendingDependentTasks :many
+SELECT tasks.id, tasks.name, tasks.type, tasks.status, tasks.priority, tasks.payload, tasks.created_at, tasks.updated_at, tasks.attempts, tasks.result, tasks.caller, tasks.workflow_id, tasks.workflow_key, tasks.batch_id, tasks.batch_counted_at, tasks.parent_id, tasks.child_key, tasks.callback_url, tasks.callback_events
+FROM tasks
+JOIN task_dependencies ON task_dependencies.task_id = tasks.id
+WHERE task_dependencies.depends_on_task_id = $1 AND tasks.status = 'pending'
+ORDER BY tasks.id
+FOR UPDATE OF tasks
+`
+
+func (q *Queries) LockPendingDependentTasks(ctx context.Context, dependsOnTaskID int32) ([]Task, error) {
+	rows, err := q.db.Query(ctx, lockPendingDependentTasks, dependsOnTaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockPendingDescendantTasks = `-- name: LockPendingDescendantTasks :many
+WITH RECURSIVE descendants AS (
+    SELECT task_dependencies.task_id FROM task_dependencies WHERE task_dependencies.depends_on_task_id = $1
+    UNION
+    SELECT task_dependencies.task_id FROM task_dependencies JOIN descendants ON task_dependencies.depends_on_task_id = descendants.task_id
+)
+SELECT tasks.id, tasks.name, tasks.type, tasks.status, tasks.priority, tasks.payload, tasks.created_at, tasks.updated_at, tasks.attempts, tasks.result, tasks.caller, tasks.workflow_id, tasks.workflow_key, tasks.batch_id, tasks.batch_counted_at, tasks.parent_id, tasks.child_key, tasks.callback_url, tasks.callback_events
+FROM tasks
+JOIN descendants ON descendants.task_id = tasks.id
+WHERE tasks.status = 'pending'
+ORDER BY tasks.id
+FOR UPDATE OF tasks
+`
+
+func (q *Queries) LockPendingDescendantTasks(ctx context.Context, dependsOnTaskID int32) ([]Task, error) {
+	rows, err := q.db.Query(ctx, lockPendingDescendantTasks, dependsOnTaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Type,
+			&i.Status,
+			&i.Priority,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Attempts,
+			&i.Result,
+			&i.Caller,
+			&i.WorkflowID,
+			&i.WorkflowKey,
+			&i.BatchID,
+			&i.BatchCountedAt,
+			&i.ParentID,
+			&i.ChildKey,
+			&i.CallbackUrl,
+			&i.CallbackEvents,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockWaitingTask = `-- name: LockWaitingTask :one
+SELECT id, name, type, status, priority, payload, created_at, updated_at, attempts, result, caller, workflow_id, workflow_key, batch_id, batch_counted_at, parent_id, child_key, callback_url, callback_events FROM tasks WHERE id = $1 AND status = 'waiting' FOR UPDATE
+`
+
+func (q *Queries) LockWaitingTask(ctx context.Context, id int32) (Task, error) {
+	row := q.db.QueryRow(ctx, lockWaitingTask, id)
+	var i Task
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Type,
+		&i.Status,
+		&i.Priority,
+		&i.Payload,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Attempts,
+		&i.Result,
+		&i.Caller,
+		&i.WorkflowID,
+		&i.WorkflowKey,
+		&i.BatchID,
+		&i.BatchCountedAt,
+		&i.ParentID,
+		&i.ChildKey,
+		&i.CallbackUrl,
+		&i.CallbackEvents,
+	)
+	return i, err
+}
+
+const markBatchTaskCounted = `-- name: MarkBatchTaskCounted :execrows
+UPDATE tasks SET batch_counted_at = now() WHERE id = $1 AND batch_id IS NOT NULL AND batch_counted_at IS NULL
+`
+
+func (q *Queries) MarkBatchTaskCounted(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, markBatchTaskCounted, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markBatchTasksCounted = `-- name: MarkBatchTasksCounted :exec
+UPDATE tasks SET batch_counted_at = now() WHERE id = ANY($1::int[])
+`
+
+func (q *Queries) MarkBatchTasksCounted(ctx context.Context, ids []int32) error {
+	_, err := q.db.Exec(ctx, markBatchTasksCounted, ids)
 	return err
 }
 
-const updateTaskStatus = `-- name: UpdateTaskStatus :exec
-UPDATE tasks SET status = $1 WHERE id = $2
+const markWebhookDeliveryAttemptFailed = `-- name: MarkWebhookDeliveryAttemptFailed :execrows
+UPDATE webhook_deliveries
+SET status = $1, next_attempt_at = now() + ($2::int * interval '1 second'),
+    last_response_status = $3, last_error = $4
+WHERE id = $5 AND status = 'pending' AND attempts = $6
 `
 
-type UpdateTaskStatusParams struct {
-	Status TaskStatus
-	ID     int32
+type MarkWebhookDeliveryAttemptFailedParams struct {
+	NewStatus         string
+	RetryAfterSeconds int32
+	ResponseStatus    sql.NullInt32
+	LastError         sql.NullString
+	ID                int32
+	Attempts          int32
+}
+
+func (q *Queries) MarkWebhookDeliveryAttemptFailed(ctx context.Context, arg MarkWebhookDeliveryAttemptFailedParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markWebhookDeliveryAttemptFailed,
+		arg.NewStatus,
+		arg.RetryAfterSeconds,
+		arg.ResponseStatus,
+		arg.LastError,
+		arg.ID,
+		arg.Attempts,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const markWebhookDeliveryDelivered = `-- name: MarkWebhookDeliveryDelivered :execrows
+UPDATE webhook_deliveries
+SET status = 'delivered', delivered_at = now(), last_response_status = $1, last_error = NULL
+WHERE id = $2 AND status = 'pending' AND attempts = $3
+`
+
+type MarkWebhookDeliveryDeliveredParams struct {
+	ResponseStatus sql.NullInt32
+	ID             int32
+	Attempts       int32
+}
+
+func (q *Queries) MarkWebhookDeliveryDelivered(ctx context.Context, arg MarkWebhookDeliveryDeliveredParams) (int64, error) {
+	result, err := q.db.Exec(ctx, markWebhookDeliveryDelivered, arg.ResponseStatus, arg.ID, arg.Attempts)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const redeliverWebhookDelivery = `-- name: RedeliverWebhookDelivery :one
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = now()
+WHERE id = $1 AND status = 'failed'
+    RETURNING id, task_id, url, old_status, new_status, status, attempts, next_attempt_at, last_attempt_at, last_response_status, last_error, delivered_at, created_at
+`
+
+func (q *Queries) RedeliverWebhookDelivery(ctx context.Context, id int32) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, redeliverWebhookDelivery, id)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.TaskID,
+		&i.Url,
+		&i.OldStatus,
+		&i.NewStatus,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastAttemptAt,
+		&i.LastResponseStatus,
+		&i.LastError,
+		&i.DeliveredAt,
+		&i.CreatedAt,
+	)
+	return i, err
 }
 
-func (q *Queries) UpdateTaskStatus(ctx context.Context, arg UpdateTaskStatusParams) error {
-	_, err := q.db.Exec(ctx, updateTaskStatus, arg.Status, arg.ID)
+const setBatchCompletionTaskIDs = `-- name: SetBatchCompletionTaskIDs :exec
+UPDATE batches SET completion_task_id = $2, completion_webhook_task_id = $3 WHERE id = $1
+`
+
+type SetBatchCompletionTaskIDsParams struct {
+	ID                      int32
+	CompletionTaskID        sql.NullInt32
+	CompletionWebhookTaskID sql.NullInt32
+}
+
+func (q *Queries) SetBatchCompletionTaskIDs(ctx context.Context, arg SetBatchCompletionTaskIDsParams) error {
+	_, err := q.db.Exec(ctx, setBatchCompletionTaskIDs, arg.ID, arg.CompletionTaskID, arg.CompletionWebhookTaskID)
 	return err
 }
+
+const setTaskResult = `-- name: SetTaskResult :execrows
+UPDATE tasks SET result = $2 WHERE id = $1
+`
+
+type SetTaskResultParams struct {
+	ID     int32
+	Result pgtype.JSONB
+}
+
+func (q *Queries) SetTaskResult(ctx context.Context, arg SetTaskResultParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setTaskResult, arg.ID, arg.Result)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const touchTask = `-- name: TouchTask :execrows
+UPDATE tasks SET updated_at = now() WHERE id = $1 AND status = $2
+`
+
+type TouchTaskParams struct {
+	ID     int32
+	Status TaskStatus
+}
+
+func (q *Queries) TouchTask(ctx context.Context, arg TouchTaskParams) (int64, error) {
+	result, err := q.db.Exec(ctx, touchTask, arg.ID, arg.Status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateQueuedTaskPriority = `-- name: UpdateQueuedTaskPriority :execrows
+UPDATE tasks SET priority = $1 WHERE id = $2 AND priority = $3 AND status = 'queued'
+`
+
+type UpdateQueuedTaskPriorityParams struct {
+	NewPriority     TaskPriority
+	ID              int32
+	CurrentPriority TaskPriority
+}
+
+func (q *Queries) UpdateQueuedTaskPriority(ctx context.Context, arg UpdateQueuedTaskPriorityParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateQueuedTaskPriority, arg.NewPriority, arg.ID, arg.CurrentPriority)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateTaskStatus = `-- name: UpdateTaskStatus :execrows
+UPDATE tasks
+SET status = $1, attempts = attempts + CASE WHEN $1 = 'running'::task_status THEN 1 ELSE 0 END
+WHERE id = $2 AND status = $3
+`
+
+type UpdateTaskStatusParams struct {
+	NewStatus     TaskStatus
+	ID            int32
+	CurrentStatus TaskStatus
+}
+
+func (q *Queries) UpdateTaskStatus(ctx context.Context, arg UpdateTaskStatusParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateTaskStatus, arg.NewStatus, arg.ID, arg.CurrentStatus)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
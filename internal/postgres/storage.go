@@ -2,6 +2,8 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/jackc/pgtype"
 	"github.com/sf7293/task-manager/internal/domain"
@@ -15,11 +17,13 @@ import (
 )
 
 type storage struct {
-	queries *Queries
-	pool    *pgxpool.Pool
+	queries   *Queries
+	pool      *pgxpool.Pool
+	taskTypes domain.TaskTypeRegistry
 }
 
-func NewStorage(ctx context.Context, dsn string) (*storage, error) {
+// NewPool connects to the database, the pool is shared by the task, the template and the workflow storages
+func NewPool(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
 	var pool *pgxpool.Pool
 	var err error
 
@@ -46,10 +50,16 @@ func NewStorage(ctx context.Context, dsn string) (*storage, error) {
 		return nil, err
 	}
 
+	return pool, nil
+}
+
+// NewStorage returns the storage of the tasks, the task types are used to validate the type of new tasks, since the type column is a plain text
+func NewStorage(pool *pgxpool.Pool, taskTypes domain.TaskTypeRegistry) *storage {
 	return &storage{
-		queries: New(pool),
-		pool:    pool,
-	}, nil
+		queries:   New(pool),
+		pool:      pool,
+		taskTypes: taskTypes,
+	}
 }
 
 func (s *storage) GetTaskByID(ctx context.Context, ID int32) (*domain.Task, error) {
@@ -109,6 +119,112 @@ func (s *storage) GetMissedTasks(ctx context.Context, taskStatus string, passedS
 	return convertedTasks, nil
 }
 
+func (s *storage) GetFilteredMissedTasks(ctx context.Context, filter domain.MissedTasksFilter) ([]*domain.Task, error) {
+	params := GetFilteredMissedTasksParams{
+		Status:        TaskStatus(filter.Status),
+		PassedSeconds: filter.PassedSeconds,
+		MaxCount:      filter.Limit,
+	}
+	if filter.Type != nil {
+		params.Type = sql.NullString{String: *filter.Type, Valid: true}
+	}
+	if filter.Priority != nil {
+		params.Priority = sql.NullString{String: *filter.Priority, Valid: true}
+	}
+	if filter.MinID != nil {
+		params.MinID = sql.NullInt32{Int32: *filter.MinID, Valid: true}
+	}
+	if filter.MaxID != nil {
+		params.MaxID = sql.NullInt32{Int32: *filter.MaxID, Valid: true}
+	}
+
+	tasks, err := s.queries.GetFilteredMissedTasks(ctx, params)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errval.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	convertedTasks := convertTasks(tasks)
+	return convertedTasks, nil
+}
+
+func (s *storage) GetAgedQueuedTasks(ctx context.Context, taskPriority string, passedSeconds, limit int32) ([]*domain.Task, error) {
+	tasks, err := s.queries.GetAgedQueuedTasks(ctx, GetAgedQueuedTasksParams{
+		Priority: TaskPriority(taskPriority),
+		Column2:  passedSeconds,
+		Limit:    limit,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errval.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	convertedTasks := convertTasks(tasks)
+	return convertedTasks, nil
+}
+
+func (s *storage) GetRetryableFailedTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	tasks, err := s.queries.GetRetryableFailedTasks(ctx, GetRetryableFailedTasksParams{
+		Attempts: maxAttempts,
+		Column2:  passedSeconds,
+		Limit:    limit,
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errval.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	convertedTasks := convertTasks(tasks)
+	return convertedTasks, nil
+}
+
+// GetTasksByIDs returns the existing tasks among the given IDs, missing IDs are skipped without any error
+func (s *storage) GetTasksByIDs(ctx context.Context, IDs []int32) ([]*domain.Task, error) {
+	tasks, err := s.queries.GetTasksByIDs(ctx, IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	convertedTasks := convertTasks(tasks)
+	return convertedTasks, nil
+}
+
+// CountQueuedTasksByPriority returns the number of queued tasks of each priority, priorities without queued tasks are not in the result
+func (s *storage) CountQueuedTasksByPriority(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.queries.CountQueuedTasksByPriority(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[string(row.Priority)] = row.TasksCount
+	}
+
+	return counts, nil
+}
+
 func (s *storage) GetTasksByStatus(ctx context.Context, taskStatus string) ([]*domain.Task, error) {
 	tasks, err := s.queries.GetTasksByStatus(ctx, TaskStatus(taskStatus))
 	if err != nil {
@@ -145,21 +261,47 @@ func (s *storage) GetTaskStatusChangeHistory(ctx context.Context, taskID int32)
 	return convertedTasks, nil
 }
 
-func (s *storage) InsertTask(ctx context.Context, name string, taskType, taskStatus, taskPriority, payload string) (task *domain.Task, err error) {
-	jsonBytes := []byte(payload)
+func (s *storage) GetTaskPriorityChangeHistory(ctx context.Context, taskID int32) ([]*domain.TaskPriorityChangeHistory, error) {
+	taskPriorityChangeHistory, err := s.queries.GetTaskPriorityChangeHistory(ctx, taskID)
+	if err != nil {
+		if strings.Contains(err.Error(), "no rows") {
+			return nil, errval.ErrNotFound
+		}
 
-	var payloadJSON pgtype.JSON
-	if err := payloadJSON.Set(jsonBytes); err != nil {
 		return nil, err
 	}
 
-	taskID, err := s.queries.InsertTask(ctx, InsertTaskParams{
+	if len(taskPriorityChangeHistory) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	convertedItems := convertTaskPriorityChangeHistories(taskPriorityChangeHistory)
+	return convertedItems, nil
+}
+
+func (s *storage) InsertTask(ctx context.Context, name string, taskType, taskStatus, taskPriority string, payload json.RawMessage, caller string, callback *domain.TaskCallback) (task *domain.Task, err error) {
+	if !s.taskTypes.IsRegistered(taskType) {
+		return nil, errval.ErrInvalidTaskType
+	}
+
+	var payloadJSON pgtype.JSONB
+	if err := payloadJSON.Set([]byte(payload)); err != nil {
+		return nil, err
+	}
+
+	params := InsertTaskParams{
 		Name:     name,
-		Type:     TaskType(taskType),
+		Type:     taskType,
 		Status:   TaskStatus(taskStatus),
 		Priority: TaskPriority(taskPriority),
 		Payload:  payloadJSON,
-	})
+		Caller:   sql.NullString{String: caller, Valid: caller != ""},
+	}
+	if callback != nil {
+		params.CallbackUrl = sql.NullString{String: callback.URL, Valid: true}
+		params.CallbackEvents = callback.Events
+	}
+	taskID, err := s.queries.InsertTask(ctx, params)
 	if err != nil {
 		return nil, err
 	}
@@ -167,9 +309,12 @@ func (s *storage) InsertTask(ctx context.Context, name string, taskType, taskSta
 	nowStamp := time.Now().UTC().Unix()
 	task = &domain.Task{
 		ID:             taskID,
-		Type:           string(taskType),
+		Type:           taskType,
 		Status:         taskStatus,
+		Priority:       taskPriority,
 		PayLoad:        payload,
+		Caller:         caller,
+		Callback:       callback,
 		CreatedAtStamp: nowStamp,
 		UpdatedAtStamp: nowStamp,
 	}
@@ -177,6 +322,42 @@ func (s *storage) InsertTask(ctx context.Context, name string, taskType, taskSta
 	return task, err
 }
 
+// SetTaskResult stores the result of the task, errval.ErrNotFound is returned when the task doesn't exist
+func (s *storage) SetTaskResult(ctx context.Context, taskID int32, result json.RawMessage) (err error) {
+	var resultJSON pgtype.JSONB
+	if err := resultJSON.Set([]byte(result)); err != nil {
+		return err
+	}
+
+	affectedRows, err := s.queries.SetTaskResult(ctx, SetTaskResultParams{
+		ID:     taskID,
+		Result: resultJSON,
+	})
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 {
+		return errval.ErrNotFound
+	}
+
+	return nil
+}
+
+// TouchTask refreshes updated_at of the task if it still has the given status, isTouched is false otherwise
+func (s *storage) TouchTask(ctx context.Context, taskID int32, taskStatus string) (isTouched bool, err error) {
+	affectedRows, err := s.queries.TouchTask(ctx, TouchTaskParams{
+		ID:     taskID,
+		Status: TaskStatus(taskStatus),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return affectedRows > 0, nil
+}
+
+// UpdateTaskStatusAndLogChangeInTx changes the status of the task from currentStatus to newStatus and logs the change in the same transaction
+// errval.ErrStatusConflict is returned if the status of the task is not currentStatus anymore
 func (s *storage) UpdateTaskStatusAndLogChangeInTx(ctx context.Context, taskID int32, currentStatus, newStatus string) (err error) {
 	tx, err := s.pool.Begin(ctx)
 	if err != nil {
@@ -184,10 +365,15 @@ func (s *storage) UpdateTaskStatusAndLogChangeInTx(ctx context.Context, taskID i
 	}
 
 	qtx := s.queries.WithTx(tx)
-	err = qtx.UpdateTaskStatus(ctx, UpdateTaskStatusParams{
-		ID:     taskID,
-		Status: TaskStatus(newStatus),
+	affectedRows, err := qtx.UpdateTaskStatus(ctx, UpdateTaskStatusParams{
+		NewStatus:     TaskStatus(newStatus),
+		ID:            taskID,
+		CurrentStatus: TaskStatus(currentStatus),
 	})
+	if err == nil && affectedRows == 0 {
+		// The status is only changed if it's still the current status, so a worker and the recovery daemon can't overwrite each other
+		err = errval.ErrStatusConflict
+	}
 	if err != nil {
 		err2 := tx.Rollback(ctx)
 		if err2 != nil {
@@ -197,11 +383,47 @@ func (s *storage) UpdateTaskStatusAndLogChangeInTx(ctx context.Context, taskID i
 		return err
 	}
 
-	err = qtx.InsertTaskStatusChangeHistory(ctx, InsertTaskStatusChangeHistoryParams{
-		TaskID:    taskID,
-		OldStatus: TaskStatus(currentStatus),
-		NewStatus: TaskStatus(newStatus),
+	err = logTaskStatusChange(ctx, qtx, taskID, TaskStatus(currentStatus), TaskStatus(newStatus))
+	if err != nil {
+		err2 := tx.Rollback(ctx)
+		if err2 != nil {
+			slog.Error("Error occurred while rolling back transaction", "error", err2.Error())
+		}
+
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// FailTaskPermanentlyAndLogChangeInTx changes the status of the task from currentStatus to failed and logs the change in the same transaction
+// The attempts of the task are raised to maxAttempts, so the task is finished and the recovery doesn't retry it
+// errval.ErrStatusConflict is returned if the status of the task is not currentStatus anymore
+func (s *storage) FailTaskPermanentlyAndLogChangeInTx(ctx context.Context, taskID int32, currentStatus string, maxAttempts int32) (err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	qtx := s.queries.WithTx(tx)
+	affectedRows, err := qtx.FailTaskPermanently(ctx, FailTaskPermanentlyParams{
+		MaxAttempts:   maxAttempts,
+		ID:            taskID,
+		CurrentStatus: TaskStatus(currentStatus),
 	})
+	if err == nil && affectedRows == 0 {
+		err = errval.ErrStatusConflict
+	}
+	if err != nil {
+		err2 := tx.Rollback(ctx)
+		if err2 != nil {
+			slog.Error("Error occurred while rolling back transaction", "error", err2.Error())
+		}
+
+		return err
+	}
+
+	err = logTaskStatusChange(ctx, qtx, taskID, TaskStatus(currentStatus), TaskStatusFailed)
 	if err != nil {
 		err2 := tx.Rollback(ctx)
 		if err2 != nil {
@@ -214,21 +436,109 @@ func (s *storage) UpdateTaskStatusAndLogChangeInTx(ctx context.Context, taskID i
 	return tx.Commit(ctx)
 }
 
+// UpdateTaskPriorityAndLogChangeInTx changes the priority of a queued task and logs the change in the same transaction
+// isUpdated is false when the task is not queued anymore, or its priority has already been changed by someone else
+func (s *storage) UpdateTaskPriorityAndLogChangeInTx(ctx context.Context, taskID int32, currentPriority, newPriority string) (isUpdated bool, err error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	qtx := s.queries.WithTx(tx)
+	affectedRows, err := qtx.UpdateQueuedTaskPriority(ctx, UpdateQueuedTaskPriorityParams{
+		NewPriority:     TaskPriority(newPriority),
+		ID:              taskID,
+		CurrentPriority: TaskPriority(currentPriority),
+	})
+	if err != nil || affectedRows == 0 {
+		err2 := tx.Rollback(ctx)
+		if err2 != nil {
+			slog.Error("Error occurred while rolling back transaction", "error", err2.Error())
+		}
+
+		return false, err
+	}
+
+	err = qtx.InsertTaskPriorityChangeHistory(ctx, InsertTaskPriorityChangeHistoryParams{
+		TaskID:      taskID,
+		OldPriority: TaskPriority(currentPriority),
+		NewPriority: TaskPriority(newPriority),
+	})
+	if err != nil {
+		err2 := tx.Rollback(ctx)
+		if err2 != nil {
+			slog.Error("Error occurred while rolling back transaction", "error", err2.Error())
+		}
+
+		return false, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (s *storage) Ping(ctx context.Context) (err error) {
 	return s.pool.Ping(ctx)
 }
 
+// logTaskStatusChange inserts the status change into the history of the task, and into the webhook outbox when the task has a callback for it
+// It must be called in the transaction of the status change, so a change is never posted without being committed or committed without being posted
+func logTaskStatusChange(ctx context.Context, qtx *Queries, taskID int32, oldStatus, newStatus TaskStatus) error {
+	err := qtx.InsertTaskStatusChangeHistory(ctx, InsertTaskStatusChangeHistoryParams{
+		TaskID:    taskID,
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+	})
+	if err != nil {
+		return err
+	}
+
+	return qtx.InsertTaskWebhookDelivery(ctx, InsertTaskWebhookDeliveryParams{
+		OldStatus: oldStatus,
+		NewStatus: newStatus,
+		TaskID:    taskID,
+	})
+}
+
 func convertTask(task Task) *domain.Task {
 	castedItem := &domain.Task{
 		ID:             task.ID,
 		Type:           string(task.Type),
 		Status:         string(task.Status),
 		Priority:       string(task.Priority),
-		PayLoad:        string(task.Payload.Bytes),
+		PayLoad:        task.Payload.Bytes,
+		Attempts:       task.Attempts,
+		Caller:         task.Caller.String,
 		CreatedAtStamp: task.CreatedAt.Time.Unix(),
 		UpdatedAtStamp: task.CreatedAt.Time.Unix(),
 	}
 
+	// A null result is kept nil, so it's omitted from the task messages
+	if task.Result.Status == pgtype.Present {
+		castedItem.Result = task.Result.Bytes
+	}
+	if task.WorkflowID.Valid {
+		workflowID := task.WorkflowID.Int32
+		castedItem.WorkflowID = &workflowID
+		castedItem.WorkflowKey = task.WorkflowKey.String
+	}
+	if task.BatchID.Valid {
+		batchID := task.BatchID.Int32
+		castedItem.BatchID = &batchID
+	}
+	if task.ParentID.Valid {
+		parentID := task.ParentID.Int32
+		castedItem.ParentID = &parentID
+		castedItem.ChildKey = task.ChildKey.String
+	}
+	if task.CallbackUrl.Valid {
+		castedItem.Callback = &domain.TaskCallback{URL: task.CallbackUrl.String, Events: task.CallbackEvents}
+	}
+
 	return castedItem
 }
 
@@ -263,3 +573,25 @@ func convertTaskStatusChangeHistories(items []TasksStatusChangeHistory) []*domai
 
 	return castedItems
 }
+
+func convertTaskPriorityChangeHistory(item TasksPriorityChangeHistory) *domain.TaskPriorityChangeHistory {
+	castedItem := &domain.TaskPriorityChangeHistory{
+		ID:             item.ID,
+		TaskID:         item.TaskID,
+		OldPriority:    string(item.OldPriority),
+		NewPriority:    string(item.NewPriority),
+		CreatedAtStamp: item.CreatedAt.Time.Unix(),
+	}
+
+	return castedItem
+}
+
+func convertTaskPriorityChangeHistories(items []TasksPriorityChangeHistory) []*domain.TaskPriorityChangeHistory {
+	castedItems := []*domain.TaskPriorityChangeHistory{}
+	for _, item := range items {
+		castedItem := convertTaskPriorityChangeHistory(item)
+		castedItems = append(castedItems, castedItem)
+	}
+
+	return castedItems
+}
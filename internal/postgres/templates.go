@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+)
+
+// uniqueViolationCode is the error code of postgres when a row with the same primary key already exists
+const uniqueViolationCode = "23505"
+
+type templateStorage struct {
+	queries *Queries
+}
+
+// NewTemplateStorage returns the storage of the email templates
+func NewTemplateStorage(pool *pgxpool.Pool) *templateStorage {
+	return &templateStorage{
+		queries: New(pool),
+	}
+}
+
+func (s *templateStorage) CreateTemplate(ctx context.Context, template *domain.Template) (*domain.Template, error) {
+	createdTemplate, err := s.queries.CreateTemplate(ctx, CreateTemplateParams{
+		TemplateID: template.TemplateID,
+		Locale:     template.Locale,
+		Subject:    template.Subject,
+		TextBody:   template.Text,
+		HtmlBody:   template.HTML,
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, errval.ErrTemplateExists
+		}
+
+		return nil, err
+	}
+
+	return convertTemplate(createdTemplate), nil
+}
+
+func (s *templateStorage) AddTemplateVersion(ctx context.Context, template *domain.Template) (*domain.Template, error) {
+	insertedTemplate, err := s.queries.InsertTemplateVersion(ctx, InsertTemplateVersionParams{
+		TemplateID: template.TemplateID,
+		Locale:     template.Locale,
+		Subject:    template.Subject,
+		TextBody:   template.Text,
+		HtmlBody:   template.HTML,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errval.ErrNotFound
+		}
+		// Another version of the same locale has been inserted concurrently
+		if isUniqueViolation(err) {
+			return nil, errval.ErrTemplateExists
+		}
+
+		return nil, err
+	}
+
+	return convertTemplate(insertedTemplate), nil
+}
+
+func (s *templateStorage) GetTemplate(ctx context.Context, templateID, locale string) (*domain.Template, error) {
+	template, err := s.queries.GetLatestTemplate(ctx, GetLatestTemplateParams{
+		TemplateID: templateID,
+		Locale:     locale,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errval.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return convertTemplate(template), nil
+}
+
+func (s *templateStorage) GetTemplateVersion(ctx context.Context, templateID, locale string, version int32) (*domain.Template, error) {
+	template, err := s.queries.GetTemplateVersion(ctx, GetTemplateVersionParams{
+		TemplateID: templateID,
+		Locale:     locale,
+		Version:    version,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errval.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return convertTemplate(template), nil
+}
+
+func (s *templateStorage) GetTemplateVersions(ctx context.Context, templateID, locale string) ([]*domain.Template, error) {
+	templates, err := s.queries.GetTemplateVersions(ctx, GetTemplateVersionsParams{
+		TemplateID: templateID,
+		Locale:     locale,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(templates) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return convertTemplates(templates), nil
+}
+
+func (s *templateStorage) GetTemplates(ctx context.Context) ([]*domain.Template, error) {
+	templates, err := s.queries.GetLatestTemplates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertTemplates(templates), nil
+}
+
+func (s *templateStorage) GetTemplateLocales(ctx context.Context, templateID string) ([]*domain.Template, error) {
+	templates, err := s.queries.GetLatestTemplatesByTemplateID(ctx, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(templates) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return convertTemplates(templates), nil
+}
+
+func (s *templateStorage) DeleteTemplate(ctx context.Context, templateID string) (isDeleted bool, err error) {
+	deletedRows, err := s.queries.DeleteTemplate(ctx, templateID)
+	if err != nil {
+		return false, err
+	}
+
+	return deletedRows > 0, nil
+}
+
+func (s *templateStorage) DeleteTemplateLocale(ctx context.Context, templateID, locale string) (isDeleted bool, err error) {
+	deletedRows, err := s.queries.DeleteTemplateLocale(ctx, DeleteTemplateLocaleParams{
+		TemplateID: templateID,
+		Locale:     locale,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return deletedRows > 0, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
+
+func convertTemplate(template Template) *domain.Template {
+	return &domain.Template{
+		TemplateID:     template.TemplateID,
+		Locale:         template.Locale,
+		Version:        template.Version,
+		Subject:        template.Subject,
+		Text:           template.TextBody,
+		HTML:           template.HtmlBody,
+		CreatedAtStamp: template.CreatedAt.Time.Unix(),
+	}
+}
+
+func convertTemplates(templates []Template) []*domain.Template {
+	convertedTemplates := []*domain.Template{}
+	for _, item := range templates {
+		convertedTemplates = append(convertedTemplates, convertTemplate(item))
+	}
+
+	return convertedTemplates
+}
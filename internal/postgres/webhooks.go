@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+)
+
+type webhookStorage struct {
+	queries *Queries
+}
+
+// NewWebhookStorage returns the storage of the webhook deliveries, the deliveries are inserted by the status changes of the tasks
+func NewWebhookStorage(pool *pgxpool.Pool) *webhookStorage {
+	return &webhookStorage{
+		queries: New(pool),
+	}
+}
+
+func (s *webhookStorage) ClaimWebhookDeliveries(ctx context.Context, leaseSeconds, limit int32) ([]*domain.WebhookDelivery, error) {
+	deliveries, err := s.queries.ClaimWebhookDeliveries(ctx, ClaimWebhookDeliveriesParams{
+		LeaseSeconds: leaseSeconds,
+		MaxCount:     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deliveries) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return convertWebhookDeliveries(deliveries), nil
+}
+
+// MarkWebhookDeliveryDelivered only updates the delivery when it's still the same attempt, so a redelivery which has been started meanwhile isn't overwritten
+func (s *webhookStorage) MarkWebhookDeliveryDelivered(ctx context.Context, delivery *domain.WebhookDelivery, responseStatus int) (bool, error) {
+	affectedRows, err := s.queries.MarkWebhookDeliveryDelivered(ctx, MarkWebhookDeliveryDeliveredParams{
+		ResponseStatus: sql.NullInt32{Int32: int32(responseStatus), Valid: true},
+		ID:             delivery.ID,
+		Attempts:       delivery.Attempts,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return affectedRows > 0, nil
+}
+
+func (s *webhookStorage) MarkWebhookDeliveryAttemptFailed(ctx context.Context, delivery *domain.WebhookDelivery, isFinal bool, retryAfterSeconds int32, responseStatus int, errMessage string) (bool, error) {
+	newStatus := domain.WebhookPending
+	if isFinal {
+		newStatus = domain.WebhookFailed
+	}
+
+	affectedRows, err := s.queries.MarkWebhookDeliveryAttemptFailed(ctx, MarkWebhookDeliveryAttemptFailedParams{
+		NewStatus:         string(newStatus),
+		RetryAfterSeconds: retryAfterSeconds,
+		ResponseStatus:    sql.NullInt32{Int32: int32(responseStatus), Valid: responseStatus != 0},
+		LastError:         sql.NullString{String: errMessage, Valid: errMessage != ""},
+		ID:                delivery.ID,
+		Attempts:          delivery.Attempts,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return affectedRows > 0, nil
+}
+
+func (s *webhookStorage) GetWebhookDeliveryByID(ctx context.Context, deliveryID int32) (*domain.WebhookDelivery, error) {
+	delivery, err := s.queries.GetWebhookDeliveryByID(ctx, deliveryID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errval.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return convertWebhookDelivery(delivery), nil
+}
+
+func (s *webhookStorage) GetFilteredWebhookDeliveries(ctx context.Context, filter domain.WebhookDeliveriesFilter) ([]*domain.WebhookDelivery, error) {
+	params := GetFilteredWebhookDeliveriesParams{MaxCount: filter.Limit}
+	if filter.Status != nil {
+		params.Status = sql.NullString{String: string(*filter.Status), Valid: true}
+	}
+	if filter.TaskID != nil {
+		params.TaskID = sql.NullInt32{Int32: *filter.TaskID, Valid: true}
+	}
+
+	deliveries, err := s.queries.GetFilteredWebhookDeliveries(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(deliveries) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return convertWebhookDeliveries(deliveries), nil
+}
+
+func (s *webhookStorage) RedeliverWebhookDelivery(ctx context.Context, deliveryID int32) (*domain.WebhookDelivery, error) {
+	delivery, err := s.queries.RedeliverWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+
+		// Nothing is updated when the delivery doesn't exist or it's not failed
+		_, err = s.GetWebhookDeliveryByID(ctx, deliveryID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, errval.ErrNotRedeliverable
+	}
+
+	return convertWebhookDelivery(delivery), nil
+}
+
+func convertWebhookDelivery(delivery WebhookDelivery) *domain.WebhookDelivery {
+	convertedDelivery := &domain.WebhookDelivery{
+		ID:                 delivery.ID,
+		TaskID:             delivery.TaskID,
+		URL:                delivery.Url,
+		OldStatus:          string(delivery.OldStatus),
+		NewStatus:          string(delivery.NewStatus),
+		Status:             domain.WebhookDeliveryStatus(delivery.Status),
+		Attempts:           delivery.Attempts,
+		NextAttemptAtStamp: delivery.NextAttemptAt.Unix(),
+		LastError:          delivery.LastError.String,
+		CreatedAtStamp:     delivery.CreatedAt.Time.Unix(),
+	}
+	if delivery.LastAttemptAt.Valid {
+		lastAttemptAtStamp := delivery.LastAttemptAt.Time.Unix()
+		convertedDelivery.LastAttemptAtStamp = &lastAttemptAtStamp
+	}
+	if delivery.LastResponseStatus.Valid {
+		lastResponseStatus := delivery.LastResponseStatus.Int32
+		convertedDelivery.LastResponseStatus = &lastResponseStatus
+	}
+	if delivery.DeliveredAt.Valid {
+		deliveredAtStamp := delivery.DeliveredAt.Time.Unix()
+		convertedDelivery.DeliveredAtStamp = &deliveredAtStamp
+	}
+
+	return convertedDelivery
+}
+
+func convertWebhookDeliveries(deliveries []WebhookDelivery) []*domain.WebhookDelivery {
+	convertedDeliveries := make([]*domain.WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		convertedDeliveries = append(convertedDeliveries, convertWebhookDelivery(delivery))
+	}
+
+	return convertedDeliveries
+}
@@ -0,0 +1,321 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"log/slog"
+)
+
+type workflowStorage struct {
+	queries   *Queries
+	pool      *pgxpool.Pool
+	taskTypes domain.TaskTypeRegistry
+}
+
+// NewWorkflowStorage returns the storage of the workflows, the task types are used to validate the types of their tasks like the task storage
+func NewWorkflowStorage(pool *pgxpool.Pool, taskTypes domain.TaskTypeRegistry) *workflowStorage {
+	return &workflowStorage{
+		queries:   New(pool),
+		pool:      pool,
+		taskTypes: taskTypes,
+	}
+}
+
+func (s *workflowStorage) InsertWorkflow(ctx context.Context, workflow *domain.Workflow, tasks []*domain.WorkflowTask) (*domain.Workflow, []*domain.Task, error) {
+	for _, task := range tasks {
+		if !s.taskTypes.IsRegistered(task.Type) {
+			return nil, nil, errval.ErrInvalidTaskType
+		}
+	}
+
+	var insertedWorkflow *domain.Workflow
+	var insertedTasks []*domain.Task
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		workflowRow, err := qtx.InsertWorkflow(ctx, InsertWorkflowParams{
+			Name:          workflow.Name,
+			FailurePolicy: string(workflow.FailurePolicy),
+			Caller:        sql.NullString{String: workflow.Caller, Valid: workflow.Caller != ""},
+		})
+		if err != nil {
+			return err
+		}
+		insertedWorkflow = convertWorkflow(workflowRow)
+
+		taskIDs := make(map[string]int32, len(tasks))
+		insertedTasks = make([]*domain.Task, 0, len(tasks))
+		for _, task := range tasks {
+			taskStatus := domain.Queued
+			if len(task.DependsOn) > 0 {
+				taskStatus = domain.Pending
+			}
+
+			var payloadJSON pgtype.JSONB
+			err = payloadJSON.Set([]byte(task.Payload))
+			if err != nil {
+				return err
+			}
+
+			taskID, err := qtx.InsertWorkflowTask(ctx, InsertWorkflowTaskParams{
+				Name:        task.Name,
+				Type:        task.Type,
+				Status:      TaskStatus(taskStatus),
+				Priority:    TaskPriority(task.Priority),
+				Payload:     payloadJSON,
+				Caller:      sql.NullString{String: workflow.Caller, Valid: workflow.Caller != ""},
+				WorkflowID:  sql.NullInt32{Int32: workflowRow.ID, Valid: true},
+				WorkflowKey: sql.NullString{String: task.Key, Valid: true},
+			})
+			if err != nil {
+				return err
+			}
+			taskIDs[task.Key] = taskID
+
+			workflowID := workflowRow.ID
+			insertedTasks = append(insertedTasks, &domain.Task{
+				ID:             taskID,
+				Type:           task.Type,
+				Status:         string(taskStatus),
+				Priority:       task.Priority,
+				PayLoad:        task.Payload,
+				Caller:         workflow.Caller,
+				WorkflowID:     &workflowID,
+				WorkflowKey:    task.Key,
+				CreatedAtStamp: insertedWorkflow.CreatedAtStamp,
+				UpdatedAtStamp: insertedWorkflow.CreatedAtStamp,
+			})
+		}
+
+		// The dependencies are inserted after all the tasks, so they don't depend on the order of the tasks
+		for _, task := range tasks {
+			for _, parentKey := range task.DependsOn {
+				parentID, ok := taskIDs[parentKey]
+				if !ok {
+					return fmt.Errorf("%w: task %q depends on the unknown task %q", errval.ErrInvalidWorkflow, task.Key, parentKey)
+				}
+
+				err = qtx.InsertTaskDependency(ctx, InsertTaskDependencyParams{
+					TaskID:          taskIDs[task.Key],
+					DependsOnTaskID: parentID,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return insertedWorkflow, insertedTasks, nil
+}
+
+func (s *workflowStorage) GetWorkflowByID(ctx context.Context, workflowID int32) (*domain.Workflow, error) {
+	workflow, err := s.queries.GetWorkflowByID(ctx, workflowID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errval.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return convertWorkflow(workflow), nil
+}
+
+func (s *workflowStorage) GetWorkflowTasks(ctx context.Context, workflowID int32) ([]*domain.Task, error) {
+	tasks, err := s.queries.GetWorkflowTasks(ctx, sql.NullInt32{Int32: workflowID, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return convertTasks(tasks), nil
+}
+
+func (s *workflowStorage) GetWorkflowDependencies(ctx context.Context, workflowID int32) ([]*domain.TaskDependency, error) {
+	dependencies, err := s.queries.GetWorkflowDependencies(ctx, sql.NullInt32{Int32: workflowID, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+
+	convertedDependencies := make([]*domain.TaskDependency, 0, len(dependencies))
+	for _, dependency := range dependencies {
+		convertedDependencies = append(convertedDependencies, &domain.TaskDependency{
+			TaskID:          dependency.TaskID,
+			DependsOnTaskID: dependency.DependsOnTaskID,
+		})
+	}
+
+	return convertedDependencies, nil
+}
+
+func (s *workflowStorage) GetWorkflowStatusChangeHistory(ctx context.Context, workflowID int32) ([]*domain.TaskStatusChangeHistory, error) {
+	history, err := s.queries.GetWorkflowStatusChangeHistory(ctx, sql.NullInt32{Int32: workflowID, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return convertTaskStatusChangeHistories(history), nil
+}
+
+func (s *workflowStorage) GetParentTasks(ctx context.Context, taskID int32) ([]*domain.Task, error) {
+	tasks, err := s.queries.GetParentTasks(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertTasks(tasks), nil
+}
+
+// QueueReadyDependentTasks locks the pending dependents before checking their parents
+// So when two parents of a task finish at the same time, the second one waits for the first one, and sees its status after it's committed
+func (s *workflowStorage) QueueReadyDependentTasks(ctx context.Context, taskID int32, failedIsFinished bool, maxAttempts int32) ([]*domain.Task, error) {
+	var queuedTasks []*domain.Task
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		dependents, err := qtx.LockPendingDependentTasks(ctx, taskID)
+		if err != nil {
+			return err
+		}
+
+		readyDependents := make([]Task, 0, len(dependents))
+		for _, dependent := range dependents {
+			unfinishedParentsCount, err := qtx.CountUnfinishedParentTasks(ctx, CountUnfinishedParentTasksParams{
+				TaskID:           dependent.ID,
+				FailedIsFinished: failedIsFinished,
+				MaxAttempts:      maxAttempts,
+			})
+			if err != nil {
+				return err
+			}
+			if unfinishedParentsCount == 0 {
+				readyDependents = append(readyDependents, dependent)
+			}
+		}
+
+		queuedTasks, err = transitLockedTasks(ctx, qtx, readyDependents, domain.Queued)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return queuedTasks, nil
+}
+
+func (s *workflowStorage) SkipDependentTasks(ctx context.Context, taskID int32) ([]*domain.Task, error) {
+	var skippedTasks []*domain.Task
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		descendants, err := qtx.LockPendingDescendantTasks(ctx, taskID)
+		if err != nil {
+			return err
+		}
+
+		skippedTasks, err = transitLockedTasks(ctx, qtx, descendants, domain.Skipped)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return skippedTasks, nil
+}
+
+func (s *workflowStorage) CancelWorkflowTasks(ctx context.Context, workflowID int32) ([]*domain.Task, error) {
+	var cancelledTasks []*domain.Task
+	err := inTx(ctx, s.pool, s.queries, func(qtx *Queries) error {
+		tasks, err := qtx.LockCancellableWorkflowTasks(ctx, sql.NullInt32{Int32: workflowID, Valid: true})
+		if err != nil {
+			return err
+		}
+
+		cancelledTasks, err = transitLockedTasks(ctx, qtx, tasks, domain.Cancelled)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cancelledTasks, nil
+}
+
+func (s *workflowStorage) GetStalledWorkflowParentTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	tasks, err := s.queries.GetStalledWorkflowParentTasks(ctx, GetStalledWorkflowParentTasksParams{
+		MaxAttempts:   maxAttempts,
+		PassedSeconds: passedSeconds,
+		MaxCount:      limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return convertTasks(tasks), nil
+}
+
+// inTx runs fn in a transaction, which is rolled back when fn returns an error
+func inTx(ctx context.Context, pool *pgxpool.Pool, queries *Queries, fn func(qtx *Queries) error) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(queries.WithTx(tx))
+	if err != nil {
+		err2 := tx.Rollback(ctx)
+		if err2 != nil {
+			slog.Error("Error occurred while rolling back transaction", "error", err2.Error())
+		}
+
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// transitLockedTasks changes the status of the tasks which are locked by the transaction, and logs the changes
+func transitLockedTasks(ctx context.Context, qtx *Queries, tasks []Task, newStatus domain.TaskStatus) ([]*domain.Task, error) {
+	transitedTasks := make([]*domain.Task, 0, len(tasks))
+	for _, task := range tasks {
+		_, err := qtx.UpdateTaskStatus(ctx, UpdateTaskStatusParams{
+			NewStatus:     TaskStatus(newStatus),
+			ID:            task.ID,
+			CurrentStatus: task.Status,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = logTaskStatusChange(ctx, qtx, task.ID, task.Status, TaskStatus(newStatus))
+		if err != nil {
+			return nil, err
+		}
+
+		transitedTask := convertTask(task)
+		transitedTask.Status = string(newStatus)
+		transitedTasks = append(transitedTasks, transitedTask)
+	}
+
+	return transitedTasks, nil
+}
+
+func convertWorkflow(workflow Workflow) *domain.Workflow {
+	return &domain.Workflow{
+		ID:             workflow.ID,
+		Name:           workflow.Name,
+		FailurePolicy:  domain.WorkflowFailurePolicy(workflow.FailurePolicy),
+		Caller:         workflow.Caller.String,
+		CreatedAtStamp: workflow.CreatedAt.Time.Unix(),
+	}
+}
@@ -2,14 +2,22 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sf7293/task-manager/internal/domain"
 	"log/slog"
+	"sync"
 )
 
 type RabbitMQClient struct {
 	ctx     context.Context
 	conn    *amqp.Connection
 	channel *amqp.Channel
+	// eventsChannel is in the confirm mode, it's opened by the first PublishEvents call and guarded by eventsMu
+	eventsMu          sync.Mutex
+	eventsChannel     *amqp.Channel
+	declaredExchanges map[string]bool
 }
 
 var queueDeclarationHistory = map[string]bool{}
@@ -44,7 +52,8 @@ func NewRabbitMQClient(ctx context.Context, amqpURL string, mainQueueNames []str
 	return client, nil
 }
 
-func (c *RabbitMQClient) PublishMessage(queueName, body string) (err error) {
+// PublishMessage publishes the message to the queue, the fields of the message are set as the AMQP properties and headers
+func (c *RabbitMQClient) PublishMessage(queueName string, message domain.QueueMessage) (err error) {
 	err = c.checkQueueDeclaration(queueName)
 	if err != nil {
 		return err
@@ -57,11 +66,107 @@ func (c *RabbitMQClient) PublishMessage(queueName, body string) (err error) {
 		false,     // mandatory
 		false,     // immediate
 		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(body),
+			ContentType: message.ContentType,
+			MessageId:   message.MessageID,
+			Type:        message.Type,
+			Timestamp:   message.Timestamp,
+			Headers:     amqp.Table(message.Headers),
+			Body:        message.Body,
 		})
 }
 
+// PublishEvents publishes the persistent messages to the durable topic exchange, and waits for the broker to confirm all of them
+// The messages are published by their own channel in the confirm mode, so the confirmations don't slow down the jobs which are published by PublishMessage
+// The confirmations are waited for until ctx is done, the context of the client is not used since it may be the short-lived initialization context
+func (c *RabbitMQClient) PublishEvents(ctx context.Context, exchange string, messages []domain.EventMessage) (err error) {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+
+	ch, err := c.getEventsChannel(exchange)
+	if err != nil {
+		return err
+	}
+
+	confirmations := make([]*amqp.DeferredConfirmation, 0, len(messages))
+	for _, message := range messages {
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(
+			ctx,
+			exchange,           // exchange
+			message.RoutingKey, // routing key
+			false,              // mandatory
+			false,              // immediate
+			amqp.Publishing{
+				ContentType:  "application/json",
+				DeliveryMode: amqp.Persistent,
+				MessageId:    message.MessageID,
+				Body:         message.Body,
+			})
+		if err != nil {
+			c.closeEventsChannel()
+			return err
+		}
+		confirmations = append(confirmations, confirmation)
+	}
+
+	for i, confirmation := range confirmations {
+		isAcked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			c.closeEventsChannel()
+			return err
+		}
+		if !isAcked {
+			return fmt.Errorf("message %s is not acknowledged by the broker", messages[i].MessageID)
+		}
+	}
+
+	return nil
+}
+
+// getEventsChannel must be called while holding eventsMu, the channel is opened again after it has been closed by an error
+func (c *RabbitMQClient) getEventsChannel(exchange string) (*amqp.Channel, error) {
+	if c.eventsChannel == nil || c.eventsChannel.IsClosed() {
+		ch, err := c.conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+
+		err = ch.Confirm(false)
+		if err != nil {
+			_ = ch.Close()
+			return nil, err
+		}
+		c.eventsChannel = ch
+		c.declaredExchanges = map[string]bool{}
+	}
+
+	if !c.declaredExchanges[exchange] {
+		err := c.eventsChannel.ExchangeDeclare(
+			exchange, // name
+			"topic",  // type
+			true,     // durable
+			false,    // auto-deleted
+			false,    // internal
+			false,    // no-wait
+			nil,      // arguments
+		)
+		if err != nil {
+			c.closeEventsChannel()
+			return nil, err
+		}
+		c.declaredExchanges[exchange] = true
+	}
+
+	return c.eventsChannel, nil
+}
+
+func (c *RabbitMQClient) closeEventsChannel() {
+	err := c.eventsChannel.Close()
+	if err != nil && !errors.Is(err, amqp.ErrClosed) {
+		slog.Error("Error occurred while closing rabbit channel of the events", "error", err.Error())
+	}
+	c.eventsChannel = nil
+}
+
 func (c *RabbitMQClient) ConsumeMessages(consumerName, queueName string, handler func(string)) error {
 	msgs, err := c.channel.ConsumeWithContext(
 		c.ctx,
@@ -87,7 +192,90 @@ func (c *RabbitMQClient) ConsumeMessages(consumerName, queueName string, handler
 	return nil
 }
 
+// QueueDepth returns the number of ready messages of the queue using a passive declaration, which doesn't create the queue if it's missing
+func (c *RabbitMQClient) QueueDepth(queueName string) (depth int, err error) {
+	// A failed passive declaration closes its channel, so a temporary channel is used to keep the main channel open
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err2 := ch.Close()
+		if err2 != nil && !errors.Is(err2, amqp.ErrClosed) {
+			slog.Error("Error occurred while closing rabbit channel created for queue inspection", "error", err2.Error())
+		}
+	}()
+
+	queue, err := ch.QueueDeclarePassive(
+		queueName, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		nil,       // arguments
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return queue.Messages, nil
+}
+
+// InspectMessages gets up to maxCount messages of the queue without auto-ack and passes their body to the inspector
+// Messages for which the inspector returns true are removed from the queue, the others are put back in the queue
+func (c *RabbitMQClient) InspectMessages(queueName string, maxCount int, inspector func(body string) (remove bool)) (inspectedCount int, err error) {
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	// Closing the channel also puts back the messages which are not acknowledged yet, in case of an early return
+	defer func() {
+		err2 := ch.Close()
+		if err2 != nil && !errors.Is(err2, amqp.ErrClosed) {
+			slog.Error("Error occurred while closing rabbit channel created for queue inspection", "error", err2.Error())
+		}
+	}()
+
+	var lastKeptTag uint64
+	for inspectedCount < maxCount {
+		delivery, ok, err := ch.Get(queueName, false)
+		if err != nil {
+			return inspectedCount, err
+		}
+		if !ok {
+			// The queue is empty
+			break
+		}
+		inspectedCount++
+
+		if inspector(string(delivery.Body)) {
+			err = delivery.Ack(false)
+			if err != nil {
+				return inspectedCount, err
+			}
+			continue
+		}
+		lastKeptTag = delivery.DeliveryTag
+	}
+
+	if lastKeptTag > 0 {
+		// Rejects all the kept messages at once and puts them back in the queue
+		err = ch.Nack(lastKeptTag, true, true)
+		if err != nil {
+			return inspectedCount, err
+		}
+	}
+
+	return inspectedCount, nil
+}
+
 func (c *RabbitMQClient) Close() error {
+	c.eventsMu.Lock()
+	if c.eventsChannel != nil {
+		c.closeEventsChannel()
+	}
+	c.eventsMu.Unlock()
+
 	err := c.channel.Close()
 	if err != nil {
 		return err
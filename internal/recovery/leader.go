@@ -0,0 +1,58 @@
+package recovery
+
+import (
+	"github.com/sf7293/task-manager/internal/domain"
+	"log/slog"
+	"time"
+)
+
+// LeaderElector elects one replica of the reconciler to act by holding a lease in the distributed lock infra
+// The leader renews its lease on every iteration, so the lease must be longer than the interval of the reconciler
+type LeaderElector struct {
+	lock          domain.DistributedLock
+	leaseKey      string
+	owner         string
+	leaseDuration time.Duration
+	isLeader      bool
+}
+
+func NewLeaderElector(lock domain.DistributedLock, leaseKey, owner string, leaseDuration time.Duration) *LeaderElector {
+	return &LeaderElector{
+		lock:          lock,
+		leaseKey:      leaseKey,
+		owner:         owner,
+		leaseDuration: leaseDuration,
+	}
+}
+
+// TryLead acquires or renews the leadership lease, and reports whether this replica is the leader
+func (e *LeaderElector) TryLead() bool {
+	isAcquired, err := e.lock.AcquireLease(e.leaseKey, e.owner, e.leaseDuration)
+	if err != nil {
+		// The leadership can't be proven without the lock infra, so the replica steps down to avoid having two leaders
+		slog.Error("Error occurred while acquiring the leadership lease", "lease_key", e.leaseKey, "owner", e.owner, "error", err.Error())
+		isAcquired = false
+	}
+
+	if isAcquired != e.isLeader {
+		slog.Info("Leadership of the reconciler is changed", "lease_key", e.leaseKey, "owner", e.owner, "is_leader", isAcquired)
+	}
+	e.isLeader = isAcquired
+
+	return e.isLeader
+}
+
+// Resign releases the lease if this replica is the leader, so another replica could take over without waiting for the lease to expire
+func (e *LeaderElector) Resign() {
+	if !e.isLeader {
+		return
+	}
+
+	err := e.lock.ReleaseLease(e.leaseKey, e.owner)
+	if err != nil {
+		slog.Error("Error occurred while releasing the leadership lease", "lease_key", e.leaseKey, "owner", e.owner, "error", err.Error())
+		return
+	}
+	e.isLeader = false
+	slog.Info("Leadership lease is released", "lease_key", e.leaseKey, "owner", e.owner)
+}
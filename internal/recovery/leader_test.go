@@ -0,0 +1,69 @@
+package recovery
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestLeaderElector_TryLead: the lease is taken while it's free and renewed while it's held, and the leadership is lost when another replica takes it
+func TestLeaderElector_TryLead(t *testing.T) {
+	lock := newFakeLock()
+	elector := NewLeaderElector(lock, testLeaseKey, testOwner, time.Minute)
+	follower := NewLeaderElector(lock, testLeaseKey, "replica-2", time.Minute)
+
+	if !elector.TryLead() || !elector.TryLead() {
+		t.Fatalf("expected the replica to take and renew the free lease")
+	}
+	if lock.acquiredCount != 2 {
+		t.Fatalf("expected the lease to be acquired and renewed, got %d acquisitions", lock.acquiredCount)
+	}
+	if follower.TryLead() {
+		t.Fatalf("expected the follower not to lead while the lease is held")
+	}
+
+	// The lease has expired and another replica has taken it
+	lock.holders[testLeaseKey] = "replica-2"
+	if elector.TryLead() {
+		t.Fatalf("expected the replica to lose the leadership")
+	}
+	if !follower.TryLead() {
+		t.Fatalf("expected the new holder to lead")
+	}
+}
+
+// TestLeaderElector_TryLead_Error: the leader steps down when the lock infra is not reachable
+func TestLeaderElector_TryLead_Error(t *testing.T) {
+	lock := newFakeLock()
+	elector := NewLeaderElector(lock, testLeaseKey, testOwner, time.Minute)
+	if !elector.TryLead() {
+		t.Fatalf("expected the replica to lead")
+	}
+
+	lock.err = errors.New("connection refused")
+	if elector.TryLead() {
+		t.Fatalf("expected the replica to step down")
+	}
+}
+
+// TestLeaderElector_Resign: only the leader releases the lease
+func TestLeaderElector_Resign(t *testing.T) {
+	lock := newFakeLock()
+	follower := NewLeaderElector(lock, testLeaseKey, "replica-2", time.Minute)
+	elector := NewLeaderElector(lock, testLeaseKey, testOwner, time.Minute)
+	elector.TryLead()
+	follower.TryLead()
+
+	follower.Resign()
+	if lock.holder(testLeaseKey) != testOwner {
+		t.Fatalf("expected the lease to stay with the leader, got %q", lock.holder(testLeaseKey))
+	}
+
+	elector.Resign()
+	if lock.holder(testLeaseKey) != "" {
+		t.Fatalf("expected the lease to be released, got %q", lock.holder(testLeaseKey))
+	}
+	if !follower.TryLead() {
+		t.Fatalf("expected the follower to take over the released lease")
+	}
+}
@@ -0,0 +1,42 @@
+package recovery
+
+import (
+	"context"
+	"time"
+)
+
+// rateLimiter spreads re-queues over time, so a big backlog of stuck tasks doesn't flood the queues and the workers at once
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+// newRateLimiter returns a limiter which allows maxPerSecond events per second, a non-positive maxPerSecond means no limit
+func newRateLimiter(maxPerSecond int32) *rateLimiter {
+	if maxPerSecond <= 0 {
+		return &rateLimiter{}
+	}
+
+	return &rateLimiter{
+		ticker: time.NewTicker(time.Second / time.Duration(maxPerSecond)),
+	}
+}
+
+// wait blocks until the next event is allowed, it returns false if the context is done meanwhile
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	if l.ticker == nil {
+		return ctx.Err() == nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-l.ticker.C:
+		return true
+	}
+}
+
+func (l *rateLimiter) stop() {
+	if l.ticker != nil {
+		l.ticker.Stop()
+	}
+}
@@ -0,0 +1,68 @@
+package recovery
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/domain"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter: the events are spread by the limit, and a non-positive limit doesn't wait
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(50)
+	defer limiter.stop()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if !limiter.wait(context.Background()) {
+			t.Fatalf("expected the event %d to be allowed", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected 5 events at 50 per second to take at least 100ms, took %s", elapsed)
+	}
+
+	unlimited := newRateLimiter(0)
+	defer unlimited.stop()
+	start = time.Now()
+	for i := 0; i < 1000; i++ {
+		unlimited.wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("expected no limit, took %s", elapsed)
+	}
+}
+
+// TestRateLimiter_Cancelled: wait returns false once the context is done
+func TestRateLimiter_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, maxPerSecond := range []int32{0, 1} {
+		limiter := newRateLimiter(maxPerSecond)
+		if limiter.wait(ctx) {
+			t.Errorf("expected the limiter of %d per second to stop waiting", maxPerSecond)
+		}
+		limiter.stop()
+	}
+}
+
+// TestReconcile_RateLimit: the re-queues of an iteration are spread by MaxRequeuesPerSecond
+func TestReconcile_RateLimit(t *testing.T) {
+	storage := newFakeStorage()
+	for id := int32(1); id <= 4; id++ {
+		storage.add(id, domain.Queued, 0, time.Hour)
+	}
+	settings := testSettings
+	settings.MaxRequeuesPerSecond = 20
+	reconciler, queue := newTestReconciler(storage, newFakeLock(), settings)
+
+	// The context is done before all the tasks could be re-queued at 20 per second
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	reconciler.Reconcile(ctx)
+
+	if published := queue.publishedIDs(); len(published) == 0 || len(published) > 3 {
+		t.Fatalf("expected the re-queues to be limited to 20 per second, got %v", published)
+	}
+}
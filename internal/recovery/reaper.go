@@ -0,0 +1,52 @@
+package recovery
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/domain"
+	"log/slog"
+)
+
+// reapExpiredRunningTasks takes back the running tasks whose heartbeats have stopped for longer than the running lease, their worker has probably died
+// A task which still has attempts left is moved back to queued and re-published, otherwise it's moved to failed
+func (r *Reconciler) reapExpiredRunningTasks(ctx context.Context, limiter *rateLimiter) {
+	tasks := r.fetch(ctx, string(domain.Running), func() ([]*domain.Task, error) {
+		return r.storage.GetMissedTasks(ctx, string(domain.Running), r.settings.RunningLeaseSeconds, r.settings.BatchSize)
+	})
+
+	for _, task := range tasks {
+		if !limiter.wait(ctx) {
+			return
+		}
+
+		if task.Attempts >= r.settings.MaxAttempts {
+			slog.Warn("Heartbeat of the running task is expired and it has no attempts left", "task_id", task.ID, "attempts", task.Attempts, "max_attempts", r.settings.MaxAttempts)
+			if r.transit(ctx, task, domain.Running, domain.Failed) {
+				r.stats.reapedFailed.Add(1)
+				err := r.workflows.TaskFinished(ctx, task)
+				if err != nil {
+					// The workflow is moved forward by advanceStalledWorkflows in the next iterations
+					slog.Error("Error occurred while moving the workflow of the reaped task forward", "task_id", task.ID, "error", err.Error())
+					r.stats.errors.Add(1)
+				}
+				err = r.batches.TaskFinished(ctx, task)
+				if err != nil {
+					// The task is counted by countStalledBatchTasks in the next iterations
+					slog.Error("Error occurred while counting the reaped task in its batch", "task_id", task.ID, "error", err.Error())
+					r.stats.errors.Add(1)
+				}
+				err = r.subtasks.TaskFinished(ctx, task)
+				if err != nil {
+					// The parent is finished by finishStalledWaitingTasks in the next iterations
+					slog.Error("Error occurred while finishing the parent of the reaped task", "task_id", task.ID, "error", err.Error())
+					r.stats.errors.Add(1)
+				}
+			}
+			continue
+		}
+
+		slog.Warn("Heartbeat of the running task is expired, re-queuing it", "task_id", task.ID, "attempts", task.Attempts, "max_attempts", r.settings.MaxAttempts)
+		if r.transit(ctx, task, domain.Running, domain.Queued) {
+			r.dispatch(ctx, task, &r.stats.requeuedRunning)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package recovery
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/heartbeat"
+	"testing"
+	"time"
+)
+
+// TestReapExpiredRunningTasks: the running tasks whose lease has expired are re-queued while they have attempts left, and failed once they are exhausted
+func TestReapExpiredRunningTasks(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Running, 1, time.Hour)
+	storage.add(2, domain.Running, 3, time.Hour)
+	storage.add(3, domain.Running, 1, time.Second)
+	reconciler, queue := newTestReconciler(storage, newFakeLock(), testSettings)
+
+	reconciler.Reconcile(context.Background())
+
+	if storage.task(1).Status != string(domain.Queued) || storage.task(2).Status != string(domain.Failed) || storage.task(3).Status != string(domain.Running) {
+		t.Fatalf("unexpected statuses: %s, %s and %s", storage.task(1).Status, storage.task(2).Status, storage.task(3).Status)
+	}
+	if published := queue.publishedIDs(); len(published) != 1 || published[0] != 1 {
+		t.Fatalf("expected task 1 to be re-queued, got %v", published)
+	}
+	if reconciler.stats.requeuedRunning.Load() != 1 || reconciler.stats.reapedFailed.Load() != 1 {
+		t.Fatalf("unexpected stats: requeued_running=%d reaped_failed=%d", reconciler.stats.requeuedRunning.Load(), reconciler.stats.reapedFailed.Load())
+	}
+	// The reaped failed task is not retried, it has no attempts left
+	if reconciler.stats.retriedFailed.Load() != 0 {
+		t.Fatalf("expected the exhausted task not to be retried, got %d", reconciler.stats.retriedFailed.Load())
+	}
+}
+
+// TestReapExpiredRunningTasks_Heartbeat: a running task whose worker keeps beating is not reaped, even if it has been running longer than the lease
+func TestReapExpiredRunningTasks_Heartbeat(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Running, 1, time.Hour)
+	storage.add(2, domain.Running, 1, time.Hour)
+	lock := newFakeLock()
+	lock.holders["lock:1"] = "my-consumer:1"
+	settings := testSettings
+	settings.RunningLeaseSeconds = 1
+	reconciler, queue := newTestReconciler(storage, lock, settings)
+
+	taskHeartbeat := heartbeat.NewHeartbeat(storage, lock, 1, "lock:1", "my-consumer:1", 10*time.Millisecond, time.Second, func() {
+		t.Errorf("expected the heartbeating task not to be lost")
+	})
+	taskHeartbeat.Start(context.Background())
+	time.Sleep(50 * time.Millisecond)
+	reconciler.Reconcile(context.Background())
+	taskHeartbeat.Stop()
+
+	if storage.task(1).Status != string(domain.Running) {
+		t.Fatalf("expected the heartbeating task to keep running, got %s", storage.task(1).Status)
+	}
+	if storage.task(2).Status != string(domain.Queued) {
+		t.Fatalf("expected the task without heartbeats to be reaped, got %s", storage.task(2).Status)
+	}
+	if published := queue.publishedIDs(); len(published) != 1 || published[0] != 2 {
+		t.Fatalf("expected only task 2 to be re-queued, got %v", published)
+	}
+}
@@ -0,0 +1,218 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/subtask"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"log/slog"
+	"time"
+)
+
+// Settings controls which tasks are considered stuck, and how fast they are re-queued
+type Settings struct {
+	Interval                time.Duration
+	QueuedAfterSeconds      int32
+	RunningLeaseSeconds     int32
+	FailedRetryAfterSeconds int32
+	MaxAttempts             int32
+	BatchSize               int32
+	MaxRequeuesPerSecond    int32
+}
+
+// Reconciler periodically finds tasks which are stuck in queued, running or retryable failed states and re-queues them
+// Running tasks are considered stuck when the heartbeats of their worker have stopped for longer than the running lease
+// It also moves forward the workflows whose finished tasks have not been handled by the workflow engine, and counts the finished members of the batches which have not been counted
+// The waiting parents whose children are all finished are finished too
+// Only the replica which holds the leadership lease acts, the others keep waiting for the leader to go away
+type Reconciler struct {
+	storage    domain.Storage
+	dispatcher *dispatch.Dispatcher
+	workflows  *workflow.Engine
+	batches    *batch.Tracker
+	subtasks   *subtask.Manager
+	elector    *LeaderElector
+	settings   Settings
+	stats      *Stats
+}
+
+func NewReconciler(storage domain.Storage, dispatcher *dispatch.Dispatcher, workflows *workflow.Engine, batches *batch.Tracker, subtasks *subtask.Manager, elector *LeaderElector, settings Settings) *Reconciler {
+	return &Reconciler{
+		storage:    storage,
+		dispatcher: dispatcher,
+		workflows:  workflows,
+		batches:    batches,
+		subtasks:   subtasks,
+		elector:    elector,
+		settings:   settings,
+		stats:      &Stats{},
+	}
+}
+
+// Stats returns the counters of the reconciler, which are exposed by the metrics API
+func (r *Reconciler) Stats() *Stats {
+	return r.stats
+}
+
+// Run blocks and reconciles stuck tasks every interval until the context is cancelled
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.settings.Interval)
+	defer ticker.Stop()
+	defer r.elector.Resign()
+
+	for {
+		isLeader := r.elector.TryLead()
+		r.stats.setLeader(isLeader)
+		if isLeader {
+			r.Reconcile(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Reconciler loop is stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile runs one iteration over all kinds of stuck tasks
+func (r *Reconciler) Reconcile(ctx context.Context) {
+	r.stats.iterations.Add(1)
+	limiter := newRateLimiter(r.settings.MaxRequeuesPerSecond)
+	defer limiter.stop()
+
+	r.requeueStaleQueuedTasks(ctx, limiter)
+	r.reapExpiredRunningTasks(ctx, limiter)
+	r.retryFailedTasks(ctx, limiter)
+	r.advanceStalledWorkflows(ctx)
+	r.countStalledBatchTasks(ctx)
+	r.finishStalledWaitingTasks(ctx)
+}
+
+// requeueStaleQueuedTasks re-publishes queued tasks which have not been picked up for a long time, their message has probably been lost
+func (r *Reconciler) requeueStaleQueuedTasks(ctx context.Context, limiter *rateLimiter) {
+	tasks := r.fetch(ctx, string(domain.Queued), func() ([]*domain.Task, error) {
+		return r.storage.GetMissedTasks(ctx, string(domain.Queued), r.settings.QueuedAfterSeconds, r.settings.BatchSize)
+	})
+
+	for _, task := range tasks {
+		if !limiter.wait(ctx) {
+			return
+		}
+
+		r.requeue(ctx, task, &r.stats.requeuedQueued)
+	}
+}
+
+// retryFailedTasks re-queues the failed tasks which have not used all of their attempts
+func (r *Reconciler) retryFailedTasks(ctx context.Context, limiter *rateLimiter) {
+	tasks := r.fetch(ctx, string(domain.Failed), func() ([]*domain.Task, error) {
+		return r.storage.GetRetryableFailedTasks(ctx, r.settings.MaxAttempts, r.settings.FailedRetryAfterSeconds, r.settings.BatchSize)
+	})
+
+	for _, task := range tasks {
+		if !limiter.wait(ctx) {
+			return
+		}
+
+		r.requeue(ctx, task, &r.stats.retriedFailed)
+	}
+}
+
+// advanceStalledWorkflows handles the finished tasks whose dependents are still pending, their worker has probably died before moving the workflow forward
+func (r *Reconciler) advanceStalledWorkflows(ctx context.Context) {
+	advancedCount, err := r.workflows.RecoverStalled(ctx, r.settings.QueuedAfterSeconds, r.settings.BatchSize)
+	r.stats.advancedWorkflowTasks.Add(int64(advancedCount))
+	if err != nil {
+		slog.Error("Error occurred while moving the stalled workflows forward", "error", err.Error())
+		r.stats.errors.Add(1)
+	}
+}
+
+// countStalledBatchTasks counts the finished members of the batches and fires the completion of the finished batches, their worker has probably died before doing it
+func (r *Reconciler) countStalledBatchTasks(ctx context.Context) {
+	recoveredCount, err := r.batches.RecoverStalled(ctx, r.settings.QueuedAfterSeconds, r.settings.BatchSize)
+	r.stats.recoveredBatchItems.Add(int64(recoveredCount))
+	if err != nil {
+		slog.Error("Error occurred while counting the stalled tasks of the batches", "error", err.Error())
+		r.stats.errors.Add(1)
+	}
+}
+
+// finishStalledWaitingTasks finishes the waiting tasks whose children are all finished, the worker of their last child has probably died before doing it
+func (r *Reconciler) finishStalledWaitingTasks(ctx context.Context) {
+	finishedCount, err := r.subtasks.RecoverStalled(ctx, r.settings.QueuedAfterSeconds, r.settings.BatchSize)
+	r.stats.finishedWaitingTasks.Add(int64(finishedCount))
+	if err != nil {
+		slog.Error("Error occurred while finishing the stalled waiting tasks", "error", err.Error())
+		r.stats.errors.Add(1)
+	}
+}
+
+func (r *Reconciler) fetch(ctx context.Context, taskStatus string, fetchFunc func() ([]*domain.Task, error)) []*domain.Task {
+	tasks, err := fetchFunc()
+	if err != nil {
+		if !errors.Is(err, errval.ErrNotFound) {
+			slog.Error("Error occurred while fetching stuck tasks", "task_status", taskStatus, "error", err.Error())
+			r.stats.errors.Add(1)
+		}
+
+		return nil
+	}
+	slog.Info("Stuck tasks are fetched", "task_status", taskStatus, "fetched_items_count", len(tasks))
+
+	return tasks
+}
+
+// transit changes the status of the task and logs the change, it returns false if the task should not be re-queued
+func (r *Reconciler) transit(ctx context.Context, task *domain.Task, currentStatus, newStatus domain.TaskStatus) bool {
+	err := r.storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, string(currentStatus), string(newStatus))
+	if err != nil {
+		if errors.Is(err, errval.ErrStatusConflict) {
+			slog.Info("Task status has been changed meanwhile, skipping the re-queue", "task_id", task.ID)
+			return false
+		}
+
+		slog.Error("Error occurred while changing the status of the stuck task", "task_id", task.ID, "old_status", currentStatus, "new_status", newStatus, "error", err.Error())
+		r.stats.errors.Add(1)
+		return false
+	}
+	slog.Info("Task state is changed by the reconciler", "task_id", task.ID, "old_status", currentStatus, "new_status", newStatus)
+
+	task.Status = string(newStatus)
+	return true
+}
+
+// requeue re-publishes the missed queued or failed task after prepareRequeue, the same way as the one-shot Requeue
+func (r *Reconciler) requeue(ctx context.Context, task *domain.Task, counter *counter) {
+	isPrepared, err := prepareRequeue(ctx, r.storage, task)
+	if err != nil {
+		slog.Error("Error occurred while preparing the missed task to be re-queued", "task_id", task.ID, "task_status", task.Status, "error", err.Error())
+		r.stats.errors.Add(1)
+		return
+	}
+	if !isPrepared {
+		slog.Info("Task status has been changed meanwhile, skipping the re-queue", "task_id", task.ID)
+		return
+	}
+
+	r.dispatch(ctx, task, counter)
+}
+
+func (r *Reconciler) dispatch(ctx context.Context, task *domain.Task, counter *counter) {
+	err := r.dispatcher.Dispatch(ctx, task)
+	if err != nil {
+		// The task is left queued, so it's picked up again by the next iterations after QueuedAfterSeconds
+		slog.Error("Error occurred while re-queuing the task", "task_id", task.ID, "error", err.Error())
+		r.stats.errors.Add(1)
+		return
+	}
+
+	slog.Info("Task is re-queued successfully", "task_id", task.ID, "priority", task.Priority)
+	counter.Add(1)
+}
@@ -0,0 +1,341 @@
+package recovery
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/subtask"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/process"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage keeps the tasks in memory, the age of each task is measured from its last update like the updated_at column
+// The methods which are not used by the recovery panic through the embedded nil interface
+type fakeStorage struct {
+	domain.Storage
+	mu        sync.Mutex
+	tasks     map[int32]*domain.Task
+	updatedAt map[int32]time.Time
+	// beforeChange runs before each status change and touch, so the tests change the tasks while they are being recovered
+	beforeChange func(task *domain.Task)
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{tasks: map[int32]*domain.Task{}, updatedAt: map[int32]time.Time{}}
+}
+
+// add inserts a task which has not been updated for age
+func (f *fakeStorage) add(id int32, status domain.TaskStatus, attempts int32, age time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.tasks[id] = &domain.Task{ID: id, Type: "send_email", Status: string(status), Priority: string(domain.Normal), Attempts: attempts}
+	f.updatedAt[id] = time.Now().Add(-age)
+}
+
+func (f *fakeStorage) task(id int32) domain.Task {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return *f.tasks[id]
+}
+
+// find returns copies of the tasks which match, ordered by their ID
+func (f *fakeStorage) find(limit int32, match func(task *domain.Task, age time.Duration) bool) ([]*domain.Task, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tasks := []*domain.Task{}
+	for id, task := range f.tasks {
+		if match(task, time.Since(f.updatedAt[id])) {
+			taskCopy := *task
+			tasks = append(tasks, &taskCopy)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	if len(tasks) > int(limit) {
+		tasks = tasks[:limit]
+	}
+	if len(tasks) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return tasks, nil
+}
+
+func (f *fakeStorage) GetMissedTasks(ctx context.Context, taskStatus string, passedSeconds, limit int32) ([]*domain.Task, error) {
+	return f.find(limit, func(task *domain.Task, age time.Duration) bool {
+		return task.Status == taskStatus && age >= time.Duration(passedSeconds)*time.Second
+	})
+}
+
+func (f *fakeStorage) GetFilteredMissedTasks(ctx context.Context, filter domain.MissedTasksFilter) ([]*domain.Task, error) {
+	return f.GetMissedTasks(ctx, filter.Status, filter.PassedSeconds, filter.Limit)
+}
+
+func (f *fakeStorage) GetRetryableFailedTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	return f.find(limit, func(task *domain.Task, age time.Duration) bool {
+		return task.Status == string(domain.Failed) && task.Attempts < maxAttempts && age >= time.Duration(passedSeconds)*time.Second
+	})
+}
+
+func (f *fakeStorage) TouchTask(ctx context.Context, taskID int32, taskStatus string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	task := f.tasks[taskID]
+	if f.beforeChange != nil {
+		f.beforeChange(task)
+	}
+	if task.Status != taskStatus {
+		return false, nil
+	}
+	f.updatedAt[taskID] = time.Now()
+
+	return true, nil
+}
+
+func (f *fakeStorage) UpdateTaskStatusAndLogChangeInTx(ctx context.Context, taskID int32, currentStatus, newStatus string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	task := f.tasks[taskID]
+	if f.beforeChange != nil {
+		f.beforeChange(task)
+	}
+	if task.Status != currentStatus {
+		return errval.ErrStatusConflict
+	}
+	task.Status = newStatus
+	if newStatus == string(domain.Running) {
+		task.Attempts++
+	}
+	f.updatedAt[taskID] = time.Now()
+
+	return nil
+}
+
+// fakeLock holds each lease for one owner until it's released, the tests take the lease over by setting the holder
+type fakeLock struct {
+	domain.DistributedLock
+	mu            sync.Mutex
+	holders       map[string]string
+	acquiredCount int
+	err           error
+}
+
+func newFakeLock() *fakeLock {
+	return &fakeLock{holders: map[string]string{}}
+}
+
+func (f *fakeLock) AcquireLease(leaseKey, owner string, leaseDuration time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return false, f.err
+	}
+	if holder, ok := f.holders[leaseKey]; ok && holder != owner {
+		return false, nil
+	}
+	f.holders[leaseKey] = owner
+	f.acquiredCount++
+
+	return true, nil
+}
+
+func (f *fakeLock) ReleaseLease(leaseKey, owner string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.holders[leaseKey] == owner {
+		delete(f.holders, leaseKey)
+	}
+
+	return nil
+}
+
+func (f *fakeLock) holder(leaseKey string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.holders[leaseKey]
+}
+
+// fakeQueue records the IDs of the published tasks
+type fakeQueue struct {
+	domain.Queue
+	mu        sync.Mutex
+	published []int32
+}
+
+func (f *fakeQueue) PublishMessage(queueName string, message domain.QueueMessage) error {
+	decoded, err := dispatch.Decode(string(message.Body))
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, decoded.TaskID)
+
+	return nil
+}
+
+func (f *fakeQueue) publishedIDs() []int32 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]int32{}, f.published...)
+}
+
+// The workflows, the batches and the child tasks have nothing stalled
+type fakeWorkflowStorage struct{ domain.WorkflowStorage }
+
+func (fakeWorkflowStorage) GetStalledWorkflowParentTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	return nil, errval.ErrNotFound
+}
+
+type fakeBatchStorage struct{ domain.BatchStorage }
+
+func (fakeBatchStorage) GetUncountedBatchTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	return nil, errval.ErrNotFound
+}
+
+func (fakeBatchStorage) GetFinishedUncompletedBatches(ctx context.Context, limit int32) ([]*domain.Batch, error) {
+	return nil, errval.ErrNotFound
+}
+
+type fakeChildTaskStorage struct{ domain.ChildTaskStorage }
+
+func (fakeChildTaskStorage) GetFinishableWaitingTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	return nil, errval.ErrNotFound
+}
+
+const (
+	testLeaseKey = "recovery:leader"
+	testOwner    = "replica-1"
+)
+
+var testSettings = Settings{
+	Interval:                10 * time.Millisecond,
+	QueuedAfterSeconds:      60,
+	RunningLeaseSeconds:     30,
+	FailedRetryAfterSeconds: 60,
+	MaxAttempts:             3,
+	BatchSize:               100,
+}
+
+func newTestReconciler(storage *fakeStorage, lock *fakeLock, settings Settings) (*Reconciler, *fakeQueue) {
+	queue := &fakeQueue{}
+	dispatcher := dispatch.NewDispatcher(queue, domain.PriorityQueueNames{High: "high", Normal: "normal", Low: "low"})
+	workflows := workflow.NewEngine(fakeWorkflowStorage{}, dispatcher, settings.MaxAttempts)
+	batches := batch.NewTracker(fakeBatchStorage{}, dispatcher, settings.MaxAttempts)
+	subtasks := subtask.NewManager(fakeChildTaskStorage{}, dispatcher, process.NewRegistry(), workflows, batches, settings.MaxAttempts)
+	elector := NewLeaderElector(lock, testLeaseKey, testOwner, time.Minute)
+
+	return NewReconciler(storage, dispatcher, workflows, batches, subtasks, elector, settings), queue
+}
+
+// TestReconcile_RequeuesStaleTasks: the stale queued tasks and the failed tasks with attempts left are re-queued, the fresh and the exhausted tasks are left alone
+func TestReconcile_RequeuesStaleTasks(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Queued, 0, time.Hour)
+	storage.add(2, domain.Queued, 0, time.Second)
+	storage.add(3, domain.Failed, 1, time.Hour)
+	storage.add(4, domain.Failed, 1, time.Second)
+	storage.add(5, domain.Failed, 3, time.Hour)
+	storage.add(6, domain.Succeeded, 1, time.Hour)
+	reconciler, queue := newTestReconciler(storage, newFakeLock(), testSettings)
+
+	reconciler.Reconcile(context.Background())
+
+	published := queue.publishedIDs()
+	if len(published) != 2 || published[0] != 1 || published[1] != 3 {
+		t.Fatalf("expected tasks 1 and 3 to be re-queued, got %v", published)
+	}
+	if storage.task(3).Status != string(domain.Queued) || storage.task(5).Status != string(domain.Failed) {
+		t.Fatalf("expected the retried task to be queued and the exhausted one to stay failed, got %s and %s", storage.task(3).Status, storage.task(5).Status)
+	}
+	if reconciler.stats.requeuedQueued.Load() != 1 || reconciler.stats.retriedFailed.Load() != 1 || reconciler.stats.errors.Load() != 0 {
+		t.Fatalf("unexpected stats: requeued_queued=%d retried_failed=%d errors=%d", reconciler.stats.requeuedQueued.Load(), reconciler.stats.retriedFailed.Load(), reconciler.stats.errors.Load())
+	}
+
+	// The re-queued tasks have just been updated, so they are not re-queued again by the next iteration
+	reconciler.Reconcile(context.Background())
+	if len(queue.publishedIDs()) != 2 {
+		t.Fatalf("expected no more re-queues, got %v", queue.publishedIDs())
+	}
+}
+
+// TestReconcile_SkipsConflictingTransitions: the tasks which are picked up by a worker after being fetched are not re-queued
+func TestReconcile_SkipsConflictingTransitions(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Queued, 0, time.Hour)
+	storage.add(2, domain.Failed, 1, time.Hour)
+	storage.add(3, domain.Running, 1, time.Hour)
+	storage.beforeChange = func(task *domain.Task) {
+		task.Status = string(domain.Succeeded)
+	}
+	reconciler, queue := newTestReconciler(storage, newFakeLock(), testSettings)
+
+	reconciler.Reconcile(context.Background())
+
+	if published := queue.publishedIDs(); len(published) != 0 {
+		t.Fatalf("expected no re-queues, got %v", published)
+	}
+	if reconciler.stats.errors.Load() != 0 {
+		t.Fatalf("expected the conflicts not to be counted as errors, got %d", reconciler.stats.errors.Load())
+	}
+}
+
+// TestRun_NotLeader: a replica which can't take the lease doesn't reconcile, and it doesn't release the lease of the leader
+func TestRun_NotLeader(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Queued, 0, time.Hour)
+	lock := newFakeLock()
+	lock.holders[testLeaseKey] = "replica-2"
+	reconciler, queue := newTestReconciler(storage, lock, testSettings)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	reconciler.Run(ctx)
+
+	if published := queue.publishedIDs(); len(published) != 0 {
+		t.Fatalf("expected no re-queues, got %v", published)
+	}
+	if reconciler.stats.iterations.Load() != 0 || reconciler.stats.isLeader.Load() {
+		t.Fatalf("expected no iterations as a follower, got %d", reconciler.stats.iterations.Load())
+	}
+	if lock.holder(testLeaseKey) != "replica-2" {
+		t.Fatalf("expected the lease to stay with the leader, got %q", lock.holder(testLeaseKey))
+	}
+}
+
+// TestRun_Leader: the leader renews its lease on every iteration, reconciles, and releases the lease when it stops
+func TestRun_Leader(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Queued, 0, time.Hour)
+	lock := newFakeLock()
+	reconciler, queue := newTestReconciler(storage, lock, testSettings)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	reconciler.Run(ctx)
+
+	if published := queue.publishedIDs(); len(published) != 1 || published[0] != 1 {
+		t.Fatalf("expected task 1 to be re-queued once, got %v", published)
+	}
+	if lock.acquiredCount < 2 || int64(lock.acquiredCount) != reconciler.stats.iterations.Load() {
+		t.Fatalf("expected the lease to be renewed on every iteration, got %d renewals and %d iterations", lock.acquiredCount, reconciler.stats.iterations.Load())
+	}
+	if lock.holder(testLeaseKey) != "" {
+		t.Fatalf("expected the lease to be released, got %q", lock.holder(testLeaseKey))
+	}
+}
@@ -0,0 +1,294 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"log/slog"
+)
+
+type OrphanReason string
+
+const (
+	ReasonTaskNotFound    OrphanReason = "task_not_found"
+	ReasonTaskTerminal    OrphanReason = "task_terminal"
+	ReasonPriorityChanged OrphanReason = "priority_changed"
+	ReasonUndecodable     OrphanReason = "undecodable"
+)
+
+// ReconciliationOptions controls a comparison of the queues with the tasks table
+type ReconciliationOptions struct {
+	// SampleSize is the maximum number of messages which are inspected in each queue
+	SampleSize int
+	// GraceSeconds is the minimum age of a queued task to be reported as missing from the queue, so tasks which are being published are not reported
+	GraceSeconds int32
+	// Fix removes the orphan messages and re-publishes the missing tasks
+	Fix bool
+}
+
+// OrphanMessage is a message of a queue which won't be processed by the workers
+type OrphanMessage struct {
+	TaskID    int32        `json:"task_id"`
+	Reason    OrphanReason `json:"reason"`
+	IsRemoved bool         `json:"is_removed"`
+}
+
+// MissingTask is a queued task which has no message in its queue
+type MissingTask struct {
+	TaskID       int32  `json:"task_id"`
+	IsRequeued   bool   `json:"is_requeued"`
+	RequeueError string `json:"requeue_error,omitempty"`
+}
+
+// QueueReconciliation is the comparison of one priority queue with the queued tasks of the same priority
+type QueueReconciliation struct {
+	Priority      string `json:"priority"`
+	QueueName     string `json:"queue_name"`
+	DBQueuedCount int64  `json:"db_queued_count"`
+	QueueDepth    int    `json:"queue_depth"`
+	// Drift is the queue depth minus the number of queued tasks, a positive drift means there are extra messages in the queue
+	Drift        int64 `json:"drift"`
+	SampledCount int   `json:"sampled_count"`
+	// IsFullySampled is true when all the messages of the queue are inspected, missing tasks are only detected in this case
+	IsFullySampled bool            `json:"is_fully_sampled"`
+	OrphanMessages []OrphanMessage `json:"orphan_messages"`
+	MissingTasks   []MissingTask   `json:"missing_tasks"`
+	Error          string          `json:"error,omitempty"`
+}
+
+// ReconciliationReport is a machine-readable report of the drift between the queues and the tasks table
+type ReconciliationReport struct {
+	Fix    bool                  `json:"fix"`
+	Queues []QueueReconciliation `json:"queues"`
+}
+
+// ReconcileQueues compares the depth of each priority queue with the number of queued tasks of that priority,
+// and inspects a sample of the messages to find orphan messages and queued tasks without any message
+// The failures of one queue are reported in its item and don't stop the reconciliation of the other queues
+func ReconcileQueues(ctx context.Context, storage domain.Storage, queueClient domain.Queue, queueNames domain.PriorityQueueNames, opts ReconciliationOptions) (*ReconciliationReport, error) {
+	queuedCounts, err := storage.CountQueuedTasksByPriority(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconciliationReport{
+		Fix:    opts.Fix,
+		Queues: []QueueReconciliation{},
+	}
+	r := &queueReconciler{
+		storage:     storage,
+		queueClient: queueClient,
+		dispatcher:  dispatch.NewDispatcher(queueClient, queueNames),
+		opts:        opts,
+	}
+	for _, priority := range domain.TaskPriorities {
+		item := QueueReconciliation{
+			Priority:       string(priority),
+			QueueName:      queueNames.ByPriority(string(priority)),
+			DBQueuedCount:  queuedCounts[string(priority)],
+			OrphanMessages: []OrphanMessage{},
+			MissingTasks:   []MissingTask{},
+		}
+		err = r.reconcile(ctx, &item)
+		if err != nil {
+			slog.Error("Error occurred while reconciling the queue", "queue_name", item.QueueName, "error", err.Error())
+			item.Error = err.Error()
+		}
+		slog.Info("Queue is reconciled", "queue_name", item.QueueName, "db_queued_count", item.DBQueuedCount, "queue_depth", item.QueueDepth, "orphan_messages_count", len(item.OrphanMessages), "missing_tasks_count", len(item.MissingTasks))
+
+		report.Queues = append(report.Queues, item)
+	}
+
+	return report, nil
+}
+
+type queueReconciler struct {
+	storage     domain.Storage
+	queueClient domain.Queue
+	dispatcher  *dispatch.Dispatcher
+	opts        ReconciliationOptions
+}
+
+func (r *queueReconciler) reconcile(ctx context.Context, item *QueueReconciliation) error {
+	depth, err := r.queueClient.QueueDepth(item.QueueName)
+	if err != nil {
+		return err
+	}
+	item.QueueDepth = depth
+	item.Drift = int64(depth) - item.DBQueuedCount
+
+	// The first pass only reads the sample, the tasks of all sampled messages are then loaded at once
+	sampledMessages := []*dispatch.Message{}
+	undecodableCount := 0
+	sampledCount, err := r.queueClient.InspectMessages(item.QueueName, r.opts.SampleSize, func(body string) (remove bool) {
+		message, err := dispatch.Decode(body)
+		if err != nil {
+			undecodableCount++
+			return false
+		}
+		sampledMessages = append(sampledMessages, message)
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	item.SampledCount = sampledCount
+	item.IsFullySampled = sampledCount >= depth
+
+	storedTasks, err := r.loadTasks(ctx, sampledMessages)
+	if err != nil {
+		return err
+	}
+
+	orphanReasons := map[int32]OrphanReason{}
+	for _, message := range sampledMessages {
+		reason, isOrphan := orphanReason(message, storedTasks[message.TaskID])
+		if isOrphan {
+			orphanReasons[message.TaskID] = reason
+		}
+	}
+	for i := 0; i < undecodableCount; i++ {
+		item.OrphanMessages = append(item.OrphanMessages, OrphanMessage{Reason: ReasonUndecodable})
+	}
+
+	removedIDs := map[int32]bool{}
+	if r.opts.Fix && len(orphanReasons) > 0 {
+		// The messages which are checked in the second pass may differ from the first pass if workers are consuming the queue,
+		// so each message is checked again against the tasks loaded in the first pass
+		_, err = r.queueClient.InspectMessages(item.QueueName, r.opts.SampleSize, func(body string) (remove bool) {
+			message, err := dispatch.Decode(body)
+			if err != nil {
+				// Undecodable messages are only reported, they may have been published by a newer version of the server
+				return false
+			}
+			if _, isOrphan := orphanReasons[message.TaskID]; !isOrphan {
+				return false
+			}
+			_, isOrphan := orphanReason(message, storedTasks[message.TaskID])
+			if isOrphan {
+				removedIDs[message.TaskID] = true
+			}
+			return isOrphan
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for taskID, reason := range orphanReasons {
+		item.OrphanMessages = append(item.OrphanMessages, OrphanMessage{
+			TaskID:    taskID,
+			Reason:    reason,
+			IsRemoved: removedIDs[taskID],
+		})
+	}
+
+	// Queued tasks without any message can only be detected when all the messages of the queue have been seen
+	if item.IsFullySampled {
+		return r.findMissingTasks(ctx, item, sampledMessages)
+	}
+
+	return nil
+}
+
+func (r *queueReconciler) loadTasks(ctx context.Context, messages []*dispatch.Message) (map[int32]*domain.Task, error) {
+	storedTasks := map[int32]*domain.Task{}
+	if len(messages) == 0 {
+		return storedTasks, nil
+	}
+
+	IDs := make([]int32, 0, len(messages))
+	for _, message := range messages {
+		IDs = append(IDs, message.TaskID)
+	}
+	tasks, err := r.storage.GetTasksByIDs(ctx, IDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		storedTasks[task.ID] = task
+	}
+
+	return storedTasks, nil
+}
+
+// orphanReason checks whether a message is ignored by the workers
+// Messages of failed tasks are not orphans, because failed tasks are still processed by the workers when they are re-queued
+func orphanReason(message *dispatch.Message, storedTask *domain.Task) (reason OrphanReason, isOrphan bool) {
+	switch {
+	case storedTask == nil:
+		return ReasonTaskNotFound, true
+	case storedTask.Status == string(domain.Succeeded), storedTask.Status == string(domain.Skipped), storedTask.Status == string(domain.Cancelled):
+		return ReasonTaskTerminal, true
+	case message.TaskPriority != "" && message.TaskPriority != storedTask.Priority:
+		return ReasonPriorityChanged, true
+	default:
+		return "", false
+	}
+}
+
+func (r *queueReconciler) findMissingTasks(ctx context.Context, item *QueueReconciliation, sampledMessages []*dispatch.Message) error {
+	if item.DBQueuedCount == 0 {
+		return nil
+	}
+
+	priority := item.Priority
+	queuedTasks, err := r.storage.GetFilteredMissedTasks(ctx, domain.MissedTasksFilter{
+		Status:        string(domain.Queued),
+		PassedSeconds: r.opts.GraceSeconds,
+		Limit:         int32(item.DBQueuedCount),
+		Priority:      &priority,
+	})
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	queuedIDs := map[int32]bool{}
+	for _, message := range sampledMessages {
+		if message.TaskPriority == "" || message.TaskPriority == priority {
+			queuedIDs[message.TaskID] = true
+		}
+	}
+	for _, task := range queuedTasks {
+		if queuedIDs[task.ID] {
+			continue
+		}
+
+		missingTask := MissingTask{TaskID: task.ID}
+		if r.opts.Fix {
+			err = r.requeue(ctx, task)
+			if err != nil {
+				missingTask.RequeueError = err.Error()
+			} else {
+				missingTask.IsRequeued = true
+			}
+		}
+		item.MissingTasks = append(item.MissingTasks, missingTask)
+	}
+
+	return nil
+}
+
+func (r *queueReconciler) requeue(ctx context.Context, task *domain.Task) error {
+	// Touching the task makes sure it's still queued, and keeps the daemon from re-queuing it again
+	isTouched, err := r.storage.TouchTask(ctx, task.ID, string(domain.Queued))
+	if err != nil {
+		return err
+	}
+	if !isTouched {
+		return errval.ErrStatusConflict
+	}
+
+	err = r.dispatcher.Dispatch(ctx, task)
+	if err != nil {
+		return err
+	}
+	slog.Info("Missing task is re-queued successfully", "task_id", task.ID, "priority", task.Priority)
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"log/slog"
+)
+
+type RequeueAction string
+
+const (
+	ActionRequeued     RequeueAction = "requeued"
+	ActionWouldRequeue RequeueAction = "would_requeue"
+	ActionFailed       RequeueAction = "failed"
+	ActionSkipped      RequeueAction = "skipped"
+	ActionNotReached   RequeueAction = "not_reached"
+)
+
+// RequeueOptions controls a one-shot re-queue of missed tasks
+type RequeueOptions struct {
+	Filter               domain.MissedTasksFilter
+	DryRun               bool
+	MaxRequeuesPerSecond int32
+}
+
+// RequeueReportItem is the outcome of one considered task
+type RequeueReportItem struct {
+	TaskID   int32         `json:"task_id"`
+	Type     string        `json:"type"`
+	Status   string        `json:"status"`
+	Priority string        `json:"priority"`
+	Attempts int32         `json:"attempts"`
+	Action   RequeueAction `json:"action"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// RequeueReport is a machine-readable report of a one-shot re-queue
+type RequeueReport struct {
+	DryRun          bool                `json:"dry_run"`
+	ConsideredCount int                 `json:"considered_count"`
+	RequeuedCount   int                 `json:"requeued_count"`
+	FailedCount     int                 `json:"failed_count"`
+	SkippedCount    int                 `json:"skipped_count"`
+	Tasks           []RequeueReportItem `json:"tasks"`
+}
+
+// Requeue re-publishes the missed tasks matching the filter once, and reports every considered task and the action taken on it
+// The tasks are prepared by prepareRequeue like the recovery daemon does, the tasks which have been changed meanwhile are skipped
+// In the dry-run mode, nothing is published and the would-be actions are reported
+func Requeue(ctx context.Context, storage domain.Storage, dispatcher *dispatch.Dispatcher, opts RequeueOptions) (*RequeueReport, error) {
+	report := &RequeueReport{
+		DryRun: opts.DryRun,
+		Tasks:  []RequeueReportItem{},
+	}
+
+	missedTasks, err := storage.GetFilteredMissedTasks(ctx, opts.Filter)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return report, nil
+		}
+
+		return nil, err
+	}
+	report.ConsideredCount = len(missedTasks)
+	slog.Info("Missed tasks are fetched", "task_status", opts.Filter.Status, "past_seconds_threshold", opts.Filter.PassedSeconds, "limit", opts.Filter.Limit, "fetched_items_count", len(missedTasks))
+
+	limiter := newRateLimiter(opts.MaxRequeuesPerSecond)
+	defer limiter.stop()
+
+	for i, task := range missedTasks {
+		item := RequeueReportItem{
+			TaskID:   task.ID,
+			Type:     task.Type,
+			Status:   task.Status,
+			Priority: task.Priority,
+			Attempts: task.Attempts,
+		}
+
+		switch {
+		case opts.DryRun:
+			item.Action = ActionWouldRequeue
+		case !limiter.wait(ctx):
+			item.Action = ActionNotReached
+			item.Error = ctx.Err().Error()
+		default:
+			isPrepared, err := prepareRequeue(ctx, storage, task)
+			if err != nil {
+				slog.Error("Error occurred while preparing the missed task to be re-queued", "task_id", task.ID, "task_status", task.Status, "error", err.Error())
+				item.Action = ActionFailed
+				item.Error = err.Error()
+				report.FailedCount++
+				break
+			}
+			if !isPrepared {
+				slog.Info("Task status has been changed meanwhile, skipping the re-queue", "task_id", task.ID)
+				item.Action = ActionSkipped
+				report.SkippedCount++
+				break
+			}
+
+			err = dispatcher.Dispatch(ctx, task)
+			if err != nil {
+				slog.Error("Error occurred while queuing task to jobs queue", "task_id", task.ID, "error", err.Error())
+				item.Action = ActionFailed
+				item.Error = err.Error()
+				report.FailedCount++
+				break
+			}
+			slog.Info("Task is re-queued successfully", "task_id", task.ID, "priority", task.Priority, "missed_tasks_count", len(missedTasks), "item_index", i)
+			item.Action = ActionRequeued
+			report.RequeuedCount++
+		}
+
+		report.Tasks = append(report.Tasks, item)
+	}
+
+	return report, nil
+}
+
+// prepareRequeue gets the missed task ready to be re-published, isPrepared is false when its status has been changed meanwhile
+// A queued task is touched, so its waiting time is reset and it's not re-published again by the next iterations while its new message is in the queue
+// A failed task is moved to queued, so it's picked up by the worker and it's not retried again by the next iterations
+func prepareRequeue(ctx context.Context, storage domain.Storage, task *domain.Task) (isPrepared bool, err error) {
+	switch domain.TaskStatus(task.Status) {
+	case domain.Queued:
+		return storage.TouchTask(ctx, task.ID, string(domain.Queued))
+	case domain.Failed:
+		err = storage.UpdateTaskStatusAndLogChangeInTx(ctx, task.ID, string(domain.Failed), string(domain.Queued))
+		if errors.Is(err, errval.ErrStatusConflict) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		task.Status = string(domain.Queued)
+
+		return true, nil
+	default:
+		return false, fmt.Errorf("task with %s status can't be re-queued", task.Status)
+	}
+}
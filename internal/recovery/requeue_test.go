@@ -0,0 +1,106 @@
+package recovery
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"testing"
+	"time"
+)
+
+func newTestRequeue(storage *fakeStorage, opts RequeueOptions) (*RequeueReport, *fakeQueue, error) {
+	queue := &fakeQueue{}
+	dispatcher := dispatch.NewDispatcher(queue, domain.PriorityQueueNames{High: "high", Normal: "normal", Low: "low"})
+	report, err := Requeue(context.Background(), storage, dispatcher, opts)
+
+	return report, queue, err
+}
+
+// TestRequeue: the missed tasks are re-queued and reported, the fresh ones are not considered
+func TestRequeue(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Queued, 0, time.Hour)
+	storage.add(2, domain.Queued, 0, time.Second)
+	storage.add(3, domain.Queued, 0, time.Hour)
+
+	report, queue, err := newTestRequeue(storage, RequeueOptions{Filter: domain.MissedTasksFilter{Status: string(domain.Queued), PassedSeconds: 60, Limit: 10}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if report.ConsideredCount != 2 || report.RequeuedCount != 2 || len(report.Tasks) != 2 || report.Tasks[0].Action != ActionRequeued {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if published := queue.publishedIDs(); len(published) != 2 || published[0] != 1 || published[1] != 3 {
+		t.Fatalf("expected tasks 1 and 3 to be re-queued, got %v", published)
+	}
+}
+
+// TestRequeue_DryRun: nothing is published in the dry-run mode
+func TestRequeue_DryRun(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Queued, 0, time.Hour)
+
+	report, queue, err := newTestRequeue(storage, RequeueOptions{Filter: domain.MissedTasksFilter{Status: string(domain.Queued), PassedSeconds: 60, Limit: 10}, DryRun: true})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if report.RequeuedCount != 0 || len(report.Tasks) != 1 || report.Tasks[0].Action != ActionWouldRequeue {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if published := queue.publishedIDs(); len(published) != 0 {
+		t.Fatalf("expected no re-queues, got %v", published)
+	}
+}
+
+// TestRequeue_Failed: the failed tasks are moved to queued before they are re-published, like the recovery daemon does
+func TestRequeue_Failed(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Failed, 1, time.Hour)
+
+	report, queue, err := newTestRequeue(storage, RequeueOptions{Filter: domain.MissedTasksFilter{Status: string(domain.Failed), PassedSeconds: 60, Limit: 10}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if report.RequeuedCount != 1 || storage.task(1).Status != string(domain.Queued) {
+		t.Fatalf("expected the failed task to be queued and re-queued, got %+v and %s", report, storage.task(1).Status)
+	}
+	if published := queue.publishedIDs(); len(published) != 1 || published[0] != 1 {
+		t.Fatalf("expected task 1 to be re-queued, got %v", published)
+	}
+}
+
+// TestRequeue_NotRequeuedAgain: the re-queued tasks are touched, so they are not re-queued again by the next recovery
+func TestRequeue_NotRequeuedAgain(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Queued, 0, time.Hour)
+	opts := RequeueOptions{Filter: domain.MissedTasksFilter{Status: string(domain.Queued), PassedSeconds: 60, Limit: 10}}
+
+	_, _, err := newTestRequeue(storage, opts)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	report, queue, err := newTestRequeue(storage, opts)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if report.ConsideredCount != 0 || len(queue.publishedIDs()) != 0 {
+		t.Fatalf("expected the re-queued task not to be considered again, got %+v", report)
+	}
+}
+
+// TestRequeue_Skipped: the tasks which are picked up by a worker after being fetched are skipped
+func TestRequeue_Skipped(t *testing.T) {
+	storage := newFakeStorage()
+	storage.add(1, domain.Failed, 1, time.Hour)
+	storage.beforeChange = func(task *domain.Task) {
+		task.Status = string(domain.Running)
+	}
+
+	report, queue, err := newTestRequeue(storage, RequeueOptions{Filter: domain.MissedTasksFilter{Status: string(domain.Failed), PassedSeconds: 60, Limit: 10}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if report.SkippedCount != 1 || report.Tasks[0].Action != ActionSkipped || len(queue.publishedIDs()) != 0 {
+		t.Fatalf("expected the task to be skipped, got %+v", report)
+	}
+}
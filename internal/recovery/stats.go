@@ -0,0 +1,69 @@
+package recovery
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sf7293/task-manager/internal/metrics"
+	"sync/atomic"
+)
+
+type counter = atomic.Int64
+
+// Stats holds the counters of the reconciler, it's safe to be collected while the reconciler is running
+type Stats struct {
+	isLeader        atomic.Bool
+	iterations      counter
+	requeuedQueued  counter
+	requeuedRunning counter
+	reapedFailed    counter
+	retriedFailed   counter
+	// advancedWorkflowTasks is the number of the finished tasks whose stalled workflow is moved forward
+	advancedWorkflowTasks counter
+	// recoveredBatchItems is the number of the counted members and the completed batches which are handled by the recovery
+	recoveredBatchItems counter
+	// finishedWaitingTasks is the number of the waiting tasks which are finished by the recovery after their children are finished
+	finishedWaitingTasks counter
+	errors               counter
+}
+
+func (s *Stats) setLeader(isLeader bool) {
+	s.isLeader.Store(isLeader)
+}
+
+var (
+	isLeaderDesc   = prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "recovery", "is_leader"), "Whether the reconciler holds the leadership lease.", nil, nil)
+	iterationsDesc = prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "recovery", "iterations_total"), "Number of the reconcile iterations of the leader.", nil, nil)
+	actionsDesc    = prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "recovery", "actions_total"), "Number of the tasks which are handled by the reconciler by action.", []string{"action"}, nil)
+	errorsDesc     = prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "recovery", "errors_total"), "Number of the errors of the reconciler.", nil, nil)
+)
+
+// Describe implements prometheus.Collector, so the counters are served by the metrics API
+func (s *Stats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- isLeaderDesc
+	ch <- iterationsDesc
+	ch <- actionsDesc
+	ch <- errorsDesc
+}
+
+// Collect implements prometheus.Collector
+func (s *Stats) Collect(ch chan<- prometheus.Metric) {
+	isLeader := 0.0
+	if s.isLeader.Load() {
+		isLeader = 1
+	}
+	ch <- prometheus.MustNewConstMetric(isLeaderDesc, prometheus.GaugeValue, isLeader)
+	ch <- prometheus.MustNewConstMetric(iterationsDesc, prometheus.CounterValue, float64(s.iterations.Load()))
+	ch <- prometheus.MustNewConstMetric(errorsDesc, prometheus.CounterValue, float64(s.errors.Load()))
+
+	actions := map[string]*counter{
+		"requeued_queued":        &s.requeuedQueued,
+		"requeued_running":       &s.requeuedRunning,
+		"reaped_failed":          &s.reapedFailed,
+		"retried_failed":         &s.retriedFailed,
+		"advanced_workflow_task": &s.advancedWorkflowTasks,
+		"recovered_batch_item":   &s.recoveredBatchItems,
+		"finished_waiting_task":  &s.finishedWaitingTasks,
+	}
+	for action, actionCounter := range actions {
+		ch <- prometheus.MustNewConstMetric(actionsDesc, prometheus.CounterValue, float64(actionCounter.Load()), action)
+	}
+}
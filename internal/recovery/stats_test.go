@@ -0,0 +1,42 @@
+package recovery
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+// TestStats_Collect: the counters of the reconciler are served as the recovery metrics
+func TestStats_Collect(t *testing.T) {
+	stats := &Stats{}
+	stats.setLeader(true)
+	stats.iterations.Add(4)
+	stats.requeuedQueued.Add(2)
+	stats.reapedFailed.Add(1)
+	stats.errors.Add(3)
+
+	expected := `
+# HELP taskmanager_recovery_actions_total Number of the tasks which are handled by the reconciler by action.
+# TYPE taskmanager_recovery_actions_total counter
+taskmanager_recovery_actions_total{action="advanced_workflow_task"} 0
+taskmanager_recovery_actions_total{action="finished_waiting_task"} 0
+taskmanager_recovery_actions_total{action="reaped_failed"} 1
+taskmanager_recovery_actions_total{action="recovered_batch_item"} 0
+taskmanager_recovery_actions_total{action="requeued_queued"} 2
+taskmanager_recovery_actions_total{action="requeued_running"} 0
+taskmanager_recovery_actions_total{action="retried_failed"} 0
+# HELP taskmanager_recovery_errors_total Number of the errors of the reconciler.
+# TYPE taskmanager_recovery_errors_total counter
+taskmanager_recovery_errors_total 3
+# HELP taskmanager_recovery_is_leader Whether the reconciler holds the leadership lease.
+# TYPE taskmanager_recovery_is_leader gauge
+taskmanager_recovery_is_leader 1
+# HELP taskmanager_recovery_iterations_total Number of the reconcile iterations of the leader.
+# TYPE taskmanager_recovery_iterations_total counter
+taskmanager_recovery_iterations_total 4
+`
+	err := testutil.CollectAndCompare(stats, strings.NewReader(expected))
+	if err != nil {
+		t.Fatalf("unexpected metrics: %v", err)
+	}
+}
@@ -38,6 +38,45 @@ func (c *Client) Unlock(lockKey string) (err error) {
 	return err
 }
 
+// acquireLeaseScript sets the lease key if it doesn't exist, or extends it if it's already owned by the same owner
+var acquireLeaseScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current == false then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+if current == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// releaseLeaseScript deletes the lease key only if it's owned by the given owner
+var releaseLeaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// AcquireLease acquires the lease for the owner, or renews it if the owner is already holding it
+// Unlike Lock, a lease is bound to its owner, so it could be renewed periodically and nobody else could release it
+func (c *Client) AcquireLease(leaseKey, owner string, leaseDuration time.Duration) (isAcquired bool, err error) {
+	result, err := acquireLeaseScript.Run(c.Context, c.RedisClient, []string{leaseKey}, owner, leaseDuration.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return result == 1, nil
+}
+
+// ReleaseLease releases the lease if it's held by the owner
+func (c *Client) ReleaseLease(leaseKey, owner string) (err error) {
+	err = releaseLeaseScript.Run(c.Context, c.RedisClient, []string{leaseKey}, owner).Err()
+	return err
+}
+
 func (c *Client) Close() (err error) {
 	err = c.RedisClient.Close()
 	return err
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/pkg/httprequest"
+	"log/slog"
+)
+
+// AddBatch validates every task of the batch the same way as AddTask, then stores the batch and queues all its tasks
+func (s *ServerLogic) AddBatch(ctx context.Context, req domain.RouterRequestAddBatch) (*domain.RouterResponseAddBatch, error) {
+	if len(req.Tasks) > batch.MaxTasks {
+		return nil, fmt.Errorf("%w: a batch can't have more than %d tasks", errval.ErrInvalidBatch, batch.MaxTasks)
+	}
+
+	tasks := make([]*domain.BatchTask, 0, len(req.Tasks))
+	for i, taskReq := range req.Tasks {
+		taskReq.Caller = req.Caller
+		batchTask, err := s.validateBatchTask(taskReq)
+		if err != nil {
+			return nil, fmt.Errorf("task %d: %w", i, err)
+		}
+		tasks = append(tasks, batchTask)
+	}
+
+	newBatch := &domain.Batch{
+		Name:                 req.Name,
+		Caller:               req.Caller,
+		CompletionWebhookURL: req.CompletionWebhookURL,
+	}
+	if req.CompletionTask != nil {
+		req.CompletionTask.Caller = req.Caller
+		completionTask, err := s.validateBatchTask(*req.CompletionTask)
+		if err != nil {
+			return nil, fmt.Errorf("completion task: %w", err)
+		}
+		newBatch.CompletionTask = completionTask
+	}
+	if req.CompletionWebhookURL != "" {
+		// The webhook is posted by an http_request task, so the caller must be allowed to create it
+		webhookTask, err := batch.NewWebhookTask(newBatch)
+		if err != nil {
+			slog.ErrorContext(ctx, "error occurred while calling batch.NewWebhookTask", "error", err)
+			return nil, errval.ErrInternal
+		}
+		_, _, err = s.validateTask(domain.RouterRequestAddTask{TaskType: httprequest.TaskTypeName, Payload: webhookTask.Payload, Caller: req.Caller}, false)
+		if err != nil {
+			return nil, fmt.Errorf("completion webhook: %w", err)
+		}
+	}
+
+	createdBatch, createdTasks, err := s.batches.Start(ctx, newBatch, tasks)
+	if err != nil {
+		if errors.Is(err, errval.ErrInvalidBatch) {
+			slog.Info("batch is invalid", "error", err.Error())
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling batches.Start", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	taskIDs := make([]int32, 0, len(createdTasks))
+	for _, task := range createdTasks {
+		taskIDs = append(taskIDs, task.ID)
+	}
+
+	return &domain.RouterResponseAddBatch{BatchID: createdBatch.ID, TaskIDs: taskIDs}, nil
+}
+
+// GetBatch returns the progress of the batch
+func (s *ServerLogic) GetBatch(ctx context.Context, batchID int32) (*batch.Progress, error) {
+	progress, err := s.batches.Progress(ctx, batchID)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			slog.Info("batch not found with the given id", "id", batchID)
+			return nil, errval.ErrNotFound
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling batches.Progress", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return progress, nil
+}
+
+// CancelBatch cancels the tasks of the batch which have not started yet, and returns the progress of the batch
+func (s *ServerLogic) CancelBatch(ctx context.Context, batchID int32) (*batch.Progress, error) {
+	progress, err := s.batches.Cancel(ctx, batchID)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			slog.Info("batch not found with the given id", "id", batchID)
+			return nil, errval.ErrNotFound
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling batches.Cancel", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return progress, nil
+}
+
+func (s *ServerLogic) validateBatchTask(req domain.RouterRequestAddTask) (*domain.BatchTask, error) {
+	if req.CallbackURL != "" {
+		return nil, fmt.Errorf("%w: callback_url is only supported by the /tasks API", errval.ErrInvalidBatch)
+	}
+
+	payload, taskPriority, err := s.validateTask(req, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.BatchTask{
+		Name:     req.Name,
+		Type:     req.TaskType,
+		Priority: taskPriority,
+		Payload:  payload,
+	}, nil
+}
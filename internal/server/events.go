@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/events"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// SubscribeTaskEvents validates the filter and subscribes to the status changes which match it, the events after lastEventID are replayed first when it's set
+func (s *ServerLogic) SubscribeTaskEvents(ctx context.Context, filter domain.TaskEventsFilter, lastEventID int32) (*events.Subscription, error) {
+	if filter.TaskID != nil {
+		_, err := s.storage.GetTaskByID(ctx, *filter.TaskID)
+		if err != nil {
+			if err == errval.ErrNotFound {
+				slog.Info("task not found with the given id", "id", *filter.TaskID)
+				return nil, err
+			}
+
+			slog.ErrorContext(ctx, "error occurred while calling storage.GetTaskByID", "error", err)
+			return nil, errval.ErrInternal
+		}
+	}
+	if filter.TaskType != "" && !s.taskTypes.IsRegistered(filter.TaskType) {
+		return nil, errval.ErrInvalidTaskType
+	}
+	if filter.NewStatus != "" && !slices.Contains(domain.TaskStatuses, domain.TaskStatus(filter.NewStatus)) {
+		return nil, errval.ErrInvalidTaskStatus
+	}
+
+	return s.events.Subscribe(filter, lastEventID), nil
+}
+
+// TaskWaitUntilTerminal waits for the task to finish, the failed tasks with attempts left are not finished since they are retried by the recovery
+const TaskWaitUntilTerminal = "terminal"
+
+// WaitForTask blocks until the task reaches until or the timeout expires, and returns the task with isReached
+// until is either TaskWaitUntilTerminal or a status, a task which finishes in another status is returned without being reached
+// It's woken by the status changes of the task, so the task is only read once per change
+func (s *ServerLogic) WaitForTask(ctx context.Context, taskID int32, until string, timeout time.Duration) (task *domain.Task, isReached bool, err error) {
+	if until != TaskWaitUntilTerminal && !slices.Contains(domain.TaskStatuses, domain.TaskStatus(until)) {
+		return nil, false, errval.ErrInvalidTaskStatus
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		// The subscription is made before reading the task, so the changes which are made after the read aren't missed
+		subscription := s.events.Subscribe(domain.TaskEventsFilter{TaskID: &taskID}, 0)
+		task, isReached, err = s.waitForTaskEvents(ctx, subscription, taskID, until, deadline)
+		subscription.Close()
+		if !errors.Is(err, events.ErrDropped) {
+			return task, isReached, err
+		}
+	}
+}
+
+// waitForTaskEvents reads the task after each change until it's reached, events.ErrDropped is returned when the subscription is dropped and must be made again
+func (s *ServerLogic) waitForTaskEvents(ctx context.Context, subscription *events.Subscription, taskID int32, until string, deadline time.Time) (*domain.Task, bool, error) {
+	for {
+		task, err := s.storage.GetTaskByID(ctx, taskID)
+		if err != nil {
+			if err == errval.ErrNotFound {
+				slog.Info("task not found with the given id", "id", taskID)
+				return nil, false, err
+			}
+
+			slog.ErrorContext(ctx, "error occurred while calling storage.GetTaskByID", "error", err)
+			return nil, false, errval.ErrInternal
+		}
+		if task.Status == until {
+			return task, true, nil
+		}
+		// A finished task won't reach any other status, so it's returned without waiting until the deadline
+		if task.IsFinished(s.maxAttempts) {
+			return task, until == TaskWaitUntilTerminal, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return task, false, nil
+		}
+
+		event, err := subscription.Next(ctx, remaining)
+		if err != nil {
+			return nil, false, err
+		}
+		if event == nil {
+			return task, false, nil
+		}
+	}
+}
@@ -3,23 +3,47 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
 	"github.com/sf7293/task-manager/internal/domain"
 	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/events"
+	"github.com/sf7293/task-manager/internal/metrics"
+	"github.com/sf7293/task-manager/internal/recovery"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/process"
 	"log/slog"
 )
 
 type ServerLogic struct {
 	storage                     domain.Storage
+	templates                   domain.TemplateStorage
 	queueClient                 domain.Queue
+	dispatcher                  *dispatch.Dispatcher
+	taskTypes                   *process.Registry
+	workflows                   *workflow.Engine
+	batches                     *batch.Tracker
+	webhooks                    domain.WebhookStorage
+	events                      *events.Hub
+	maxAttempts                 int32
 	highPriorityJobsQueueName   string
 	normalPriorityJobsQueueName string
 	lowPriorityJobsQueueName    string
 }
 
-func NewServerLogic(storage domain.Storage, queueClient domain.Queue, highPriorityJobsQueueName, normalJobsQueueName, lowPriorityJobsQueueName string) *ServerLogic {
+func NewServerLogic(storage domain.Storage, templates domain.TemplateStorage, queueClient domain.Queue, taskTypes *process.Registry, workflows *workflow.Engine, batches *batch.Tracker, webhooks domain.WebhookStorage, events *events.Hub, maxAttempts int32, highPriorityJobsQueueName, normalJobsQueueName, lowPriorityJobsQueueName string) *ServerLogic {
 	return &ServerLogic{
 		storage:                     storage,
+		templates:                   templates,
 		queueClient:                 queueClient,
+		dispatcher:                  dispatch.NewDispatcher(queueClient, domain.PriorityQueueNames{High: highPriorityJobsQueueName, Normal: normalJobsQueueName, Low: lowPriorityJobsQueueName}),
+		taskTypes:                   taskTypes,
+		workflows:                   workflows,
+		batches:                     batches,
+		webhooks:                    webhooks,
+		events:                      events,
+		maxAttempts:                 maxAttempts,
 		highPriorityJobsQueueName:   highPriorityJobsQueueName,
 		normalPriorityJobsQueueName: normalJobsQueueName,
 		lowPriorityJobsQueueName:    lowPriorityJobsQueueName,
@@ -27,44 +51,80 @@ func NewServerLogic(storage domain.Storage, queueClient domain.Queue, highPriori
 }
 
 func (s *ServerLogic) AddTask(ctx context.Context, req domain.RouterRequestAddTask) (taskID int32, err error) {
-	marshalledPayload, err := json.Marshal(req.Payload)
+	payload, taskPriority, err := s.validateTask(req, false)
 	if err != nil {
-		slog.Error("error while marshalling request payload", "err", err.Error())
-		return -1, errval.ErrInternal
+		return -1, err
 	}
 
-	taskPriority := string(domain.Normal)
-	if req.TaskPriority != nil {
-		taskPriority = *req.TaskPriority
+	var callback *domain.TaskCallback
+	if req.CallbackURL != "" {
+		callback = &domain.TaskCallback{URL: req.CallbackURL, Events: req.CallbackEvents}
 	}
 
-	task, err := s.storage.InsertTask(ctx, req.Name, req.TaskType, string(domain.Queued), taskPriority, string(marshalledPayload))
+	task, err := s.storage.InsertTask(ctx, req.Name, req.TaskType, string(domain.Queued), taskPriority, payload, req.Caller, callback)
 	if err != nil {
 		slog.ErrorContext(ctx, "error occurred while calling storage.InsertTask", "error", err)
 		return -1, errval.ErrInternal
 	}
+	metrics.TaskCreated(task.Type, task.Priority)
 
-	marshalledTask, err := json.Marshal(task)
+	// The trace context of the request is carried by the message to the worker
+	err = s.dispatcher.Dispatch(ctx, task)
 	if err != nil {
-		slog.Error("There was an error in marshalling newly created task", "error", err.Error())
+		slog.Error("Error occurred while queuing the task to jobs queue", "error", err.Error())
 		// I've ignored returning the error here and just log it because I'll handle re-queueing task again in another worker
-		return task.ID, nil
 	}
 
-	queueName := s.normalPriorityJobsQueueName
-	switch taskPriority {
-	case string(domain.High):
-		queueName = s.highPriorityJobsQueueName
-	case string(domain.Low):
-		queueName = s.lowPriorityJobsQueueName
+	return task.ID, nil
+}
+
+// validateTask validates the payload of the task against its task type and checks that the caller is allowed to create it
+// It returns the unwrapped payload and the priority of the task, which is the default priority of its type when it's not set
+// When allowReferences is set, the payloads which refer to the results of other tasks are validated by the worker after the references are resolved
+func (s *ServerLogic) validateTask(req domain.RouterRequestAddTask, allowReferences bool) (payload json.RawMessage, taskPriority string, err error) {
+	definition, ok := s.taskTypes.Lookup(req.TaskType)
+	if !ok {
+		slog.Error("task type is not registered", "task_type", req.TaskType)
+		return nil, "", errval.ErrInvalidTaskType
 	}
-	err = s.queueClient.PublishMessage(queueName, string(marshalledTask))
+
+	payload, err = domain.UnwrapPayload(req.Payload)
 	if err != nil {
-		slog.Error("Error occurred while queuing marshalled task to jobs queue", "error", err.Error())
-		// Again I've ignored returning the error here and just log it because I'll handle re-queueing task again in another worker
+		return nil, "", fmt.Errorf("%w: %s", errval.ErrInvalidPayload, err.Error())
 	}
 
-	return task.ID, nil
+	taskPriority = string(definition.DefaultPriority)
+	if req.TaskPriority != nil {
+		taskPriority = *req.TaskPriority
+	}
+
+	if allowReferences && workflow.HasReferences(payload) {
+		return payload, taskPriority, nil
+	}
+
+	// The field-level errors of the schema are returned to the client, so they are kept in the chain of the returned error
+	err = s.taskTypes.ValidatePayload(req.TaskType, payload)
+	if err != nil {
+		slog.Info("payload of the task doesn't match its schema", "task_type", req.TaskType, "error", err.Error())
+		return nil, "", fmt.Errorf("%w: %w", errval.ErrInvalidPayload, err)
+	}
+
+	// The payload is decoded only to be validated by its task type, the raw payload is stored as it is
+	decodedPayload, err := definition.DecodePayload(payload)
+	if err != nil {
+		slog.Info("payload of the task is invalid", "task_type", req.TaskType, "error", err.Error())
+		return nil, "", fmt.Errorf("%w: %s", errval.ErrInvalidPayload, err.Error())
+	}
+
+	if definition.Authorize != nil {
+		err = definition.Authorize(req.Caller, decodedPayload)
+		if err != nil {
+			slog.Info("caller is not allowed to create the task", "task_type", req.TaskType, "caller", req.Caller, "error", err.Error())
+			return nil, "", fmt.Errorf("%w: %s", errval.ErrForbidden, err.Error())
+		}
+	}
+
+	return payload, taskPriority, nil
 }
 
 func (s *ServerLogic) GetTaskStatus(ctx context.Context, taskID int32) (status string, err error) {
@@ -82,6 +142,22 @@ func (s *ServerLogic) GetTaskStatus(ctx context.Context, taskID int32) (status s
 	return task.Status, nil
 }
 
+// GetTaskResult returns the result of the task, it's nil when the task has not succeeded or its type doesn't produce a result
+func (s *ServerLogic) GetTaskResult(ctx context.Context, taskID int32) (result json.RawMessage, err error) {
+	task, err := s.storage.GetTaskByID(ctx, taskID)
+	if err != nil {
+		if err == errval.ErrNotFound {
+			slog.Info("task not found with the given id", "id", taskID)
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling storage.GetTaskByID", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return task.Result, nil
+}
+
 func (s *ServerLogic) GetTaskStatusHistory(ctx context.Context, taskID int32) (history []*domain.TaskStatusChangeHistory, err error) {
 	taskHistory, err := s.storage.GetTaskStatusChangeHistory(ctx, taskID)
 	if err != nil {
@@ -96,3 +172,51 @@ func (s *ServerLogic) GetTaskStatusHistory(ctx context.Context, taskID int32) (h
 
 	return taskHistory, nil
 }
+
+// GetTaskPriorityHistory returns the priority changes of the task, an empty list is returned when the priority has never been changed
+func (s *ServerLogic) GetTaskPriorityHistory(ctx context.Context, taskID int32) (history []*domain.TaskPriorityChangeHistory, err error) {
+	taskPriorityHistory, err := s.storage.GetTaskPriorityChangeHistory(ctx, taskID)
+	if err != nil {
+		if err == errval.ErrNotFound {
+			return []*domain.TaskPriorityChangeHistory{}, nil
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling storage.GetTaskPriorityChangeHistory", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return taskPriorityHistory, nil
+}
+
+// GetTaskTypes returns all the registered task types with the JSON schema of their payload
+func (s *ServerLogic) GetTaskTypes() []domain.RouterResponseTaskType {
+	definitions := s.taskTypes.Definitions()
+	taskTypes := make([]domain.RouterResponseTaskType, 0, len(definitions))
+	for _, definition := range definitions {
+		taskTypes = append(taskTypes, domain.RouterResponseTaskType{
+			Name:             definition.Name,
+			DefaultPriority:  string(definition.DefaultPriority),
+			TimeoutInSeconds: int64(definition.Timeout.Seconds()),
+			MaxRetries:       definition.MaxRetries,
+			PayloadSchema:    definition.PayloadSchema,
+		})
+	}
+
+	return taskTypes
+}
+
+// ReconcileQueues compares the queues with the queued tasks, and fixes the drift if requested
+func (s *ServerLogic) ReconcileQueues(ctx context.Context, opts recovery.ReconciliationOptions) (*recovery.ReconciliationReport, error) {
+	queueNames := domain.PriorityQueueNames{
+		High:   s.highPriorityJobsQueueName,
+		Normal: s.normalPriorityJobsQueueName,
+		Low:    s.lowPriorityJobsQueueName,
+	}
+	report, err := recovery.ReconcileQueues(ctx, s.storage, s.queueClient, queueNames, opts)
+	if err != nil {
+		slog.ErrorContext(ctx, "error occurred while calling recovery.ReconcileQueues", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return report, nil
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/pkg/email"
+	"log/slog"
+)
+
+// CreateTemplate stores the first version of a locale of a template
+func (s *ServerLogic) CreateTemplate(ctx context.Context, req domain.RouterRequestAddTemplate) (*domain.Template, error) {
+	template := &domain.Template{
+		TemplateID: req.TemplateID,
+		Locale:     req.Locale,
+		Subject:    req.Subject,
+		Text:       req.Text,
+		HTML:       req.HTML,
+	}
+	err := validateTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	createdTemplate, err := s.templates.CreateTemplate(ctx, template)
+	if err != nil {
+		if errors.Is(err, errval.ErrTemplateExists) {
+			slog.Info("template already exists", "template_id", req.TemplateID, "locale", req.Locale)
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling templates.CreateTemplate", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return createdTemplate, nil
+}
+
+// UpdateTemplate stores a new version of a locale of a template
+func (s *ServerLogic) UpdateTemplate(ctx context.Context, templateID, locale string, req domain.RouterRequestUpdateTemplate) (*domain.Template, error) {
+	template := &domain.Template{
+		TemplateID: templateID,
+		Locale:     locale,
+		Subject:    req.Subject,
+		Text:       req.Text,
+		HTML:       req.HTML,
+	}
+	err := validateTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	insertedTemplate, err := s.templates.AddTemplateVersion(ctx, template)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) || errors.Is(err, errval.ErrTemplateExists) {
+			slog.Info("new version of the template is not stored", "template_id", templateID, "locale", locale, "error", err.Error())
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling templates.AddTemplateVersion", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return insertedTemplate, nil
+}
+
+// GetTemplates returns the latest version of every locale of all templates
+func (s *ServerLogic) GetTemplates(ctx context.Context) ([]*domain.Template, error) {
+	templates, err := s.templates.GetTemplates(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "error occurred while calling templates.GetTemplates", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return templates, nil
+}
+
+// GetTemplateLocales returns the latest version of every locale of a template
+func (s *ServerLogic) GetTemplateLocales(ctx context.Context, templateID string) ([]*domain.Template, error) {
+	templates, err := s.templates.GetTemplateLocales(ctx, templateID)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling templates.GetTemplateLocales", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return templates, nil
+}
+
+// GetTemplate returns a version of a locale of a template, the latest version is returned when the version is not set
+func (s *ServerLogic) GetTemplate(ctx context.Context, templateID, locale string, version *int32) (*domain.Template, error) {
+	var template *domain.Template
+	var err error
+	if version != nil {
+		template, err = s.templates.GetTemplateVersion(ctx, templateID, locale, *version)
+	} else {
+		template, err = s.templates.GetTemplate(ctx, templateID, locale)
+	}
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while getting the template", "template_id", templateID, "locale", locale, "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return template, nil
+}
+
+// GetTemplateVersions returns all versions of a locale of a template, the latest version comes first
+func (s *ServerLogic) GetTemplateVersions(ctx context.Context, templateID, locale string) ([]*domain.Template, error) {
+	templates, err := s.templates.GetTemplateVersions(ctx, templateID, locale)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling templates.GetTemplateVersions", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return templates, nil
+}
+
+// DeleteTemplate deletes all versions of all locales of a template, the queued emails of the template fail permanently
+func (s *ServerLogic) DeleteTemplate(ctx context.Context, templateID string) error {
+	isDeleted, err := s.templates.DeleteTemplate(ctx, templateID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error occurred while calling templates.DeleteTemplate", "error", err)
+		return errval.ErrInternal
+	}
+	if !isDeleted {
+		return errval.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteTemplateLocale deletes all versions of a locale of a template
+func (s *ServerLogic) DeleteTemplateLocale(ctx context.Context, templateID, locale string) error {
+	isDeleted, err := s.templates.DeleteTemplateLocale(ctx, templateID, locale)
+	if err != nil {
+		slog.ErrorContext(ctx, "error occurred while calling templates.DeleteTemplateLocale", "error", err)
+		return errval.ErrInternal
+	}
+	if !isDeleted {
+		return errval.ErrNotFound
+	}
+
+	return nil
+}
+
+// PreviewTemplate renders a template with the same locale fallbacks as send_email, nothing is sent
+func (s *ServerLogic) PreviewTemplate(ctx context.Context, templateID string, req domain.RouterRequestPreviewTemplate) (*domain.RouterResponsePreviewTemplate, error) {
+	template, err := email.FindTemplate(ctx, s.templates, templateID, req.Locale, req.Version)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling email.FindTemplate", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	rendered, err := email.RenderTemplate(template, req.Variables)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.RouterResponsePreviewTemplate{
+		TemplateID: template.TemplateID,
+		Locale:     template.Locale,
+		Version:    template.Version,
+		Subject:    rendered.Subject,
+		Text:       rendered.Text,
+		HTML:       rendered.HTML,
+	}, nil
+}
+
+// validateTemplate rejects the templates which would never be rendered
+func validateTemplate(template *domain.Template) error {
+	if template.Text == "" && template.HTML == "" {
+		return fmt.Errorf("%w: text or html must be set", errval.ErrInvalidTemplate)
+	}
+
+	return email.ParseTemplate(template)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"log/slog"
+)
+
+// ListWebhookDeliveries returns the latest deliveries which match the filter, it returns an empty list when nothing matches
+func (s *ServerLogic) ListWebhookDeliveries(ctx context.Context, filter domain.WebhookDeliveriesFilter) ([]*domain.WebhookDelivery, error) {
+	deliveries, err := s.webhooks.GetFilteredWebhookDeliveries(ctx, filter)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return []*domain.WebhookDelivery{}, nil
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling webhooks.GetFilteredWebhookDeliveries", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return deliveries, nil
+}
+
+// RedeliverWebhookDelivery moves the failed delivery back to pending, so it's posted again by the next iteration of the delivery loop
+func (s *ServerLogic) RedeliverWebhookDelivery(ctx context.Context, deliveryID int32) (*domain.WebhookDelivery, error) {
+	delivery, err := s.webhooks.RedeliverWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			slog.Info("webhook delivery not found with the given id", "id", deliveryID)
+			return nil, errval.ErrNotFound
+		}
+		if errors.Is(err, errval.ErrNotRedeliverable) {
+			slog.Info("webhook delivery is not failed", "id", deliveryID)
+			return nil, errval.ErrNotRedeliverable
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling webhooks.RedeliverWebhookDelivery", "error", err)
+		return nil, errval.ErrInternal
+	}
+	slog.Info("webhook delivery is redelivered", "id", deliveryID, "task_id", delivery.TaskID)
+
+	return delivery, nil
+}
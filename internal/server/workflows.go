@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"log/slog"
+)
+
+// AddWorkflow validates every task of the workflow the same way as AddTask, then stores the workflow and queues its tasks without any dependency
+func (s *ServerLogic) AddWorkflow(ctx context.Context, req domain.RouterRequestAddWorkflow) (*domain.RouterResponseAddWorkflow, error) {
+	if len(req.Tasks) > workflow.MaxTasks {
+		return nil, fmt.Errorf("%w: a workflow can't have more than %d tasks", errval.ErrInvalidWorkflow, workflow.MaxTasks)
+	}
+
+	tasks := make([]*domain.WorkflowTask, 0, len(req.Tasks))
+	for _, taskReq := range req.Tasks {
+		taskReq.Caller = req.Caller
+		if taskReq.CallbackURL != "" {
+			return nil, fmt.Errorf("%w: task %q: callback_url is only supported by the /tasks API", errval.ErrInvalidWorkflow, taskReq.Key)
+		}
+		payload, taskPriority, err := s.validateTask(taskReq.RouterRequestAddTask, true)
+		if err != nil {
+			return nil, fmt.Errorf("task %q: %w", taskReq.Key, err)
+		}
+
+		tasks = append(tasks, &domain.WorkflowTask{
+			Key:       taskReq.Key,
+			Name:      taskReq.Name,
+			Type:      taskReq.TaskType,
+			Priority:  taskPriority,
+			Payload:   payload,
+			DependsOn: taskReq.DependsOn,
+		})
+	}
+
+	createdWorkflow, createdTasks, err := s.workflows.Start(ctx, &domain.Workflow{
+		Name:          req.Name,
+		FailurePolicy: domain.WorkflowFailurePolicy(req.FailurePolicy),
+		Caller:        req.Caller,
+	}, tasks)
+	if err != nil {
+		if errors.Is(err, errval.ErrInvalidWorkflow) {
+			slog.Info("workflow is invalid", "error", err.Error())
+			return nil, err
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling workflows.Start", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	taskIDs := make(map[string]int32, len(createdTasks))
+	for _, task := range createdTasks {
+		taskIDs[task.WorkflowKey] = task.ID
+	}
+
+	return &domain.RouterResponseAddWorkflow{WorkflowID: createdWorkflow.ID, TaskIDs: taskIDs}, nil
+}
+
+// GetWorkflow returns the aggregated status of the workflow and its tasks
+func (s *ServerLogic) GetWorkflow(ctx context.Context, workflowID int32) (*workflow.Report, error) {
+	report, err := s.workflows.Report(ctx, workflowID)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			slog.Info("workflow not found with the given id", "id", workflowID)
+			return nil, errval.ErrNotFound
+		}
+
+		slog.ErrorContext(ctx, "error occurred while calling workflows.Report", "error", err)
+		return nil, errval.ErrInternal
+	}
+
+	return report, nil
+}
@@ -0,0 +1,174 @@
+package subtask
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/metrics"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/process"
+	"log/slog"
+	"slices"
+)
+
+// Manager spawns the child tasks of the running tasks, and finishes the parents which wait for their children when their last child finishes
+type Manager struct {
+	storage     domain.ChildTaskStorage
+	dispatcher  *dispatch.Dispatcher
+	taskTypes   *process.Registry
+	workflows   *workflow.Engine
+	batches     *batch.Tracker
+	maxAttempts int32
+}
+
+// NewManager returns the child task manager, maxAttempts decides when a failed child is finished
+// The workflows and the batches of the parents are moved forward when the parents are finished
+func NewManager(storage domain.ChildTaskStorage, dispatcher *dispatch.Dispatcher, taskTypes *process.Registry, workflows *workflow.Engine, batches *batch.Tracker, maxAttempts int32) *Manager {
+	return &Manager{
+		storage:     storage,
+		dispatcher:  dispatcher,
+		taskTypes:   taskTypes,
+		workflows:   workflows,
+		batches:     batches,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Spawn validates the child the same way as the /tasks API on behalf of the caller of its parent, then stores and queues it
+// The invalid children are returned as permanent errors, since retrying the execution of the parent won't fix them
+func (m *Manager) Spawn(ctx context.Context, parent *process.TaskContext, child process.ChildTask) (int32, error) {
+	payload, err := m.validate(parent.Caller, child)
+	if err != nil {
+		return 0, process.Permanent(err)
+	}
+
+	priority := child.Priority
+	if priority == "" {
+		definition, _ := m.taskTypes.Lookup(child.Type)
+		priority = definition.DefaultPriority
+	}
+
+	task, isCreated, err := m.storage.InsertChildTask(ctx, parent.TaskID, child.Key, child.Name, child.Type, string(priority), payload, parent.Caller)
+	if err != nil {
+		return 0, err
+	}
+	if !isCreated {
+		slog.Info("Child task has already been spawned with the same key", "task_id", task.ID, "parent_id", parent.TaskID, "child_key", child.Key)
+		return task.ID, nil
+	}
+	slog.Info("Child task is spawned", "task_id", task.ID, "parent_id", parent.TaskID, "task_type", task.Type)
+	metrics.TaskCreated(task.Type, task.Priority)
+
+	err = m.dispatcher.Dispatch(ctx, task)
+	if err != nil {
+		// The task is left queued, so it's re-published by the recovery after RECOVERY_QUEUED_AFTER_SECONDS
+		slog.Error("Error occurred while queuing the child task", "task_id", task.ID, "error", err.Error())
+	}
+
+	return task.ID, nil
+}
+
+// TaskWaiting finishes the task which has just been moved to waiting, when all its children have already finished
+func (m *Manager) TaskWaiting(ctx context.Context, task *domain.Task) error {
+	return m.finishWaitingTask(ctx, task.ID)
+}
+
+// TaskFinished finishes the waiting parent of the task when it's the last unfinished child, the task must have its status and attempts after the finished execution
+// It's a no-op for the tasks which are not spawned by another task
+func (m *Manager) TaskFinished(ctx context.Context, task *domain.Task) error {
+	if task.ParentID == nil || !task.IsFinished(m.maxAttempts) {
+		return nil
+	}
+
+	return m.finishWaitingTask(ctx, *task.ParentID)
+}
+
+// RecoverStalled finishes the waiting tasks whose children are all finished, but their worker has stopped before finishing the parent
+// It returns the number of the finished tasks
+func (m *Manager) RecoverStalled(ctx context.Context, passedSeconds, limit int32) (int, error) {
+	tasks, err := m.storage.GetFinishableWaitingTasks(ctx, m.maxAttempts, passedSeconds, limit)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	recoveredCount := 0
+	var recoveryErrs []error
+	for _, task := range tasks {
+		slog.Warn("Children of the waiting task are finished but it's still waiting, finishing it", "task_id", task.ID)
+		err = m.finishWaitingTask(ctx, task.ID)
+		if err != nil {
+			recoveryErrs = append(recoveryErrs, err)
+			continue
+		}
+		recoveredCount++
+	}
+
+	return recoveredCount, errors.Join(recoveryErrs...)
+}
+
+// finishWaitingTask finishes the waiting task if all its children are finished, the storage makes sure that it's finished only once
+// The finished task is handled like any other finished task, so its workflow, its batch and its own parent are moved forward too
+func (m *Manager) finishWaitingTask(ctx context.Context, taskID int32) error {
+	task, err := m.storage.FinishWaitingTask(ctx, taskID, m.maxAttempts)
+	if err != nil {
+		return err
+	}
+	if task == nil {
+		return nil
+	}
+	slog.Info(fmt.Sprintf("Task state is changed from 'waiting' to '%s' after its children are finished", task.Status), "task_id", task.ID)
+
+	return errors.Join(
+		m.workflows.TaskFinished(ctx, task),
+		m.batches.TaskFinished(ctx, task),
+		m.TaskFinished(ctx, task),
+	)
+}
+
+// validate checks the child against its task type and the caller of its parent, and returns its encoded payload
+func (m *Manager) validate(caller string, child process.ChildTask) (json.RawMessage, error) {
+	definition, ok := m.taskTypes.Lookup(child.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errval.ErrInvalidTaskType, child.Type)
+	}
+	if child.Priority != "" && !slices.Contains(domain.TaskPriorities, child.Priority) {
+		return nil, fmt.Errorf("priority of the child task is invalid: %s", child.Priority)
+	}
+
+	encodedPayload, err := json.Marshal(child.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errval.ErrInvalidPayload, err.Error())
+	}
+	payload, err := domain.UnwrapPayload(encodedPayload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errval.ErrInvalidPayload, err.Error())
+	}
+
+	err = m.taskTypes.ValidatePayload(child.Type, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errval.ErrInvalidPayload, err)
+	}
+
+	decodedPayload, err := definition.DecodePayload(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errval.ErrInvalidPayload, err.Error())
+	}
+
+	if definition.Authorize != nil {
+		err = definition.Authorize(caller, decodedPayload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errval.ErrForbidden, err.Error())
+		}
+	}
+
+	return payload, nil
+}
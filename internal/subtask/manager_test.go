@@ -0,0 +1,252 @@
+package subtask
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sf7293/task-manager/internal/batch"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/workflow"
+	"github.com/sf7293/task-manager/pkg/process"
+	"testing"
+)
+
+const testMaxAttempts = 3
+
+// fakeChildTaskStorage keeps the children by their parent and key, and the waiting tasks with the status they finish with
+// The methods which are not used by the manager panic through the embedded nil interface
+type fakeChildTaskStorage struct {
+	domain.ChildTaskStorage
+	nextID   int32
+	children map[int32]map[string]*domain.Task
+	// waiting are the waiting tasks whose children are all finished, each of them is finished only once like the storage does
+	waiting    map[int32]*domain.Task
+	finished   []int32
+	finishable []*domain.Task
+}
+
+func newFakeChildTaskStorage() *fakeChildTaskStorage {
+	return &fakeChildTaskStorage{nextID: 100, children: map[int32]map[string]*domain.Task{}, waiting: map[int32]*domain.Task{}}
+}
+
+func (f *fakeChildTaskStorage) InsertChildTask(ctx context.Context, parentID int32, key, name, taskType, priority string, payload json.RawMessage, caller string) (*domain.Task, bool, error) {
+	if f.children[parentID] == nil {
+		f.children[parentID] = map[string]*domain.Task{}
+	}
+	if child, ok := f.children[parentID][key]; ok {
+		return child, false, nil
+	}
+
+	f.nextID++
+	child := &domain.Task{ID: f.nextID, Type: taskType, Status: string(domain.Queued), Priority: priority, PayLoad: payload, ParentID: &parentID, ChildKey: key, Caller: caller}
+	f.children[parentID][key] = child
+
+	return child, true, nil
+}
+
+func (f *fakeChildTaskStorage) FinishWaitingTask(ctx context.Context, taskID int32, maxAttempts int32) (*domain.Task, error) {
+	task, ok := f.waiting[taskID]
+	if !ok {
+		return nil, nil
+	}
+	delete(f.waiting, taskID)
+	f.finished = append(f.finished, taskID)
+
+	return task, nil
+}
+
+func (f *fakeChildTaskStorage) GetFinishableWaitingTasks(ctx context.Context, maxAttempts, passedSeconds, limit int32) ([]*domain.Task, error) {
+	if len(f.finishable) == 0 {
+		return nil, errval.ErrNotFound
+	}
+
+	return f.finishable, nil
+}
+
+func (f *fakeChildTaskStorage) childrenCount() int {
+	count := 0
+	for _, children := range f.children {
+		count += len(children)
+	}
+
+	return count
+}
+
+// fakeQueue records the IDs of the published tasks
+type fakeQueue struct {
+	domain.Queue
+	published []int32
+}
+
+func (f *fakeQueue) PublishMessage(queueName string, message domain.QueueMessage) error {
+	decoded, err := dispatch.Decode(string(message.Body))
+	if err != nil {
+		return err
+	}
+	f.published = append(f.published, decoded.TaskID)
+
+	return nil
+}
+
+type echoPayload struct {
+	Message string `json:"message"`
+}
+
+func (p *echoPayload) Validate() error {
+	if p.Message == "" {
+		return errors.New("message must not be empty")
+	}
+
+	return nil
+}
+
+func newTestManager(t *testing.T, storage *fakeChildTaskStorage) (*Manager, *fakeQueue) {
+	taskTypes := process.NewRegistry()
+	err := taskTypes.Register(process.Definition{
+		Name:          "echo",
+		PayloadSchema: json.RawMessage(`{"type":"object","properties":{"message":{"type":"string"}},"required":["message"]}`),
+		NewPayload: func() process.Payload {
+			return &echoPayload{}
+		},
+		Factory: func() process.Process {
+			return nil
+		},
+		Authorize: func(caller string, payload process.Payload) error {
+			if caller != "reporting" {
+				return errors.New("caller is not allowed")
+			}
+
+			return nil
+		},
+		DefaultPriority: domain.Normal,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	queue := &fakeQueue{}
+	dispatcher := dispatch.NewDispatcher(queue, domain.PriorityQueueNames{High: "high", Normal: "normal", Low: "low"})
+	// The tasks of the tests don't belong to any workflow or batch, so their storages are never called
+	workflows := workflow.NewEngine(struct{ domain.WorkflowStorage }{}, dispatcher, testMaxAttempts)
+	batches := batch.NewTracker(struct{ domain.BatchStorage }{}, dispatcher, testMaxAttempts)
+
+	return NewManager(storage, dispatcher, taskTypes, workflows, batches, testMaxAttempts), queue
+}
+
+// TestSpawn_InvalidChild: the children of unknown types, with invalid payloads or refused for the caller of the parent fail permanently and are not stored
+func TestSpawn_InvalidChild(t *testing.T) {
+	testCases := map[string]struct {
+		caller string
+		child  process.ChildTask
+		err    error
+	}{
+		"unknown type":    {caller: "reporting", child: process.ChildTask{Key: "a", Type: "unknown", Payload: echoPayload{Message: "hi"}}, err: errval.ErrInvalidTaskType},
+		"invalid payload": {caller: "reporting", child: process.ChildTask{Key: "a", Type: "echo", Payload: echoPayload{}}, err: errval.ErrInvalidPayload},
+		"refused caller":  {caller: "anonymous", child: process.ChildTask{Key: "a", Type: "echo", Payload: echoPayload{Message: "hi"}}, err: errval.ErrForbidden},
+	}
+	for name, testCase := range testCases {
+		storage := newFakeChildTaskStorage()
+		manager, queue := newTestManager(t, storage)
+
+		_, err := manager.Spawn(context.Background(), &process.TaskContext{TaskID: 1, Caller: testCase.caller}, testCase.child)
+		if !process.IsPermanent(err) || !errors.Is(err, testCase.err) {
+			t.Errorf("%s: expected a permanent %v, got %v", name, testCase.err, err)
+		}
+		if storage.childrenCount() != 0 || len(queue.published) != 0 {
+			t.Errorf("%s: expected no child to be stored or queued, got %d stored and %v queued", name, storage.childrenCount(), queue.published)
+		}
+	}
+}
+
+// TestSpawn_DedupByKey: spawning the same key again on a retry of the parent returns the existing child without queuing it again
+func TestSpawn_DedupByKey(t *testing.T) {
+	storage := newFakeChildTaskStorage()
+	manager, queue := newTestManager(t, storage)
+	parent := &process.TaskContext{TaskID: 1, Caller: "reporting"}
+	child := process.ChildTask{Key: "part-1", Name: "part 1", Type: "echo", Payload: echoPayload{Message: "hi"}}
+
+	firstID, err := manager.Spawn(context.Background(), parent, child)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	secondID, err := manager.Spawn(context.Background(), parent, child)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if firstID != secondID || storage.childrenCount() != 1 {
+		t.Fatalf("expected the same child to be returned, got %d and %d with %d stored", firstID, secondID, storage.childrenCount())
+	}
+	if len(queue.published) != 1 || queue.published[0] != firstID {
+		t.Fatalf("expected the child to be queued once, got %v", queue.published)
+	}
+	if storage.children[1]["part-1"].Priority != string(domain.Normal) {
+		t.Fatalf("expected the default priority of the task type, got %s", storage.children[1]["part-1"].Priority)
+	}
+}
+
+// TestTaskFinished_FinishesParent: the waiting parent is finished by its succeeded and its exhausted failed children, and it finishes its own parent too
+func TestTaskFinished_FinishesParent(t *testing.T) {
+	parentID := int32(1)
+	grandparentID := int32(2)
+	testCases := map[string]struct {
+		child            *domain.Task
+		expectedFinished []int32
+	}{
+		"succeeded child":          {child: &domain.Task{ID: 10, Status: string(domain.Succeeded), Attempts: 1, ParentID: &parentID}, expectedFinished: []int32{1, 2}},
+		"exhausted failed child":   {child: &domain.Task{ID: 10, Status: string(domain.Failed), Attempts: testMaxAttempts, ParentID: &parentID}, expectedFinished: []int32{1, 2}},
+		"retryable failed child":   {child: &domain.Task{ID: 10, Status: string(domain.Failed), Attempts: 1, ParentID: &parentID}, expectedFinished: nil},
+		"running child":            {child: &domain.Task{ID: 10, Status: string(domain.Running), Attempts: 1, ParentID: &parentID}, expectedFinished: nil},
+		"child without any parent": {child: &domain.Task{ID: 10, Status: string(domain.Succeeded), Attempts: 1}, expectedFinished: nil},
+	}
+	for name, testCase := range testCases {
+		storage := newFakeChildTaskStorage()
+		storage.waiting[parentID] = &domain.Task{ID: parentID, Status: string(domain.Failed), Attempts: testMaxAttempts, ParentID: &grandparentID}
+		storage.waiting[grandparentID] = &domain.Task{ID: grandparentID, Status: string(domain.Failed), Attempts: testMaxAttempts}
+		manager, _ := newTestManager(t, storage)
+
+		err := manager.TaskFinished(context.Background(), testCase.child)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		if len(storage.finished) != len(testCase.expectedFinished) {
+			t.Fatalf("%s: expected %v to be finished, got %v", name, testCase.expectedFinished, storage.finished)
+		}
+		for i, taskID := range testCase.expectedFinished {
+			if storage.finished[i] != taskID {
+				t.Fatalf("%s: expected %v to be finished, got %v", name, testCase.expectedFinished, storage.finished)
+			}
+		}
+
+		// The parent is only finished once, even when its children are reported more than once
+		err = manager.TaskFinished(context.Background(), testCase.child)
+		if err != nil || len(storage.finished) != len(testCase.expectedFinished) {
+			t.Fatalf("%s: expected the parents not to be finished again, got %v and %v", name, err, storage.finished)
+		}
+	}
+}
+
+// TestRecoverStalled: the waiting tasks whose children are all finished are finished, and only the ones which are actually finished are counted
+func TestRecoverStalled(t *testing.T) {
+	storage := newFakeChildTaskStorage()
+	manager, _ := newTestManager(t, storage)
+
+	recoveredCount, err := manager.RecoverStalled(context.Background(), 60, 100)
+	if err != nil || recoveredCount != 0 {
+		t.Fatalf("expected nothing to be recovered, got %d and %v", recoveredCount, err)
+	}
+
+	storage.waiting[1] = &domain.Task{ID: 1, Status: string(domain.Succeeded), Attempts: 1}
+	storage.waiting[2] = &domain.Task{ID: 2, Status: string(domain.Failed), Attempts: testMaxAttempts}
+	storage.finishable = []*domain.Task{{ID: 1, Status: string(domain.Waiting)}, {ID: 2, Status: string(domain.Waiting)}}
+
+	recoveredCount, err = manager.RecoverStalled(context.Background(), 60, 100)
+	if err != nil || recoveredCount != 2 {
+		t.Fatalf("expected 2 recovered tasks, got %d and %v", recoveredCount, err)
+	}
+	if len(storage.finished) != 2 || storage.finished[0] != 1 || storage.finished[1] != 2 {
+		t.Fatalf("expected tasks 1 and 2 to be finished, got %v", storage.finished)
+	}
+}
@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// EventTaskStatusChanged is the type of the events which are posted for the status changes of the tasks
+	EventTaskStatusChanged = "task.status_changed"
+
+	// The headers of the callbacks, the receivers verify the signature with the shared secret before trusting the body
+	HeaderID        = "X-Webhook-ID"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+
+	// maxErrorBodyBytes caps the part of the response body which is kept in the last error of a failed attempt
+	maxErrorBodyBytes = 256
+)
+
+// Event is the JSON body of a callback, ID is the same for all the attempts of a delivery so the receivers are able to drop the duplicates
+type Event struct {
+	ID              int32  `json:"id"`
+	Type            string `json:"type"`
+	TaskID          int32  `json:"task_id"`
+	OldStatus       string `json:"old_status"`
+	NewStatus       string `json:"new_status"`
+	OccurredAtStamp int64  `json:"occurred_at_stamp"`
+}
+
+// Settings controls the delivery of the callbacks
+type Settings struct {
+	// SigningSecret is the key of the HMAC-SHA256 signature of the callbacks
+	SigningSecret string
+	Interval      time.Duration
+	// BatchSize is the number of the deliveries which are sent concurrently in each iteration
+	BatchSize   int32
+	MaxAttempts int32
+	// InitialBackoff is the delay after the first failed attempt, it's doubled after each attempt up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+}
+
+// Deliverer periodically posts the pending deliveries of the webhook outbox to the callback URLs of their tasks, and retries the failed attempts with backoff
+// Concurrent deliverers are safe, since each delivery is claimed by one of them for a lease which is longer than the timeout of the requests
+type Deliverer struct {
+	storage  domain.WebhookStorage
+	client   *http.Client
+	settings Settings
+}
+
+func NewDeliverer(storage domain.WebhookStorage, settings Settings) *Deliverer {
+	return &Deliverer{
+		storage: storage,
+		client: &http.Client{
+			Timeout: settings.Timeout,
+			// The redirects are not followed, since the signature is only meant for the configured URL
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		settings: settings,
+	}
+}
+
+// Run blocks and sends the pending deliveries every interval until the context is cancelled
+func (d *Deliverer) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.settings.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Webhook delivery loop is stopped")
+			return
+		case <-ticker.C:
+			d.DeliverPending(ctx)
+		}
+	}
+}
+
+// DeliverPending sends one batch of the due deliveries concurrently, and returns the number of the delivered ones
+func (d *Deliverer) DeliverPending(ctx context.Context) (deliveredCount int) {
+	// The lease outlives the timeout of the request, so a delivery is only claimed again when its sender has died
+	leaseSeconds := int32(2*d.settings.Timeout/time.Second) + 1
+	deliveries, err := d.storage.ClaimWebhookDeliveries(ctx, leaseSeconds, d.settings.BatchSize)
+	if err != nil {
+		if !errors.Is(err, errval.ErrNotFound) {
+			slog.Error("Error occurred while claiming webhook deliveries", "error", err.Error())
+		}
+
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery *domain.WebhookDelivery) {
+			defer wg.Done()
+			if d.deliver(ctx, delivery) {
+				mu.Lock()
+				deliveredCount++
+				mu.Unlock()
+			}
+		}(delivery)
+	}
+	wg.Wait()
+
+	return deliveredCount
+}
+
+// deliver sends one attempt of the claimed delivery and records its outcome
+func (d *Deliverer) deliver(ctx context.Context, delivery *domain.WebhookDelivery) bool {
+	responseStatus, err := d.send(ctx, delivery)
+	if err == nil {
+		isUpdated, err := d.storage.MarkWebhookDeliveryDelivered(ctx, delivery, responseStatus)
+		if err != nil {
+			// The delivery is sent again after its lease, so the receivers must drop the duplicates by the id of the event
+			slog.Error("Error occurred while marking the webhook delivery as delivered", "delivery_id", delivery.ID, "error", err.Error())
+			return false
+		}
+		if isUpdated {
+			slog.Info("Webhook is delivered", "delivery_id", delivery.ID, "task_id", delivery.TaskID, "attempts", delivery.Attempts)
+		}
+
+		return isUpdated
+	}
+
+	isFinal := delivery.Attempts >= d.settings.MaxAttempts
+	retryAfter := Backoff(delivery.Attempts, d.settings.InitialBackoff, d.settings.MaxBackoff)
+	slog.Warn("Webhook delivery attempt has failed", "delivery_id", delivery.ID, "task_id", delivery.TaskID, "attempts", delivery.Attempts, "is_final", isFinal, "error", err.Error())
+
+	_, err = d.storage.MarkWebhookDeliveryAttemptFailed(ctx, delivery, isFinal, int32(retryAfter/time.Second), responseStatus, err.Error())
+	if err != nil {
+		slog.Error("Error occurred while recording the failed attempt of the webhook delivery", "delivery_id", delivery.ID, "error", err.Error())
+	}
+
+	return false
+}
+
+// send posts the signed event of the delivery, responseStatus is zero when no response has been received
+func (d *Deliverer) send(ctx context.Context, delivery *domain.WebhookDelivery) (responseStatus int, err error) {
+	body, err := json.Marshal(NewEvent(delivery))
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(HeaderID, strconv.FormatInt(int64(delivery.ID), 10))
+	request.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(HeaderSignature, Sign(d.settings.SigningSecret, timestamp, body))
+
+	response, err := d.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodyBytes))
+		return response.StatusCode, fmt.Errorf("unexpected response status %d: %s", response.StatusCode, responseBody)
+	}
+	// The body is drained, so the connection is reused
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	return response.StatusCode, nil
+}
+
+// NewEvent returns the event which is posted for the delivery
+func NewEvent(delivery *domain.WebhookDelivery) Event {
+	return Event{
+		ID:              delivery.ID,
+		Type:            EventTaskStatusChanged,
+		TaskID:          delivery.TaskID,
+		OldStatus:       delivery.OldStatus,
+		NewStatus:       delivery.NewStatus,
+		OccurredAtStamp: delivery.CreatedAtStamp,
+	}
+}
+
+// Sign returns the X-Webhook-Signature of the body, which is the hex encoded HMAC-SHA256 of "{timestamp}.{body}" with the sha256= prefix
+// The timestamp is signed too, so the receivers are able to reject the old callbacks which are replayed
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns the delay after the given number of failed attempts, it's doubled after each attempt up to maxBackoff
+func Backoff(attempts int32, initialBackoff, maxBackoff time.Duration) time.Duration {
+	backoff := initialBackoff
+	for i := int32(1); i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff
+}
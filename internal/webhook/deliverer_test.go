@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage hands out the deliveries once, and records the outcome of their attempts
+type fakeStorage struct {
+	domain.WebhookStorage
+	mu         sync.Mutex
+	deliveries []*domain.WebhookDelivery
+	delivered  map[int32]int
+	failed     map[int32]bool
+	retryAfter map[int32]int32
+}
+
+func newFakeStorage(deliveries ...*domain.WebhookDelivery) *fakeStorage {
+	return &fakeStorage{deliveries: deliveries, delivered: map[int32]int{}, failed: map[int32]bool{}, retryAfter: map[int32]int32{}}
+}
+
+func (f *fakeStorage) ClaimWebhookDeliveries(ctx context.Context, leaseSeconds, limit int32) ([]*domain.WebhookDelivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.deliveries) == 0 {
+		return nil, errval.ErrNotFound
+	}
+	deliveries := f.deliveries
+	f.deliveries = nil
+
+	return deliveries, nil
+}
+
+func (f *fakeStorage) MarkWebhookDeliveryDelivered(ctx context.Context, delivery *domain.WebhookDelivery, responseStatus int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delivered[delivery.ID] = responseStatus
+
+	return true, nil
+}
+
+func (f *fakeStorage) MarkWebhookDeliveryAttemptFailed(ctx context.Context, delivery *domain.WebhookDelivery, isFinal bool, retryAfterSeconds int32, responseStatus int, errMessage string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failed[delivery.ID] = isFinal
+	f.retryAfter[delivery.ID] = retryAfterSeconds
+
+	return true, nil
+}
+
+func testSettings() Settings {
+	return Settings{
+		SigningSecret:  "secret",
+		Interval:       time.Second,
+		BatchSize:      10,
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Second,
+		MaxBackoff:     time.Minute,
+		Timeout:        time.Second,
+	}
+}
+
+// TestSign: the signature is the HMAC-SHA256 of the timestamp and the body, so changing either of them changes it
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	signature := Sign("secret", 1700000000, body)
+	if signature != "sha256=3dd1b9aef568d75f6790a84bd2e5dfa1f44409eef3cbdbd3f10b837376100c11" {
+		t.Fatalf("unexpected signature %s", signature)
+	}
+	if signature == Sign("secret", 1700000001, body) {
+		t.Fatalf("expected a different signature for a different timestamp")
+	}
+	if signature == Sign("other", 1700000000, body) {
+		t.Fatalf("expected a different signature for a different secret")
+	}
+}
+
+// TestBackoff: the delay is doubled after each failed attempt and is capped by the max backoff
+func TestBackoff(t *testing.T) {
+	cases := map[int32]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: time.Minute, 30: time.Minute}
+	for attempts, expectedBackoff := range cases {
+		backoff := Backoff(attempts, 10*time.Second, time.Minute)
+		if backoff != expectedBackoff {
+			t.Fatalf("expected %s after %d attempts, got %s", expectedBackoff, attempts, backoff)
+		}
+	}
+}
+
+// TestDeliverPending_Delivered: a 2xx response marks the delivery as delivered, and the request is signed
+func TestDeliverPending_Delivered(t *testing.T) {
+	var receivedEvent Event
+	var isSignatureValid bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+		isSignatureValid = r.Header.Get(HeaderSignature) == Sign("secret", timestamp, body)
+		_ = json.Unmarshal(body, &receivedEvent)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	storage := newFakeStorage(&domain.WebhookDelivery{ID: 7, TaskID: 3, URL: receiver.URL, OldStatus: "running", NewStatus: "succeeded", Attempts: 1})
+	deliveredCount := NewDeliverer(storage, testSettings()).DeliverPending(context.Background())
+
+	if deliveredCount != 1 || storage.delivered[7] != http.StatusNoContent {
+		t.Fatalf("expected the delivery to be delivered, got %d delivered and %v", deliveredCount, storage.delivered)
+	}
+	if !isSignatureValid {
+		t.Fatalf("expected a valid signature")
+	}
+	if receivedEvent.ID != 7 || receivedEvent.Type != EventTaskStatusChanged || receivedEvent.TaskID != 3 || receivedEvent.NewStatus != "succeeded" {
+		t.Fatalf("unexpected event %#v", receivedEvent)
+	}
+}
+
+// TestDeliverPending_Failed: a non 2xx response is retried with backoff, and the delivery is failed after its last attempt
+func TestDeliverPending_Failed(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	storage := newFakeStorage(
+		&domain.WebhookDelivery{ID: 1, URL: receiver.URL, Attempts: 2},
+		&domain.WebhookDelivery{ID: 2, URL: receiver.URL, Attempts: 3},
+	)
+	deliveredCount := NewDeliverer(storage, testSettings()).DeliverPending(context.Background())
+
+	if deliveredCount != 0 {
+		t.Fatalf("expected nothing to be delivered, got %d", deliveredCount)
+	}
+	if isFinal, ok := storage.failed[1]; !ok || isFinal || storage.retryAfter[1] != 20 {
+		t.Fatalf("expected the first delivery to be retried after 20 seconds, got %v and %v", storage.failed, storage.retryAfter)
+	}
+	if isFinal := storage.failed[2]; !isFinal {
+		t.Fatalf("expected the second delivery to be failed, got %v", storage.failed)
+	}
+}
@@ -0,0 +1,151 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"github.com/sf7293/task-manager/internal/dispatch"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/internal/metrics"
+	"log/slog"
+)
+
+// Engine creates the workflows, and moves them forward when their tasks finish
+type Engine struct {
+	storage     domain.WorkflowStorage
+	dispatcher  *dispatch.Dispatcher
+	maxAttempts int32
+}
+
+// NewEngine returns the workflow engine, maxAttempts decides when a failed task is finished
+func NewEngine(storage domain.WorkflowStorage, dispatcher *dispatch.Dispatcher, maxAttempts int32) *Engine {
+	return &Engine{
+		storage:     storage,
+		dispatcher:  dispatcher,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Start stores the workflow and queues its tasks which have no dependency
+func (e *Engine) Start(ctx context.Context, workflow *domain.Workflow, tasks []*domain.WorkflowTask) (*domain.Workflow, []*domain.Task, error) {
+	err := Validate(tasks)
+	if err != nil {
+		return nil, nil, err
+	}
+	if workflow.FailurePolicy == "" {
+		workflow.FailurePolicy = domain.FailFast
+	}
+
+	insertedWorkflow, insertedTasks, err := e.storage.InsertWorkflow(ctx, workflow, tasks)
+	if err != nil {
+		return nil, nil, err
+	}
+	slog.Info("Workflow is created", "workflow_id", insertedWorkflow.ID, "tasks_count", len(insertedTasks), "failure_policy", insertedWorkflow.FailurePolicy)
+
+	for _, task := range insertedTasks {
+		metrics.TaskCreated(task.Type, task.Priority)
+		if task.Status == string(domain.Queued) {
+			e.dispatch(ctx, task)
+		}
+	}
+
+	return insertedWorkflow, insertedTasks, nil
+}
+
+// TaskFinished moves the workflow of the task forward, the task must have its status and attempts after the finished execution
+// It's a no-op for the tasks which are not a part of any workflow
+func (e *Engine) TaskFinished(ctx context.Context, task *domain.Task) error {
+	if task.WorkflowID == nil {
+		return nil
+	}
+
+	if !task.IsFinished(e.maxAttempts) {
+		if task.Status == string(domain.Failed) {
+			slog.Info("Failed task of the workflow still has attempts left", "task_id", task.ID, "workflow_id", *task.WorkflowID, "attempts", task.Attempts)
+		}
+		return nil
+	}
+
+	switch domain.TaskStatus(task.Status) {
+	case domain.Succeeded:
+		return e.queueReadyDependents(ctx, task, false)
+	case domain.Failed:
+	default:
+		return nil
+	}
+
+	workflow, err := e.storage.GetWorkflowByID(ctx, *task.WorkflowID)
+	if err != nil {
+		return err
+	}
+	slog.Warn("Task of the workflow has failed", "task_id", task.ID, "workflow_id", workflow.ID, "failure_policy", workflow.FailurePolicy)
+
+	switch workflow.FailurePolicy {
+	case domain.Continue:
+		return e.queueReadyDependents(ctx, task, true)
+	case domain.SkipDependents:
+		skippedTasks, err := e.storage.SkipDependentTasks(ctx, task.ID)
+		if err != nil {
+			return err
+		}
+		slog.Info("Dependents of the failed task are skipped", "task_id", task.ID, "workflow_id", workflow.ID, "skipped_tasks_count", len(skippedTasks))
+	default:
+		cancelledTasks, err := e.storage.CancelWorkflowTasks(ctx, workflow.ID)
+		if err != nil {
+			return err
+		}
+		slog.Info("Tasks of the workflow are cancelled", "task_id", task.ID, "workflow_id", workflow.ID, "cancelled_tasks_count", len(cancelledTasks))
+	}
+
+	return nil
+}
+
+// RecoverStalled moves forward the workflows whose engine has stopped before handling a finished task, like a worker which dies right after a task succeeds
+// It returns the number of the finished tasks which are handled
+func (e *Engine) RecoverStalled(ctx context.Context, passedSeconds, limit int32) (int, error) {
+	tasks, err := e.storage.GetStalledWorkflowParentTasks(ctx, e.maxAttempts, passedSeconds, limit)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	// A task which can't be handled doesn't stop the others, the errors are returned together
+	recoveredCount := 0
+	var taskErrs []error
+	for _, task := range tasks {
+		slog.Warn("Workflow of the finished task has stalled, moving it forward", "task_id", task.ID, "workflow_id", *task.WorkflowID, "task_status", task.Status)
+		err = e.TaskFinished(ctx, task)
+		if err != nil {
+			taskErrs = append(taskErrs, err)
+			continue
+		}
+		recoveredCount++
+	}
+
+	return recoveredCount, errors.Join(taskErrs...)
+}
+
+func (e *Engine) queueReadyDependents(ctx context.Context, task *domain.Task, failedIsFinished bool) error {
+	queuedTasks, err := e.storage.QueueReadyDependentTasks(ctx, task.ID, failedIsFinished, e.maxAttempts)
+	if err != nil {
+		return err
+	}
+
+	for _, queuedTask := range queuedTasks {
+		slog.Info("Dependent task of the workflow is queued", "task_id", queuedTask.ID, "parent_task_id", task.ID, "workflow_id", *task.WorkflowID)
+		e.dispatch(ctx, queuedTask)
+	}
+
+	return nil
+}
+
+func (e *Engine) dispatch(ctx context.Context, task *domain.Task) {
+	err := e.dispatcher.Dispatch(ctx, task)
+	if err != nil {
+		// The task is left queued, so it's re-published by the recovery after RECOVERY_QUEUED_AFTER_SECONDS
+		slog.Error("Error occurred while queuing the task of the workflow", "task_id", task.ID, "error", err.Error())
+	}
+}
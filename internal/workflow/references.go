@@ -0,0 +1,272 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// referencePattern matches the references of a payload to the results of the parent tasks, e.g. {{ tasks.extract.result.rows }}
+// The path after result is made of the keys of the objects and the indexes of the arrays
+var referencePattern = regexp.MustCompile(`\{\{\s*tasks\.([^.\s{}]+)\.result((?:\.[^.\s{}]+)*)\s*\}\}`)
+
+// referencePrefixPattern finds the malformed references too, the other {{ }} blocks like the variables of the email templates are left as they are
+var referencePrefixPattern = regexp.MustCompile(`\{\{\s*tasks\.`)
+
+// Reference refers to a value in the result of a parent task
+type Reference struct {
+	TaskKey string
+	// Path is empty when the whole result is referred
+	Path []string
+}
+
+func (r Reference) String() string {
+	return strings.Join(append([]string{"tasks", r.TaskKey, "result"}, r.Path...), ".")
+}
+
+// HasReferences tells whether the payload might have a reference, it's a cheap check which is done before decoding the payload
+func HasReferences(payload json.RawMessage) bool {
+	return referencePrefixPattern.Match(payload)
+}
+
+// References returns the references of the payload, an error is returned for the malformed references
+func References(payload json.RawMessage) ([]Reference, error) {
+	if !HasReferences(payload) {
+		return nil, nil
+	}
+
+	var references []Reference
+	_, err := walkStrings(payload, func(value string) (any, error) {
+		stringReferences, err := parseReferences(value)
+		references = append(references, stringReferences...)
+		return value, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return references, nil
+}
+
+// ResolveReferences replaces the references of the payload with the values of the results, which are mapped by the keys of their tasks
+// A string which is only a reference is replaced with the referred value as it is, e.g. the rows of a query as an array
+// A reference inside a longer string is replaced with the referred string, or with the JSON of the other values
+// Each referred value must not be larger than maxReferenceBytes when it's encoded
+func ResolveReferences(payload json.RawMessage, results map[string]json.RawMessage, maxReferenceBytes int) (json.RawMessage, error) {
+	decodedResults := map[string]any{}
+	lookup := func(reference Reference) (any, json.RawMessage, error) {
+		result, ok := results[reference.TaskKey]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s refers to task %q which is not a dependency of the task", errval.ErrUnresolvedReference, reference, reference.TaskKey)
+		}
+		if len(result) == 0 || string(result) == "null" {
+			return nil, nil, fmt.Errorf("%w: %s refers to task %q which has no result", errval.ErrUnresolvedReference, reference, reference.TaskKey)
+		}
+
+		decodedResult, ok := decodedResults[reference.TaskKey]
+		if !ok {
+			err := decodeJSON(result, &decodedResult)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: result of task %q cannot be decoded: %s", errval.ErrUnresolvedReference, reference.TaskKey, err.Error())
+			}
+			decodedResults[reference.TaskKey] = decodedResult
+		}
+
+		value, err := lookupPath(decodedResult, reference.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s is not found in the result of task %q: %s", errval.ErrUnresolvedReference, reference, reference.TaskKey, err.Error())
+		}
+		encodedValue, err := json.Marshal(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %s cannot be encoded: %s", errval.ErrUnresolvedReference, reference, err.Error())
+		}
+		if len(encodedValue) > maxReferenceBytes {
+			return nil, nil, fmt.Errorf("%w: %s is %d bytes, which is larger than the limit of %d bytes", errval.ErrUnresolvedReference, reference, len(encodedValue), maxReferenceBytes)
+		}
+
+		return value, encodedValue, nil
+	}
+
+	return walkStrings(payload, func(value string) (any, error) {
+		matches := referencePattern.FindAllStringSubmatchIndex(value, -1)
+		if len(matches) == 0 {
+			return value, nil
+		}
+
+		if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(value) {
+			referredValue, _, err := lookup(newReference(value, matches[0]))
+			return referredValue, err
+		}
+
+		var resolvedValue strings.Builder
+		lastIndex := 0
+		for _, match := range matches {
+			_, encodedValue, err := lookup(newReference(value, match))
+			if err != nil {
+				return nil, err
+			}
+
+			resolvedValue.WriteString(value[lastIndex:match[0]])
+			var stringValue string
+			if json.Unmarshal(encodedValue, &stringValue) == nil {
+				resolvedValue.WriteString(stringValue)
+			} else {
+				resolvedValue.Write(encodedValue)
+			}
+			lastIndex = match[1]
+		}
+		resolvedValue.WriteString(value[lastIndex:])
+
+		return resolvedValue.String(), nil
+	})
+}
+
+// Resolver resolves the references of the payloads of the workflow tasks, it's used by the workers when the tasks start running
+type Resolver struct {
+	storage           domain.WorkflowStorage
+	maxReferenceBytes int
+	maxPayloadBytes   int
+}
+
+func NewResolver(storage domain.WorkflowStorage, maxReferenceBytes, maxPayloadBytes int) *Resolver {
+	return &Resolver{
+		storage:           storage,
+		maxReferenceBytes: maxReferenceBytes,
+		maxPayloadBytes:   maxPayloadBytes,
+	}
+}
+
+// Resolve returns the payload of the task whose references are replaced by the stored results of its parent tasks
+// The payload is returned as it is for the tasks which are not a part of any workflow, since their payloads can't have a reference
+// All the errors except the ones of the storage are errval.ErrUnresolvedReference, since resolving the same results again won't help
+func (r *Resolver) Resolve(ctx context.Context, task *domain.Task) (json.RawMessage, error) {
+	if task.WorkflowID == nil || !HasReferences(task.PayLoad) {
+		return task.PayLoad, nil
+	}
+
+	parents, err := r.storage.GetParentTasks(ctx, task.ID)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]json.RawMessage, len(parents))
+	for _, parent := range parents {
+		results[parent.WorkflowKey] = parent.Result
+	}
+
+	payload, err := ResolveReferences(task.PayLoad, results, r.maxReferenceBytes)
+	if err != nil {
+		if !errors.Is(err, errval.ErrUnresolvedReference) {
+			err = fmt.Errorf("%w: %s", errval.ErrUnresolvedReference, err.Error())
+		}
+
+		return nil, err
+	}
+	if len(payload) > r.maxPayloadBytes {
+		return nil, fmt.Errorf("%w: resolved payload is %d bytes, which is larger than the limit of %d bytes", errval.ErrUnresolvedReference, len(payload), r.maxPayloadBytes)
+	}
+
+	return payload, nil
+}
+
+// parseReferences returns the references of a string value, a string which has a malformed reference is refused
+func parseReferences(value string) ([]Reference, error) {
+	matches := referencePattern.FindAllStringSubmatchIndex(value, -1)
+	if len(referencePrefixPattern.FindAllStringIndex(value, -1)) != len(matches) {
+		return nil, fmt.Errorf("%q has a malformed reference, references must look like {{ tasks.<key>.result.<path> }}", value)
+	}
+
+	references := make([]Reference, 0, len(matches))
+	for _, match := range matches {
+		references = append(references, newReference(value, match))
+	}
+
+	return references, nil
+}
+
+func newReference(value string, match []int) Reference {
+	reference := Reference{TaskKey: value[match[2]:match[3]]}
+	if path := value[match[4]:match[5]]; path != "" {
+		reference.Path = strings.Split(strings.TrimPrefix(path, "."), ".")
+	}
+
+	return reference
+}
+
+func lookupPath(value any, path []string) (any, error) {
+	for i, segment := range path {
+		switch typedValue := value.(type) {
+		case map[string]any:
+			fieldValue, ok := typedValue[segment]
+			if !ok {
+				return nil, fmt.Errorf("field %q does not exist", strings.Join(path[:i+1], "."))
+			}
+			value = fieldValue
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(typedValue) {
+				return nil, fmt.Errorf("index %q is out of the %d items of %q", segment, len(typedValue), strings.Join(path[:i], "."))
+			}
+			value = typedValue[index]
+		default:
+			return nil, fmt.Errorf("%q is neither an object nor an array", strings.Join(path[:i], "."))
+		}
+	}
+
+	return value, nil
+}
+
+// walkStrings replaces every string value of the JSON payload with the value which is returned by replace, the keys of the objects are kept
+func walkStrings(payload json.RawMessage, replace func(value string) (any, error)) (json.RawMessage, error) {
+	var decodedPayload any
+	err := decodeJSON(payload, &decodedPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	var walk func(value any) (any, error)
+	walk = func(value any) (any, error) {
+		switch typedValue := value.(type) {
+		case string:
+			return replace(typedValue)
+		case map[string]any:
+			for key, fieldValue := range typedValue {
+				replacedValue, err := walk(fieldValue)
+				if err != nil {
+					return nil, err
+				}
+				typedValue[key] = replacedValue
+			}
+		case []any:
+			for i, item := range typedValue {
+				replacedValue, err := walk(item)
+				if err != nil {
+					return nil, err
+				}
+				typedValue[i] = replacedValue
+			}
+		}
+
+		return value, nil
+	}
+
+	replacedPayload, err := walk(decodedPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(replacedPayload)
+}
+
+// decodeJSON keeps the numbers as they are, so the large integers of the results are not rounded
+func decodeJSON(data []byte, value *any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(value)
+}
@@ -0,0 +1,97 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"testing"
+)
+
+var testResults = map[string]json.RawMessage{
+	"extract": json.RawMessage(`{"columns": ["id", "email"], "rows": [[1, "a@example.com"], [2, "b@example.com"]], "row_count": 2}`),
+	"notify":  nil,
+}
+
+func resolveTestPayload(t *testing.T, payload string, maxReferenceBytes int) map[string]any {
+	resolvedPayload, err := ResolveReferences(json.RawMessage(payload), testResults, maxReferenceBytes)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var decodedPayload map[string]any
+	err = json.Unmarshal(resolvedPayload, &decodedPayload)
+	if err != nil {
+		t.Fatalf("expected a JSON object, got %v", err)
+	}
+
+	return decodedPayload
+}
+
+// TestResolveReferences_WholeValue: a string which is only a reference is replaced with the referred value as it is
+func TestResolveReferences_WholeValue(t *testing.T) {
+	payload := resolveTestPayload(t, `{"rows": "{{ tasks.extract.result.rows }}", "email": "{{tasks.extract.result.rows.1.1}}"}`, 1024)
+
+	rows, ok := payload["rows"].([]any)
+	if !ok || len(rows) != 2 {
+		t.Fatalf("expected the rows array, got %#v", payload["rows"])
+	}
+	if payload["email"] != "b@example.com" {
+		t.Fatalf("expected the email of the second row, got %#v", payload["email"])
+	}
+}
+
+// TestResolveReferences_InsideString: references inside a longer string are interpolated, the other {{ }} blocks are kept
+func TestResolveReferences_InsideString(t *testing.T) {
+	payload := resolveTestPayload(t, `{"body": "Hi {{.name}}, {{ tasks.extract.result.row_count }} rows of {{ tasks.extract.result.columns }} for {{ tasks.extract.result.rows.0.1 }}"}`, 1024)
+
+	expected := `Hi {{.name}}, 2 rows of ["id","email"] for a@example.com`
+	if payload["body"] != expected {
+		t.Fatalf("expected %q, got %q", expected, payload["body"])
+	}
+}
+
+// TestResolveReferences_Errors: the references which can't be resolved fail with ErrUnresolvedReference
+func TestResolveReferences_Errors(t *testing.T) {
+	cases := map[string]string{
+		"missing result":     `{"to": "{{ tasks.notify.result }}"}`,
+		"not a dependency":   `{"to": "{{ tasks.load.result }}"}`,
+		"missing field":      `{"to": "{{ tasks.extract.result.emails }}"}`,
+		"index out of range": `{"to": "{{ tasks.extract.result.rows.5 }}"}`,
+		"path of a scalar":   `{"to": "{{ tasks.extract.result.row_count.value }}"}`,
+		"too large value":    `{"to": "{{ tasks.extract.result }}"}`,
+	}
+	for name, payload := range cases {
+		_, err := ResolveReferences(json.RawMessage(payload), testResults, 64)
+		if !errors.Is(err, errval.ErrUnresolvedReference) {
+			t.Fatalf("%s: expected ErrUnresolvedReference, got %v", name, err)
+		}
+	}
+}
+
+// TestValidate_References: the references must be well-formed and only refer to the dependencies of their task
+func TestValidate_References(t *testing.T) {
+	newTasks := func(payload string, dependsOn ...string) []*domain.WorkflowTask {
+		return []*domain.WorkflowTask{
+			{Key: "extract", Payload: json.RawMessage(`{}`)},
+			{Key: "load", Payload: json.RawMessage(`{}`), DependsOn: []string{"extract"}},
+			{Key: "notify", Payload: json.RawMessage(payload), DependsOn: dependsOn},
+		}
+	}
+
+	err := Validate(newTasks(`{"body": "{{ tasks.load.result.count }} of {{ tasks.extract.result }}"}`, "extract", "load"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	invalidTasks := map[string][]*domain.WorkflowTask{
+		"indirect parent": newTasks(`{"body": "{{ tasks.extract.result }}"}`, "load"),
+		"malformed":       newTasks(`{"body": "{{ tasks.load.rows }}"}`, "load"),
+	}
+	for name, tasks := range invalidTasks {
+		err = Validate(tasks)
+		if !errors.Is(err, errval.ErrInvalidWorkflow) {
+			t.Fatalf("%s: expected ErrInvalidWorkflow, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package workflow
+
+import (
+	"context"
+	"github.com/sf7293/task-manager/internal/domain"
+)
+
+// Report is the status of a workflow which is aggregated from its tasks and the history of their status changes
+type Report struct {
+	domain.Workflow
+	Status domain.WorkflowStatus `json:"status"`
+	// TaskCounts is the number of the tasks in each status
+	TaskCounts map[string]int `json:"task_counts"`
+	// StartedAtStamp is the first time that a task of the workflow started running
+	StartedAtStamp *int64 `json:"started_at_stamp,omitempty"`
+	// FinishedAtStamp is the last status change of the workflow, it's only set when the workflow is finished
+	FinishedAtStamp *int64        `json:"finished_at_stamp,omitempty"`
+	Tasks           []*TaskReport `json:"tasks"`
+}
+
+type TaskReport struct {
+	ID        int32    `json:"id"`
+	Key       string   `json:"key"`
+	Type      string   `json:"type"`
+	Status    string   `json:"status"`
+	Priority  string   `json:"priority"`
+	Attempts  int32    `json:"attempts"`
+	DependsOn []string `json:"depends_on"`
+	// Finished is false for the failed tasks which are going to be retried
+	Finished        bool                              `json:"finished"`
+	StartedAtStamp  *int64                            `json:"started_at_stamp,omitempty"`
+	FinishedAtStamp *int64                            `json:"finished_at_stamp,omitempty"`
+	History         []*domain.TaskStatusChangeHistory `json:"history"`
+}
+
+// Report returns the aggregated status of the workflow, errval.ErrNotFound is returned when the workflow doesn't exist
+func (e *Engine) Report(ctx context.Context, workflowID int32) (*Report, error) {
+	workflow, err := e.storage.GetWorkflowByID(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks, err := e.storage.GetWorkflowTasks(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencies, err := e.storage.GetWorkflowDependencies(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := e.storage.GetWorkflowStatusChangeHistory(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildReport(workflow, tasks, dependencies, history, e.maxAttempts), nil
+}
+
+// BuildReport aggregates the tasks of the workflow, the history must be in the order of the changes
+func BuildReport(workflow *domain.Workflow, tasks []*domain.Task, dependencies []*domain.TaskDependency, history []*domain.TaskStatusChangeHistory, maxAttempts int32) *Report {
+	report := &Report{
+		Workflow:   *workflow,
+		TaskCounts: map[string]int{},
+		Tasks:      make([]*TaskReport, 0, len(tasks)),
+	}
+
+	keysByID := make(map[int32]string, len(tasks))
+	for _, task := range tasks {
+		keysByID[task.ID] = task.WorkflowKey
+	}
+	parentKeys := map[int32][]string{}
+	for _, dependency := range dependencies {
+		parentKeys[dependency.TaskID] = append(parentKeys[dependency.TaskID], keysByID[dependency.DependsOnTaskID])
+	}
+	historyByTaskID := map[int32][]*domain.TaskStatusChangeHistory{}
+	for _, change := range history {
+		historyByTaskID[change.TaskID] = append(historyByTaskID[change.TaskID], change)
+	}
+
+	isStarted := false
+	isFinished := true
+	isSucceeded := true
+	var lastChangeStamp int64
+	for _, task := range tasks {
+		taskReport := &TaskReport{
+			ID:        task.ID,
+			Key:       task.WorkflowKey,
+			Type:      task.Type,
+			Status:    task.Status,
+			Priority:  task.Priority,
+			Attempts:  task.Attempts,
+			DependsOn: parentKeys[task.ID],
+			Finished:  task.IsFinished(maxAttempts),
+			History:   historyByTaskID[task.ID],
+		}
+		if taskReport.DependsOn == nil {
+			taskReport.DependsOn = []string{}
+		}
+		if taskReport.History == nil {
+			taskReport.History = []*domain.TaskStatusChangeHistory{}
+		}
+
+		for _, change := range taskReport.History {
+			if change.NewStatus == string(domain.Running) && taskReport.StartedAtStamp == nil {
+				startedAtStamp := change.CreatedAtStamp
+				taskReport.StartedAtStamp = &startedAtStamp
+				if report.StartedAtStamp == nil || startedAtStamp < *report.StartedAtStamp {
+					report.StartedAtStamp = &startedAtStamp
+				}
+			}
+			if change.CreatedAtStamp > lastChangeStamp {
+				lastChangeStamp = change.CreatedAtStamp
+			}
+		}
+		if taskReport.Finished && len(taskReport.History) > 0 {
+			finishedAtStamp := taskReport.History[len(taskReport.History)-1].CreatedAtStamp
+			taskReport.FinishedAtStamp = &finishedAtStamp
+		}
+
+		isStarted = isStarted || task.Attempts > 0 || taskReport.Finished
+		isFinished = isFinished && taskReport.Finished
+		isSucceeded = isSucceeded && task.Status == string(domain.Succeeded)
+		report.TaskCounts[task.Status]++
+		report.Tasks = append(report.Tasks, taskReport)
+	}
+
+	switch {
+	case isFinished && isSucceeded:
+		report.Status = domain.WorkflowSucceeded
+	case isFinished:
+		report.Status = domain.WorkflowFailed
+	case isStarted:
+		report.Status = domain.WorkflowRunning
+	default:
+		report.Status = domain.WorkflowQueued
+	}
+	if isFinished && lastChangeStamp > 0 {
+		report.FinishedAtStamp = &lastChangeStamp
+	}
+
+	return report
+}
@@ -0,0 +1,92 @@
+package workflow
+
+import (
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+)
+
+// MaxTasks limits the size of a workflow, since all of its tasks are inserted in a single transaction
+const MaxTasks = 100
+
+// Validate checks that the keys of the tasks are unique, their dependencies exist, and the dependencies have no cycle
+// The references of the payloads must be well-formed, and only refer to the results of the dependencies of their task
+func Validate(tasks []*domain.WorkflowTask) error {
+	if len(tasks) == 0 {
+		return fmt.Errorf("%w: workflow must have at least one task", errval.ErrInvalidWorkflow)
+	}
+	if len(tasks) > MaxTasks {
+		return fmt.Errorf("%w: workflow must not have more than %d tasks", errval.ErrInvalidWorkflow, MaxTasks)
+	}
+
+	tasksByKey := make(map[string]*domain.WorkflowTask, len(tasks))
+	for _, task := range tasks {
+		if task.Key == "" {
+			return fmt.Errorf("%w: key of the tasks must not be empty", errval.ErrInvalidWorkflow)
+		}
+		if _, ok := tasksByKey[task.Key]; ok {
+			return fmt.Errorf("%w: key %q is used by more than one task", errval.ErrInvalidWorkflow, task.Key)
+		}
+		tasksByKey[task.Key] = task
+	}
+
+	// Kahn's algorithm, the tasks which are left after removing all the tasks without any remaining parent are in a cycle
+	remainingParents := make(map[string]int, len(tasks))
+	children := make(map[string][]string, len(tasks))
+	for _, task := range tasks {
+		parents := map[string]bool{}
+		for _, parentKey := range task.DependsOn {
+			if _, ok := tasksByKey[parentKey]; !ok {
+				return fmt.Errorf("%w: task %q depends on the unknown task %q", errval.ErrInvalidWorkflow, task.Key, parentKey)
+			}
+			if parentKey == task.Key {
+				return fmt.Errorf("%w: task %q depends on itself", errval.ErrInvalidWorkflow, task.Key)
+			}
+			if parents[parentKey] {
+				return fmt.Errorf("%w: task %q depends on task %q more than once", errval.ErrInvalidWorkflow, task.Key, parentKey)
+			}
+			parents[parentKey] = true
+			children[parentKey] = append(children[parentKey], task.Key)
+		}
+		remainingParents[task.Key] = len(task.DependsOn)
+
+		// The results are resolved from the parents of the task, so the referred tasks are always finished when the task runs
+		references, err := References(task.Payload)
+		if err != nil {
+			return fmt.Errorf("%w: payload of task %q: %s", errval.ErrInvalidWorkflow, task.Key, err.Error())
+		}
+		for _, reference := range references {
+			if !parents[reference.TaskKey] {
+				return fmt.Errorf("%w: task %q refers to the result of task %q which is not one of its dependencies", errval.ErrInvalidWorkflow, task.Key, reference.TaskKey)
+			}
+		}
+	}
+
+	readyKeys := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		if remainingParents[task.Key] == 0 {
+			readyKeys = append(readyKeys, task.Key)
+		}
+	}
+	visitedCount := 0
+	for len(readyKeys) > 0 {
+		key := readyKeys[0]
+		readyKeys = readyKeys[1:]
+		visitedCount++
+		for _, childKey := range children[key] {
+			remainingParents[childKey]--
+			if remainingParents[childKey] == 0 {
+				readyKeys = append(readyKeys, childKey)
+			}
+		}
+	}
+	if visitedCount != len(tasks) {
+		for _, task := range tasks {
+			if remainingParents[task.Key] > 0 {
+				return fmt.Errorf("%w: dependencies of task %q have a cycle", errval.ErrInvalidWorkflow, task.Key)
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,18 +1,221 @@
 package email
 
 import (
-	"log/slog"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/pkg/process"
+	"net/mail"
+	"strings"
 	"time"
 )
 
-type SendEmailTask struct{}
+const TaskTypeName = "send_email"
 
-func NewSendEmailTask() SendEmailTask {
-	return SendEmailTask{}
+// maxAttachmentsSize limits the total size of the attachments of an email, most SMTP servers reject bigger messages
+const maxAttachmentsSize = 10 * 1024 * 1024
+
+var payloadSchema = json.RawMessage(`{
+	"type": "object",
+	"required": ["to"],
+	"anyOf": [{"required": ["subject"]}, {"required": ["template_id"]}],
+	"properties": {
+		"to": {"type": "array", "minItems": 1, "items": {"type": "string", "minLength": 3}},
+		"cc": {"type": "array", "items": {"type": "string", "minLength": 3}},
+		"bcc": {"type": "array", "items": {"type": "string", "minLength": 3}},
+		"subject": {"type": "string", "minLength": 1},
+		"text": {"type": "string"},
+		"html": {"type": "string"},
+		"body": {"type": "string", "deprecated": true, "description": "It's used as the text body when text is not set"},
+		"template_id": {"type": "string", "minLength": 1, "description": "The subject and the bodies are rendered from the template instead"},
+		"locale": {"type": "string", "minLength": 1},
+		"template_version": {"type": "integer", "minimum": 1, "description": "The latest version is used when it's not set"},
+		"variables": {"type": "object"},
+		"attachments": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["filename", "content"],
+				"properties": {
+					"filename": {"type": "string", "minLength": 1},
+					"content_type": {"type": "string"},
+					"content": {"type": "string", "contentEncoding": "base64"}
+				}
+			}
+		}
+	}
+}`)
+
+// Attachment is a file which is attached to the email, its content is base64 encoded in the JSON payload
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Content     []byte `json:"content"`
+}
+
+// Payload is the payload of send_email tasks
+type Payload struct {
+	To      []string `json:"to"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	Subject string   `json:"subject"`
+	Text    string   `json:"text,omitempty"`
+	HTML    string   `json:"html,omitempty"`
+	// Body is the text body of the tasks which are created before the text and HTML bodies were supported
+	Body        string       `json:"body,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// TemplateID renders the subject and the bodies from a stored template with the variables, the locale falls back to DefaultLocale
+	TemplateID      string         `json:"template_id,omitempty"`
+	Locale          string         `json:"locale,omitempty"`
+	TemplateVersion *int32         `json:"template_version,omitempty"`
+	Variables       map[string]any `json:"variables,omitempty"`
+}
+
+func (p *Payload) Validate() error {
+	if len(p.To) == 0 {
+		return errors.New("to must contain at least one recipient")
+	}
+	for _, recipient := range p.Recipients() {
+		_, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("recipient %q is not a valid address: %w", recipient, err)
+		}
+	}
+	if p.TemplateID != "" {
+		if p.Subject != "" || p.Text != "" || p.HTML != "" || p.Body != "" {
+			return errors.New("subject and bodies must not be set when template_id is set")
+		}
+	} else if strings.TrimSpace(p.Subject) == "" {
+		return errors.New("subject must not be empty")
+	}
+	if p.TemplateVersion != nil && *p.TemplateVersion < 1 {
+		return errors.New("template_version must be at least 1")
+	}
+
+	attachmentsSize := 0
+	for _, attachment := range p.Attachments {
+		if strings.TrimSpace(attachment.Filename) == "" {
+			return errors.New("filename of attachments must not be empty")
+		}
+		attachmentsSize += len(attachment.Content)
+	}
+	if attachmentsSize > maxAttachmentsSize {
+		return fmt.Errorf("total size of attachments must not be more than %d bytes", maxAttachmentsSize)
+	}
+
+	return nil
+}
+
+// Recipients returns all the recipients including cc and bcc
+func (p *Payload) Recipients() []string {
+	recipients := make([]string, 0, len(p.To)+len(p.Cc)+len(p.Bcc))
+	recipients = append(recipients, p.To...)
+	recipients = append(recipients, p.Cc...)
+	recipients = append(recipients, p.Bcc...)
+
+	return recipients
+}
+
+// TextBody returns the text body, and falls back to the body of the old payloads
+func (p *Payload) TextBody() string {
+	if p.Text != "" {
+		return p.Text
+	}
+
+	return p.Body
+}
+
+// Definition registers send_email in the task type registry, the emails are sent through the given SMTP server
+// The templates of the emails are read from the template store
+func Definition(config SMTPConfig, templates TemplateStore) process.Definition {
+	return process.Definition{
+		Name:          TaskTypeName,
+		PayloadSchema: payloadSchema,
+		NewPayload: func() process.Payload {
+			return &Payload{}
+		},
+		Factory: func() process.Process {
+			return NewSendEmailTask(NewSMTPSender(config), config.From, templates)
+		},
+		DefaultPriority: domain.Normal,
+		Timeout:         30 * time.Second,
+		MaxRetries:      3,
+	}
+}
+
+type SendEmailTask struct {
+	sender    Sender
+	from      string
+	templates TemplateStore
+}
+
+func NewSendEmailTask(sender Sender, from string, templates TemplateStore) SendEmailTask {
+	return SendEmailTask{
+		sender:    sender,
+		from:      from,
+		templates: templates,
+	}
 }
 
-func (e SendEmailTask) Execute(params map[string]string) error {
-	slog.Info("send_email parameters:", "params", params)
-	time.Sleep(3 * time.Second)
+// Execute sends the email, the errors which are not worth retrying are returned as permanent errors
+func (e SendEmailTask) Execute(ctx context.Context, taskCtx *process.TaskContext) error {
+	payload, ok := taskCtx.Payload.(*Payload)
+	if !ok {
+		return process.Permanent(errors.New("send_email task is executed with an unexpected payload"))
+	}
+
+	if payload.TemplateID != "" {
+		var err error
+		payload, err = e.renderPayload(ctx, taskCtx, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	taskCtx.Logger.Info("Sending email", "to", payload.To, "cc", payload.Cc, "bcc_count", len(payload.Bcc), "attachments_count", len(payload.Attachments))
+	message, err := NewMessage(e.from, payload, time.Now())
+	if err != nil {
+		return process.Permanent(err)
+	}
+
+	err = e.sender.Send(ctx, message)
+	if err != nil {
+		taskCtx.Logger.Warn("Error occurred while sending the email", "is_permanent", process.IsPermanent(err), "error", err.Error())
+		return err
+	}
+	taskCtx.Progress.ReportProgress(100, "email is sent")
+
 	return nil
 }
+
+// renderPayload returns a copy of the payload whose subject and bodies are rendered from its template
+func (e SendEmailTask) renderPayload(ctx context.Context, taskCtx *process.TaskContext, payload *Payload) (*Payload, error) {
+	if e.templates == nil {
+		return nil, process.Permanent(errors.New("templates are not available to the send_email task"))
+	}
+
+	template, err := FindTemplate(ctx, e.templates, payload.TemplateID, payload.Locale, payload.TemplateVersion)
+	if err != nil {
+		if errors.Is(err, errval.ErrNotFound) {
+			return nil, process.Permanent(fmt.Errorf("template %q is not found for locale %q", payload.TemplateID, payload.Locale))
+		}
+
+		return nil, fmt.Errorf("loading template %q: %w", payload.TemplateID, err)
+	}
+
+	rendered, err := RenderTemplate(template, payload.Variables)
+	if err != nil {
+		return nil, process.Permanent(err)
+	}
+	taskCtx.Logger.Info("Email template is rendered", "template_id", template.TemplateID, "locale", template.Locale, "version", template.Version)
+
+	renderedPayload := *payload
+	renderedPayload.Subject = rendered.Subject
+	renderedPayload.Text = rendered.Text
+	renderedPayload.HTML = rendered.HTML
+
+	return &renderedPayload, nil
+}
@@ -1,34 +1,404 @@
 package email
 
 import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/sf7293/task-manager/pkg/process"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+// receivedMessage is a message which is accepted by the fake SMTP server
+type receivedMessage struct {
+	from       string
+	recipients []string
+	data       string
+}
+
+// fakeSMTPServer is an in-process SMTP server which supports STARTTLS and AUTH PLAIN
+type fakeSMTPServer struct {
+	listener net.Listener
+	// tlsConfig enables STARTTLS when it's set
+	tlsConfig *tls.Config
+	// rcptReplies overrides the reply of RCPT TO for the given addresses
+	rcptReplies map[string]string
+	// isSilent keeps the server from sending the greeting, so the clients hang until their deadline
+	isSilent bool
+
+	mu              sync.Mutex
+	messages        []receivedMessage
+	isAuthenticated bool
+	isTLS           bool
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error in listening, got %v", err)
+	}
+
+	server := &fakeSMTPServer{
+		listener:    listener,
+		rcptReplies: map[string]string{},
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	return server
+}
+
+func (s *fakeSMTPServer) start() {
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	if s.isSilent {
+		time.Sleep(5 * time.Second)
+		return
+	}
+
+	text := textproto.NewConn(conn)
+	_ = text.PrintfLine("220 fake.smtp ESMTP")
+
+	message := receivedMessage{}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		argument := ""
+		if parts := strings.SplitN(line, " ", 2); len(parts) == 2 {
+			argument = parts[1]
+		}
+
+		switch command {
+		case "EHLO":
+			replies := []string{"250-fake.smtp"}
+			if s.tlsConfig != nil && !s.isTLSConn() {
+				replies = append(replies, "250-STARTTLS")
+			}
+			replies = append(replies, "250 AUTH PLAIN")
+			_ = text.PrintfLine(strings.Join(replies, "\r\n"))
+		case "STARTTLS":
+			_ = text.PrintfLine("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			s.mu.Lock()
+			s.isTLS = true
+			s.mu.Unlock()
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+		case "AUTH":
+			s.mu.Lock()
+			s.isAuthenticated = true
+			s.mu.Unlock()
+			_ = text.PrintfLine("235 authenticated")
+		case "MAIL":
+			message.from = strings.Trim(strings.TrimPrefix(argument, "FROM:"), "<>")
+			_ = text.PrintfLine("250 ok")
+		case "RCPT":
+			recipient := strings.Trim(strings.TrimPrefix(argument, "TO:"), "<>")
+			if reply, ok := s.rcptReplies[recipient]; ok {
+				_ = text.PrintfLine(reply)
+				continue
+			}
+			message.recipients = append(message.recipients, recipient)
+			_ = text.PrintfLine("250 ok")
+		case "DATA":
+			_ = text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			message = receivedMessage{}
+			_ = text.PrintfLine("250 queued")
+		case "QUIT":
+			_ = text.PrintfLine("221 bye")
+			return
+		default:
+			_ = text.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) isTLSConn() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isTLS
+}
+
+func (s *fakeSMTPServer) receivedMessages() []receivedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]receivedMessage{}, s.messages...)
+}
+
+// newTestCertificate returns a self-signed certificate of 127.0.0.1 and a pool which trusts it
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected no error in generating key, got %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected no error in creating certificate, got %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("expected no error in parsing certificate, got %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func newTestSender(server *fakeSMTPServer, requireStartTLS bool) *SMTPSender {
+	return NewSMTPSender(SMTPConfig{
+		Host:            "127.0.0.1",
+		Port:            server.port(),
+		Username:        "user",
+		Password:        "pass",
+		From:            "Task Manager <tasks@example.com>",
+		RequireStartTLS: requireStartTLS,
+		Timeout:         2 * time.Second,
+	})
+}
+
+func newTestTaskContext(payload *Payload) *process.TaskContext {
+	return &process.TaskContext{
+		TaskID:   1,
+		Attempt:  1,
+		Logger:   slog.Default(),
+		Progress: process.LogProgressReporter{Logger: slog.Default()},
+		Payload:  payload,
+	}
+}
+
+func newTestPayload() *Payload {
+	return &Payload{
+		To:      []string{"user@example.com"},
+		Cc:      []string{"cc@example.com"},
+		Bcc:     []string{"hidden@example.com"},
+		Subject: "Test Email",
+		Text:    "This is a test email.",
+		HTML:    "<p>This is a test email.</p>",
+		Attachments: []Attachment{
+			{Filename: "report.csv", ContentType: "text/csv", Content: []byte("id,name\n1,test\n")},
+		},
+	}
+}
+
+// TestSendEmailTask_Execute: Checking the email is delivered through STARTTLS and AUTH to all recipients
 func TestSendEmailTask_Execute(t *testing.T) {
-	task := SendEmailTask{}
-	params := map[string]string{
-		"to":      "user@example.com",
-		"subject": "Test Email",
-		"body":    "This is a test email.",
+	cert, pool := newTestCertificate(t)
+	server := newFakeSMTPServer(t)
+	server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.start()
+
+	sender := newTestSender(server, true)
+	sender.tlsConfig.RootCAs = pool
+	task := NewSendEmailTask(sender, "Task Manager <tasks@example.com>", nil)
+
+	err := task.Execute(context.Background(), newTestTaskContext(newTestPayload()))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
 	}
 
-	// Start measuring time
-	start := time.Now()
+	messages := server.receivedMessages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	if !server.isTLSConn() || !server.isAuthenticated {
+		t.Fatalf("expected the message to be sent over TLS after authentication")
+	}
+	if messages[0].from != "tasks@example.com" {
+		t.Fatalf("expected tasks@example.com as the sender, got %s", messages[0].from)
+	}
+	if strings.Join(messages[0].recipients, ",") != "user@example.com,cc@example.com,hidden@example.com" {
+		t.Fatalf("expected to, cc and bcc recipients, got %v", messages[0].recipients)
+	}
+	for _, expected := range []string{"Subject: Test Email", "Cc: cc@example.com", "text/html", `filename=report.csv`} {
+		if !strings.Contains(messages[0].data, expected) {
+			t.Fatalf("expected the message to contain %q, got %s", expected, messages[0].data)
+		}
+	}
+	if strings.Contains(messages[0].data, "hidden@example.com") {
+		t.Fatalf("expected bcc recipients not to be written in the message")
+	}
+}
+
+// TestSMTPSender_Send_PermanentError: Testing 5xx replies are permanent errors, so they are not retried
+func TestSMTPSender_Send_PermanentError(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.rcptReplies["bad@example.com"] = "550 5.1.1 no such user"
+	server.start()
 
-	// Execute the task
-	err := task.Execute(params)
+	payload := newTestPayload()
+	payload.To = []string{"bad@example.com"}
+	task := NewSendEmailTask(newTestSender(server, false), "tasks@example.com", nil)
 
-	// Calculate elapsed time
+	err := task.Execute(context.Background(), newTestTaskContext(payload))
+	if !process.IsPermanent(err) {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+}
+
+// TestSMTPSender_Send_TransientError: Testing 4xx replies and connection errors are transient, so they are retried
+func TestSMTPSender_Send_TransientError(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.rcptReplies["busy@example.com"] = "451 4.3.0 try again later"
+	server.start()
+
+	payload := newTestPayload()
+	payload.To = []string{"busy@example.com"}
+	task := NewSendEmailTask(newTestSender(server, false), "tasks@example.com", nil)
+
+	err := task.Execute(context.Background(), newTestTaskContext(payload))
+	if err == nil || process.IsPermanent(err) {
+		t.Fatalf("expected a transient error, got %v", err)
+	}
+
+	sender := newTestSender(server, false)
+	_ = server.listener.Close()
+	err = NewSendEmailTask(sender, "tasks@example.com", nil).Execute(context.Background(), newTestTaskContext(newTestPayload()))
+	if err == nil || process.IsPermanent(err) {
+		t.Fatalf("expected a transient connection error, got %v", err)
+	}
+}
+
+// TestSMTPSender_Send_RequireStartTLS: Testing no email is sent in plain text when STARTTLS is required
+func TestSMTPSender_Send_RequireStartTLS(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.start()
+
+	task := NewSendEmailTask(newTestSender(server, true), "tasks@example.com", nil)
+	err := task.Execute(context.Background(), newTestTaskContext(newTestPayload()))
+	if !process.IsPermanent(err) {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+	if server.isAuthenticated || len(server.receivedMessages()) != 0 {
+		t.Fatalf("expected nothing to be sent without TLS")
+	}
+}
+
+// TestSendEmailTask_Execute_Timeout: Testing the task stops as soon as its context is done
+func TestSendEmailTask_Execute_Timeout(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.isSilent = true
+	server.start()
+
+	task := NewSendEmailTask(newTestSender(server, false), "tasks@example.com", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := task.Execute(ctx, newTestTaskContext(newTestPayload()))
 	elapsed := time.Since(start)
 
-	// Check if the function executed without errors
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+	if elapsed >= time.Second {
+		t.Fatalf("expected the task to be stopped before 1 second, got %v", elapsed)
+	}
+}
+
+// TestPayload_Validate: Testing nested payloads are decoded and the recipients are required
+func TestPayload_Validate(t *testing.T) {
+	definition := Definition(SMTPConfig{}, nil)
+	payload, err := definition.DecodePayload([]byte(`{"to":["a@example.com","b@example.com"],"subject":"Hi","text":"Hello","attachments":[{"filename":"a.txt","content":"aGVsbG8="}]}`))
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
+	if len(payload.(*Payload).To) != 2 {
+		t.Fatalf("expected 2 recipients, got %v", payload.(*Payload).To)
+	}
+	if string(payload.(*Payload).Attachments[0].Content) != "hello" {
+		t.Fatalf("expected the attachment to be decoded from base64, got %s", payload.(*Payload).Attachments[0].Content)
+	}
 
-	// Check if the elapsed time is at least 3 seconds
-	if elapsed < 3*time.Second {
-		t.Fatalf("expected at least 3 seconds delay, got %v", elapsed)
+	invalidPayloads := []string{
+		`{"subject":"Hi"}`,
+		`{"to":[""],"subject":"Hi"}`,
+		`{"to":["not an address"],"subject":"Hi"}`,
+		`{"to":["a@example.com"]}`,
+		`{"to":"a@example.com","subject":"Hi"}`,
+		`{"to":["a@example.com"],"template_id":"welcome","subject":"Hi"}`,
+		`{"to":["a@example.com"],"template_id":"welcome","template_version":0}`,
+	}
+	for _, invalidPayload := range invalidPayloads {
+		_, err = definition.DecodePayload([]byte(invalidPayload))
+		if err == nil {
+			t.Fatalf("expected an error for %s, got nil", invalidPayload)
+		}
+	}
+}
+
+// TestNewMessage_LegacyBody: Checking the body of the old payloads is sent as the text body
+func TestNewMessage_LegacyBody(t *testing.T) {
+	message, err := NewMessage("tasks@example.com", &Payload{To: []string{"a@example.com"}, Subject: "Hi", Body: "legacy body"}, time.Now())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	reader := bufio.NewReader(strings.NewReader(string(message.Body)))
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("expected valid headers, got %v", err)
+	}
+	if !strings.HasPrefix(header.Get("Content-Type"), "multipart/mixed") {
+		t.Fatalf("expected a multipart/mixed message, got %s", header.Get("Content-Type"))
+	}
+	// "legacy body" in base64
+	if !strings.Contains(string(message.Body), "bGVnYWN5IGJvZHk=") {
+		t.Fatalf("expected the legacy body in the message, got %s", message.Body)
 	}
 }
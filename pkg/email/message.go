@@ -0,0 +1,177 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// base64LineLength is the maximum length of the lines of base64 encoded parts, which is recommended by RFC 2045
+const base64LineLength = 76
+
+// Message is an email which is ready to be sent, Body contains the headers and the MIME encoded content
+type Message struct {
+	// From is the bare address of the sender, which is used in the SMTP transaction
+	From string
+	To   []string
+	Cc   []string
+	// Bcc recipients are only used in the SMTP transaction, they are not written in the headers
+	Bcc  []string
+	Body []byte
+}
+
+// Recipients returns the addresses of all recipients including the Bcc ones
+func (m *Message) Recipients() []string {
+	recipients := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	recipients = append(recipients, m.To...)
+	recipients = append(recipients, m.Cc...)
+	recipients = append(recipients, m.Bcc...)
+
+	return recipients
+}
+
+// NewMessage builds a multipart/mixed message, the text and HTML bodies are put in a multipart/alternative part before the attachments
+func NewMessage(from string, payload *Payload, now time.Time) (*Message, error) {
+	var body bytes.Buffer
+	mixedWriter := multipart.NewWriter(&body)
+
+	headers := []string{
+		"From: " + from,
+		"To: " + strings.Join(payload.To, ", "),
+	}
+	if len(payload.Cc) > 0 {
+		headers = append(headers, "Cc: "+strings.Join(payload.Cc, ", "))
+	}
+	headers = append(headers,
+		"Subject: "+mime.QEncoding.Encode("utf-8", payload.Subject),
+		"Date: "+now.Format(time.RFC1123Z),
+		"Message-ID: "+messageID(from, now),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary="+mixedWriter.Boundary(),
+	)
+	body.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	err := writeAlternativePart(mixedWriter, payload.TextBody(), payload.HTML)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range payload.Attachments {
+		err = writeAttachmentPart(mixedWriter, attachment)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = mixedWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	envelopeFrom := from
+	if address, err := mail.ParseAddress(from); err == nil {
+		envelopeFrom = address.Address
+	}
+
+	return &Message{
+		From: envelopeFrom,
+		To:   payload.To,
+		Cc:   payload.Cc,
+		Bcc:  payload.Bcc,
+		Body: body.Bytes(),
+	}, nil
+}
+
+func writeAlternativePart(mixedWriter *multipart.Writer, text, html string) error {
+	var alternative bytes.Buffer
+	alternativeWriter := multipart.NewWriter(&alternative)
+
+	bodies := []struct {
+		contentType string
+		content     string
+	}{
+		{contentType: "text/plain; charset=utf-8", content: text},
+		{contentType: "text/html; charset=utf-8", content: html},
+	}
+	for _, body := range bodies {
+		if body.content == "" && body.contentType != bodies[0].contentType {
+			continue
+		}
+
+		part, err := alternativeWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = part.Write(wrapBase64([]byte(body.content)))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := alternativeWriter.Close()
+	if err != nil {
+		return err
+	}
+
+	part, err := mixedWriter.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + alternativeWriter.Boundary()},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(alternative.Bytes())
+
+	return err
+}
+
+func writeAttachmentPart(mixedWriter *multipart.Writer, attachment Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	part, err := mixedWriter.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": attachment.Filename})},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(wrapBase64(attachment.Content))
+
+	return err
+}
+
+// wrapBase64 encodes the content in base64 with CRLF line breaks, because SMTP servers reject too long lines
+func wrapBase64(content []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	var wrapped bytes.Buffer
+	for len(encoded) > base64LineLength {
+		wrapped.WriteString(encoded[:base64LineLength] + "\r\n")
+		encoded = encoded[base64LineLength:]
+	}
+	wrapped.WriteString(encoded + "\r\n")
+
+	return wrapped.Bytes()
+}
+
+func messageID(from string, now time.Time) string {
+	domain := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(address.Address, "@"); at >= 0 {
+			domain = address.Address[at+1:]
+		}
+	}
+
+	return fmt.Sprintf("<%d@%s>", now.UnixNano(), domain)
+}
@@ -0,0 +1,177 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/pkg/process"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// SMTPConfig is the connection settings of the SMTP server which the emails are sent through
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the address of the sender of all emails
+	From string
+	// RequireStartTLS refuses to send emails when the server doesn't support STARTTLS, so the credentials and the emails are never sent in plain text
+	RequireStartTLS bool
+	// Timeout limits the whole SMTP conversation when the context of the task has a later deadline
+	Timeout time.Duration
+}
+
+// Sender sends the email messages
+type Sender interface {
+	Send(ctx context.Context, message *Message) error
+}
+
+// SMTPSender sends emails using an SMTP server, the errors are classified as permanent or transient
+type SMTPSender struct {
+	config SMTPConfig
+	// tlsConfig is only replaced in the tests, to trust the certificate of the fake server
+	tlsConfig *tls.Config
+}
+
+func NewSMTPSender(config SMTPConfig) *SMTPSender {
+	return &SMTPSender{
+		config: config,
+		tlsConfig: &tls.Config{
+			ServerName: config.Host,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+}
+
+// Send delivers the message to all of its recipients in one SMTP transaction
+// 5xx replies of the server are permanent errors, while 4xx replies and connection errors are transient and worth retrying
+func (s *SMTPSender) Send(ctx context.Context, message *Message) error {
+	address := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+	dialer := net.Dialer{Timeout: s.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("connecting to the SMTP server: %w", err)
+	}
+
+	deadline := time.Now().Add(s.config.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	// Closing the connection stops the SMTP conversation as soon as the task is cancelled
+	stopWatching := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stopWatching()
+
+	client, err := smtp.NewClient(conn, s.config.Host)
+	if err != nil {
+		_ = conn.Close()
+		if ctxErr := contextErr(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		return classify("greeting", err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	err = s.send(client, message)
+	if err != nil {
+		if ctxErr := contextErr(ctx); ctxErr != nil {
+			return ctxErr
+		}
+	}
+
+	return err
+}
+
+// contextErr returns the error of the context, the deadline of the connection is the same as the deadline of the context, so it might be reached a moment before the context is done
+func contextErr(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+
+	return nil
+}
+
+func (s *SMTPSender) send(client *smtp.Client, message *Message) error {
+	err := client.Hello("localhost")
+	if err != nil {
+		return classify("EHLO", err)
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(s.tlsConfig)
+		if err != nil {
+			return classify("STARTTLS", err)
+		}
+	} else if s.config.RequireStartTLS {
+		// Retrying doesn't help until the server or the config is changed
+		return process.Permanent(errors.New("SMTP server doesn't support STARTTLS"))
+	}
+
+	if s.config.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host))
+		if err != nil {
+			return classify("AUTH", err)
+		}
+	}
+
+	err = client.Mail(message.From)
+	if err != nil {
+		return classify("MAIL FROM", err)
+	}
+	for _, recipient := range message.Recipients() {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return classify("RCPT TO "+recipient, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return classify("DATA", err)
+	}
+	_, err = writer.Write(message.Body)
+	if err != nil {
+		return classify("DATA", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		return classify("DATA", err)
+	}
+
+	// The message is already accepted by the server, so a failed QUIT must not cause the email to be sent again by a retry
+	_ = client.Quit()
+	return nil
+}
+
+// classify marks the 5xx replies of the server as permanent errors, all other errors are left transient
+func classify(command string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	err = fmt.Errorf("SMTP %s: %w", command, err)
+	var protocolErr *textproto.Error
+	if errors.As(err, &protocolErr) && protocolErr.Code >= 500 && protocolErr.Code < 600 {
+		return process.Permanent(err)
+	}
+
+	return err
+}
@@ -0,0 +1,123 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultLocale is used when the payload has no locale, and when the template has no variant for the requested locale
+const DefaultLocale = "en"
+
+// TemplateStore returns the stored versions of the templates
+type TemplateStore interface {
+	GetTemplate(ctx context.Context, templateID, locale string) (*domain.Template, error)
+	GetTemplateVersion(ctx context.Context, templateID, locale string, version int32) (*domain.Template, error)
+}
+
+// RenderedTemplate is the subject and the bodies of a template which are rendered with the variables of an email
+type RenderedTemplate struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// FindTemplate returns the template of the locale, and falls back to the language of the locale and then to the default locale
+// The version is looked up in the same order when it's set, otherwise the latest version is returned
+func FindTemplate(ctx context.Context, store TemplateStore, templateID, locale string, version *int32) (*domain.Template, error) {
+	for _, candidate := range candidateLocales(locale) {
+		var template *domain.Template
+		var err error
+		if version != nil {
+			template, err = store.GetTemplateVersion(ctx, templateID, candidate, *version)
+		} else {
+			template, err = store.GetTemplate(ctx, templateID, candidate)
+		}
+		if errors.Is(err, errval.ErrNotFound) {
+			continue
+		}
+
+		return template, err
+	}
+
+	return nil, errval.ErrNotFound
+}
+
+// candidateLocales returns the locale, its language and the default locale without duplicates, e.g. pt-BR, pt, en
+func candidateLocales(locale string) []string {
+	candidates := []string{}
+	if locale != "" {
+		candidates = append(candidates, locale)
+		language, _, found := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+		if found && language != "" {
+			candidates = append(candidates, language)
+		}
+	}
+	if locale != DefaultLocale {
+		candidates = append(candidates, DefaultLocale)
+	}
+
+	return candidates
+}
+
+// ParseTemplate checks the syntax of the subject and the bodies of the template
+func ParseTemplate(template *domain.Template) error {
+	_, _, _, err := parseTemplate(template)
+	return err
+}
+
+// RenderTemplate renders the subject and the text body with text/template, and the HTML body with html/template, so the variables are escaped in the HTML
+// Referring to a variable which is not given is an error, so a half-rendered email is never sent
+func RenderTemplate(template *domain.Template, variables map[string]any) (*RenderedTemplate, error) {
+	subjectTemplate, textTemplate, htmlTemplate, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	if variables == nil {
+		variables = map[string]any{}
+	}
+
+	var subject, text, html bytes.Buffer
+	err = subjectTemplate.Execute(&subject, variables)
+	if err != nil {
+		return nil, fmt.Errorf("%w: rendering the subject: %s", errval.ErrInvalidTemplate, err.Error())
+	}
+	err = textTemplate.Execute(&text, variables)
+	if err != nil {
+		return nil, fmt.Errorf("%w: rendering the text body: %s", errval.ErrInvalidTemplate, err.Error())
+	}
+	err = htmlTemplate.Execute(&html, variables)
+	if err != nil {
+		return nil, fmt.Errorf("%w: rendering the HTML body: %s", errval.ErrInvalidTemplate, err.Error())
+	}
+
+	return &RenderedTemplate{
+		// A subject is a single header line, so the line breaks of the variables are removed
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func parseTemplate(template *domain.Template) (subject, text *texttemplate.Template, html *htmltemplate.Template, err error) {
+	subject, err = texttemplate.New("subject").Option("missingkey=error").Parse(template.Subject)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: parsing the subject: %s", errval.ErrInvalidTemplate, err.Error())
+	}
+	text, err = texttemplate.New("text").Option("missingkey=error").Parse(template.Text)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: parsing the text body: %s", errval.ErrInvalidTemplate, err.Error())
+	}
+	html, err = htmltemplate.New("html").Option("missingkey=error").Parse(template.HTML)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: parsing the HTML body: %s", errval.ErrInvalidTemplate, err.Error())
+	}
+
+	return subject, text, html, nil
+}
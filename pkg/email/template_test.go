@@ -0,0 +1,146 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/internal/errval"
+	"github.com/sf7293/task-manager/pkg/process"
+	"strings"
+	"testing"
+)
+
+// memoryTemplateStore keeps the templates by their template id, locale and version
+type memoryTemplateStore struct {
+	templates map[string]*domain.Template
+}
+
+func newMemoryTemplateStore(templates ...*domain.Template) *memoryTemplateStore {
+	store := &memoryTemplateStore{templates: map[string]*domain.Template{}}
+	for _, template := range templates {
+		store.templates[fmt.Sprintf("%s/%s/%d", template.TemplateID, template.Locale, template.Version)] = template
+	}
+
+	return store
+}
+
+func (s *memoryTemplateStore) GetTemplate(ctx context.Context, templateID, locale string) (*domain.Template, error) {
+	var latest *domain.Template
+	for _, template := range s.templates {
+		if template.TemplateID == templateID && template.Locale == locale && (latest == nil || template.Version > latest.Version) {
+			latest = template
+		}
+	}
+	if latest == nil {
+		return nil, errval.ErrNotFound
+	}
+
+	return latest, nil
+}
+
+func (s *memoryTemplateStore) GetTemplateVersion(ctx context.Context, templateID, locale string, version int32) (*domain.Template, error) {
+	template, ok := s.templates[fmt.Sprintf("%s/%s/%d", templateID, locale, version)]
+	if !ok {
+		return nil, errval.ErrNotFound
+	}
+
+	return template, nil
+}
+
+func newTestTemplateStore() *memoryTemplateStore {
+	return newMemoryTemplateStore(
+		&domain.Template{TemplateID: "welcome", Locale: "en", Version: 1, Subject: "Welcome {{.name}}", Text: "Hi {{.name}}", HTML: "<p>Hi {{.name}}</p>"},
+		&domain.Template{TemplateID: "welcome", Locale: "en", Version: 2, Subject: "Welcome aboard {{.name}}", Text: "Hello {{.name}}", HTML: "<p>Hello {{.name}}</p>"},
+		&domain.Template{TemplateID: "welcome", Locale: "pt", Version: 1, Subject: "Bem-vindo {{.name}}", Text: "Olá {{.name}}", HTML: "<p>Olá {{.name}}</p>"},
+	)
+}
+
+// TestFindTemplate: Testing the locale falls back to its language and then to the default locale
+func TestFindTemplate(t *testing.T) {
+	store := newTestTemplateStore()
+	version := int32(1)
+	testCases := []struct {
+		locale          string
+		version         *int32
+		expectedLocale  string
+		expectedVersion int32
+	}{
+		{locale: "pt-BR", expectedLocale: "pt", expectedVersion: 1},
+		{locale: "de", expectedLocale: "en", expectedVersion: 2},
+		{locale: "", expectedLocale: "en", expectedVersion: 2},
+		{locale: "en", version: &version, expectedLocale: "en", expectedVersion: 1},
+	}
+	for _, testCase := range testCases {
+		template, err := FindTemplate(context.Background(), store, "welcome", testCase.locale, testCase.version)
+		if err != nil {
+			t.Fatalf("expected no error for locale %q, got %v", testCase.locale, err)
+		}
+		if template.Locale != testCase.expectedLocale || template.Version != testCase.expectedVersion {
+			t.Fatalf("expected %s version %d for locale %q, got %s version %d", testCase.expectedLocale, testCase.expectedVersion, testCase.locale, template.Locale, template.Version)
+		}
+	}
+
+	_, err := FindTemplate(context.Background(), store, "unknown", "en", nil)
+	if !errors.Is(err, errval.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// TestRenderTemplate: Checking the variables are escaped only in the HTML body, and a missing variable is an error
+func TestRenderTemplate(t *testing.T) {
+	template := &domain.Template{Subject: "Order {{.order_id}}\nshipped", Text: "Dear {{.name}}", HTML: "<p>Dear {{.name}}</p>"}
+	rendered, err := RenderTemplate(template, map[string]any{"order_id": 42, "name": "<Sam>"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if rendered.Subject != "Order 42 shipped" {
+		t.Fatalf("expected the subject in one line, got %q", rendered.Subject)
+	}
+	if rendered.Text != "Dear <Sam>" {
+		t.Fatalf("expected the text body not to be escaped, got %q", rendered.Text)
+	}
+	if rendered.HTML != "<p>Dear &lt;Sam&gt;</p>" {
+		t.Fatalf("expected the HTML body to be escaped, got %q", rendered.HTML)
+	}
+
+	_, err = RenderTemplate(template, map[string]any{"name": "Sam"})
+	if !errors.Is(err, errval.ErrInvalidTemplate) {
+		t.Fatalf("expected ErrInvalidTemplate for a missing variable, got %v", err)
+	}
+
+	err = ParseTemplate(&domain.Template{Subject: "{{.name"})
+	if !errors.Is(err, errval.ErrInvalidTemplate) {
+		t.Fatalf("expected ErrInvalidTemplate for a broken template, got %v", err)
+	}
+}
+
+// TestSendEmailTask_Execute_Template: Checking the email is rendered from the template of its locale
+func TestSendEmailTask_Execute_Template(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.start()
+
+	task := NewSendEmailTask(newTestSender(server, false), "tasks@example.com", newTestTemplateStore())
+	payload := &Payload{To: []string{"user@example.com"}, TemplateID: "welcome", Locale: "pt-BR", Variables: map[string]any{"name": "Sam"}}
+	err := task.Execute(context.Background(), newTestTaskContext(payload))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	messages := server.receivedMessages()
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(messages))
+	}
+	if !strings.Contains(messages[0].data, "Subject: Bem-vindo Sam") {
+		t.Fatalf("expected the rendered subject, got %s", messages[0].data)
+	}
+	if payload.Subject != "" {
+		t.Fatalf("expected the payload of the task not to be changed, got subject %q", payload.Subject)
+	}
+
+	payload.TemplateID = "unknown"
+	err = task.Execute(context.Background(), newTestTaskContext(payload))
+	if !process.IsPermanent(err) {
+		t.Fatalf("expected a permanent error for an unknown template, got %v", err)
+	}
+}
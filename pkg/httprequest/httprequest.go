@@ -0,0 +1,320 @@
+package httprequest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/pkg/process"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const TaskTypeName = "http_request"
+
+const (
+	// RedirectFollow follows up to the max redirects, it's the default redirect policy
+	RedirectFollow = "follow"
+	// RedirectSameHost only follows the redirects to the host of the request
+	RedirectSameHost = "same_host"
+	// RedirectNone returns the redirect response itself, so it's checked against the expected status codes
+	RedirectNone = "none"
+)
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	defaultMaxRedirects   = 10
+)
+
+var payloadSchema = json.RawMessage(`{
+	"type": "object",
+	"required": ["url"],
+	"properties": {
+		"method": {"type": "string", "enum": ["GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"]},
+		"url": {"type": "string", "minLength": 1},
+		"headers": {"type": "object", "additionalProperties": {"type": "string"}},
+		"body": {"type": "string"},
+		"timeout_in_seconds": {"type": "integer", "minimum": 1, "description": "It's limited by the timeout of the task type"},
+		"expected_status_codes": {"type": "array", "items": {"type": "integer", "minimum": 100, "maximum": 599}, "description": "Any 2xx status is expected when it's not set"},
+		"redirect_policy": {"type": "string", "enum": ["follow", "same_host", "none"]},
+		"max_redirects": {"type": "integer", "minimum": 0}
+	}
+}`)
+
+// Payload is the payload of http_request tasks
+type Payload struct {
+	Method              string            `json:"method,omitempty"`
+	URL                 string            `json:"url"`
+	Headers             map[string]string `json:"headers,omitempty"`
+	Body                string            `json:"body,omitempty"`
+	TimeoutInSeconds    int               `json:"timeout_in_seconds,omitempty"`
+	ExpectedStatusCodes []int             `json:"expected_status_codes,omitempty"`
+	RedirectPolicy      string            `json:"redirect_policy,omitempty"`
+	MaxRedirects        *int              `json:"max_redirects,omitempty"`
+}
+
+func (p *Payload) Validate() error {
+	requestURL, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("url is invalid: %w", err)
+	}
+	if requestURL.Scheme != "http" && requestURL.Scheme != "https" {
+		return errors.New("url must be an http or https URL")
+	}
+	if requestURL.Host == "" {
+		return errors.New("url must have a host")
+	}
+	if p.RedirectPolicy != "" && p.RedirectPolicy != RedirectFollow && p.RedirectPolicy != RedirectSameHost && p.RedirectPolicy != RedirectNone {
+		return fmt.Errorf("redirect_policy must be %s, %s or %s", RedirectFollow, RedirectSameHost, RedirectNone)
+	}
+	if p.MaxRedirects != nil && *p.MaxRedirects < 0 {
+		return errors.New("max_redirects must not be negative")
+	}
+	if p.TimeoutInSeconds < 0 {
+		return errors.New("timeout_in_seconds must not be negative")
+	}
+	for _, statusCode := range p.ExpectedStatusCodes {
+		if statusCode < 100 || statusCode > 599 {
+			return fmt.Errorf("expected status code %d is invalid", statusCode)
+		}
+	}
+
+	return nil
+}
+
+func (p *Payload) method() string {
+	if p.Method == "" {
+		return http.MethodGet
+	}
+
+	return strings.ToUpper(p.Method)
+}
+
+func (p *Payload) isExpected(statusCode int) bool {
+	if len(p.ExpectedStatusCodes) == 0 {
+		return statusCode >= 200 && statusCode < 300
+	}
+
+	return slices.Contains(p.ExpectedStatusCodes, statusCode)
+}
+
+// Result is the captured response which is stored as the result of the task
+type Result struct {
+	StatusCode int         `json:"status_code"`
+	Headers    http.Header `json:"headers"`
+	Body       string      `json:"body"`
+	// BodyEncoding is base64 when the body is not a valid UTF-8 text
+	BodyEncoding string `json:"body_encoding"`
+	// BodyTruncated is true when the body is longer than the cap of the response bodies
+	BodyTruncated bool `json:"body_truncated"`
+}
+
+// Definition registers http_request in the task type registry, at most maxBodyBytes bytes of the response bodies are captured
+func Definition(maxBodyBytes int64) process.Definition {
+	return process.Definition{
+		Name:          TaskTypeName,
+		PayloadSchema: payloadSchema,
+		NewPayload: func() process.Payload {
+			return &Payload{}
+		},
+		Factory: func() process.Process {
+			return NewHTTPRequestTask(http.DefaultTransport, maxBodyBytes)
+		},
+		DefaultPriority: domain.Normal,
+		Timeout:         60 * time.Second,
+		MaxRetries:      5,
+	}
+}
+
+type HTTPRequestTask struct {
+	transport    http.RoundTripper
+	maxBodyBytes int64
+}
+
+func NewHTTPRequestTask(transport http.RoundTripper, maxBodyBytes int64) HTTPRequestTask {
+	return HTTPRequestTask{
+		transport:    transport,
+		maxBodyBytes: maxBodyBytes,
+	}
+}
+
+// Execute sends the request and stores the response as the result when its status is expected
+// Unexpected 408, 425, 429 and 5xx responses (except 501) and connection errors are retried, the rest of the unexpected responses fail the task permanently
+func (h HTTPRequestTask) Execute(ctx context.Context, taskCtx *process.TaskContext) error {
+	payload, ok := taskCtx.Payload.(*Payload)
+	if !ok {
+		return process.Permanent(errors.New("http_request task is executed with an unexpected payload"))
+	}
+
+	timeout := defaultRequestTimeout
+	if payload.TimeoutInSeconds > 0 {
+		timeout = time.Duration(payload.TimeoutInSeconds) * time.Second
+	}
+	requestCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(requestCtx, payload.method(), payload.URL, strings.NewReader(payload.Body))
+	if err != nil {
+		return process.Permanent(err)
+	}
+	for name, value := range payload.Headers {
+		request.Header.Set(name, value)
+	}
+
+	client := &http.Client{
+		Transport:     h.transport,
+		CheckRedirect: newRedirectChecker(payload),
+	}
+
+	taskCtx.Logger.Info("Sending HTTP request", "method", request.Method, "host", request.URL.Host, "path", request.URL.Path)
+	response, err := client.Do(request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		taskCtx.Logger.Warn("Error occurred while sending the HTTP request", "error", err.Error())
+		return fmt.Errorf("sending the HTTP request: %w", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	result, err := h.captureResponse(response)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fmt.Errorf("reading the HTTP response: %w", err)
+	}
+
+	if !payload.isExpected(response.StatusCode) {
+		err = classifyStatus(response, result)
+		taskCtx.Logger.Warn("Unexpected HTTP response status", "status_code", response.StatusCode, "is_permanent", process.IsPermanent(err))
+		return err
+	}
+
+	err = taskCtx.SetResult(result)
+	if err != nil {
+		return process.Permanent(err)
+	}
+	taskCtx.Progress.ReportProgress(100, "HTTP request is sent")
+
+	return nil
+}
+
+// captureResponse reads the body up to the cap, the rest of the body is dropped
+func (h HTTPRequestTask) captureResponse(response *http.Response) (*Result, error) {
+	body, err := io.ReadAll(io.LimitReader(response.Body, h.maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		StatusCode:   response.StatusCode,
+		Headers:      response.Header,
+		BodyEncoding: "text",
+	}
+	if int64(len(body)) > h.maxBodyBytes {
+		body = body[:h.maxBodyBytes]
+		result.BodyTruncated = true
+	}
+	if utf8.Valid(body) {
+		result.Body = string(body)
+	} else {
+		result.Body = base64.StdEncoding.EncodeToString(body)
+		result.BodyEncoding = "base64"
+	}
+
+	return result, nil
+}
+
+// newRedirectChecker applies the redirect policy of the payload, the redirect response itself is returned when the redirect is not followed
+func newRedirectChecker(payload *Payload) func(request *http.Request, via []*http.Request) error {
+	maxRedirects := defaultMaxRedirects
+	if payload.MaxRedirects != nil {
+		maxRedirects = *payload.MaxRedirects
+	}
+
+	return func(request *http.Request, via []*http.Request) error {
+		switch {
+		case payload.RedirectPolicy == RedirectNone:
+			return http.ErrUseLastResponse
+		case payload.RedirectPolicy == RedirectSameHost && request.URL.Host != via[0].URL.Host:
+			return http.ErrUseLastResponse
+		case len(via) > maxRedirects:
+			return http.ErrUseLastResponse
+		}
+
+		return nil
+	}
+}
+
+// classifyStatus returns the error of an unexpected response, the Retry-After header of 429 and 503 responses is passed to the worker
+func classifyStatus(response *http.Response, result *Result) error {
+	err := fmt.Errorf("unexpected HTTP status %d: %s", response.StatusCode, bodySnippet(result))
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable:
+		if delay, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+			return process.RetryAfter(err, delay)
+		}
+		return err
+	case response.StatusCode == http.StatusRequestTimeout || response.StatusCode == http.StatusTooEarly:
+		return err
+	case response.StatusCode >= 500 && response.StatusCode != http.StatusNotImplemented:
+		return err
+	}
+
+	return process.Permanent(err)
+}
+
+// parseRetryAfter parses both formats of the Retry-After header, the delay in seconds and the HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	delay := date.Sub(now)
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay, true
+}
+
+// bodySnippet returns the beginning of the body, so the error is useful without flooding the logs
+func bodySnippet(result *Result) string {
+	const maxSnippetLength = 200
+	if result.BodyEncoding != "text" {
+		return "binary body"
+	}
+
+	snippet := strings.TrimSpace(result.Body)
+	if len(snippet) > maxSnippetLength {
+		snippet = strings.ToValidUTF8(snippet[:maxSnippetLength], "") + "..."
+	}
+
+	return snippet
+}
@@ -0,0 +1,241 @@
+package httprequest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sf7293/task-manager/pkg/process"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTaskContext(payload *Payload) *process.TaskContext {
+	return &process.TaskContext{
+		TaskID:   1,
+		Attempt:  1,
+		Logger:   slog.Default(),
+		Progress: process.LogProgressReporter{Logger: slog.Default()},
+		Payload:  payload,
+	}
+}
+
+func decodeResult(t *testing.T, taskCtx *process.TaskContext) Result {
+	var result Result
+	err := json.Unmarshal(taskCtx.Result(), &result)
+	if err != nil {
+		t.Fatalf("expected a JSON result, got %v", err)
+	}
+
+	return result
+}
+
+// TestHTTPRequestTask_Execute: Checking the request is sent as it's described, and the response is captured up to the cap
+func TestHTTPRequestTask_Execute(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("X-Token") != "secret" || string(body) != `{"id":1}` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("X-Request-Id", "abc")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	task := NewHTTPRequestTask(http.DefaultTransport, 4)
+	taskCtx := newTestTaskContext(&Payload{Method: "post", URL: server.URL, Headers: map[string]string{"X-Token": "secret"}, Body: `{"id":1}`})
+	err := task.Execute(context.Background(), taskCtx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	result := decodeResult(t, taskCtx)
+	if result.StatusCode != http.StatusAccepted || result.Headers.Get("X-Request-Id") != "abc" {
+		t.Fatalf("expected the status and the headers to be captured, got %+v", result)
+	}
+	if result.Body != "0123" || !result.BodyTruncated || result.BodyEncoding != "text" {
+		t.Fatalf("expected the body to be truncated to 4 bytes, got %+v", result)
+	}
+}
+
+// TestHTTPRequestTask_Execute_Status: Testing the unexpected statuses are classified as permanent or transient
+func TestHTTPRequestTask_Execute_Status(t *testing.T) {
+	testCases := []struct {
+		statusCode          int
+		expectedStatusCodes []int
+		isPermanent         bool
+		isSucceeded         bool
+	}{
+		{statusCode: http.StatusNotFound, isPermanent: true},
+		{statusCode: http.StatusNotImplemented, isPermanent: true},
+		{statusCode: http.StatusBadGateway, isPermanent: false},
+		{statusCode: http.StatusRequestTimeout, isPermanent: false},
+		{statusCode: http.StatusConflict, expectedStatusCodes: []int{http.StatusConflict}, isSucceeded: true},
+		{statusCode: http.StatusOK, expectedStatusCodes: []int{http.StatusCreated}, isPermanent: true},
+	}
+	for _, testCase := range testCases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(testCase.statusCode)
+		}))
+
+		task := NewHTTPRequestTask(http.DefaultTransport, 1024)
+		err := task.Execute(context.Background(), newTestTaskContext(&Payload{URL: server.URL, ExpectedStatusCodes: testCase.expectedStatusCodes}))
+		server.Close()
+
+		if testCase.isSucceeded {
+			if err != nil {
+				t.Fatalf("expected no error for status %d, got %v", testCase.statusCode, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("expected an error for status %d, got nil", testCase.statusCode)
+		}
+		if process.IsPermanent(err) != testCase.isPermanent {
+			t.Fatalf("expected permanent to be %v for status %d, got %v", testCase.isPermanent, testCase.statusCode, err)
+		}
+	}
+}
+
+// TestHTTPRequestTask_Execute_RetryAfter: Checking the Retry-After header of 429 responses is passed to the worker
+func TestHTTPRequestTask_Execute_RetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("slow down"))
+	}))
+	defer server.Close()
+
+	task := NewHTTPRequestTask(http.DefaultTransport, 1024)
+	err := task.Execute(context.Background(), newTestTaskContext(&Payload{URL: server.URL}))
+	if process.IsPermanent(err) {
+		t.Fatalf("expected a transient error, got %v", err)
+	}
+	delay, ok := process.RetryDelay(err)
+	if !ok || delay != 7*time.Second {
+		t.Fatalf("expected a retry delay of 7s, got %v", delay)
+	}
+	if !strings.Contains(err.Error(), "slow down") {
+		t.Fatalf("expected the body in the error, got %v", err)
+	}
+}
+
+// TestParseRetryAfter: Testing both formats of the Retry-After header
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	delay, ok := parseRetryAfter("Mon, 01 Jan 2024 12:00:30 GMT", now)
+	if !ok || delay != 30*time.Second {
+		t.Fatalf("expected 30s for the HTTP date, got %v", delay)
+	}
+
+	for _, invalidValue := range []string{"", "-1", "soon"} {
+		_, ok = parseRetryAfter(invalidValue, now)
+		if ok {
+			t.Fatalf("expected %q to be ignored", invalidValue)
+		}
+	}
+}
+
+// TestHTTPRequestTask_Execute_Redirects: Testing the redirect policies
+func TestHTTPRequestTask_Execute_Redirects(t *testing.T) {
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("other host"))
+	}))
+	defer otherServer.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		case "/other":
+			http.Redirect(w, r, otherServer.URL, http.StatusFound)
+		default:
+			_, _ = w.Write([]byte("target"))
+		}
+	}))
+	defer server.Close()
+
+	task := NewHTTPRequestTask(http.DefaultTransport, 1024)
+
+	taskCtx := newTestTaskContext(&Payload{URL: server.URL + "/other"})
+	err := task.Execute(context.Background(), taskCtx)
+	if err != nil || decodeResult(t, taskCtx).Body != "other host" {
+		t.Fatalf("expected the redirect to be followed, got %v", err)
+	}
+
+	taskCtx = newTestTaskContext(&Payload{URL: server.URL + "/same", RedirectPolicy: RedirectSameHost})
+	err = task.Execute(context.Background(), taskCtx)
+	if err != nil || decodeResult(t, taskCtx).Body != "target" {
+		t.Fatalf("expected the redirect to the same host to be followed, got %v", err)
+	}
+
+	err = task.Execute(context.Background(), newTestTaskContext(&Payload{URL: server.URL + "/other", RedirectPolicy: RedirectSameHost}))
+	if !process.IsPermanent(err) {
+		t.Fatalf("expected the redirect to another host to be returned as an unexpected status, got %v", err)
+	}
+
+	taskCtx = newTestTaskContext(&Payload{URL: server.URL + "/same", RedirectPolicy: RedirectNone, ExpectedStatusCodes: []int{http.StatusFound}})
+	err = task.Execute(context.Background(), taskCtx)
+	if err != nil || decodeResult(t, taskCtx).Headers.Get("Location") != "/target" {
+		t.Fatalf("expected the redirect response to be captured, got %v", err)
+	}
+}
+
+// TestHTTPRequestTask_Execute_Timeout: Testing the request is stopped by the timeout of the payload, and the error is transient
+func TestHTTPRequestTask_Execute_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	task := NewHTTPRequestTask(http.DefaultTransport, 1024)
+	start := time.Now()
+	err := task.Execute(context.Background(), newTestTaskContext(&Payload{URL: server.URL, TimeoutInSeconds: 1}))
+	if err == nil || process.IsPermanent(err) {
+		t.Fatalf("expected a transient error, got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatalf("expected the request to be stopped after 1 second")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = task.Execute(ctx, newTestTaskContext(&Payload{URL: server.URL}))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled error, got %v", err)
+	}
+}
+
+// TestPayload_Validate: Testing the URL and the options of the payload are validated
+func TestPayload_Validate(t *testing.T) {
+	definition := Definition(1024)
+	_, err := definition.DecodePayload([]byte(`{"url":"https://example.com/hooks","method":"POST","expected_status_codes":[200,204]}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	invalidPayloads := []string{
+		`{"url":"ftp://example.com"}`,
+		`{"url":"/relative"}`,
+		`{"url":"https://example.com","redirect_policy":"sometimes"}`,
+		`{"url":"https://example.com","expected_status_codes":[42]}`,
+		`{"url":"https://example.com","max_redirects":-1}`,
+	}
+	for _, invalidPayload := range invalidPayloads {
+		_, err = definition.DecodePayload([]byte(invalidPayload))
+		if err == nil {
+			t.Fatalf("expected an error for %s, got nil", invalidPayload)
+		}
+	}
+}
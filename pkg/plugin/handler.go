@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/pkg/process"
+	"io"
+	"math"
+	"net"
+)
+
+// Handler executes a task in a handler which is written in Go, the errors of process.Permanent and process.RetryAfter are passed to the worker
+// ctx is cancelled when the worker cancels the task
+type Handler func(ctx context.Context, params ExecuteParams, reporter *Reporter) (any, error)
+
+// Reporter sends the progress and the logs of the task to the worker
+type Reporter struct {
+	codec *codec
+}
+
+func (r *Reporter) Progress(percent int, message string) error {
+	return r.codec.notify(MethodProgress, ProgressParams{Percent: percent, Message: message})
+}
+
+func (r *Reporter) Log(level, message string, attrs map[string]any) error {
+	return r.codec.notify(MethodLog, LogParams{Level: level, Message: message, Attrs: attrs})
+}
+
+// Serve handles a single task which is sent over r and w, like the stdin and the stdout of a handler which is launched by the worker
+// It returns after the response is written
+func Serve(ctx context.Context, r io.Reader, w io.Writer, handler Handler) error {
+	c := newCodec(r, w, defaultMaxMessageBytes)
+	for {
+		msg, err := c.read()
+		if err != nil {
+			return err
+		}
+		if msg.ID == nil {
+			continue
+		}
+		if msg.Method != MethodExecute {
+			err = c.write(&message{ID: msg.ID, Error: &RPCError{Code: ErrorCodeMethodNotFound, Message: fmt.Sprintf("method %q is not found", msg.Method)}})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		return serveExecute(ctx, c, msg, handler)
+	}
+}
+
+// ServeListener serves each connection of the listener in its own goroutine, like a handler which listens on a unix socket
+// It returns when the listener is closed
+func ServeListener(ctx context.Context, listener net.Listener, handler Handler) error {
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			defer connection.Close()
+			_ = Serve(ctx, connection, connection, handler)
+		}()
+	}
+}
+
+func serveExecute(ctx context.Context, c *codec, request *message, handler Handler) error {
+	var params ExecuteParams
+	err := json.Unmarshal(request.Params, &params)
+	if err != nil {
+		return c.write(&message{ID: request.ID, Error: &RPCError{Code: ErrorCodeInvalidRequest, Message: err.Error()}})
+	}
+	if params.ProtocolVersion != ProtocolVersion {
+		return c.write(&message{ID: request.ID, Error: &RPCError{Code: ErrorCodeInvalidRequest, Message: fmt.Sprintf("protocol version %d is not supported", params.ProtocolVersion)}})
+	}
+
+	// The task is cancelled by the cancel notification, or when the worker closes the connection
+	handlerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for {
+			msg, err := c.read()
+			if err != nil || msg.Method == MethodCancel {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	result, err := handler(handlerCtx, params, &Reporter{codec: c})
+	if err != nil {
+		rpcError := &RPCError{Code: ErrorCodeTaskFailed, Message: err.Error()}
+		if process.IsPermanent(err) {
+			rpcError.Data = &ErrorData{Permanent: true}
+		} else if delay, ok := process.RetryDelay(err); ok {
+			rpcError.Data = &ErrorData{RetryAfterInSeconds: int(math.Ceil(delay.Seconds()))}
+		}
+		return c.write(&message{ID: request.ID, Error: rpcError})
+	}
+
+	encodedResult, err := json.Marshal(result)
+	if err != nil {
+		return c.write(&message{ID: request.ID, Error: &RPCError{Code: ErrorCodeTaskFailed, Message: err.Error(), Data: &ErrorData{Permanent: true}}})
+	}
+
+	return c.write(&message{ID: request.ID, Result: encodedResult})
+}
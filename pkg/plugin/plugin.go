@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/pkg/process"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxMessageBytes   = 1 << 20
+	defaultCancelGracePeriod = 5 * time.Second
+)
+
+// defaultPayloadSchema accepts any object, the handlers which don't publish a schema validate their payloads themselves
+var defaultPayloadSchema = json.RawMessage(`{"type": "object"}`)
+
+// Config describes a handler which is registered as a task type, the handler is either launched for each task by Command, or is already listening on Socket
+type Config struct {
+	// Name is the task type of the handler
+	Name string
+	// Command is the absolute path of the handler executable, the messages are sent over its stdin and stdout
+	Command string
+	Args    []string
+	// Env is the environment of the command, the environment of the worker is not inherited
+	Env map[string]string
+	// Socket is the path of a unix socket which a running handler listens on, a connection is opened for each task
+	Socket string
+
+	PayloadSchema   json.RawMessage
+	DefaultPriority domain.TaskPriority
+	Timeout         time.Duration
+	MaxRetries      uint64
+	// MaxMessageBytes limits each message which is read from the handler
+	MaxMessageBytes int
+	// CancelGracePeriod is the time which the handler has to return after the cancel notification, before it's killed or disconnected
+	CancelGracePeriod time.Duration
+}
+
+func (c Config) Validate() error {
+	if c.Name == "" {
+		return errors.New("name of the plugin must not be empty")
+	}
+	if (c.Command == "") == (c.Socket == "") {
+		return fmt.Errorf("plugin %q must have either a command or a socket", c.Name)
+	}
+	if c.Command != "" && !filepath.IsAbs(c.Command) {
+		return fmt.Errorf("command of plugin %q must be an absolute path", c.Name)
+	}
+	for name := range c.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("env name %q of plugin %q is invalid", name, c.Name)
+		}
+	}
+
+	return nil
+}
+
+// Payload keeps the raw payload, since it's only understood by the handler
+type Payload struct {
+	Raw json.RawMessage
+}
+
+func (p *Payload) UnmarshalJSON(data []byte) error {
+	p.Raw = append(p.Raw[:0], data...)
+	return nil
+}
+
+func (p *Payload) Validate() error {
+	return nil
+}
+
+// Definition registers the handler as a task type
+func Definition(config Config) process.Definition {
+	payloadSchema := config.PayloadSchema
+	if len(payloadSchema) == 0 {
+		payloadSchema = defaultPayloadSchema
+	}
+
+	return process.Definition{
+		Name:          config.Name,
+		PayloadSchema: payloadSchema,
+		NewPayload: func() process.Payload {
+			return &Payload{}
+		},
+		Factory: func() process.Process {
+			return NewPluginTask(config)
+		},
+		DefaultPriority: config.DefaultPriority,
+		Timeout:         config.Timeout,
+		MaxRetries:      config.MaxRetries,
+	}
+}
+
+// conn is a connection to a handler, close releases it and waits for the handler when it's launched by the worker
+type conn struct {
+	reader io.Reader
+	writer io.Writer
+	close  func(gracePeriod time.Duration) error
+}
+
+type PluginTask struct {
+	config Config
+}
+
+func NewPluginTask(config Config) PluginTask {
+	if config.MaxMessageBytes <= 0 {
+		config.MaxMessageBytes = defaultMaxMessageBytes
+	}
+	if config.CancelGracePeriod <= 0 {
+		config.CancelGracePeriod = defaultCancelGracePeriod
+	}
+
+	return PluginTask{
+		config: config,
+	}
+}
+
+// Execute sends the task to the handler and relays its progress and logs until it responds
+// When ctx is done, the handler is notified with cancel, and it's killed or disconnected if it doesn't respond within the grace period
+func (p PluginTask) Execute(ctx context.Context, taskCtx *process.TaskContext) error {
+	payload, ok := taskCtx.Payload.(*Payload)
+	if !ok {
+		return process.Permanent(fmt.Errorf("plugin %s is executed with an unexpected payload", p.config.Name))
+	}
+	logger := taskCtx.Logger.With("plugin", p.config.Name)
+
+	var connection *conn
+	var err error
+	if p.config.Command != "" {
+		connection, err = p.launch(logger)
+	} else {
+		connection, err = p.dial(ctx)
+	}
+	if err != nil {
+		// The handler might be restarting, so it's retried like the other connection errors
+		return fmt.Errorf("connecting to plugin %s: %w", p.config.Name, err)
+	}
+
+	err = p.execute(ctx, taskCtx, logger, payload, newCodec(connection.reader, connection.writer, p.config.MaxMessageBytes))
+	// The grace period of a cancelled task is already spent waiting for its response
+	closeGracePeriod := p.config.CancelGracePeriod
+	if ctx.Err() != nil {
+		closeGracePeriod = 0
+	}
+	closeErr := connection.close(closeGracePeriod)
+	if closeErr != nil {
+		logger.Warn("Plugin is not closed cleanly", "error", closeErr)
+	}
+
+	return err
+}
+
+// readResult is a message, or the error which stopped the reading
+type readResult struct {
+	msg *message
+	err error
+}
+
+func (p PluginTask) execute(ctx context.Context, taskCtx *process.TaskContext, logger *slog.Logger, payload *Payload, c *codec) error {
+	const requestID int64 = 1
+	params, err := json.Marshal(ExecuteParams{
+		ProtocolVersion: ProtocolVersion,
+		TaskID:          taskCtx.TaskID,
+		TaskType:        p.config.Name,
+		Attempt:         taskCtx.Attempt,
+		Deadline:        taskCtx.Deadline,
+		Caller:          taskCtx.Caller,
+		Payload:         payload.Raw,
+	})
+	if err != nil {
+		return process.Permanent(err)
+	}
+	id := requestID
+	err = c.write(&message{ID: &id, Method: MethodExecute, Params: params})
+	if err != nil {
+		return fmt.Errorf("sending the task to plugin %s: %w", p.config.Name, err)
+	}
+
+	// The messages are read in the background, so the cancellation is not blocked by a handler which doesn't write anything
+	messages := make(chan readResult)
+	stopReading := make(chan struct{})
+	defer close(stopReading)
+	go func() {
+		for {
+			msg, err := c.read()
+			select {
+			case messages <- readResult{msg: msg, err: err}:
+			case <-stopReading:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var gracePeriod <-chan time.Time
+	done := ctx.Done()
+	for {
+		select {
+		case <-done:
+			logger.Warn("Cancelling the plugin task", "error", ctx.Err())
+			err = c.notify(MethodCancel, struct{}{})
+			if err != nil {
+				return ctx.Err()
+			}
+			// The handler is still read until the grace period passes, so its last logs are not lost
+			done = nil
+			gracePeriod = time.After(p.config.CancelGracePeriod)
+		case <-gracePeriod:
+			return ctx.Err()
+		case read := <-messages:
+			if read.err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if errors.Is(read.err, io.EOF) || errors.Is(read.err, io.ErrUnexpectedEOF) {
+					return fmt.Errorf("plugin %s is closed before responding", p.config.Name)
+				}
+				return process.Permanent(fmt.Errorf("reading plugin %s: %w", p.config.Name, read.err))
+			}
+
+			msg := read.msg
+			if msg.ID == nil {
+				p.handleNotification(taskCtx, logger, msg)
+				continue
+			}
+			if *msg.ID != requestID {
+				return process.Permanent(fmt.Errorf("plugin %s responded to an unknown request %d", p.config.Name, *msg.ID))
+			}
+			if ctx.Err() != nil {
+				// The handler has responded to the cancellation, the task is still cancelled whatever it has responded
+				return ctx.Err()
+			}
+
+			return p.handleResponse(taskCtx, msg)
+		}
+	}
+}
+
+func (p PluginTask) handleNotification(taskCtx *process.TaskContext, logger *slog.Logger, msg *message) {
+	switch msg.Method {
+	case MethodProgress:
+		var params ProgressParams
+		err := json.Unmarshal(msg.Params, &params)
+		if err != nil {
+			logger.Warn("Progress of the plugin cannot be decoded", "error", err)
+			return
+		}
+		taskCtx.Progress.ReportProgress(params.Percent, params.Message)
+	case MethodLog:
+		var params LogParams
+		err := json.Unmarshal(msg.Params, &params)
+		if err != nil {
+			logger.Warn("Log of the plugin cannot be decoded", "error", err)
+			return
+		}
+		attrs := make([]any, 0, len(params.Attrs)*2)
+		for key, value := range params.Attrs {
+			attrs = append(attrs, key, value)
+		}
+		logger.Log(context.Background(), logLevel(params.Level), params.Message, attrs...)
+	default:
+		// The unknown notifications are ignored, so the handlers are able to use the notifications of the newer protocol versions
+		logger.Debug("Unknown notification of the plugin is ignored", "method", msg.Method)
+	}
+}
+
+func (p PluginTask) handleResponse(taskCtx *process.TaskContext, msg *message) error {
+	if msg.Error != nil {
+		err := fmt.Errorf("plugin %s has failed the task: %s", p.config.Name, msg.Error.Message)
+		if msg.Error.Data != nil {
+			if msg.Error.Data.Permanent {
+				return process.Permanent(err)
+			}
+			if msg.Error.Data.RetryAfterInSeconds > 0 {
+				return process.RetryAfter(err, time.Duration(msg.Error.Data.RetryAfterInSeconds)*time.Second)
+			}
+		}
+		if msg.Error.Code != ErrorCodeTaskFailed {
+			// The protocol errors, like an unknown method, won't be fixed by retrying
+			return process.Permanent(err)
+		}
+		return err
+	}
+
+	if len(msg.Result) > 0 && string(msg.Result) != "null" {
+		err := taskCtx.SetResult(msg.Result)
+		if err != nil {
+			return process.Permanent(err)
+		}
+	}
+
+	return nil
+}
+
+func logLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
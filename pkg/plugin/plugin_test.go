@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sf7293/task-manager/pkg/process"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// handlerEnv selects the behaviour of the test binary when it's launched as a handler
+const handlerEnv = "PLUGIN_TEST_HANDLER"
+
+func TestMain(m *testing.M) {
+	if behaviour := os.Getenv(handlerEnv); behaviour != "" {
+		runTestHandler(behaviour)
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+func runTestHandler(behaviour string) {
+	if behaviour == "exit" {
+		os.Exit(1)
+	}
+
+	_ = Serve(context.Background(), os.Stdin, os.Stdout, func(ctx context.Context, params ExecuteParams, reporter *Reporter) (any, error) {
+		switch behaviour {
+		case "permanent":
+			return nil, process.Permanent(errors.New("bad input"))
+		case "retry_after":
+			return nil, process.RetryAfter(errors.New("busy"), 1500*time.Millisecond)
+		case "wait_for_cancel":
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case "ignore_cancel":
+			time.Sleep(time.Minute)
+			return nil, nil
+		}
+
+		_ = reporter.Progress(50, "half way")
+		_ = reporter.Log("warn", "plugin log", map[string]any{"key": "value"})
+		var payload map[string]any
+		_ = json.Unmarshal(params.Payload, &payload)
+		return map[string]any{"task_id": params.TaskID, "payload": payload, "env": os.Getenv("PLUGIN_TEST_VALUE")}, nil
+	})
+}
+
+type recordingProgressReporter struct {
+	percents []int
+}
+
+func (r *recordingProgressReporter) ReportProgress(percent int, message string) {
+	r.percents = append(r.percents, percent)
+}
+
+func newTestTaskContext(rawPayload string) *process.TaskContext {
+	return &process.TaskContext{
+		TaskID:   7,
+		Attempt:  1,
+		Deadline: time.Now().Add(time.Minute),
+		Logger:   slog.Default(),
+		Progress: &recordingProgressReporter{},
+		Payload:  &Payload{Raw: json.RawMessage(rawPayload)},
+	}
+}
+
+func newTestCommandConfig(t *testing.T, behaviour string) Config {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	return Config{
+		Name:              "test_plugin",
+		Command:           executable,
+		Env:               map[string]string{handlerEnv: behaviour, "PLUGIN_TEST_VALUE": "from config"},
+		CancelGracePeriod: 500 * time.Millisecond,
+	}
+}
+
+type testResult struct {
+	TaskID  int32          `json:"task_id"`
+	Payload map[string]any `json:"payload"`
+	Env     string         `json:"env"`
+}
+
+// TestPluginTask_Execute_Command: Checking the task is sent to a launched handler, and its progress and result are received
+func TestPluginTask_Execute_Command(t *testing.T) {
+	task := NewPluginTask(newTestCommandConfig(t, "succeed"))
+	taskCtx := newTestTaskContext(`{"size":10}`)
+	err := task.Execute(context.Background(), taskCtx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var result testResult
+	err = json.Unmarshal(taskCtx.Result(), &result)
+	if err != nil {
+		t.Fatalf("expected a JSON result, got %v", err)
+	}
+	if result.TaskID != 7 || result.Payload["size"] != float64(10) || result.Env != "from config" {
+		t.Fatalf("expected the task to be received by the handler, got %+v", result)
+	}
+	if percents := taskCtx.Progress.(*recordingProgressReporter).percents; len(percents) != 1 || percents[0] != 50 {
+		t.Fatalf("expected the progress of the handler to be reported, got %v", percents)
+	}
+}
+
+// TestPluginTask_Execute_Errors: Checking the errors of the handler are classified for the retries of the worker
+func TestPluginTask_Execute_Errors(t *testing.T) {
+	err := NewPluginTask(newTestCommandConfig(t, "permanent")).Execute(context.Background(), newTestTaskContext(`{}`))
+	if !process.IsPermanent(err) {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+
+	err = NewPluginTask(newTestCommandConfig(t, "retry_after")).Execute(context.Background(), newTestTaskContext(`{}`))
+	if delay, ok := process.RetryDelay(err); !ok || delay != 2*time.Second {
+		t.Fatalf("expected a retry after 2s, got %v", err)
+	}
+
+	err = NewPluginTask(newTestCommandConfig(t, "exit")).Execute(context.Background(), newTestTaskContext(`{}`))
+	if err == nil || process.IsPermanent(err) {
+		t.Fatalf("expected a transient error when the handler exits without responding, got %v", err)
+	}
+}
+
+// TestPluginTask_Execute_Cancel: Checking the handler is notified of the cancellation, and it's killed when it ignores it
+func TestPluginTask_Execute_Cancel(t *testing.T) {
+	for _, behaviour := range []string{"wait_for_cancel", "ignore_cancel"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		start := time.Now()
+		err := NewPluginTask(newTestCommandConfig(t, behaviour)).Execute(ctx, newTestTaskContext(`{}`))
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: expected %v, got %v", behaviour, context.DeadlineExceeded, err)
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Fatalf("%s: expected the handler to be stopped after the grace period, took %s", behaviour, elapsed)
+		}
+	}
+}
+
+// TestPluginTask_Execute_Socket: Checking a handler which listens on a unix socket receives the task
+func TestPluginTask_Execute_Socket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		_ = ServeListener(context.Background(), listener, func(ctx context.Context, params ExecuteParams, reporter *Reporter) (any, error) {
+			return map[string]any{"task_id": params.TaskID}, nil
+		})
+	}()
+
+	task := NewPluginTask(Config{Name: "test_plugin", Socket: socket})
+	taskCtx := newTestTaskContext(`{}`)
+	err = task.Execute(context.Background(), taskCtx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if string(taskCtx.Result()) != `{"task_id":7}` {
+		t.Fatalf("expected the result of the handler, got %s", taskCtx.Result())
+	}
+}
+
+// TestConfig_Validate: Checking a handler must have exactly one of the command and the socket
+func TestConfig_Validate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config Config
+		valid  bool
+	}{
+		{name: "command", config: Config{Name: "p", Command: "/opt/plugins/p"}, valid: true},
+		{name: "socket", config: Config{Name: "p", Socket: "/run/p.sock"}, valid: true},
+		{name: "both", config: Config{Name: "p", Command: "/opt/plugins/p", Socket: "/run/p.sock"}},
+		{name: "none", config: Config{Name: "p"}},
+		{name: "relative command", config: Config{Name: "p", Command: "plugins/p"}},
+		{name: "no name", config: Config{Command: "/opt/plugins/p"}},
+	}
+	for _, testCase := range testCases {
+		err := testCase.config.Validate()
+		if testCase.valid && err != nil {
+			t.Fatalf("%s: expected no error, got %v", testCase.name, err)
+		}
+		if !testCase.valid && err == nil {
+			t.Fatalf("%s: expected an error, got nil", testCase.name)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package plugin
+
+import "os/exec"
+
+func setProcessGroup(command *exec.Cmd) {}
+
+// killProcessGroup only kills the handler, its children are left running on the platforms without process groups
+func killProcessGroup(command *exec.Cmd) {
+	_ = command.Process.Kill()
+}
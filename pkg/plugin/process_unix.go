@@ -0,0 +1,17 @@
+//go:build unix
+
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the handler as the leader of a new process group, so the processes which it starts are killed with it
+func setProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(command *exec.Cmd) {
+	_ = syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+}
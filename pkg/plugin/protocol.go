@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is sent with each task, so the handlers are able to refuse the versions which they don't know
+const ProtocolVersion = 1
+
+const jsonRPCVersion = "2.0"
+
+// The methods of the protocol, execute is the only request and the rest are notifications without any id
+const (
+	// MethodExecute is sent by the worker with the task, the handler responds to it with the result once the task is finished
+	MethodExecute = "execute"
+	// MethodCancel is sent by the worker when the task is cancelled or its deadline passes
+	MethodCancel = "cancel"
+	// MethodProgress is sent by the handler to report the progress of the task
+	MethodProgress = "progress"
+	// MethodLog is sent by the handler to write a log line of the task in the logs of the worker
+	MethodLog = "log"
+)
+
+// Error codes of the responses, the codes between -32768 and -32000 are reserved by JSON-RPC
+const (
+	// ErrorCodeTaskFailed is returned by the handlers when the task fails
+	ErrorCodeTaskFailed = 1
+	// ErrorCodeInvalidRequest is returned by the handlers when the request can't be handled
+	ErrorCodeInvalidRequest = -32600
+	// ErrorCodeMethodNotFound is returned by the handlers for the unknown methods
+	ErrorCodeMethodNotFound = -32601
+)
+
+// message is a JSON-RPC 2.0 request, notification or response, each message is written as a single line of JSON
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// ExecuteParams are the params of the execute request
+type ExecuteParams struct {
+	ProtocolVersion int             `json:"protocol_version"`
+	TaskID          int32           `json:"task_id"`
+	TaskType        string          `json:"task_type"`
+	Attempt         int32           `json:"attempt"`
+	Deadline        time.Time       `json:"deadline"`
+	Caller          string          `json:"caller,omitempty"`
+	Payload         json.RawMessage `json:"payload"`
+}
+
+// ProgressParams are the params of the progress notification, percent is between 0 and 100
+type ProgressParams struct {
+	Percent int    `json:"percent"`
+	Message string `json:"message"`
+}
+
+// LogParams are the params of the log notification, level is one of debug, info, warn and error
+type LogParams struct {
+	Level   string         `json:"level"`
+	Message string         `json:"message"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// RPCError is the error of a response
+type RPCError struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *ErrorData `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("plugin error %d: %s", e.Code, e.Message)
+}
+
+// ErrorData tells the worker how to retry a failed task, the failed tasks are retried by default
+type ErrorData struct {
+	// Permanent stops the worker from retrying the task
+	Permanent bool `json:"permanent,omitempty"`
+	// RetryAfterInSeconds asks the worker to wait at least this long before retrying the task
+	RetryAfterInSeconds int `json:"retry_after_in_seconds,omitempty"`
+}
+
+// errMessageTooLarge is returned when a line is longer than the limit, the connection can't be read anymore after it
+var errMessageTooLarge = errors.New("message of the plugin is larger than the limit")
+
+// codec reads and writes the line delimited messages of a connection, writes are safe for concurrent use
+type codec struct {
+	reader *bufio.Reader
+	limit  int
+
+	writeLock sync.Mutex
+	writer    io.Writer
+}
+
+func newCodec(r io.Reader, w io.Writer, maxMessageBytes int) *codec {
+	return &codec{
+		reader: bufio.NewReader(r),
+		limit:  maxMessageBytes,
+		writer: w,
+	}
+}
+
+// read returns the next message, the empty lines are skipped
+func (c *codec) read() (*message, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg message
+		err = json.Unmarshal(line, &msg)
+		if err != nil {
+			return nil, fmt.Errorf("message of the plugin is not a valid JSON: %w", err)
+		}
+		if msg.JSONRPC != jsonRPCVersion {
+			return nil, fmt.Errorf("message of the plugin has an unsupported jsonrpc version %q", msg.JSONRPC)
+		}
+
+		return &msg, nil
+	}
+}
+
+// readLine reads up to the next new line without keeping more than the limit in memory
+func (c *codec) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		fragment, isPrefix, err := c.reader.ReadLine()
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return line, nil
+			}
+			return nil, err
+		}
+		if len(line)+len(fragment) > c.limit {
+			return nil, errMessageTooLarge
+		}
+		line = append(line, fragment...)
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+func (c *codec) write(msg *message) error {
+	msg.JSONRPC = jsonRPCVersion
+	encodedMessage, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err = c.writer.Write(append(encodedMessage, '\n'))
+	return err
+}
+
+// notify writes a notification, which is a message without any id
+func (c *codec) notify(method string, params any) error {
+	encodedParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return c.write(&message{Method: method, Params: encodedParams})
+}
@@ -0,0 +1,115 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os/exec"
+	"sort"
+	"time"
+)
+
+// basePath is given to the launched handlers, which don't inherit the environment of the worker
+const basePath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// launch starts the handler command for a task, its stderr is written to the logs of the task
+func (p PluginTask) launch(logger *slog.Logger) (*conn, error) {
+	command := exec.Command(p.config.Command, p.config.Args...)
+	command.Env = commandEnv(p.config.Env)
+	setProcessGroup(command)
+
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = command.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	stderrDone := make(chan struct{})
+	go func() {
+		defer close(stderrDone)
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Info("Plugin has written to stderr", "line", scanner.Text())
+		}
+		// The rest is drained, otherwise the handler is blocked on a long line
+		_, _ = io.Copy(io.Discard, stderr)
+	}()
+
+	return &conn{
+		reader: stdout,
+		writer: stdin,
+		close: func(gracePeriod time.Duration) error {
+			// Closing stdin tells the handler that no more messages are coming, it's killed if it doesn't exit in time
+			// Wait is only called here, since it closes stdout which might still be read before
+			_ = stdin.Close()
+			exited := make(chan error, 1)
+			go func() {
+				<-stderrDone
+				exited <- command.Wait()
+			}()
+			select {
+			case err := <-exited:
+				return ignoreExitError(err)
+			case <-time.After(gracePeriod):
+				killProcessGroup(command)
+				<-exited
+				return errors.New("plugin is killed since it hasn't exited in time")
+			}
+		},
+	}, nil
+}
+
+// dial connects to the unix socket of a running handler
+func (p PluginTask) dial(ctx context.Context) (*conn, error) {
+	var dialer net.Dialer
+	connection, err := dialer.DialContext(ctx, "unix", p.config.Socket)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{
+		reader: connection,
+		writer: connection,
+		close: func(time.Duration) error {
+			return connection.Close()
+		},
+	}, nil
+}
+
+// ignoreExitError drops the exit code of the handler, since the response is what decides the status of the task
+func ignoreExitError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+
+	return err
+}
+
+func commandEnv(env map[string]string) []string {
+	commandEnv := []string{"PATH=" + basePath}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		commandEnv = append(commandEnv, name+"="+env[name])
+	}
+
+	return commandEnv
+}
@@ -1,30 +1,170 @@
 package process
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/sf7293/task-manager/internal/domain"
-	"github.com/sf7293/task-manager/pkg/email"
-	"github.com/sf7293/task-manager/pkg/query"
-	"math/rand"
+	"log/slog"
 	"time"
 )
 
+// ProgressReporter receives the progress of a running task, percent is between 0 and 100
+type ProgressReporter interface {
+	ReportProgress(percent int, message string)
+}
+
+// TaskContext carries everything a process needs to know about the task which is being executed
+type TaskContext struct {
+	TaskID int32
+	// Attempt is the number of times the task has been picked up by the workers, including the current one
+	Attempt int32
+	// Deadline is the time when the context of the execution is cancelled
+	Deadline time.Time
+	Logger   *slog.Logger
+	Progress ProgressReporter
+	// Payload is the decoded payload of the task, its concrete type is the one returned by NewPayload of the task type definition
+	Payload Payload
+	// Caller is the client who has created the task, it's empty when the task is created without an API key
+	Caller string
+	// Spawner creates the child tasks of the task, it's nil when the process is not executed by a worker
+	Spawner Spawner
+
+	result          json.RawMessage
+	waitForChildren bool
+}
+
+// SetResult keeps the result of the task, which is stored by the worker when the execution is finished, even when it fails
+// The result must be encodable to JSON, the last result is kept when it's called more than once
+func (t *TaskContext) SetResult(result any) error {
+	encodedResult, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("result of the task cannot be encoded: %w", err)
+	}
+	t.result = encodedResult
+
+	return nil
+}
+
+// Result returns the result which is set by the process, it's nil when no result is set
+func (t *TaskContext) Result() json.RawMessage {
+	return t.result
+}
+
+// Spawn creates a child task on behalf of the caller of the task and queues it, it returns the id of the child
+// The child is validated like the tasks of the /tasks API, and the validation errors are permanent
+func (t *TaskContext) Spawn(ctx context.Context, child ChildTask) (int32, error) {
+	if t.Spawner == nil {
+		return 0, errors.New("child tasks cannot be spawned outside of a worker")
+	}
+
+	return t.Spawner.Spawn(ctx, t, child)
+}
+
+// WaitForChildren keeps the task waiting after a successful execution until all its children are finished
+// Then the task succeeds when all its children have succeeded, otherwise it fails
+func (t *TaskContext) WaitForChildren() {
+	t.waitForChildren = true
+}
+
+// WaitsForChildren tells whether the process has asked to wait for the children of the task
+func (t *TaskContext) WaitsForChildren() bool {
+	return t.waitForChildren
+}
+
+// ChildTask is a task which is spawned by a running task
+type ChildTask struct {
+	// Key identifies the child among the children of its parent, so a retried execution gets the existing child instead of spawning a duplicate
+	// The children without a key are spawned on every call
+	Key  string
+	Name string
+	Type string
+	// Priority is the default priority of the task type when it's empty
+	Priority domain.TaskPriority
+	// Payload is encoded to JSON, so it's either the payload struct of the task type or a json.RawMessage
+	Payload any
+}
+
+// Spawner creates the child tasks of the running tasks
+type Spawner interface {
+	Spawn(ctx context.Context, parent *TaskContext, child ChildTask) (int32, error)
+}
+
+// Process executes the tasks of a task type
+// Execute must return as soon as possible after ctx is done, and return the error of the context
 type Process interface {
-	Execute(params map[string]string) error
-}
-
-func NewProcess(taskType domain.TaskType) (Process, error) {
-	switch taskType {
-	case domain.SendEmail:
-		return email.NewSendEmailTask(), nil
-	case domain.RunQuery:
-		randomFunc := func() int {
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			return r.Intn(100) + 1
-		}
-		process := query.NewRunQueryTask(randomFunc)
-		return process, nil
-	default:
-		return nil, errors.New("unrecognized task type")
+	Execute(ctx context.Context, taskCtx *TaskContext) error
+}
+
+// LogProgressReporter reports the progress of the task using its logger
+type LogProgressReporter struct {
+	Logger *slog.Logger
+}
+
+func (r LogProgressReporter) ReportProgress(percent int, message string) {
+	r.Logger.Info("Task progress is reported", "percent", percent, "message", message)
+}
+
+// PermanentError is returned by processes when retrying the execution won't help, like an invalid recipient address
+// The worker stops retrying the task as soon as it gets a permanent error
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks the error as permanent, so the execution is not retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent checks whether the error or any error in its chain is permanent
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr)
+}
+
+// RetryAfterError is returned by processes when the other side asks to be retried after a delay, like the Retry-After header of HTTP
+// The worker waits at least the delay before the next retry
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter asks the worker to wait at least the delay before retrying the error
+func RetryAfter(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+
+	return &RetryAfterError{Err: err, After: after}
+}
+
+// RetryDelay returns the delay which is requested by the error or any error in its chain
+func RetryDelay(err error) (time.Duration, bool) {
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		return retryAfterErr.After, true
 	}
+
+	return 0, false
 }
@@ -0,0 +1,91 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestRetryDelay: Checking the requested delay is found in the chain of the error, and it's kept by permanent errors
+func TestRetryDelay(t *testing.T) {
+	err := fmt.Errorf("calling the API: %w", RetryAfter(errors.New("too many requests"), 5*time.Second))
+	delay, ok := RetryDelay(err)
+	if !ok || delay != 5*time.Second {
+		t.Fatalf("expected a delay of 5s, got %v", delay)
+	}
+	if IsPermanent(err) {
+		t.Fatalf("expected the error not to be permanent")
+	}
+
+	_, ok = RetryDelay(errors.New("connection reset"))
+	if ok {
+		t.Fatalf("expected no delay for a plain error")
+	}
+	if RetryAfter(nil, time.Second) != nil {
+		t.Fatalf("expected nil for a nil error")
+	}
+}
+
+// TestTaskContext_SetResult: Checking the last result is kept as JSON
+func TestTaskContext_SetResult(t *testing.T) {
+	taskCtx := &TaskContext{}
+	if taskCtx.Result() != nil {
+		t.Fatalf("expected no result, got %s", taskCtx.Result())
+	}
+
+	_ = taskCtx.SetResult(map[string]int{"attempt": 1})
+	err := taskCtx.SetResult(map[string]int{"attempt": 2})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if string(taskCtx.Result()) != `{"attempt":2}` {
+		t.Fatalf("expected the last result, got %s", taskCtx.Result())
+	}
+
+	err = taskCtx.SetResult(make(chan int))
+	if err == nil {
+		t.Fatalf("expected an error for a result which can't be encoded, got nil")
+	}
+}
+
+type recordingSpawner struct {
+	children []ChildTask
+}
+
+func (s *recordingSpawner) Spawn(_ context.Context, parent *TaskContext, child ChildTask) (int32, error) {
+	s.children = append(s.children, child)
+	return parent.TaskID + int32(len(s.children)), nil
+}
+
+// TestTaskContext_Spawn: Checking the children are passed to the spawner with their parent, and spawning fails without a spawner
+func TestTaskContext_Spawn(t *testing.T) {
+	spawner := &recordingSpawner{}
+	taskCtx := &TaskContext{TaskID: 10, Spawner: spawner}
+	childID, err := taskCtx.Spawn(context.Background(), ChildTask{Key: "row_1", Name: "email_row_1", Type: "send_email"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if childID != 11 || len(spawner.children) != 1 || spawner.children[0].Key != "row_1" {
+		t.Fatalf("expected the child to be spawned with id 11, got %d and %+v", childID, spawner.children)
+	}
+
+	_, err = (&TaskContext{}).Spawn(context.Background(), ChildTask{Type: "send_email"})
+	if err == nil {
+		t.Fatalf("expected an error without a spawner")
+	}
+}
+
+// TestTaskContext_WaitForChildren: Checking the task only waits for its children when the process asks for it
+func TestTaskContext_WaitForChildren(t *testing.T) {
+	taskCtx := &TaskContext{}
+	if taskCtx.WaitsForChildren() {
+		t.Fatalf("expected the task not to wait for its children by default")
+	}
+
+	taskCtx.WaitForChildren()
+	if !taskCtx.WaitsForChildren() {
+		t.Fatalf("expected the task to wait for its children")
+	}
+}
@@ -0,0 +1,154 @@
+package process
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/sf7293/task-manager/internal/domain"
+	"sort"
+	"time"
+)
+
+// Factory creates a new process for each task of its type
+type Factory func() Process
+
+// Payload is the typed payload of a task type, it's validated after being decoded
+type Payload interface {
+	Validate() error
+}
+
+// Definition is everything the server, the storage and the workers need to know about a task type
+type Definition struct {
+	// Name is stored in the type column of the tasks, so it must not be changed after tasks are created
+	Name string
+	// PayloadSchema is the JSON schema of the payload of the tasks
+	PayloadSchema json.RawMessage
+	// NewPayload returns a pointer to a new empty payload struct of the task type, which the payloads are decoded into
+	NewPayload func() Payload
+	Factory    Factory
+	// DefaultPriority is used when no priority is provided in creating the task
+	DefaultPriority domain.TaskPriority
+	// Timeout limits the process time of the task by the worker, the worker timeout config is used when it's zero
+	Timeout time.Duration
+	// MaxRetries is the number of times the worker retries a failed execution before marking the task as failed
+	MaxRetries uint64
+	// Authorize checks whether the caller is allowed to create a task with the payload, it's optional
+	// The returned error is shown to the caller
+	Authorize func(caller string, payload Payload) error
+}
+
+// DecodePayload decodes the raw payload into the payload struct of the task type and validates it
+func (d Definition) DecodePayload(rawPayload json.RawMessage) (Payload, error) {
+	payload := d.NewPayload()
+	err := json.Unmarshal(rawPayload, payload)
+	if err != nil {
+		return nil, fmt.Errorf("payload of %s tasks cannot be decoded: %w", d.Name, err)
+	}
+
+	err = payload.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("payload of %s tasks is invalid: %w", d.Name, err)
+	}
+
+	return payload, nil
+}
+
+// Registry holds the definitions of all task types which are able to be processed
+// It must be fully populated before it's used, because it's not safe for concurrent registrations
+type Registry struct {
+	definitions map[string]Definition
+	schemas     map[string]*jsonschema.Schema
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		definitions: map[string]Definition{},
+		schemas:     map[string]*jsonschema.Schema{},
+	}
+}
+
+// Register adds the definition of a new task type, a task type can only be registered once
+func (r *Registry) Register(definition Definition) error {
+	if definition.Name == "" {
+		return errors.New("task type name must not be empty")
+	}
+	if definition.Factory == nil {
+		return fmt.Errorf("factory of task type %q must not be nil", definition.Name)
+	}
+	if definition.NewPayload == nil {
+		return fmt.Errorf("payload of task type %q must not be nil", definition.Name)
+	}
+	if _, ok := r.definitions[definition.Name]; ok {
+		return fmt.Errorf("task type %q is already registered", definition.Name)
+	}
+	if definition.DefaultPriority == "" {
+		definition.DefaultPriority = domain.Normal
+	}
+
+	// The schema is compiled once here, so an invalid schema stops the app from starting instead of failing the requests
+	if len(definition.PayloadSchema) > 0 {
+		schema, err := compilePayloadSchema(definition.Name, definition.PayloadSchema)
+		if err != nil {
+			return err
+		}
+		r.schemas[definition.Name] = schema
+	}
+
+	r.definitions[definition.Name] = definition
+	return nil
+}
+
+// Lookup returns the definition of the task type, the second result is false if it's not registered
+func (r *Registry) Lookup(taskType string) (Definition, bool) {
+	definition, ok := r.definitions[taskType]
+	return definition, ok
+}
+
+// IsRegistered implements domain.TaskTypeRegistry
+func (r *Registry) IsRegistered(taskType string) bool {
+	_, ok := r.definitions[taskType]
+	return ok
+}
+
+// ValidatePayload validates the payload against the JSON schema of the task type
+// A *PayloadValidationError is returned which contains all the invalid fields, the task types without any schema accept all payloads
+func (r *Registry) ValidatePayload(taskType string, payload json.RawMessage) error {
+	schema, ok := r.schemas[taskType]
+	if !ok {
+		return nil
+	}
+
+	return validateAgainstSchema(taskType, schema, payload)
+}
+
+// Definitions returns the definitions of all registered task types in alphabetical order of their names
+func (r *Registry) Definitions() []Definition {
+	definitions := make([]Definition, 0, len(r.definitions))
+	for _, name := range r.Names() {
+		definitions = append(definitions, r.definitions[name])
+	}
+
+	return definitions
+}
+
+// Names returns the names of all registered task types in alphabetical order
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.definitions))
+	for name := range r.definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewProcess creates a process for the task type using its registered factory
+func (r *Registry) NewProcess(taskType string) (Process, error) {
+	definition, ok := r.definitions[taskType]
+	if !ok {
+		return nil, errors.New("unrecognized task type")
+	}
+
+	return definition.Factory(), nil
+}
@@ -0,0 +1,131 @@
+package process
+
+import (
+	"context"
+	"testing"
+)
+
+type noopProcess struct{}
+
+func (p noopProcess) Execute(ctx context.Context, taskCtx *TaskContext) error {
+	return nil
+}
+
+type noopPayload struct{}
+
+func (p *noopPayload) Validate() error {
+	return nil
+}
+
+func newNoopDefinition(name string) Definition {
+	return Definition{
+		Name: name,
+		NewPayload: func() Payload {
+			return &noopPayload{}
+		},
+		Factory: func() Process {
+			return noopProcess{}
+		},
+	}
+}
+
+// TestRegistry_Register: Checking registered task types are looked up with the default priority
+func TestRegistry_Register(t *testing.T) {
+	registry := NewRegistry()
+	err := registry.Register(newNoopDefinition("noop"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	definition, ok := registry.Lookup("noop")
+	if !ok {
+		t.Fatalf("expected noop to be registered")
+	}
+	if definition.DefaultPriority != "normal" {
+		t.Fatalf("expected normal default priority, got %s", definition.DefaultPriority)
+	}
+
+	_, err = registry.NewProcess("noop")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+// TestRegistry_Register_Duplicate: Testing a task type cannot be registered twice
+func TestRegistry_Register_Duplicate(t *testing.T) {
+	registry := NewRegistry()
+	err := registry.Register(newNoopDefinition("noop"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = registry.Register(newNoopDefinition("noop"))
+	if err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+}
+
+// TestRegistry_NewProcess_Unregistered: Testing no process is created for unregistered task types
+func TestRegistry_NewProcess_Unregistered(t *testing.T) {
+	registry := NewRegistry()
+	if registry.IsRegistered("unknown") {
+		t.Fatalf("expected unknown not to be registered")
+	}
+
+	_, err := registry.NewProcess("unknown")
+	if err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+}
+
+// TestRegistry_ValidatePayload: Testing field-level errors are returned for payloads which don't match the schema
+func TestRegistry_ValidatePayload(t *testing.T) {
+	definition := newNoopDefinition("noop")
+	definition.PayloadSchema = []byte(`{
+		"type": "object",
+		"required": ["to"],
+		"properties": {
+			"to": {"type": "array", "items": {"type": "string"}},
+			"retries": {"type": "integer", "minimum": 0}
+		}
+	}`)
+	registry := NewRegistry()
+	err := registry.Register(definition)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = registry.ValidatePayload("noop", []byte(`{"to":["user@example.com"],"retries":2}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = registry.ValidatePayload("noop", []byte(`{"to":["user@example.com", 3],"retries":-1}`))
+	validationErr, ok := err.(*PayloadValidationError)
+	if !ok {
+		t.Fatalf("expected a payload validation error, got %v", err)
+	}
+	fields := map[string]bool{}
+	for _, fieldErr := range validationErr.Fields {
+		fields[fieldErr.Field] = true
+	}
+	if !fields["/to/1"] || !fields["/retries"] {
+		t.Fatalf("expected errors of /to/1 and /retries, got %v", validationErr.Fields)
+	}
+
+	err = registry.ValidatePayload("noop", []byte(`{}`))
+	if err == nil {
+		t.Fatalf("expected an error for the missing required field, got nil")
+	}
+}
+
+// TestRegistry_Register_InvalidSchema: Testing a task type with an invalid schema cannot be registered
+func TestRegistry_Register_InvalidSchema(t *testing.T) {
+	definition := newNoopDefinition("noop")
+	definition.PayloadSchema = []byte(`{"type": 12}`)
+
+	err := NewRegistry().Register(definition)
+	if err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+}
@@ -0,0 +1,94 @@
+package process
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"strings"
+)
+
+// FieldError is a validation error of one field of the payload
+type FieldError struct {
+	// Field is the JSON pointer of the invalid field in the payload, it's empty for the errors of the payload itself
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// PayloadValidationError is returned when the payload doesn't match the JSON schema of its task type
+type PayloadValidationError struct {
+	TaskType string
+	Fields   []FieldError
+}
+
+func (e *PayloadValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field.Field, field.Message))
+	}
+
+	return fmt.Sprintf("payload of %s tasks doesn't match its schema: %s", e.TaskType, strings.Join(messages, ", "))
+}
+
+func compilePayloadSchema(taskType string, schema json.RawMessage) (*jsonschema.Schema, error) {
+	url := "task-types/" + taskType + "/payload.json"
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	err := compiler.AddResource(url, bytes.NewReader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("payload schema of task type %q is invalid: %w", taskType, err)
+	}
+
+	compiledSchema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("payload schema of task type %q cannot be compiled: %w", taskType, err)
+	}
+
+	return compiledSchema, nil
+}
+
+// validateAgainstSchema returns a *PayloadValidationError containing all the invalid fields of the payload
+func validateAgainstSchema(taskType string, schema *jsonschema.Schema, payload json.RawMessage) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	// The validator needs the numbers as json.Number to check their exact value
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return &PayloadValidationError{
+			TaskType: taskType,
+			Fields:   []FieldError{{Message: "payload is not a valid JSON"}},
+		}
+	}
+
+	err = schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+
+	return &PayloadValidationError{
+		TaskType: taskType,
+		Fields:   collectFieldErrors(validationErr, []FieldError{}),
+	}
+}
+
+// collectFieldErrors only collects the leaf errors, because the parent errors just say that their causes have failed
+func collectFieldErrors(validationErr *jsonschema.ValidationError, fieldErrors []FieldError) []FieldError {
+	if len(validationErr.Causes) == 0 {
+		return append(fieldErrors, FieldError{
+			Field:   validationErr.InstanceLocation,
+			Message: validationErr.Message,
+		})
+	}
+
+	for _, cause := range validationErr.Causes {
+		fieldErrors = collectFieldErrors(cause, fieldErrors)
+	}
+
+	return fieldErrors
+}
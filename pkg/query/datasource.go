@@ -0,0 +1,121 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// DriverPostgres is the default driver of the data sources
+const DriverPostgres = "postgres"
+
+// anyCaller in the allowed callers of a data source allows all callers, including the tasks without a caller
+const anyCaller = "*"
+
+// DataSourceConfig is a named database which the queries are run against
+type DataSourceConfig struct {
+	Name   string
+	Driver string
+	URI    string
+	// AllowWrites lets the payloads with read_write run their queries outside of read-only transactions
+	AllowWrites bool
+	// AllowedCallers are the callers which are able to query the data source
+	AllowedCallers []string
+}
+
+// Statement is a query with its parameters and the limits which it's run with
+type Statement struct {
+	SQL      string
+	Params   []any
+	ReadOnly bool
+	// Timeout is the statement timeout of the database
+	Timeout time.Duration
+	// MaxRows is the number of rows which are read, the rest of the rows are dropped and the result is marked as truncated
+	MaxRows int
+}
+
+// ResultSet is the rows which are returned by a statement, each row has a value for each column
+type ResultSet struct {
+	Columns   []string
+	Rows      [][]any
+	Truncated bool
+}
+
+// Executor runs the statements on a database
+type Executor interface {
+	Run(ctx context.Context, statement Statement) (*ResultSet, error)
+}
+
+// DataSource is a configured database with the callers which are allowed to query it
+type DataSource struct {
+	config   DataSourceConfig
+	executor Executor
+}
+
+func (d *DataSource) Name() string {
+	return d.config.Name
+}
+
+// IsAllowed checks whether the caller is in the allowed callers of the data source
+func (d *DataSource) IsAllowed(caller string) bool {
+	return slices.Contains(d.config.AllowedCallers, anyCaller) || (caller != "" && slices.Contains(d.config.AllowedCallers, caller))
+}
+
+// DataSources holds the configured data sources, the connections are opened by the first query of each data source
+type DataSources struct {
+	sources           map[string]*DataSource
+	defaultDataSource string
+}
+
+// NewDataSources validates the configs of the data sources, the default data source is optional
+func NewDataSources(configs []DataSourceConfig, defaultDataSource string) (*DataSources, error) {
+	dataSources := &DataSources{
+		sources:           map[string]*DataSource{},
+		defaultDataSource: defaultDataSource,
+	}
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, errors.New("name of data sources must not be empty")
+		}
+		if _, ok := dataSources.sources[config.Name]; ok {
+			return nil, fmt.Errorf("data source %q is defined more than once", config.Name)
+		}
+		if config.Driver == "" {
+			config.Driver = DriverPostgres
+		}
+		if config.Driver != DriverPostgres {
+			return nil, fmt.Errorf("driver %q of data source %q is not supported", config.Driver, config.Name)
+		}
+
+		dataSources.sources[config.Name] = &DataSource{
+			config:   config,
+			executor: newPostgresExecutor(config.URI),
+		}
+	}
+	if defaultDataSource != "" {
+		if _, ok := dataSources.sources[defaultDataSource]; !ok {
+			return nil, fmt.Errorf("default data source %q is not defined", defaultDataSource)
+		}
+	}
+
+	return dataSources, nil
+}
+
+// Lookup returns the data source by its name, the default data source is returned when the name is empty
+func (d *DataSources) Lookup(name string) (*DataSource, error) {
+	if name == "" {
+		name = d.defaultDataSource
+	}
+	if name == "" {
+		return nil, errors.New("data_source must be set, since there is no default data source")
+	}
+
+	dataSource, ok := d.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("data source %q is not defined", name)
+	}
+
+	return dataSource, nil
+}
@@ -0,0 +1,157 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sf7293/task-manager/pkg/process"
+	"strings"
+	"sync"
+)
+
+// postgresExecutor runs the statements on a postgres database, the pool is connected on the first statement
+type postgresExecutor struct {
+	uri string
+
+	mu   sync.Mutex
+	pool *pgxpool.Pool
+}
+
+func newPostgresExecutor(uri string) *postgresExecutor {
+	return &postgresExecutor{
+		uri: uri,
+	}
+}
+
+func (e *postgresExecutor) connect(ctx context.Context) (*pgxpool.Pool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.pool != nil {
+		return e.pool, nil
+	}
+
+	config, err := pgxpool.ParseConfig(e.uri)
+	if err != nil {
+		// The URI is not going to be fixed by retrying
+		return nil, process.Permanent(fmt.Errorf("parsing the URI of the data source: %w", err))
+	}
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the data source: %w", err)
+	}
+	e.pool = pool
+
+	return pool, nil
+}
+
+// Run executes the statement in a transaction, which is rolled back when the statement is read-only
+func (e *postgresExecutor) Run(ctx context.Context, statement Statement) (*ResultSet, error) {
+	pool, err := e.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	accessMode := pgx.ReadWrite
+	if statement.ReadOnly {
+		accessMode = pgx.ReadOnly
+	}
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{AccessMode: accessMode})
+	if err != nil {
+		return nil, classifyPostgresError(err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if statement.Timeout > 0 {
+		// SET doesn't accept parameters, the value is an integer so it's safe to be formatted into the statement
+		_, err = tx.Exec(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", statement.Timeout.Milliseconds()))
+		if err != nil {
+			return nil, classifyPostgresError(err)
+		}
+	}
+
+	rows, err := tx.Query(ctx, statement.SQL, statement.Params...)
+	if err != nil {
+		return nil, classifyPostgresError(err)
+	}
+	resultSet, err := readRows(rows, statement.MaxRows)
+	if err != nil {
+		return nil, classifyPostgresError(err)
+	}
+
+	if !statement.ReadOnly {
+		err = tx.Commit(ctx)
+		if err != nil {
+			return nil, classifyPostgresError(err)
+		}
+	}
+
+	return resultSet, nil
+}
+
+// readRows reads at most maxRows rows, and closes the rows as soon as one more row is found
+func readRows(rows pgx.Rows, maxRows int) (*ResultSet, error) {
+	defer rows.Close()
+
+	resultSet := &ResultSet{Rows: [][]any{}}
+	for _, field := range rows.FieldDescriptions() {
+		resultSet.Columns = append(resultSet.Columns, string(field.Name))
+	}
+	for rows.Next() {
+		if len(resultSet.Rows) >= maxRows {
+			resultSet.Truncated = true
+			break
+		}
+
+		values, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+		for i, value := range values {
+			values[i] = normalizeValue(value)
+		}
+		resultSet.Rows = append(resultSet.Rows, values)
+	}
+	rows.Close()
+
+	return resultSet, rows.Err()
+}
+
+// normalizeValue converts the values of the postgres types which have no JSON representation, like numeric and uuid
+func normalizeValue(value any) any {
+	switch v := value.(type) {
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])
+	case driver.Valuer:
+		normalizedValue, err := v.Value()
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return normalizedValue
+	}
+
+	return value
+}
+
+// classifyPostgresError marks the errors of the query itself as permanent, like syntax errors, permission errors, writing in a read-only transaction and the statement timeout
+// Connection errors, serialization failures and the errors of an overloaded database are left transient
+func classifyPostgresError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	for _, transientClass := range []string{"08", "40", "53", "57P"} {
+		if strings.HasPrefix(pgErr.Code, transientClass) {
+			return err
+		}
+	}
+
+	return process.Permanent(err)
+}
@@ -1,33 +1,158 @@
 package query
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"log/slog"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/pkg/process"
+	"strings"
 	"time"
 )
 
+const TaskTypeName = "run_query"
+
+var payloadSchema = json.RawMessage(`{
+	"type": "object",
+	"required": ["query"],
+	"properties": {
+		"data_source": {"type": "string", "minLength": 1, "description": "The default data source is used when it's not set"},
+		"query": {"type": "string", "minLength": 1, "description": "Parameters are referred by $1, $2, ..."},
+		"params": {"type": "array"},
+		"read_write": {"type": "boolean", "description": "Queries are run in read-only transactions unless it's set, and the data source allows writes"},
+		"format": {"type": "string", "enum": ["json", "csv"]},
+		"max_rows": {"type": "integer", "minimum": 1, "description": "It's limited by the row limit of the workers"}
+	}
+}`)
+
+// Payload is the payload of run_query tasks
+type Payload struct {
+	DataSource string `json:"data_source,omitempty"`
+	Query      string `json:"query"`
+	Params     []any  `json:"params,omitempty"`
+	ReadWrite  bool   `json:"read_write,omitempty"`
+	Format     string `json:"format,omitempty"`
+	MaxRows    int    `json:"max_rows,omitempty"`
+}
+
+func (p *Payload) Validate() error {
+	if strings.TrimSpace(p.Query) == "" {
+		return errors.New("query must not be empty")
+	}
+	if p.Format != "" && p.Format != FormatJSON && p.Format != FormatCSV {
+		return fmt.Errorf("format must be %s or %s", FormatJSON, FormatCSV)
+	}
+	if p.MaxRows < 0 {
+		return errors.New("max_rows must not be negative")
+	}
+
+	return nil
+}
+
+// Limits are applied to all the queries, whatever their payload asks for
+type Limits struct {
+	StatementTimeout time.Duration
+	MaxRows          int
+}
+
+// Definition registers run_query in the task type registry, the queries are run against the given data sources
+func Definition(dataSources *DataSources, limits Limits) process.Definition {
+	return process.Definition{
+		Name:          TaskTypeName,
+		PayloadSchema: payloadSchema,
+		NewPayload: func() process.Payload {
+			return &Payload{}
+		},
+		Factory: func() process.Process {
+			return NewRunQueryTask(dataSources, limits)
+		},
+		DefaultPriority: domain.Normal,
+		Timeout:         60 * time.Second,
+		MaxRetries:      5,
+		Authorize: func(caller string, payload process.Payload) error {
+			_, err := authorize(dataSources, caller, payload.(*Payload))
+			return err
+		},
+	}
+}
+
+// authorize returns the data source of the payload when the caller is allowed to run the query on it
+func authorize(dataSources *DataSources, caller string, payload *Payload) (*DataSource, error) {
+	dataSource, err := dataSources.Lookup(payload.DataSource)
+	if err != nil {
+		return nil, err
+	}
+	if !dataSource.IsAllowed(caller) {
+		return nil, fmt.Errorf("caller %q is not allowed to query data source %q", caller, dataSource.Name())
+	}
+	// The tasks without an authenticated caller are only able to query the read-only data sources, even when they allow every caller
+	if caller == "" && dataSource.config.AllowWrites {
+		return nil, fmt.Errorf("data source %q allows writes, so it's only queried by authenticated callers", dataSource.Name())
+	}
+	if payload.ReadWrite && !dataSource.config.AllowWrites {
+		return nil, fmt.Errorf("data source %q doesn't allow writes", dataSource.Name())
+	}
+
+	return dataSource, nil
+}
+
 type RunQueryTask struct {
-	RandomFunc func() int
+	dataSources *DataSources
+	limits      Limits
 }
 
-// NewRunQueryTask is a constructor that takes a random function as a dependency
-func NewRunQueryTask(randomFunc func() int) RunQueryTask {
+func NewRunQueryTask(dataSources *DataSources, limits Limits) RunQueryTask {
 	return RunQueryTask{
-		RandomFunc: randomFunc,
+		dataSources: dataSources,
+		limits:      limits,
 	}
 }
 
-func (q RunQueryTask) Execute(params map[string]string) (err error) {
-	slog.Info("run_query parameters:", "params", params)
-	time.Sleep(3 * time.Second)
+// Execute runs the query and stores its rows as the result of the task
+// The caller is checked again, since the allowed callers might have been changed after the task is created
+func (q RunQueryTask) Execute(ctx context.Context, taskCtx *process.TaskContext) (err error) {
+	payload, ok := taskCtx.Payload.(*Payload)
+	if !ok {
+		return process.Permanent(errors.New("run_query task is executed with an unexpected payload"))
+	}
+
+	dataSource, err := authorize(q.dataSources, taskCtx.Caller, payload)
+	if err != nil {
+		return process.Permanent(err)
+	}
+
+	maxRows := q.limits.MaxRows
+	if payload.MaxRows > 0 && payload.MaxRows < maxRows {
+		maxRows = payload.MaxRows
+	}
+
+	taskCtx.Logger.Info("Running query", "data_source", dataSource.Name(), "read_write", payload.ReadWrite, "max_rows", maxRows)
+	resultSet, err := dataSource.executor.Run(ctx, Statement{
+		SQL:      payload.Query,
+		Params:   payload.Params,
+		ReadOnly: !payload.ReadWrite,
+		Timeout:  q.limits.StatementTimeout,
+		MaxRows:  maxRows,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		taskCtx.Logger.Warn("Error occurred while executing the query", "data_source", dataSource.Name(), "is_permanent", process.IsPermanent(err), "error", err.Error())
+		return err
+	}
 
-	// q.Random func is an injected function which returns random number between 1 and 100
-	randomNumber := q.RandomFunc()
-	// This function fails for 20% of times
-	if randomNumber <= 20 {
-		slog.Warn("Error occurred while executing the query", "params", params)
-		return errors.New("run_query failed")
+	result, err := newResult(dataSource.Name(), payload.Format, resultSet)
+	if err != nil {
+		return process.Permanent(err)
+	}
+	err = taskCtx.SetResult(result)
+	if err != nil {
+		return process.Permanent(err)
 	}
+	taskCtx.Progress.ReportProgress(100, "query is executed")
 
 	return nil
 }
@@ -1,60 +1,241 @@
 package query
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/sf7293/task-manager/pkg/process"
+	"log/slog"
 	"testing"
+	"time"
 )
 
-// TestRunQueryTask_Execute_Success: Checking success when random number is greater than 20
-func TestRunQueryTask_Execute_Success(t *testing.T) {
-	// Mock the RandomFunc to always return a number greater than 20
-	task := NewRunQueryTask(func() int {
-		return 21
-	})
+// fakeExecutor returns its result set, and keeps the last statement which it has run
+type fakeExecutor struct {
+	resultSet *ResultSet
+	err       error
+	statement Statement
+}
+
+func (e *fakeExecutor) Run(ctx context.Context, statement Statement) (*ResultSet, error) {
+	e.statement = statement
+	if e.err != nil {
+		return nil, e.err
+	}
+	if e.resultSet == nil {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return e.resultSet, nil
+}
+
+func newTestDataSources(executor Executor) *DataSources {
+	return &DataSources{
+		sources: map[string]*DataSource{
+			"analytics": {
+				config:   DataSourceConfig{Name: "analytics", AllowedCallers: []string{"reporting"}},
+				executor: executor,
+			},
+			"public": {
+				config:   DataSourceConfig{Name: "public", AllowWrites: true, AllowedCallers: []string{"*"}},
+				executor: executor,
+			},
+		},
+		defaultDataSource: "public",
+	}
+}
+
+func newTestTaskContext(payload *Payload, caller string) *process.TaskContext {
+	return &process.TaskContext{
+		TaskID:   1,
+		Attempt:  1,
+		Logger:   slog.Default(),
+		Progress: process.LogProgressReporter{Logger: slog.Default()},
+		Payload:  payload,
+		Caller:   caller,
+	}
+}
 
-	params := map[string]string{
-		"query": "SELECT * FROM users",
+func newTestResultSet() *ResultSet {
+	return &ResultSet{
+		Columns:   []string{"id", "name"},
+		Rows:      [][]any{{int32(1), "first"}, {int32(2), nil}},
+		Truncated: true,
 	}
+}
 
-	err := task.Execute(params)
+// TestRunQueryTask_Execute_JSON: Checking the rows are stored as the result, and the query is run read-only with the limits
+func TestRunQueryTask_Execute_JSON(t *testing.T) {
+	executor := &fakeExecutor{resultSet: newTestResultSet()}
+	task := NewRunQueryTask(newTestDataSources(executor), Limits{StatementTimeout: 5 * time.Second, MaxRows: 100})
 
-	// Check if the function executed without errors
+	taskCtx := newTestTaskContext(&Payload{DataSource: "analytics", Query: "SELECT id, name FROM users WHERE id > $1", Params: []any{0.0}, MaxRows: 2}, "reporting")
+	err := task.Execute(context.Background(), taskCtx)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
+
+	if !executor.statement.ReadOnly || executor.statement.MaxRows != 2 || executor.statement.Timeout != 5*time.Second || len(executor.statement.Params) != 1 {
+		t.Fatalf("expected a read-only statement with the limits, got %+v", executor.statement)
+	}
+
+	var result struct {
+		DataSource string           `json:"data_source"`
+		Format     string           `json:"format"`
+		Columns    []string         `json:"columns"`
+		RowCount   int              `json:"row_count"`
+		Truncated  bool             `json:"truncated"`
+		Rows       []map[string]any `json:"rows"`
+	}
+	err = json.Unmarshal(taskCtx.Result(), &result)
+	if err != nil {
+		t.Fatalf("expected a JSON result, got %v", err)
+	}
+	if result.DataSource != "analytics" || result.Format != FormatJSON || result.RowCount != 2 || !result.Truncated {
+		t.Fatalf("unexpected result summary %s", taskCtx.Result())
+	}
+	if result.Rows[0]["name"] != "first" || result.Rows[1]["name"] != nil {
+		t.Fatalf("unexpected result rows %s", taskCtx.Result())
+	}
 }
 
-// TestRunQueryTask_Execute_Failure1: Testing failure while random number is exactly 20: Marginal test case
-func TestRunQueryTask_Execute_Failure1(t *testing.T) {
-	// Mock the RandomFunc to always return a number less than or equal to 20
-	task := NewRunQueryTask(func() int {
-		return 20
-	})
+// TestRunQueryTask_Execute_CSV: Checking the rows are stored as CSV with a header line
+func TestRunQueryTask_Execute_CSV(t *testing.T) {
+	task := NewRunQueryTask(newTestDataSources(&fakeExecutor{resultSet: newTestResultSet()}), Limits{MaxRows: 100})
 
-	params := map[string]string{
-		"query": "SELECT * FROM users",
+	taskCtx := newTestTaskContext(&Payload{Query: "SELECT id, name FROM users", Format: FormatCSV}, "reporting")
+	err := task.Execute(context.Background(), taskCtx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
 	}
 
-	err := task.Execute(params)
-	// Check if the function returned an error
-	if err == nil {
-		t.Fatalf("expected an error, got nil")
+	var result struct {
+		DataSource string `json:"data_source"`
+		CSV        string `json:"csv"`
+	}
+	err = json.Unmarshal(taskCtx.Result(), &result)
+	if err != nil {
+		t.Fatalf("expected a JSON result, got %v", err)
+	}
+	if result.DataSource != "public" {
+		t.Fatalf("expected the default data source, got %s", result.DataSource)
+	}
+	if result.CSV != "id,name\n1,first\n2,\n" {
+		t.Fatalf("unexpected CSV %q", result.CSV)
 	}
 }
 
-// TestRunQueryTask_Execute_Failure2: Testing failure while random number is less than 20
-func TestRunQueryTask_Execute_Failure2(t *testing.T) {
-	// Mock the RandomFunc to always return a number less than or equal to 20
-	task := NewRunQueryTask(func() int {
-		return 19
-	})
+// TestRunQueryTask_Execute_NotAllowed: Testing the callers which are not allowed, writes to read-only data sources and the unauthenticated callers of the writable data sources fail permanently
+func TestRunQueryTask_Execute_NotAllowed(t *testing.T) {
+	dataSources := newTestDataSources(&fakeExecutor{resultSet: newTestResultSet()})
+	task := NewRunQueryTask(dataSources, Limits{MaxRows: 100})
 
-	params := map[string]string{
-		"query": "SELECT * FROM users",
+	payloads := []struct {
+		payload *Payload
+		caller  string
+	}{
+		{payload: &Payload{DataSource: "analytics", Query: "SELECT 1"}, caller: "billing"},
+		{payload: &Payload{DataSource: "analytics", Query: "SELECT 1"}, caller: ""},
+		{payload: &Payload{DataSource: "analytics", Query: "DELETE FROM users", ReadWrite: true}, caller: "reporting"},
+		{payload: &Payload{DataSource: "unknown", Query: "SELECT 1"}, caller: "reporting"},
+		{payload: &Payload{DataSource: "public", Query: "SELECT 1"}, caller: ""},
 	}
+	for _, testCase := range payloads {
+		err := task.Execute(context.Background(), newTestTaskContext(testCase.payload, testCase.caller))
+		if !process.IsPermanent(err) {
+			t.Fatalf("expected a permanent error for %+v of caller %q, got %v", testCase.payload, testCase.caller, err)
+		}
 
-	err := task.Execute(params)
-	// Check if the function returned an error
+		err = Definition(dataSources, Limits{}).Authorize(testCase.caller, testCase.payload)
+		if err == nil {
+			t.Fatalf("expected the task creation to be rejected for %+v of caller %q", testCase.payload, testCase.caller)
+		}
+	}
+
+	err := Definition(dataSources, Limits{}).Authorize("billing", &Payload{DataSource: "public", Query: "DELETE FROM users", ReadWrite: true})
+	if err != nil {
+		t.Fatalf("expected writes to be allowed on the public data source, got %v", err)
+	}
+}
+
+// TestRunQueryTask_Execute_Cancelled: Testing the query stops when its context is cancelled
+func TestRunQueryTask_Execute_Cancelled(t *testing.T) {
+	task := NewRunQueryTask(newTestDataSources(&fakeExecutor{}), Limits{MaxRows: 100})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := task.Execute(ctx, newTestTaskContext(&Payload{Query: "SELECT pg_sleep(10)"}, "reporting"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+// TestClassifyPostgresError: Testing the errors of the query are permanent while the connection errors are transient
+func TestClassifyPostgresError(t *testing.T) {
+	permanentCodes := []string{"42601", "42501", "25006", "57014"}
+	for _, code := range permanentCodes {
+		if !process.IsPermanent(classifyPostgresError(&pgconn.PgError{Code: code})) {
+			t.Fatalf("expected %s to be permanent", code)
+		}
+	}
+
+	transientCodes := []string{"08006", "40001", "53300", "57P01"}
+	for _, code := range transientCodes {
+		if process.IsPermanent(classifyPostgresError(&pgconn.PgError{Code: code})) {
+			t.Fatalf("expected %s to be transient", code)
+		}
+	}
+
+	if process.IsPermanent(classifyPostgresError(errors.New("connection reset"))) {
+		t.Fatalf("expected connection errors to be transient")
+	}
+}
+
+// TestNewDataSources: Testing the configs of the data sources are validated
+func TestNewDataSources(t *testing.T) {
+	_, err := NewDataSources([]DataSourceConfig{{Name: "analytics", URI: "postgres://localhost/analytics"}}, "analytics")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	invalidConfigs := [][]DataSourceConfig{
+		{{Name: ""}},
+		{{Name: "analytics"}, {Name: "analytics"}},
+		{{Name: "analytics", Driver: "mysql"}},
+	}
+	for _, configs := range invalidConfigs {
+		_, err = NewDataSources(configs, "")
+		if err == nil {
+			t.Fatalf("expected an error for %+v, got nil", configs)
+		}
+	}
+
+	_, err = NewDataSources(nil, "analytics")
 	if err == nil {
-		t.Fatalf("expected an error, got nil")
+		t.Fatalf("expected an error for an undefined default data source, got nil")
+	}
+}
+
+// TestPayload_Validate: Testing the query of the payload is required
+func TestPayload_Validate(t *testing.T) {
+	definition := Definition(newTestDataSources(&fakeExecutor{}), Limits{})
+	_, err := definition.DecodePayload([]byte(`{"query":"SELECT 1"}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	invalidPayloads := []string{
+		`{"query":" "}`,
+		`{"query":"SELECT 1","format":"xml"}`,
+		`{"query":"SELECT 1","max_rows":-1}`,
+	}
+	for _, invalidPayload := range invalidPayloads {
+		_, err = definition.DecodePayload([]byte(invalidPayload))
+		if err == nil {
+			t.Fatalf("expected an error for %s, got nil", invalidPayload)
+		}
 	}
 }
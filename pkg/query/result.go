@@ -0,0 +1,109 @@
+package query
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// resultSummary is the part of the result which is the same in all formats
+type resultSummary struct {
+	DataSource string   `json:"data_source"`
+	Format     string   `json:"format"`
+	Columns    []string `json:"columns"`
+	RowCount   int      `json:"row_count"`
+	// Truncated is true when the query has returned more rows than the row limit
+	Truncated bool `json:"truncated"`
+}
+
+// jsonResult has a JSON object for each row, the value of the last column is kept when the query returns duplicate column names
+type jsonResult struct {
+	resultSummary
+	Rows []map[string]any `json:"rows"`
+}
+
+// csvResult has the rows as a CSV text with a header line
+type csvResult struct {
+	resultSummary
+	CSV string `json:"csv"`
+}
+
+// newResult returns the result of the task in the requested format, JSON is the default format
+func newResult(dataSource, format string, resultSet *ResultSet) (any, error) {
+	summary := resultSummary{
+		DataSource: dataSource,
+		Format:     format,
+		Columns:    resultSet.Columns,
+		RowCount:   len(resultSet.Rows),
+		Truncated:  resultSet.Truncated,
+	}
+
+	switch format {
+	case "", FormatJSON:
+		summary.Format = FormatJSON
+		rows := make([]map[string]any, 0, len(resultSet.Rows))
+		for _, values := range resultSet.Rows {
+			row := make(map[string]any, len(values))
+			for i, value := range values {
+				row[resultSet.Columns[i]] = value
+			}
+			rows = append(rows, row)
+		}
+
+		return jsonResult{resultSummary: summary, Rows: rows}, nil
+	case FormatCSV:
+		var buffer bytes.Buffer
+		writer := csv.NewWriter(&buffer)
+		err := writer.Write(resultSet.Columns)
+		if err != nil {
+			return nil, err
+		}
+		for _, values := range resultSet.Rows {
+			record := make([]string, 0, len(values))
+			for _, value := range values {
+				record = append(record, formatCSVValue(value))
+			}
+			err = writer.Write(record)
+			if err != nil {
+				return nil, err
+			}
+		}
+		writer.Flush()
+		if writer.Error() != nil {
+			return nil, writer.Error()
+		}
+
+		return csvResult{resultSummary: summary, CSV: buffer.String()}, nil
+	default:
+		return nil, fmt.Errorf("result format %q is not supported", format)
+	}
+}
+
+func formatCSVValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case map[string]any, []any:
+		encodedValue, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encodedValue)
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package shellcommand
+
+import "os/exec"
+
+// setProcessGroup keeps the default cancellation of exec, which only kills the command itself
+func setProcessGroup(command *exec.Cmd) {}
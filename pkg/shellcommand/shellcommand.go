@@ -0,0 +1,302 @@
+package shellcommand
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/pkg/process"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const TaskTypeName = "shell_command"
+
+// basePath is the only variable which the commands inherit, the environment of the worker carries credentials which must not leak to the commands
+const basePath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// anyCaller in the allowed callers allows all callers, including the tasks without a caller
+const anyCaller = "*"
+
+const (
+	defaultCommandTimeout = 5 * time.Minute
+	// waitDelay is the time which the output pipes are waited for after the command is killed, since they might be held open by its children
+	waitDelay = 5 * time.Second
+)
+
+var payloadSchema = json.RawMessage(`{
+	"type": "object",
+	"required": ["executable"],
+	"properties": {
+		"executable": {"type": "string", "minLength": 1, "description": "Absolute path of an allowed executable, it's run without any shell"},
+		"args": {"type": "array", "items": {"type": "string"}},
+		"env": {"type": "object", "additionalProperties": {"type": "string"}, "description": "Only the allowed names are accepted, PATH and LD_* are never accepted"},
+		"working_directory": {"type": "string", "description": "It must be inside one of the allowed working directories"},
+		"timeout_in_seconds": {"type": "integer", "minimum": 1, "description": "It's limited by the timeout of the task type"}
+	}
+}`)
+
+// Payload is the payload of shell_command tasks
+type Payload struct {
+	Executable       string            `json:"executable"`
+	Args             []string          `json:"args,omitempty"`
+	Env              map[string]string `json:"env,omitempty"`
+	WorkingDirectory string            `json:"working_directory,omitempty"`
+	TimeoutInSeconds int               `json:"timeout_in_seconds,omitempty"`
+}
+
+func (p *Payload) Validate() error {
+	if !filepath.IsAbs(p.Executable) {
+		return errors.New("executable must be an absolute path")
+	}
+	if p.WorkingDirectory != "" && !filepath.IsAbs(p.WorkingDirectory) {
+		return errors.New("working_directory must be an absolute path")
+	}
+	for name := range p.Env {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			return fmt.Errorf("env name %q is invalid", name)
+		}
+		if isProtectedEnvName(name) {
+			return fmt.Errorf("env name %q is not allowed to be set", name)
+		}
+	}
+	if p.TimeoutInSeconds < 0 {
+		return errors.New("timeout_in_seconds must not be negative")
+	}
+
+	return nil
+}
+
+// Config is the sandbox which the commands are run in
+type Config struct {
+	// AllowedExecutables are the absolute paths of the executables which are able to be run
+	AllowedExecutables []string
+	// AllowedWorkingDirectories are the directories which the commands are able to be run in, including their subdirectories
+	// The commands without a working directory are run in the first one, or in the temp directory when there is none
+	AllowedWorkingDirectories []string
+	// AllowedEnvNames are the environment variables which the payloads are able to set
+	// Variables like BASH_ENV or NODE_OPTIONS make an allowed executable run other code, so they must not be allowed
+	AllowedEnvNames []string
+	// AllowedCallers are the callers which are able to run the commands, "*" allows all callers including the tasks without a caller
+	AllowedCallers []string
+	// MaxOutputBytes caps each of stdout and stderr which are stored in the result
+	MaxOutputBytes int
+}
+
+// Validate checks that the allow-lists only have absolute paths, so they can't be matched by a relative path of a payload
+// PATH and LD_* are refused in the allowed env names, since they choose which code the allowed executables run
+func (c Config) Validate() error {
+	for _, path := range append(slices.Clone(c.AllowedExecutables), c.AllowedWorkingDirectories...) {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("allowed path %q of shell commands must be absolute", path)
+		}
+	}
+	for _, name := range c.AllowedEnvNames {
+		if isProtectedEnvName(name) {
+			return fmt.Errorf("env name %q of shell commands must not be allowed", name)
+		}
+	}
+	return nil
+}
+
+// isProtectedEnvName tells whether the variable chooses the code which is run, the payloads are never able to set them
+func isProtectedEnvName(name string) bool {
+	return name == "PATH" || strings.HasPrefix(name, "LD_")
+}
+
+// Definition registers shell_command in the task type registry
+// The commands are not retried by the worker, since the maintenance scripts are not expected to be safe to be run twice
+func Definition(config Config) process.Definition {
+	return process.Definition{
+		Name:          TaskTypeName,
+		PayloadSchema: payloadSchema,
+		NewPayload: func() process.Payload {
+			return &Payload{}
+		},
+		Factory: func() process.Process {
+			return NewShellCommandTask(config)
+		},
+		DefaultPriority: domain.Low,
+		Timeout:         30 * time.Minute,
+		MaxRetries:      0,
+		Authorize: func(caller string, payload process.Payload) error {
+			_, _, err := authorize(config, caller, payload.(*Payload))
+			return err
+		},
+	}
+}
+
+// authorize returns the executable and the working directory of the payload when the caller, the executable, the env names and the working directory are allowed
+func authorize(config Config, caller string, payload *Payload) (executable, workingDirectory string, err error) {
+	if !slices.Contains(config.AllowedCallers, anyCaller) && (caller == "" || !slices.Contains(config.AllowedCallers, caller)) {
+		return "", "", fmt.Errorf("caller %q is not allowed to run shell commands", caller)
+	}
+
+	executable = filepath.Clean(payload.Executable)
+	if !slices.Contains(config.AllowedExecutables, executable) {
+		return "", "", fmt.Errorf("executable %q is not allowed", payload.Executable)
+	}
+
+	for name := range payload.Env {
+		if isProtectedEnvName(name) || !slices.Contains(config.AllowedEnvNames, name) {
+			return "", "", fmt.Errorf("env name %q is not allowed", name)
+		}
+	}
+
+	if payload.WorkingDirectory == "" {
+		if len(config.AllowedWorkingDirectories) == 0 {
+			return executable, os.TempDir(), nil
+		}
+		return executable, config.AllowedWorkingDirectories[0], nil
+	}
+
+	workingDirectory = filepath.Clean(payload.WorkingDirectory)
+	for _, allowedDirectory := range config.AllowedWorkingDirectories {
+		allowedDirectory = filepath.Clean(allowedDirectory)
+		if workingDirectory == allowedDirectory || strings.HasPrefix(workingDirectory, allowedDirectory+string(filepath.Separator)) {
+			return executable, workingDirectory, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("working directory %q is not allowed", payload.WorkingDirectory)
+}
+
+// Result is the output of the command which is stored as the result of the task, whether the command succeeds or not
+type Result struct {
+	ExitCode        int    `json:"exit_code"`
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutTruncated bool   `json:"stdout_truncated"`
+	StderrTruncated bool   `json:"stderr_truncated"`
+	DurationInMs    int64  `json:"duration_in_ms"`
+}
+
+type ShellCommandTask struct {
+	config Config
+}
+
+func NewShellCommandTask(config Config) ShellCommandTask {
+	return ShellCommandTask{
+		config: config,
+	}
+}
+
+// Execute runs the command without a shell, the command and all of its children are killed when the task is cancelled or its deadline passes
+// A non-zero exit code is a permanent error, the output is stored as the result in both cases
+func (s ShellCommandTask) Execute(ctx context.Context, taskCtx *process.TaskContext) error {
+	payload, ok := taskCtx.Payload.(*Payload)
+	if !ok {
+		return process.Permanent(errors.New("shell_command task is executed with an unexpected payload"))
+	}
+
+	// The allow-lists are checked again, since they might have been changed after the task is created
+	executable, workingDirectory, err := authorize(s.config, taskCtx.Caller, payload)
+	if err != nil {
+		return process.Permanent(err)
+	}
+
+	timeout := defaultCommandTimeout
+	if payload.TimeoutInSeconds > 0 {
+		timeout = time.Duration(payload.TimeoutInSeconds) * time.Second
+	}
+	commandCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := newLimitedBuffer(s.config.MaxOutputBytes)
+	stderr := newLimitedBuffer(s.config.MaxOutputBytes)
+	command := exec.CommandContext(commandCtx, executable, payload.Args...)
+	command.Dir = workingDirectory
+	command.Env = commandEnv(payload.Env)
+	command.Stdout = stdout
+	command.Stderr = stderr
+	command.WaitDelay = waitDelay
+	setProcessGroup(command)
+
+	taskCtx.Logger.Info("Running shell command", "executable", executable, "args_count", len(payload.Args), "working_directory", workingDirectory)
+	start := time.Now()
+	err = command.Run()
+	// ExitCode of a command which hasn't been started is -1
+	result := Result{
+		ExitCode:        command.ProcessState.ExitCode(),
+		Stdout:          stdout.String(),
+		Stderr:          stderr.String(),
+		StdoutTruncated: stdout.truncated,
+		StderrTruncated: stderr.truncated,
+		DurationInMs:    time.Since(start).Milliseconds(),
+	}
+	if setResultErr := taskCtx.SetResult(result); setResultErr != nil {
+		return process.Permanent(setResultErr)
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if commandCtx.Err() != nil {
+			return process.Permanent(fmt.Errorf("shell command is killed after %s", timeout))
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			taskCtx.Logger.Warn("Shell command exited with an error", "exit_code", result.ExitCode)
+			return process.Permanent(fmt.Errorf("shell command exited with code %d", result.ExitCode))
+		}
+
+		// The executable couldn't be started, like when it doesn't exist on this worker
+		return process.Permanent(fmt.Errorf("starting the shell command: %w", err))
+	}
+	taskCtx.Progress.ReportProgress(100, "shell command is finished")
+
+	return nil
+}
+
+// commandEnv returns the environment of the payload on top of the base PATH, the protected variables of the payload are dropped even though they have been refused by authorize
+func commandEnv(env map[string]string) []string {
+	commandEnv := []string{"PATH=" + basePath}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		if isProtectedEnvName(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		commandEnv = append(commandEnv, name+"="+env[name])
+	}
+
+	return commandEnv
+}
+
+// limitedBuffer keeps the first bytes of the output up to its limit, the rest is dropped, so a chatty command doesn't exhaust the memory of the worker
+type limitedBuffer struct {
+	data      []byte
+	limit     int
+	truncated bool
+}
+
+func newLimitedBuffer(limit int) *limitedBuffer {
+	return &limitedBuffer{limit: limit}
+}
+
+// Write never fails, otherwise the command would be stopped by a broken pipe
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.limit - len(b.data)
+	if len(p) > remaining {
+		b.data = append(b.data, p[:remaining]...)
+		b.truncated = true
+		return len(p), nil
+	}
+	b.data = append(b.data, p...)
+
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return strings.ToValidUTF8(string(b.data), "�")
+}
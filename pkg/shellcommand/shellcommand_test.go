@@ -0,0 +1,204 @@
+package shellcommand
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/sf7293/task-manager/pkg/process"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTaskContext(payload *Payload) *process.TaskContext {
+	return &process.TaskContext{
+		TaskID:   1,
+		Attempt:  1,
+		Logger:   slog.Default(),
+		Progress: process.LogProgressReporter{Logger: slog.Default()},
+		Payload:  payload,
+		Caller:   "maintenance",
+	}
+}
+
+func decodeResult(t *testing.T, taskCtx *process.TaskContext) Result {
+	var result Result
+	err := json.Unmarshal(taskCtx.Result(), &result)
+	if err != nil {
+		t.Fatalf("expected a JSON result, got %v", err)
+	}
+
+	return result
+}
+
+func newTestConfig(t *testing.T) Config {
+	return Config{
+		AllowedExecutables:        []string{"/bin/sh"},
+		AllowedWorkingDirectories: []string{t.TempDir()},
+		AllowedEnvNames:           []string{"GREETING"},
+		AllowedCallers:            []string{"maintenance"},
+		MaxOutputBytes:            1024,
+	}
+}
+
+// TestShellCommandTask_Execute: Checking the output, the environment and the working directory of the command
+func TestShellCommandTask_Execute(t *testing.T) {
+	config := newTestConfig(t)
+	t.Setenv("WORKER_SECRET", "secret")
+	task := NewShellCommandTask(config)
+	taskCtx := newTestTaskContext(&Payload{
+		Executable: "/bin/sh",
+		Args:       []string{"-c", `echo "$GREETING $WORKER_SECRET"; pwd; echo oops >&2`},
+		Env:        map[string]string{"GREETING": "hello"},
+	})
+	err := task.Execute(context.Background(), taskCtx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	result := decodeResult(t, taskCtx)
+	expectedStdout := "hello \n" + config.AllowedWorkingDirectories[0] + "\n"
+	if result.ExitCode != 0 || result.Stdout != expectedStdout || result.Stderr != "oops\n" {
+		t.Fatalf("expected the output of the command without the environment of the worker, got %+v", result)
+	}
+}
+
+// TestShellCommandTask_Execute_NonZeroExit: Checking a failed command is a permanent error, and its output is still kept
+func TestShellCommandTask_Execute_NonZeroExit(t *testing.T) {
+	task := NewShellCommandTask(newTestConfig(t))
+	taskCtx := newTestTaskContext(&Payload{Executable: "/bin/sh", Args: []string{"-c", "echo failed >&2; exit 3"}})
+	err := task.Execute(context.Background(), taskCtx)
+	if !process.IsPermanent(err) {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+
+	result := decodeResult(t, taskCtx)
+	if result.ExitCode != 3 || result.Stderr != "failed\n" {
+		t.Fatalf("expected the exit code and stderr to be kept, got %+v", result)
+	}
+}
+
+// TestShellCommandTask_Execute_OutputLimit: Checking the output is truncated at the limit
+func TestShellCommandTask_Execute_OutputLimit(t *testing.T) {
+	config := newTestConfig(t)
+	config.MaxOutputBytes = 4
+	task := NewShellCommandTask(config)
+	taskCtx := newTestTaskContext(&Payload{Executable: "/bin/sh", Args: []string{"-c", "echo 0123456789"}})
+	err := task.Execute(context.Background(), taskCtx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	result := decodeResult(t, taskCtx)
+	if result.Stdout != "0123" || !result.StdoutTruncated || result.StderrTruncated {
+		t.Fatalf("expected stdout to be truncated at 4 bytes, got %+v", result)
+	}
+}
+
+// TestShellCommandTask_Execute_Timeout: Checking the command and its children are killed when the timeout of the payload passes
+func TestShellCommandTask_Execute_Timeout(t *testing.T) {
+	task := NewShellCommandTask(newTestConfig(t))
+	// The child sleep keeps stdout open, so Execute would wait for it if only the shell was killed
+	taskCtx := newTestTaskContext(&Payload{Executable: "/bin/sh", Args: []string{"-c", "sleep 30 & wait"}, TimeoutInSeconds: 1})
+	start := time.Now()
+	err := task.Execute(context.Background(), taskCtx)
+	if !process.IsPermanent(err) || !strings.Contains(err.Error(), "killed") {
+		t.Fatalf("expected a permanent error of the killed command, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("expected the command to be killed after about a second, took %s", elapsed)
+	}
+}
+
+// TestShellCommandTask_Execute_Cancelled: Checking the command is killed and the context error is returned when the task is cancelled
+func TestShellCommandTask_Execute_Cancelled(t *testing.T) {
+	task := NewShellCommandTask(newTestConfig(t))
+	taskCtx := newTestTaskContext(&Payload{Executable: "/bin/sh", Args: []string{"-c", "sleep 30"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := task.Execute(ctx, taskCtx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+// TestAuthorize: Checking only the allowed executables and working directories are accepted
+func TestAuthorize(t *testing.T) {
+	config := newTestConfig(t)
+	allowedDirectory := config.AllowedWorkingDirectories[0]
+	testCases := []struct {
+		name    string
+		payload Payload
+		allowed bool
+	}{
+		{name: "allowed executable", payload: Payload{Executable: "/bin/sh"}, allowed: true},
+		{name: "not cleaned allowed executable", payload: Payload{Executable: "/bin/../bin/sh"}, allowed: true},
+		{name: "not allowed executable", payload: Payload{Executable: "/bin/rm"}},
+		{name: "subdirectory", payload: Payload{Executable: "/bin/sh", WorkingDirectory: allowedDirectory + "/logs"}, allowed: true},
+		{name: "escaping directory", payload: Payload{Executable: "/bin/sh", WorkingDirectory: allowedDirectory + "/../"}},
+		{name: "sibling with the same prefix", payload: Payload{Executable: "/bin/sh", WorkingDirectory: allowedDirectory + "-other"}},
+		{name: "allowed env name", payload: Payload{Executable: "/bin/sh", Env: map[string]string{"GREETING": "hello"}}, allowed: true},
+		{name: "not allowed env name", payload: Payload{Executable: "/bin/sh", Env: map[string]string{"BASH_ENV": "/tmp/evil.sh"}}},
+		{name: "PATH", payload: Payload{Executable: "/bin/sh", Env: map[string]string{"PATH": "/tmp"}}},
+		{name: "LD_PRELOAD", payload: Payload{Executable: "/bin/sh", Env: map[string]string{"LD_PRELOAD": "/tmp/evil.so"}}},
+	}
+	for _, testCase := range testCases {
+		_, _, err := authorize(config, "maintenance", &testCase.payload)
+		if testCase.allowed && err != nil {
+			t.Fatalf("%s: expected no error, got %v", testCase.name, err)
+		}
+		if !testCase.allowed && err == nil {
+			t.Fatalf("%s: expected an error, got nil", testCase.name)
+		}
+	}
+}
+
+// TestAuthorize_DefaultWorkingDirectory: Checking the temp directory is used when no working directory is allowed
+func TestAuthorize_DefaultWorkingDirectory(t *testing.T) {
+	_, workingDirectory, err := authorize(Config{AllowedExecutables: []string{"/bin/sh"}, AllowedCallers: []string{"*"}}, "", &Payload{Executable: "/bin/sh"})
+	if err != nil || workingDirectory != os.TempDir() {
+		t.Fatalf("expected the temp directory, got %q, %v", workingDirectory, err)
+	}
+}
+
+// TestAuthorize_Caller: Checking only the allowed callers are able to run the commands, "*" allows the tasks without a caller too
+func TestAuthorize_Caller(t *testing.T) {
+	config := newTestConfig(t)
+	for _, caller := range []string{"maintenance", "reporting", ""} {
+		_, _, err := authorize(config, caller, &Payload{Executable: "/bin/sh"})
+		if (caller == "maintenance") != (err == nil) {
+			t.Fatalf("unexpected authorization of caller %q: %v", caller, err)
+		}
+	}
+
+	config.AllowedCallers = []string{"*"}
+	_, _, err := authorize(config, "", &Payload{Executable: "/bin/sh"})
+	if err != nil {
+		t.Fatalf("expected every caller to be allowed, got %v", err)
+	}
+
+	err = Definition(newTestConfig(t)).Authorize("reporting", &Payload{Executable: "/bin/sh"})
+	if err == nil {
+		t.Fatalf("expected the task creation of a not allowed caller to be rejected")
+	}
+}
+
+// TestProtectedEnvNames: Checking PATH and LD_* are refused by the payloads and by the config
+func TestProtectedEnvNames(t *testing.T) {
+	for _, name := range []string{"PATH", "LD_PRELOAD", "LD_LIBRARY_PATH"} {
+		err := (&Payload{Executable: "/bin/sh", Env: map[string]string{name: "/tmp"}}).Validate()
+		if err == nil {
+			t.Fatalf("expected the payload which sets %s to be refused", name)
+		}
+
+		err = Config{AllowedEnvNames: []string{name}}.Validate()
+		if err == nil {
+			t.Fatalf("expected the config which allows %s to be refused", name)
+		}
+
+		if env := commandEnv(map[string]string{name: "/tmp"}); len(env) != 1 || env[0] != "PATH="+basePath {
+			t.Fatalf("expected %s to be dropped from the environment, got %v", name, env)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package tasktypes
+
+import (
+	"github.com/sf7293/task-manager/configs"
+	"github.com/sf7293/task-manager/internal/domain"
+	"github.com/sf7293/task-manager/pkg/email"
+	"github.com/sf7293/task-manager/pkg/httprequest"
+	"github.com/sf7293/task-manager/pkg/plugin"
+	"github.com/sf7293/task-manager/pkg/process"
+	"github.com/sf7293/task-manager/pkg/query"
+	"github.com/sf7293/task-manager/pkg/shellcommand"
+	"time"
+)
+
+// NewDefaultRegistry returns a registry of all the task types which are shipped with the app
+// A new task type only needs to be added here, the server, the storage and the workers read it from the registry
+// The templates are used by send_email to render the emails which are sent with a template_id
+func NewDefaultRegistry(cfg *configs.Config, templates email.TemplateStore) (*process.Registry, error) {
+	dataSourceConfigs := make([]query.DataSourceConfig, 0, len(cfg.Query.DataSources))
+	for _, dataSource := range cfg.Query.DataSources {
+		dataSourceConfigs = append(dataSourceConfigs, query.DataSourceConfig{
+			Name:           dataSource.Name,
+			Driver:         dataSource.Driver,
+			URI:            dataSource.URI,
+			AllowWrites:    dataSource.AllowWrites,
+			AllowedCallers: dataSource.AllowedCallers,
+		})
+	}
+	dataSources, err := query.NewDataSources(dataSourceConfigs, cfg.Query.DefaultDataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	shellCommandConfig := shellcommand.Config{
+		AllowedExecutables:        cfg.ShellCommand.AllowedExecutables,
+		AllowedWorkingDirectories: cfg.ShellCommand.AllowedWorkingDirectories,
+		AllowedEnvNames:           cfg.ShellCommand.AllowedEnvNames,
+		AllowedCallers:            cfg.ShellCommand.AllowedCallers,
+		MaxOutputBytes:            cfg.ShellCommand.MaxOutputBytes,
+	}
+	err = shellCommandConfig.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	registry := process.NewRegistry()
+	definitions := []process.Definition{
+		email.Definition(email.SMTPConfig{
+			Host:            cfg.SMTP.Host,
+			Port:            cfg.SMTP.Port,
+			Username:        cfg.SMTP.Username,
+			Password:        cfg.SMTP.Password,
+			From:            cfg.SMTP.From,
+			RequireStartTLS: cfg.SMTP.RequireStartTLS,
+			Timeout:         time.Duration(cfg.SMTP.TimeoutInSeconds) * time.Second,
+		}, templates),
+		query.Definition(dataSources, query.Limits{
+			StatementTimeout: time.Duration(cfg.Query.StatementTimeoutInSeconds) * time.Second,
+			MaxRows:          cfg.Query.MaxRows,
+		}),
+		httprequest.Definition(cfg.HTTPRequest.MaxResponseBodyBytes),
+		shellcommand.Definition(shellCommandConfig),
+	}
+	// The plugins are registered after the shipped task types, so a plugin can't take the name of one of them
+	for _, handler := range cfg.Plugins.Handlers {
+		pluginConfig := plugin.Config{
+			Name:              handler.Name,
+			Command:           handler.Command,
+			Args:              handler.Args,
+			Env:               handler.Env,
+			Socket:            handler.Socket,
+			PayloadSchema:     handler.PayloadSchema,
+			DefaultPriority:   domain.TaskPriority(handler.DefaultPriority),
+			Timeout:           time.Duration(handler.TimeoutInSeconds) * time.Second,
+			MaxRetries:        handler.MaxRetries,
+			MaxMessageBytes:   cfg.Plugins.MaxMessageBytes,
+			CancelGracePeriod: time.Duration(cfg.Plugins.CancelGracePeriodInSeconds) * time.Second,
+		}
+		err = pluginConfig.Validate()
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, plugin.Definition(pluginConfig))
+	}
+
+	for _, definition := range definitions {
+		err = registry.Register(definition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
@@ -0,0 +1,58 @@
+package tasktypes
+
+import (
+	"github.com/sf7293/task-manager/configs"
+	"testing"
+)
+
+// TestNewDefaultRegistry: Checking all the shipped task types are registered with a valid schema
+func TestNewDefaultRegistry(t *testing.T) {
+	registry, err := NewDefaultRegistry(&configs.Config{}, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = registry.ValidatePayload("send_email", []byte(`{"subject":"Hi"}`))
+	if err == nil {
+		t.Fatalf("expected an error for send_email without to, got nil")
+	}
+
+	err = registry.ValidatePayload("send_email", []byte(`{"to":["user@example.com"],"subject":"Hi"}`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	err = registry.ValidatePayload("send_email", []byte(`{"to":["user@example.com"],"template_id":"welcome","variables":{"name":"Sam"}}`))
+	if err != nil {
+		t.Fatalf("expected no error for a templated email, got %v", err)
+	}
+
+	for _, taskType := range []string{"send_email", "run_query", "http_request", "shell_command"} {
+		if !registry.IsRegistered(taskType) {
+			t.Fatalf("expected %s to be registered", taskType)
+		}
+	}
+}
+
+// TestNewDefaultRegistry_Plugins: Checking the plugins are registered as task types, without taking the names of the shipped ones
+func TestNewDefaultRegistry_Plugins(t *testing.T) {
+	cfg := &configs.Config{}
+	cfg.Plugins.Handlers = configs.PluginHandlersConfig{{Name: "resize_image", Command: "/opt/plugins/resize", PayloadSchema: []byte(`{"type":"object","required":["url"]}`)}}
+	registry, err := NewDefaultRegistry(cfg, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !registry.IsRegistered("resize_image") {
+		t.Fatalf("expected resize_image to be registered")
+	}
+	err = registry.ValidatePayload("resize_image", []byte(`{}`))
+	if err == nil {
+		t.Fatalf("expected an error for a payload without url, got nil")
+	}
+
+	cfg.Plugins.Handlers = configs.PluginHandlersConfig{{Name: "send_email", Socket: "/run/email.sock"}}
+	_, err = NewDefaultRegistry(cfg, nil)
+	if err == nil {
+		t.Fatalf("expected an error for a plugin named send_email, got nil")
+	}
+}